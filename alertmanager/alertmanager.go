@@ -0,0 +1,223 @@
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(a *Alertmanager)
+
+// WithLabel adds a static label to all alerts, e.g. dc.
+func WithLabel(name, value string) Option {
+	return func(a *Alertmanager) {
+		a.labels[name] = value
+	}
+}
+
+// WithResendInterval sets how often firing alerts are re-posted,
+// it has to be lower than alertmanager's resolve_timeout.
+func WithResendInterval(d time.Duration) Option {
+	return func(a *Alertmanager) {
+		a.resend = d
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(a *Alertmanager) {
+		a.logger = l
+	}
+}
+
+// New creates new alertmanager client, url is alertmanager's base url.
+func New(url string, opts ...Option) (*Alertmanager, error) {
+	if url == "" {
+		return nil, fmt.Errorf("alertmanager: url is empty")
+	}
+	a := &Alertmanager{
+		url:    strings.TrimRight(url, "/") + "/api/v2/alerts",
+		labels: map[string]string{},
+		resend: time.Minute,
+		firing: map[string]*alert{},
+		stopCh: make(chan struct{}),
+		logger: log.New(os.Stdout, "[alertmanager] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.resend <= 0 {
+		return nil, fmt.Errorf("alertmanager: resend interval must be positive")
+	}
+	go a.loop()
+	return a, nil
+}
+
+// Alertmanager posts events as alerts to prometheus alertmanager.
+type Alertmanager struct {
+	url    string
+	labels map[string]string
+	resend time.Duration
+	logger *log.Logger
+
+	mu     sync.Mutex
+	firing map[string]*alert
+	stopCh chan struct{}
+}
+
+// alert is an element of the postable alerts list.
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt,omitempty"`
+}
+
+// Notify posts a firing alert for failing checks and resolves it by
+// setting endsAt on recovery, since severity is a label a severity change
+// resolves the previous alert and fires a new one.
+func (a *Alertmanager) Notify(ev *consul.Event) error {
+	key := ev.Key()
+	now := time.Now().UTC()
+	summary := fmt.Sprintf("[%s] %s is %s", ev.Node, ev.ServiceID, ev.Status)
+
+	var alerts []*alert
+	a.mu.Lock()
+	if prev, ok := a.firing[key]; ok && prev.Labels["severity"] != ev.Status {
+		delete(a.firing, key)
+		prev.EndsAt = now
+		prev.Annotations["summary"] = summary
+		alerts = append(alerts, prev)
+	}
+	if ev.Failing() {
+		al, ok := a.firing[key]
+		if !ok {
+			al = &alert{StartsAt: now, Labels: a.newLabels(ev)}
+			a.firing[key] = al
+		}
+		al.Annotations = map[string]string{
+			"summary":     summary,
+			"description": ev.Output,
+			"notes":       ev.Notes,
+		}
+		alerts = append(alerts, al)
+	}
+	a.mu.Unlock()
+
+	if len(alerts) == 0 {
+		return nil // recovery of an unknown alert
+	}
+	return a.post(alerts)
+}
+
+// newLabels returns alert labels of the event.
+func (a *Alertmanager) newLabels(ev *consul.Event) map[string]string {
+	labels := map[string]string{
+		"alertname": "ConsulServiceUnhealthy",
+		"service":   ev.ServiceName,
+		"node":      ev.Node,
+		"severity":  ev.Status,
+	}
+	for k, v := range map[string]string{
+		"check":     ev.CheckID,
+		"cluster":   ev.Cluster,
+		"dc":        ev.Datacenter,
+		"partition": ev.Partition,
+		"peer":      ev.Peer,
+	} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	for k, v := range a.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// loop periodically re-posts firing alerts so they don't auto-resolve.
+func (a *Alertmanager) loop() {
+	t := time.NewTicker(a.resend)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-a.stopCh:
+			return
+		}
+
+		a.mu.Lock()
+		alerts := make([]*alert, 0, len(a.firing))
+		for _, al := range a.firing {
+			alerts = append(alerts, al)
+		}
+		a.mu.Unlock()
+
+		if len(alerts) == 0 {
+			continue
+		}
+		if err := a.post(alerts); err != nil {
+			a.infof("resend error: %v", err)
+		}
+	}
+}
+
+// post sends alerts to the api.
+func (a *Alertmanager) post(alerts []*alert) error {
+	a.mu.Lock()
+	b, err := json.Marshal(alerts)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	a.infof("payload: %s", b)
+	r, err := http.Post(a.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	a.infof("response: %s", r.Status)
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	return nil
+}
+
+// Close stops re-posting firing alerts.
+func (a *Alertmanager) Close() error {
+	select {
+	case <-a.stopCh:
+		return fmt.Errorf("already closed")
+	default:
+	}
+	close(a.stopCh)
+	return nil
+}
+
+// infof prints a debug message.
+func (a *Alertmanager) infof(format string, v ...interface{}) {
+	if a.logger != nil {
+		a.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("alertmanager responded with %d status code", r.r.StatusCode)
+}
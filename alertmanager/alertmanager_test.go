@@ -0,0 +1,51 @@
+package alertmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var got [][]*alert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("path = %q, want %q", r.URL.Path, "/api/v2/alerts")
+		}
+		var alerts []*alert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, alerts)
+	}))
+	defer ts.Close()
+
+	a, err := New(ts.URL, WithLabel("dc", "dc1"), WithResendInterval(time.Hour), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	for _, status := range []string{consul.Warning, consul.Critical, consul.Passing, consul.Passing} {
+		if err = a.Notify(&consul.Event{Node: "n1", ServiceID: "web", ID: "n1:web", ServiceName: "web", Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("got %d requests, want 3", len(got))
+	}
+	if len(got[1]) != 2 || got[1][0].Labels["severity"] != consul.Warning || got[1][0].EndsAt.IsZero() {
+		t.Errorf("severity change expected to resolve the warning alert")
+	}
+	last := got[2][0]
+	if last.EndsAt.IsZero() || last.Labels["severity"] != consul.Critical || last.Labels["dc"] != "dc1" {
+		t.Errorf("recovery = %+v, want resolved critical alert with dc label", last)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// alerts keeps track of when checks started failing,
+// so the api can tell for how long they've been failing.
+type alerts struct {
+	mu    sync.Mutex
+	since map[string]time.Time
+}
+
+func newAlerts() *alerts {
+	return &alerts{since: map[string]time.Time{}}
+}
+
+// track records the time the check of the event started failing
+// at and forgets it once the check isn't failing anymore.
+func (a *alerts) track(ev *consul.Event, now time.Time) {
+	if ev.Reminder != 0 || ev.IsLock() {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !ev.Failing() {
+		delete(a.since, ev.Key())
+		return
+	}
+	if _, ok := a.since[ev.Key()]; !ok {
+		a.since[ev.Key()] = now
+	}
+}
+
+// failingSince returns the time the check of the event started
+// failing at, false is returned when it's not known, e.g. the check
+// has been failing since before the instance became active.
+func (a *alerts) failingSince(ev *consul.Event) (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t, ok := a.since[ev.Key()]
+	return t, ok
+}
+
+// alertLister lists failing checks and silenced services.
+type alertLister interface {
+	silencer
+	Failing() ([]*consul.Event, error)
+}
+
+// apiAlert is a failing check returned by the api.
+type apiAlert struct {
+	Location      string     `json:"location"`
+	Node          string     `json:"node"`
+	ServiceID     string     `json:"service_id,omitempty"`
+	ServiceName   string     `json:"service_name,omitempty"`
+	CheckID       string     `json:"check_id"`
+	Check         string     `json:"check"`
+	Status        string     `json:"status"`
+	Output        string     `json:"output"`
+	Since         *time.Time `json:"since,omitempty"`
+	Duration      string     `json:"duration,omitempty"`
+	Silenced      bool       `json:"silenced"`
+	SilencedUntil *time.Time `json:"silenced_until,omitempty"`
+}
+
+// apiSilence is a silenced service returned by the api.
+type apiSilence struct {
+	Service string    `json:"service"`
+	Until   time.Time `json:"until"`
+}
+
+// alertsHandler serves currently failing checks, for how long
+// they've been failing when it's known and whether they're silenced.
+func alertsHandler(l alertLister, a *alerts) http.Handler {
+	return apiHandler(func() (interface{}, error) {
+		return listAlerts(l, a, time.Now())
+	})
+}
+
+// listAlerts returns currently failing checks with durations at now.
+func listAlerts(l alertLister, a *alerts, now time.Time) ([]*apiAlert, error) {
+	evs, err := l.Failing()
+	if err != nil {
+		return nil, err
+	}
+	silences, err := l.Silences()
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]*apiAlert, 0, len(evs))
+	for _, ev := range evs {
+		alert := &apiAlert{
+			Location:    ev.Location(),
+			Node:        ev.Node,
+			ServiceID:   ev.ServiceID,
+			ServiceName: ev.ServiceName,
+			CheckID:     ev.CheckID,
+			Check:       ev.Name,
+			Status:      ev.Status,
+			Output:      ev.Output,
+		}
+		if since, ok := a.failingSince(ev); ok {
+			alert.Since = &since
+			alert.Duration = now.Sub(since).Truncate(time.Second).String()
+		}
+		if until, ok := silences[ev.ServiceName]; ok && ev.ServiceName != "" {
+			alert.Silenced, alert.SilencedUntil = true, &until
+		}
+		r = append(r, alert)
+	}
+	return r, nil
+}
+
+// silencesHandler serves silenced services sorted by name.
+func silencesHandler(s silencer) http.Handler {
+	return apiHandler(func() (interface{}, error) {
+		return listSilences(s)
+	})
+}
+
+// listSilences returns silenced services sorted by name.
+func listSilences(s silencer) ([]*apiSilence, error) {
+	silences, err := s.Silences()
+	if err != nil {
+		return nil, err
+	}
+	r := make([]*apiSilence, 0, len(silences))
+	for service, until := range silences {
+		r = append(r, &apiSilence{Service: service, Until: until})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Service < r[j].Service
+	})
+	return r, nil
+}
+
+// apiHandler serves the result of fn as json to GET requests,
+// errors are served as {"error": "..."} with the 502 status code
+// since they're errors of querying consul.
+func apiHandler(fn func() (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		v, err := fn()
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	})
+}
+
+// writeJSON writes v as json with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
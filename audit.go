@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time    time.Time     `json:"time"`
+	Target  string        `json:"target"`
+	Channel string        `json:"channel,omitempty"`
+	Event   *consul.Event `json:"event"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// auditLog records every notification sent to targets
+// along with the delivery result as json lines.
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newAuditLog(w io.Writer) *auditLog {
+	return &auditLog{enc: json.NewEncoder(w)}
+}
+
+// record appends the delivery of the event to the target's channel,
+// it's empty for the default one, errors of writing the log are reported
+// like delivery errors since the log is useless with gaps.
+func (a *auditLog) record(target, channel string, ev *consul.Event, err error) {
+	if a == nil {
+		return
+	}
+	entry := &auditEntry{Time: time.Now(), Target: target, Channel: channel, Event: ev}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(entry); err != nil {
+		notifyError("audit", err)
+	}
+}
@@ -0,0 +1,50 @@
+// Package catalog translates built-in messages of chat notifiers.
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Catalog maps english message formats, labels and status words
+// to translations, formats keep verbs of the english ones in the same order.
+type Catalog map[string]string
+
+// T returns the translation of s, it's s itself when the catalog
+// has none or it's nil, that is the english one.
+func (c Catalog) T(s string) string {
+	if t, ok := c[s]; ok {
+		return t
+	}
+	return s
+}
+
+// catalogs are built-in catalogs by language code.
+var catalogs = map[string]Catalog{
+	"de": de,
+	"ru": ru,
+}
+
+// Lookup returns the built-in catalog of the language,
+// it's nil for english, messages are english already.
+func Lookup(lang string) (Catalog, error) {
+	if lang == "" || lang == "en" {
+		return nil, nil
+	}
+	c, ok := catalogs[lang]
+	if !ok {
+		return nil, fmt.Errorf("catalog: unknown language %q, want one of %s", lang, strings.Join(Languages(), ", "))
+	}
+	return c, nil
+}
+
+// Languages returns codes of supported languages.
+func Languages() []string {
+	r := []string{"en"}
+	for lang := range catalogs {
+		r = append(r, lang)
+	}
+	sort.Strings(r[1:])
+	return r
+}
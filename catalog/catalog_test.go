@@ -0,0 +1,47 @@
+package catalog
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var verbRegexp = regexp.MustCompile(`%[#+\-0-9.]*[a-zA-Z%]`)
+
+func TestCatalogs(t *testing.T) {
+	t.Parallel()
+
+	for lang, c := range catalogs {
+		for k, v := range c {
+			if want, got := verbRegexp.FindAllString(k, -1), verbRegexp.FindAllString(v, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %q has verbs %v, want %v", lang, v, got, want)
+			}
+		}
+		for k := range de {
+			if _, ok := c[k]; !ok {
+				t.Errorf("%s: %q isn't translated", lang, k)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	c, err := Lookup("en")
+	if err != nil || c != nil {
+		t.Fatalf("Lookup(en) = %v, %v, want nil catalog", c, err)
+	}
+	if s := c.T("Time"); s != "Time" {
+		t.Errorf("T(Time) = %q, want it untranslated", s)
+	}
+	if c, err = Lookup("de"); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.T("Time"); s != "Zeit" {
+		t.Errorf("T(Time) = %q, want Zeit", s)
+	}
+	if _, err = Lookup("xx"); err == nil {
+		t.Error("Lookup(xx) expected to fail")
+	}
+}
@@ -0,0 +1,101 @@
+package catalog
+
+var de = Catalog{
+	// status words
+	"passing":     "in Ordnung",
+	"warning":     "Warnung",
+	"critical":    "kritisch",
+	"maintenance": "Wartung",
+
+	// attachments
+	" (was %s)":                  " (war %s)",
+	" (was critical for %s)":     " (war %s lang kritisch)",
+	" (output changed)":          " (Ausgabe geändert)",
+	" (external)":                " (extern)",
+	"node check %s":              "Node-Check %s",
+	"critical for %s":            "seit %s kritisch",
+	"%s is %s":                   "%s ist %s",
+	", check %s":                 ", Check %s",
+	"[%s] %s is %s":              "[%s] %s ist %s",
+	"[%s] %d checks changed":     "[%s] %d Checks haben sich geändert",
+	"\nAffected dependents: %s":  "\nBetroffene abhängige Dienste: %s",
+	"\nAcknowledged by %s at %s": "\nBestätigt von %s um %s",
+	"\nIncident: %s":             "\nVorfall: %s",
+	"\nTimeline: %s":             "\nVerlauf: %s",
+	"failing for %s":             "seit %s fehlerhaft",
+	"%d flaps":                   "%d Wechsel",
+	"%d output changes":          "%d Ausgabeänderungen",
+	"\nTime: %s":                 "\nZeit: %s",
+	"and %d more":                "und %d weitere",
+
+	"consul-slack on %s acquired the lock and is now active":                 "consul-slack auf %s hat die Sperre erhalten und ist jetzt aktiv",
+	"consul-slack on %s lost the lock":                                       "consul-slack auf %s hat die Sperre verloren",
+	"[%s] %s is back to normal%s\nCheck: %s\nNotes: %s\nOutput: %s":          "[%s] %s ist wieder normal%s\nCheck: %s\nNotizen: %s\nAusgabe: %s",
+	"[%s] %s is having problems%s\nCheck: %s\nNotes: %s\nOutput: %s":         "[%s] %s hat Probleme%s\nCheck: %s\nNotizen: %s\nAusgabe: %s",
+	"[%s] %s is critical%s\nCheck: %s\nNotes: %s\nOutput: %s%s":              "[%s] %s ist kritisch%s\nCheck: %s\nNotizen: %s\nAusgabe: %s%s",
+	"[%s] %s is under maintenance%s\nNotes: %s":                              "[%s] %s ist im Wartungsmodus%s\nNotizen: %s",
+	"[%s] %s has been registered\nTags: %s":                                  "[%s] %s wurde registriert\nTags: %s",
+	"[%s] %s has been deregistered%s":                                        "[%s] %s wurde abgemeldet%s",
+	"[%s] %s is %s%s\nCheck: %s\nNotes: %s\nOutput: %s":                      "[%s] %s ist %s%s\nCheck: %s\nNotizen: %s\nAusgabe: %s",
+	"%s[%s] %s is still %s (reminder #%d)\nCheck: %s\nOutput: %s":            "%s[%s] %s ist weiterhin %s (Erinnerung #%d)\nCheck: %s\nAusgabe: %s",
+	"Node %s check %s has been registered":                                   "Node %s: Check %s wurde registriert",
+	"Node %s check %s has been deregistered%s":                               "Node %s: Check %s wurde abgemeldet%s",
+	"Node %s is back in the cluster%s":                                       "Node %s ist wieder im Cluster%s",
+	", affects %d services: %s":                                              ", betrifft %d Dienste: %s",
+	"Node %s left the cluster or is unreachable%s%s\nOutput: %s":             "Node %s hat den Cluster verlassen oder ist nicht erreichbar%s%s\nAusgabe: %s",
+	"Node %s check %s is back to normal%s\nOutput: %s":                       "Node %s: Check %s ist wieder normal%s\nAusgabe: %s",
+	"Node %s check %s is having problems%s\nOutput: %s":                      "Node %s: Check %s hat Probleme%s\nAusgabe: %s",
+	"Node %s check %s is %s%s\nOutput: %s":                                   "Node %s: Check %s ist %s%s\nAusgabe: %s",
+	"Datacenter %s is reachable over WAN again%s\nOutput: %s":                "Rechenzentrum %s ist über WAN wieder erreichbar%s\nAusgabe: %s",
+	"Datacenter %s is unreachable over WAN%s\nOutput: %s":                    "Rechenzentrum %s ist über WAN nicht erreichbar%s\nAusgabe: %s",
+	"Datacenter %s has a raft leader again%s\nOutput: %s":                    "Rechenzentrum %s hat wieder einen Raft-Leader%s\nAusgabe: %s",
+	"Datacenter %s has no raft leader%s\nOutput: %s":                         "Rechenzentrum %s hat keinen Raft-Leader%s\nAusgabe: %s",
+	"Datacenter %s has raft quorum again%s\nOutput: %s":                      "Rechenzentrum %s hat wieder ein Raft-Quorum%s\nAusgabe: %s",
+	"Datacenter %s lost raft quorum%s\nOutput: %s":                           "Rechenzentrum %s hat das Raft-Quorum verloren%s\nAusgabe: %s",
+	"Datacenter %s tolerates server failures again%s\nOutput: %s":            "Rechenzentrum %s verkraftet wieder Serverausfälle%s\nAusgabe: %s",
+	"Datacenter %s cannot lose any more servers%s\nOutput: %s":               "Rechenzentrum %s kann keine weiteren Server verlieren%s\nAusgabe: %s",
+	"Server %s is healthy again%s\nOutput: %s":                               "Server %s ist wieder gesund%s\nAusgabe: %s",
+	"Server %s is unhealthy according to autopilot%s\nOutput: %s":            "Server %s ist laut Autopilot nicht gesund%s\nAusgabe: %s",
+	"Summary: all checks are passing":                                        "Zusammenfassung: alle Checks sind in Ordnung",
+	"Summary: %d checks are failing":                                         "Zusammenfassung: %d Checks schlagen fehl",
+	"All clear: all %d checks are passing in %s":                             "Alles in Ordnung: alle %d Checks in %s sind in Ordnung",
+	"Daily report: %d of %d checks are failing in %s":                        "Tagesbericht: %d von %d Checks in %s schlagen fehl",
+	"Suppressed %d alerts during maintenance window %s":                      "%d Alarme während des Wartungsfensters %s unterdrückt",
+	"%d more events suppressed by the rate limit, see `consul-slack status`": "%d weitere Ereignisse durch das Ratenlimit unterdrückt, siehe `consul-slack status`",
+	"Outage since %s: %d checks are failing, alerts about them are held":     "Ausfall seit %s: %d Checks schlagen fehl, Alarme dazu werden zurückgehalten",
+	"Outage since %s is over, all checks are passing":                        "Ausfall seit %s ist vorbei, alle Checks sind in Ordnung",
+	"Outage since %s is over, alerts resume: %d checks are still failing":    "Ausfall seit %s ist vorbei, Alarme werden fortgesetzt: %d Checks schlagen weiterhin fehl",
+
+	// telegram
+	"is back to normal":                   "ist wieder normal",
+	"is having problems":                  "hat Probleme",
+	"is critical":                         "ist kritisch",
+	"is under maintenance":                "ist im Wartungsmodus",
+	"has been registered":                 "wurde registriert",
+	"has been deregistered":               "wurde abgemeldet",
+	"acquired the lock and is now active": "hat die Sperre erhalten und ist jetzt aktiv",
+	"lost the lock":                       "hat die Sperre verloren",
+	"is %s":                               "ist %s",
+	"is still critical":                   "ist weiterhin kritisch",
+	"is still critical for %s":            "ist seit %s weiterhin kritisch",
+	" (reminder #%d)":                     " (Erinnerung #%d)",
+	"Affected dependents":                 "Betroffene abhängige Dienste",
+	"Affected services":                   "Betroffene Dienste",
+	"Notes":                               "Notizen",
+	"Acknowledged by":                     "Bestätigt von",
+	"Incident":                            "Vorfall",
+	"Timeline":                            "Verlauf",
+	"Time":                                "Zeit",
+	"%s at %s":                            "%s um %s",
+	"All checks are passing":              "Alle Checks sind in Ordnung",
+	"%d checks are failing":               "%d Checks schlagen fehl",
+
+	"%d more events suppressed by the rate limit, see consul-slack status": "%d weitere Ereignisse durch das Ratenlimit unterdrückt, siehe consul-slack status",
+
+	"Weekly report for %s: no incidents":                           "Wochenbericht für %s: keine Vorfälle",
+	"Weekly report for %s: %d incidents, mean time to recovery %s": "Wochenbericht für %s: %d Vorfälle, mittlere Wiederherstellungszeit %s",
+	"Worst offenders:": "Größte Verursacher:",
+	"%s: %d incidents, down for %s, MTTR %s, MTBF %s": "%s: %d Vorfälle, %s ausgefallen, MTTR %s, MTBF %s",
+	"Flappiest checks:":             "Instabilste Checks:",
+	"[%s] %s %s: %d status changes": "[%s] %s %s: %d Statuswechsel",
+}
@@ -0,0 +1,101 @@
+package catalog
+
+var ru = Catalog{
+	// status words
+	"passing":     "норма",
+	"warning":     "предупреждение",
+	"critical":    "критично",
+	"maintenance": "обслуживание",
+
+	// attachments
+	" (was %s)":                  " (было: %s)",
+	" (was critical for %s)":     " (было критично %s)",
+	" (output changed)":          " (вывод изменился)",
+	" (external)":                " (внешний)",
+	"node check %s":              "проверка узла %s",
+	"critical for %s":            "критично уже %s",
+	"%s is %s":                   "%s: %s",
+	", check %s":                 ", проверка %s",
+	"[%s] %s is %s":              "[%s] %s: %s",
+	"[%s] %d checks changed":     "[%s] изменилось проверок: %d",
+	"\nAffected dependents: %s":  "\nЗатронутые зависимые сервисы: %s",
+	"\nAcknowledged by %s at %s": "\nПодтверждено: %s в %s",
+	"\nIncident: %s":             "\nИнцидент: %s",
+	"\nTimeline: %s":             "\nХронология: %s",
+	"failing for %s":             "сбой длился %s",
+	"%d flaps":                   "переключений: %d",
+	"%d output changes":          "изменений вывода: %d",
+	"\nTime: %s":                 "\nВремя: %s",
+	"and %d more":                "и ещё %d",
+
+	"consul-slack on %s acquired the lock and is now active":                 "consul-slack на %s получил блокировку и теперь активен",
+	"consul-slack on %s lost the lock":                                       "consul-slack на %s потерял блокировку",
+	"[%s] %s is back to normal%s\nCheck: %s\nNotes: %s\nOutput: %s":          "[%s] %s снова в норме%s\nПроверка: %s\nЗаметки: %s\nВывод: %s",
+	"[%s] %s is having problems%s\nCheck: %s\nNotes: %s\nOutput: %s":         "[%s] %s: есть проблемы%s\nПроверка: %s\nЗаметки: %s\nВывод: %s",
+	"[%s] %s is critical%s\nCheck: %s\nNotes: %s\nOutput: %s%s":              "[%s] %s в критическом состоянии%s\nПроверка: %s\nЗаметки: %s\nВывод: %s%s",
+	"[%s] %s is under maintenance%s\nNotes: %s":                              "[%s] %s на обслуживании%s\nЗаметки: %s",
+	"[%s] %s has been registered\nTags: %s":                                  "[%s] %s зарегистрирован\nТеги: %s",
+	"[%s] %s has been deregistered%s":                                        "[%s] %s снят с регистрации%s",
+	"[%s] %s is %s%s\nCheck: %s\nNotes: %s\nOutput: %s":                      "[%s] %s: %s%s\nПроверка: %s\nЗаметки: %s\nВывод: %s",
+	"%s[%s] %s is still %s (reminder #%d)\nCheck: %s\nOutput: %s":            "%s[%s] %s всё ещё %s (напоминание #%d)\nПроверка: %s\nВывод: %s",
+	"Node %s check %s has been registered":                                   "Узел %s: проверка %s зарегистрирована",
+	"Node %s check %s has been deregistered%s":                               "Узел %s: проверка %s снята с регистрации%s",
+	"Node %s is back in the cluster%s":                                       "Узел %s снова в кластере%s",
+	", affects %d services: %s":                                              ", затрагивает сервисы (%d): %s",
+	"Node %s left the cluster or is unreachable%s%s\nOutput: %s":             "Узел %s покинул кластер или недоступен%s%s\nВывод: %s",
+	"Node %s check %s is back to normal%s\nOutput: %s":                       "Узел %s: проверка %s снова в норме%s\nВывод: %s",
+	"Node %s check %s is having problems%s\nOutput: %s":                      "Узел %s: проверка %s: есть проблемы%s\nВывод: %s",
+	"Node %s check %s is %s%s\nOutput: %s":                                   "Узел %s: проверка %s: %s%s\nВывод: %s",
+	"Datacenter %s is reachable over WAN again%s\nOutput: %s":                "Датацентр %s снова доступен по WAN%s\nВывод: %s",
+	"Datacenter %s is unreachable over WAN%s\nOutput: %s":                    "Датацентр %s недоступен по WAN%s\nВывод: %s",
+	"Datacenter %s has a raft leader again%s\nOutput: %s":                    "В датацентре %s снова есть лидер raft%s\nВывод: %s",
+	"Datacenter %s has no raft leader%s\nOutput: %s":                         "В датацентре %s нет лидера raft%s\nВывод: %s",
+	"Datacenter %s has raft quorum again%s\nOutput: %s":                      "В датацентре %s снова есть кворум raft%s\nВывод: %s",
+	"Datacenter %s lost raft quorum%s\nOutput: %s":                           "Датацентр %s потерял кворум raft%s\nВывод: %s",
+	"Datacenter %s tolerates server failures again%s\nOutput: %s":            "Датацентр %s снова переживёт отказ серверов%s\nВывод: %s",
+	"Datacenter %s cannot lose any more servers%s\nOutput: %s":               "Датацентр %s не переживёт отказ ещё одного сервера%s\nВывод: %s",
+	"Server %s is healthy again%s\nOutput: %s":                               "Сервер %s снова исправен%s\nВывод: %s",
+	"Server %s is unhealthy according to autopilot%s\nOutput: %s":            "Сервер %s неисправен по данным autopilot%s\nВывод: %s",
+	"Summary: all checks are passing":                                        "Сводка: все проверки проходят",
+	"Summary: %d checks are failing":                                         "Сводка: не проходят проверок: %d",
+	"All clear: all %d checks are passing in %s":                             "Всё в порядке: все %d проверок проходят в %s",
+	"Daily report: %d of %d checks are failing in %s":                        "Ежедневный отчёт: не проходят %d из %d проверок в %s",
+	"Suppressed %d alerts during maintenance window %s":                      "Подавлено оповещений: %d во время окна обслуживания %s",
+	"%d more events suppressed by the rate limit, see `consul-slack status`": "Ещё %d событий подавлено ограничением частоты, см. `consul-slack status`",
+	"Outage since %s: %d checks are failing, alerts about them are held":     "Авария с %s: не проходят проверок: %d, оповещения о них задержаны",
+	"Outage since %s is over, all checks are passing":                        "Авария с %s закончилась, все проверки проходят",
+	"Outage since %s is over, alerts resume: %d checks are still failing":    "Авария с %s закончилась, оповещения возобновлены: всё ещё не проходят проверок: %d",
+
+	// telegram
+	"is back to normal":                   "снова в норме",
+	"is having problems":                  "— есть проблемы",
+	"is critical":                         "в критическом состоянии",
+	"is under maintenance":                "на обслуживании",
+	"has been registered":                 "зарегистрирован",
+	"has been deregistered":               "снят с регистрации",
+	"acquired the lock and is now active": "получил блокировку и теперь активен",
+	"lost the lock":                       "потерял блокировку",
+	"is %s":                               "— %s",
+	"is still critical":                   "всё ещё в критическом состоянии",
+	"is still critical for %s":            "всё ещё в критическом состоянии (%s)",
+	" (reminder #%d)":                     " (напоминание #%d)",
+	"Affected dependents":                 "Затронутые зависимые сервисы",
+	"Affected services":                   "Затронутые сервисы",
+	"Notes":                               "Заметки",
+	"Acknowledged by":                     "Подтверждено",
+	"Incident":                            "Инцидент",
+	"Timeline":                            "Хронология",
+	"Time":                                "Время",
+	"%s at %s":                            "%s в %s",
+	"All checks are passing":              "Все проверки проходят",
+	"%d checks are failing":               "Не проходят проверок: %d",
+
+	"%d more events suppressed by the rate limit, see consul-slack status": "Ещё %d событий подавлено ограничением частоты, см. consul-slack status",
+
+	"Weekly report for %s: no incidents":                           "Недельный отчёт за %s: инцидентов не было",
+	"Weekly report for %s: %d incidents, mean time to recovery %s": "Недельный отчёт за %s: инцидентов: %d, среднее время восстановления %s",
+	"Worst offenders:": "Главные нарушители:",
+	"%s: %d incidents, down for %s, MTTR %s, MTBF %s": "%s: инцидентов: %d, простой %s, MTTR %s, MTBF %s",
+	"Flappiest checks:":             "Самые нестабильные проверки:",
+	"[%s] %s %s: %d status changes": "[%s] %s %s: смен статуса: %d",
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+	"github.com/amenzhinsky/consul-slack/watcher"
+)
+
+// cluster is a named consul cluster address.
+type cluster struct {
+	name    string
+	address string          // empty when it's set by opts
+	opts    []consul.Option // options of a watch profile, nil for the shared ones
+}
+
+// clustersFlag is a repeatable NAME=ADDRESS command-line flag.
+type clustersFlag []cluster
+
+func (f *clustersFlag) String() string {
+	return ""
+}
+
+func (f *clustersFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 1 || i == len(s)-1 {
+		return fmt.Errorf("malformed cluster %q, want NAME=ADDRESS", s)
+	}
+	for _, c := range *f {
+		if c.name == s[:i] {
+			return fmt.Errorf("duplicate cluster %q", c.name)
+		}
+	}
+	*f = append(*f, cluster{name: s[:i], address: s[i+1:]})
+	return nil
+}
+
+// source is a stream of consul events delivered to notifiers,
+// either a single watcher or several clusters merged together.
+type source interface {
+	pingRoler
+	sourceCounter
+	acker
+	valuer
+	SetValue(key, value string) error
+	Run(ctx context.Context) error
+	Next(ctx context.Context) (*consul.Event, error)
+	Failing() ([]*consul.Event, error)
+	Report() (*consul.Report, error)
+	Silences() (map[string]time.Time, error)
+	Beat() error
+	WatchPrefix(ctx context.Context, prefix string, fn func(values map[string]string))
+}
+
+// clusters watches several consul clusters, each one has its own
+// session, lock and state, and merges their events into one stream.
+type clusters struct {
+	list      []*consul.Consul
+	notifiers []watcher.Notifier
+
+	once sync.Once
+	next chan *consul.Event
+}
+
+// newClusters creates clients of the clusters with the given options,
+// events are labeled with cluster names and delivered to the notifiers,
+// every named cluster spills events to its own -spill-file suffixed with its name.
+func newClusters(list []cluster, opts []consul.Option, notifiers ...watcher.Notifier) (*clusters, error) {
+	cs := &clusters{notifiers: notifiers, next: make(chan *consul.Event)}
+	for _, cl := range list {
+		clOpts := opts
+		if cl.opts != nil {
+			clOpts = cl.opts
+		}
+		clOpts = append(clOpts[:len(clOpts):len(clOpts)], consul.WithCluster(cl.name))
+		if cl.address != "" {
+			clOpts = append(clOpts, consul.WithAddress(cl.address))
+		}
+		if spillFileFlag != "" && cl.name != "" {
+			clOpts = append(clOpts, consul.WithSpillFile(spillFileFlag+"."+cl.name))
+		}
+		c, err := consul.New(clOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", cl.name, err)
+		}
+		cs.list = append(cs.list, c)
+	}
+	return cs, nil
+}
+
+// Run watches all clusters until ctx is canceled or one of them stops,
+// the others are stopped then and the first error is returned.
+func (cs *clusters) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(cs.list))
+	for _, c := range cs.list {
+		go func(c *consul.Consul) {
+			errs <- c.Run(ctx)
+		}(c)
+	}
+	var err error
+	for range cs.list {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+		cancel()
+	}
+	return err
+}
+
+// Next returns the next event of any cluster after delivering it
+// to all notifiers, see watcher's Next. The first Next call's ctx
+// stops reading events of the clusters.
+func (cs *clusters) Next(ctx context.Context) (*consul.Event, error) {
+	cs.once.Do(func() {
+		go cs.merge(ctx)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	select {
+	case ev, ok := <-cs.next:
+		if !ok {
+			return nil, cs.Err()
+		}
+		if errs := watcher.Notify(ev, cs.notifiers...); len(errs) != 0 {
+			return ev, errs
+		}
+		return ev, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// merge forwards events of all clusters to the next channel
+// and closes it once all of them are stopped.
+func (cs *clusters) merge(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(len(cs.list))
+	for _, c := range cs.list {
+		go func(c *consul.Consul) {
+			defer wg.Done()
+			for {
+				ev, _ := c.Next(ctx)
+				if ev == nil {
+					return
+				}
+				select {
+				case cs.next <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(cs.next)
+}
+
+// Err returns the first error clusters have failed with.
+func (cs *clusters) Err() error {
+	for _, c := range cs.list {
+		if err := c.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Active reports whether the instance is active in any of the clusters.
+func (cs *clusters) Active() bool {
+	for _, c := range cs.list {
+		if c.Active() {
+			return true
+		}
+	}
+	return false
+}
+
+// Ping checks that all clusters are reachable.
+func (cs *clusters) Ping() error {
+	for _, c := range cs.list {
+		if err := c.Ping(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Failing returns failing checks of all clusters.
+func (cs *clusters) Failing() ([]*consul.Event, error) {
+	var evs []*consul.Event
+	for _, c := range cs.list {
+		r, err := c.Failing()
+		if err != nil {
+			return nil, err
+		}
+		evs = append(evs, r...)
+	}
+	return evs, nil
+}
+
+// Report returns the merged report of all clusters.
+func (cs *clusters) Report() (*consul.Report, error) {
+	r := &consul.Report{Checks: map[string]int{}}
+	for _, c := range cs.list {
+		cr, err := c.Report()
+		if err != nil {
+			return nil, err
+		}
+		r.Merge(cr)
+	}
+	return r, nil
+}
+
+// Silences returns silences of all clusters, the latest one
+// wins when a service is silenced in several clusters.
+func (cs *clusters) Silences() (map[string]time.Time, error) {
+	m := map[string]time.Time{}
+	for _, c := range cs.list {
+		r, err := c.Silences()
+		if err != nil {
+			return nil, err
+		}
+		for s, until := range r {
+			if until.After(m[s]) {
+				m[s] = until
+			}
+		}
+	}
+	return m, nil
+}
+
+// NoiseSuppressed sums changes suppressed by noise filters of all clusters.
+func (cs *clusters) NoiseSuppressed() map[string]uint64 {
+	m := map[string]uint64{}
+	for _, c := range cs.list {
+		for p, n := range c.NoiseSuppressed() {
+			m[p] += n
+		}
+	}
+	return m
+}
+
+// EventsDropped sums events dropped from buffers of all clusters.
+func (cs *clusters) EventsDropped() uint64 {
+	var n uint64
+	for _, c := range cs.list {
+		n += c.EventsDropped()
+	}
+	return n
+}
+
+// Acks returns acks of all clusters, the latest one wins
+// when a service is acknowledged in several clusters.
+func (cs *clusters) Acks() (map[string]*consul.Ack, error) {
+	m := map[string]*consul.Ack{}
+	for _, c := range cs.list {
+		r, err := c.Acks()
+		if err != nil {
+			return nil, err
+		}
+		for s, ack := range r {
+			if prev, ok := m[s]; !ok || ack.Time.After(prev.Time) {
+				m[s] = ack
+			}
+		}
+	}
+	return m, nil
+}
+
+// Value returns the value of the KV key in the first cluster it's set in.
+func (cs *clusters) Value(key string) (string, error) {
+	for _, c := range cs.list {
+		v, err := c.Value(key)
+		if err != nil || v != "" {
+			return v, err
+		}
+	}
+	return "", nil
+}
+
+// SetValue sets the KV key in the first cluster.
+func (cs *clusters) SetValue(key, value string) error {
+	return cs.list[0].SetValue(key, value)
+}
+
+// WatchPrefix watches the KV prefix in the first cluster, see consul's WatchPrefix.
+func (cs *clusters) WatchPrefix(ctx context.Context, prefix string, fn func(values map[string]string)) {
+	cs.list[0].WatchPrefix(ctx, prefix, fn)
+}
+
+// Beat writes heartbeats to clusters the instance is active in.
+func (cs *clusters) Beat() error {
+	for _, c := range cs.list {
+		if !c.Active() {
+			continue
+		}
+		if err := c.Beat(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// command is a subcommand, args are positional arguments left after flags.
+type command struct {
+	args string
+	help string
+	run  func(args []string) error
+}
+
+// commands are subcommands, run is the default one.
+var commands = map[string]*command{
+	"run": {
+		args: "[SLACK_WEBHOOK_URL]",
+		help: "watch health checks and send notifications",
+		run:  runCommand,
+	},
+	"status": {
+		help: "print the lock holder, silences and failing checks",
+		run:  statusCommand,
+	},
+	"silence": {
+		args: "[SERVICE DURATION]",
+		help: "silence notifications about the service, zero duration lifts the silence, lists silences without arguments",
+		run:  silenceCommand,
+	},
+	"ack": {
+		args: "[SERVICE [COMMENT]]",
+		help: "acknowledge the failing service, stops reminders until it recovers, lists acks without arguments",
+		run:  ackCommand,
+	},
+	"state": {
+		args: "export|import [FILE]",
+		help: "dump saved states, silences and acks to the json file or restore them from it, stdout and stdin by default",
+		run:  stateCommand,
+	},
+	"replay": {
+		args: "[SLACK_WEBHOOK_URL]",
+		help: "print events of the last -replay-since in -history-file as they'd be sent now or resend them with -replay-send",
+		run:  replayCommand,
+	},
+	"validate": {
+		args: "[SLACK_WEBHOOK_URL]",
+		help: "check flags and the config file, print where notifications go and verify consul permissions",
+		run:  validateCommand,
+	},
+	"test": {
+		args: "[SLACK_WEBHOOK_URL]",
+		help: "send a test notification of every status to all configured notifiers and print responses",
+		run:  testCommand,
+	},
+}
+
+// parseCommand returns the subcommand name and the rest of arguments,
+// run is returned when arguments don't start with a subcommand.
+func parseCommand(args []string) (string, []string) {
+	if len(args) != 0 {
+		if _, ok := commands[args[0]]; ok {
+			return args[0], args[1:]
+		}
+	}
+	return "run", args
+}
+
+// usage prints the usage of all subcommands.
+func usage(w io.Writer, name string) {
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "usage: %s [COMMAND] [FLAGS] [ARGS]\n\ncommands:\n", name)
+	for _, n := range names {
+		fmt.Fprintf(w, "  %s %s\n    \t%s\n", n, commands[n].args, commands[n].help)
+	}
+	fmt.Fprintf(w, "\nflags:\n")
+}
+
+// webhookURL returns the slack webhook url passed as the only argument
+// or set with the flag.
+func webhookURL(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		return slackWebhookURLFlag, nil
+	case 1:
+		return args[0], nil
+	default:
+		return "", errors.New("too many arguments")
+	}
+}
+
+func runCommand(args []string) error {
+	url, err := webhookURL(args)
+	if err != nil {
+		return withCode(exitConfig, err)
+	}
+	return start(url)
+}
+
+func statusCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New("too many arguments")
+	}
+	c, err := newConsul()
+	if err != nil {
+		return err
+	}
+	h, err := c.Holder()
+	if err != nil {
+		return err
+	}
+	silences, err := c.Silences()
+	if err != nil {
+		return err
+	}
+	evs, err := c.Failing()
+	if err != nil {
+		return err
+	}
+	b, err := c.LastBeat()
+	if err != nil {
+		return err
+	}
+	shards, err := c.ShardHolders()
+	if err != nil {
+		return err
+	}
+	acks, err := c.Acks()
+	if err != nil {
+		return err
+	}
+	printStatus(os.Stdout, h, silences, evs)
+	printAcks(os.Stdout, acks)
+	for i := 0; i < shardsFlag; i++ {
+		if host, ok := shards[i]; ok {
+			fmt.Printf("shard %d is held by %s\n", i, host)
+		} else {
+			fmt.Printf("shard %d is not held\n", i)
+		}
+	}
+	if b != nil {
+		fmt.Printf("last heartbeat is written by %s at %s\n", b.Host, b.Time.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// printStatus prints the lock holder, silences and failing checks.
+func printStatus(w io.Writer, h *consul.Holder, silences map[string]time.Time, evs []*consul.Event) {
+	if h != nil {
+		fmt.Fprintf(w, "lock is held by %s since %s (session %s)\n", h.Host, h.Since.Format(time.RFC3339), h.Session)
+	} else {
+		fmt.Fprintln(w, "lock is not held")
+	}
+
+	printSilences(w, silences)
+	if len(evs) == 0 {
+		fmt.Fprintln(w, "all checks are passing")
+		return
+	}
+	for _, ev := range evs {
+		if ev.IsNode() {
+			fmt.Fprintf(w, "[%s] node check %s is %s\n", ev.Location(), ev.Name, ev.Status)
+		} else {
+			fmt.Fprintf(w, "[%s] %s is %s\n", ev.Location(), ev.ServiceID, ev.Status)
+		}
+	}
+}
+
+// printSilences prints silenced services sorted by name.
+func printSilences(w io.Writer, silences map[string]time.Time) {
+	services := make([]string, 0, len(silences))
+	for s := range silences {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	for _, s := range services {
+		fmt.Fprintf(w, "%s is silenced until %s\n", s, silences[s].Format(time.RFC3339))
+	}
+}
+
+func stateCommand(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return withCode(exitConfig, errors.New("export or import is required"))
+	}
+	name := "-"
+	if len(args) == 2 {
+		name = args[1]
+	}
+	switch args[0] {
+	case "export", "import":
+	default:
+		return withCode(exitConfig, fmt.Errorf("unknown state command %q", args[0]))
+	}
+	c, err := newConsul()
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "export" {
+		s, err := c.Export()
+		if err != nil {
+			return err
+		}
+		w := io.Writer(os.Stdout)
+		if name != "-" {
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	r := io.Reader(os.Stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var s consul.Snapshot
+	if err = json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+	if h, err := c.Holder(); err != nil {
+		return err
+	} else if h != nil {
+		return fmt.Errorf("lock is held by %s, stop running instances before importing", h.Host)
+	}
+	return c.Import(&s)
+}
+
+func silenceCommand(args []string) error {
+	if len(args) != 0 && len(args) != 2 {
+		return errors.New("service name and duration are required")
+	}
+	c, err := newConsul()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		silences, err := c.Silences()
+		if err != nil {
+			return err
+		}
+		printSilences(os.Stdout, silences)
+		return nil
+	}
+	d, err := time.ParseDuration(args[1])
+	if err != nil {
+		return err
+	}
+	return c.Silence(args[0], d)
+}
+
+func ackCommand(args []string) error {
+	c, err := newConsul()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		acks, err := c.Acks()
+		if err != nil {
+			return err
+		}
+		printAcks(os.Stdout, acks)
+		return nil
+	}
+
+	evs, err := c.Failing()
+	if err != nil {
+		return err
+	}
+	failing := false
+	for _, ev := range evs {
+		if ev.ServiceName == args[0] {
+			failing = true
+			break
+		}
+	}
+	if !failing {
+		return fmt.Errorf("service %s isn't failing", args[0])
+	}
+	by := os.Getenv("USER")
+	if by == "" {
+		by = "unknown"
+	}
+	return c.Acknowledge(args[0], &consul.Ack{By: by, Comment: strings.Join(args[1:], " ")})
+}
+
+// printAcks prints acknowledged services sorted by name.
+func printAcks(w io.Writer, acks map[string]*consul.Ack) {
+	services := make([]string, 0, len(acks))
+	for s := range acks {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	for _, s := range services {
+		fmt.Fprintf(w, "%s is acknowledged by %s at %s", s, acks[s].By, acks[s].Time.Format(time.RFC3339))
+		if acks[s].Comment != "" {
+			fmt.Fprintf(w, ": %s", acks[s].Comment)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func testCommand(args []string) error {
+	url, err := webhookURL(args)
+	if err != nil {
+		return err
+	}
+	if err = setupLogging(); err != nil {
+		return err
+	}
+	targets, err := allTargets(url)
+	if err != nil {
+		return err
+	}
+	if dryRunFlag {
+		dryRun(targets, os.Stdout)
+	} else {
+		http.DefaultClient.Transport = &responseTransport{rt: http.DefaultTransport, w: os.Stdout}
+	}
+	if !sendTest(os.Stdout, targets) {
+		return errors.New("some notifications failed")
+	}
+	return nil
+}
+
+// sendTest sends a test event of every status to the targets one by one
+// and prints delivery results, false is returned when any of them failed.
+func sendTest(w io.Writer, targets []*target) bool {
+	ok := true
+	for _, status := range []string{consul.Passing, consul.Warning, consul.Critical} {
+		prev := consul.Passing
+		if status == consul.Passing {
+			prev = consul.Critical
+		}
+		fmt.Fprintf(w, "%s:\n", status)
+		errs := map[string]error{}
+		dispatch(targets, &consul.Event{
+			Node:        "consul-slack",
+			ServiceID:   "consul-slack-test",
+			ServiceName: "consul-slack-test",
+			Name:        "Test",
+			Status:      status,
+			PrevStatus:  prev,
+			Output:      "test notification sent by consul-slack test",
+		}, func(name string, err error) {
+			errs[name] = err
+		})
+		for _, t := range targets {
+			if err, failed := errs[t.label()]; failed {
+				fmt.Fprintf(w, "  %s: %v\n", t.label(), err)
+				ok = false
+			} else {
+				fmt.Fprintf(w, "  %s: ok\n", t.label())
+			}
+		}
+	}
+	return ok
+}
+
+// responseTransport prints statuses of http responses, so it's
+// clear what notifier endpoints answer, only hosts are printed
+// because webhook urls and bot tokens are secrets.
+type responseTransport struct {
+	rt http.RoundTripper
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *responseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	fmt.Fprintf(t.w, "  %s %s://%s responded %s\n", req.Method, req.URL.Scheme, req.URL.Host, r.Status)
+	t.mu.Unlock()
+	return r, nil
+}
+
+func validateCommand(args []string) error {
+	url, err := webhookURL(args)
+	if err != nil {
+		return err
+	}
+	if err = setupLogging(); err != nil {
+		return err
+	}
+	targets, err := allTargets(url)
+	if err != nil {
+		return err
+	}
+	if err = printRoutes(os.Stdout, targets); err != nil {
+		return err
+	}
+	c, err := newConsul()
+	if err != nil {
+		return err
+	}
+	if err = c.Validate(); err != nil {
+		return err
+	}
+	fmt.Println("consul is reachable and permissions are sufficient")
+	return nil
+}
+
+// printRoutes prints notifiers that events, reminders and summaries are
+// sent to, it fails when an enabled feature has no notifiers to send to.
+func printRoutes(w io.Writer, targets []*target) error {
+	routes := []struct {
+		name    string
+		enabled bool
+		names   []string
+	}{
+		{"events", true, nil},
+		{"reminders", remindIntervalFlag > 0, splitList(remindTargetsFlag)},
+		{"escalations", remindIntervalFlag > 0 && escalateAfterFlag > 0,
+			append(splitList(remindTargetsFlag), splitList(escalateTargetsFlag)...)},
+		{"summaries", summaryIntervalFlag > 0, splitList(summaryTargetsFlag)},
+	}
+	for _, r := range routes {
+		if !r.enabled {
+			continue
+		}
+		selected := targets
+		if r.names != nil {
+			selected = selectTargets(targets, r.names)
+		}
+		if len(selected) == 0 {
+			return fmt.Errorf("%s are enabled but none of %v notifiers is configured", r.name, r.names)
+		}
+		names := make([]string, 0, len(selected))
+		for _, t := range selected {
+			s := t.label()
+			if t.filter != nil {
+				s += " (filtered)"
+			}
+			names = append(names, s)
+		}
+		fmt.Fprintf(w, "%s go to %s\n", r.name, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// newConsul creates a consul client configured by command-line flags.
+func newConsul() (*consul.Consul, error) {
+	if err := setupLogging(); err != nil {
+		return nil, err
+	}
+	opts, err := consulOptions()
+	if err != nil {
+		return nil, err
+	}
+	return consul.New(opts...)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadConfig sets flags that aren't set on the command line from
+// the given file, it consists of HCL-like flag-name = value lines,
+// values may be double-quoted, lines starting with # are comments
+// and repeatable flags like filter can be given multiple times.
+func loadConfig(fs *flag.FlagSet, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 1 {
+			return fmt.Errorf("%s:%d: malformed line, want name = value", name, n)
+		}
+		key, val := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if strings.HasPrefix(val, `"`) {
+			if val, err = strconv.Unquote(val); err != nil {
+				return fmt.Errorf("%s:%d: malformed value: %v", name, n, err)
+			}
+		}
+
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("%s:%d: unknown option %q", name, n, key)
+		}
+		// command-line flags take precedence
+		if set[key] {
+			continue
+		}
+		if err = fs.Set(key, val); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", name, n, key, err)
+		}
+	}
+	return s.Err()
+}
+
+// envPrefix is the prefix of environment variables flags are read from.
+const envPrefix = "CONSUL_SLACK_"
+
+// envName returns the environment variable name of the flag,
+// e.g. CONSUL_SLACK_SLACK_CHANNEL for slack-channel.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// loadEnv sets flags that aren't set on the command line from
+// environment variables, it goes before loadConfig to take precedence.
+func loadEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		val, ok := lookup(envName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if err = fs.Set(f.Name, val); err != nil {
+			err = fmt.Errorf("%s: %v", envName(f.Name), err)
+		}
+	})
+	return err
+}
@@ -0,0 +1,73 @@
+package consul
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const ackKey = "consul-slack/acks"
+
+// Ack is an acknowledgment of a failing service, acks are kept
+// in the KV so all instances share them like silences.
+type Ack struct {
+	By      string    `json:"by"`
+	Time    time.Time `json:"time"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+// Acknowledge acknowledges the failing service, the ack is attached to
+// the recovery event and removed once none of its checks are failing.
+func (c *Consul) Acknowledge(service string, ack *Ack) error {
+	if ack.Time.IsZero() {
+		ack.Time = time.Now()
+	}
+	b, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	_, err = c.api.KV().Put(&api.KVPair{Key: ackKey + "/" + service, Value: b}, nil)
+	return err
+}
+
+// Acks returns acknowledged services along with their acks.
+func (c *Consul) Acks() (map[string]*Ack, error) {
+	pairs, _, err := c.api.KV().List(ackKey+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*Ack, len(pairs))
+	for _, kv := range pairs {
+		if !strings.HasPrefix(kv.Key, ackKey+"/") {
+			continue
+		}
+		var ack Ack
+		if err = json.Unmarshal(kv.Value, &ack); err != nil {
+			return nil, err
+		}
+		m[strings.TrimPrefix(kv.Key, ackKey+"/")] = &ack
+	}
+	return m, nil
+}
+
+// clearAcks removes acks of the resolved services
+// that have no failing checks among hcs anymore.
+func (c *Consul) clearAcks(w *watcher, resolved map[string]bool, hcs map[string]*api.HealthCheck) error {
+	if c.readOnly {
+		return nil
+	}
+	for _, hc := range hcs {
+		if hc.Status == Warning || hc.Status == Critical {
+			delete(resolved, hc.ServiceName)
+		}
+	}
+	for service := range resolved {
+		if _, err := c.api.KV().Delete(ackKey+"/"+service, nil); err != nil {
+			return err
+		}
+		c.logf("%s%s: acknowledgment cleared", w.prefix(), service)
+	}
+	return nil
+}
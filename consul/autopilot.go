@@ -0,0 +1,140 @@
+package consul
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Check ids of events about health of servers and the failure
+// tolerance autopilot reports, see WithAutopilotMonitoring.
+const (
+	AutopilotCheckID        = "consul-slack/autopilot"
+	FailureToleranceCheckID = "consul-slack/failure-tolerance"
+)
+
+// WithAutopilotMonitoring polls autopilot health every interval and sends
+// critical events when a server becomes unhealthy or the failure tolerance
+// of the datacenter drops to zero and passing ones when autopilot considers
+// it healthy again, that is it's been stable for the stabilization time.
+// Servers removed while unhealthy are reported deleted, zero disables it.
+func WithAutopilotMonitoring(interval time.Duration) Option {
+	return func(c *Consul) {
+		c.autopilotInterval = interval
+	}
+}
+
+// watchAutopilot reports changes of autopilot health every autopilot interval until stopped.
+func (c *Consul) watchAutopilot() {
+	defer c.wg.Done()
+	statuses := map[string]string{}
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-c.failCh:
+			return
+		case <-time.After(c.autopilotInterval):
+		}
+		// with shards it's up to the holder of the first one
+		if !c.Active() || c.shards > 0 && c.shardEpoch(0) == 0 {
+			continue
+		}
+
+		evs, err := c.autopilotEvents(statuses)
+		if err != nil {
+			// it's retried on the next run
+			c.warnf("autopilot health error: %v", err)
+			continue
+		}
+		for _, ev := range evs {
+			c.logf("%s: %s -> %s", ev.ID, ev.PrevStatus, ev.Status)
+			if !c.send(ev) {
+				return
+			}
+		}
+	}
+}
+
+// autopilotEvents returns events about servers and the failure tolerance that
+// changed their statuses, statuses keeps the last ones by event id.
+func (c *Consul) autopilotEvents(statuses map[string]string) ([]*Event, error) {
+	dc, err := c.localDatacenter()
+	if err != nil {
+		return nil, err
+	}
+	// the endpoint responds with 429 when the cluster is unhealthy
+	h, err := c.api.Operator().AutopilotServerHealth(withOKStatus(&api.QueryOptions{}, http.StatusTooManyRequests))
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[string]*Event{}
+	for _, s := range h.Servers {
+		lastContact := "none"
+		if s.LastContact != nil {
+			lastContact = s.LastContact.String()
+		}
+		ev := &Event{
+			Node:    s.Name,
+			CheckID: AutopilotCheckID,
+			Name:    "autopilot",
+			ID:      AutopilotCheckID + "/" + s.Name,
+			Status:  Passing,
+			Output: fmt.Sprintf("serf %s, voter %t, last contact %s, last index %d, stable since %s",
+				s.SerfStatus, s.Voter, lastContact, s.LastIndex, s.StableSince.Format(time.RFC3339)),
+		}
+		if !s.Healthy {
+			ev.Status = Critical
+		}
+		current[ev.ID] = ev
+	}
+	ft := &Event{
+		CheckID: FailureToleranceCheckID,
+		Name:    "failure tolerance",
+		ID:      FailureToleranceCheckID,
+		Status:  Passing,
+		Output:  fmt.Sprintf("%d servers can fail without an outage", h.FailureTolerance),
+	}
+	if h.FailureTolerance <= 0 {
+		ft.Status = Critical
+	}
+	current[ft.ID] = ft
+
+	var evs []*Event
+	for id, ev := range current {
+		prev, seen := statuses[id]
+		statuses[id] = ev.Status
+		if prev == ev.Status || !seen && ev.Status == Passing {
+			continue
+		}
+		ev.PrevStatus = prev
+		evs = append(evs, ev)
+	}
+	for id, prev := range statuses {
+		if _, ok := current[id]; ok {
+			continue
+		}
+		delete(statuses, id)
+		if prev != Passing {
+			evs = append(evs, &Event{
+				Node:       id[len(AutopilotCheckID)+1:],
+				CheckID:    AutopilotCheckID,
+				Name:       "autopilot",
+				ID:         id,
+				Status:     Deleted,
+				PrevStatus: prev,
+			})
+		}
+	}
+	for _, ev := range evs {
+		ev.Datacenter = dc
+	}
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].ID < evs[j].ID
+	})
+	return evs, nil
+}
@@ -0,0 +1,299 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policies of a full events buffer, see WithEventBuffer.
+const (
+	// OverflowBlock makes watching wait until events are read.
+	OverflowBlock = "block"
+
+	// OverflowDropOldest drops the oldest buffered event to make room for
+	// a new one, a summary of dropped events is read once there's room.
+	OverflowDropOldest = "drop-oldest"
+
+	// OverflowSpill appends events to the spill file and reads them back
+	// in order, see WithSpillFile. Events left in it are lost on restarts.
+	OverflowSpill = "spill"
+)
+
+// droppedCheckID is the check id of dropped events summaries.
+const droppedCheckID = "consul-slack/dropped"
+
+// maxDroppedNames is the maximum number of services listed in dropped events summaries.
+const maxDroppedNames = 10
+
+// WithEventBuffer buffers up to size events that haven't been read
+// with Next yet, so a slow notifier doesn't hold up watching, overflow
+// is what happens when the buffer is full. Events aren't buffered and
+// watching waits for every one of them to be read by default.
+func WithEventBuffer(size int, overflow string) Option {
+	return func(c *Consul) {
+		c.bufferSize = size
+		c.overflow = overflow
+	}
+}
+
+// WithSpillFile sets the file events are spilled to with OverflowSpill,
+// it's truncated when the client is created.
+func WithSpillFile(name string) Option {
+	return func(c *Consul) {
+		c.spillFile = name
+	}
+}
+
+// EventsDropped returns number of events dropped since
+// the client has been created, see OverflowDropOldest.
+func (c *Consul) EventsDropped() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// setupBuffer validates buffering options and creates the events buffer.
+func (c *Consul) setupBuffer() error {
+	if c.bufferSize < 0 {
+		return fmt.Errorf("consul: negative event buffer size %d", c.bufferSize)
+	}
+	switch c.overflow {
+	case "", OverflowBlock, OverflowDropOldest:
+	case OverflowSpill:
+		if c.spillFile == "" {
+			return errors.New("consul: spill overflow policy needs a spill file")
+		}
+		s, err := openSpill(c.spillFile)
+		if err != nil {
+			return fmt.Errorf("consul: %v", err)
+		}
+		c.spill = s
+	default:
+		return fmt.Errorf("consul: unknown overflow policy %q", c.overflow)
+	}
+	c.events = make(chan *Event, c.bufferSize)
+	return nil
+}
+
+// enqueue adds the event to the events buffer according to the overflow
+// policy, false is returned when watching is stopped.
+func (c *Consul) enqueue(ev *Event) bool {
+	switch c.overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case c.events <- ev:
+				return true
+			case <-c.stopCh:
+				return false
+			case <-c.failCh:
+				return false
+			default:
+			}
+			select {
+			case old := <-c.events:
+				c.drop(old)
+			default:
+			}
+		}
+	case OverflowSpill:
+		err := c.spill.push(ev, c.events)
+		if err == nil {
+			return true
+		}
+		c.warnf("spill event: %v", err)
+	}
+	select {
+	case c.events <- ev:
+		return true
+	case <-c.stopCh:
+		return false
+	case <-c.failCh:
+		return false
+	}
+}
+
+// drop counts the dropped event for the summary.
+func (c *Consul) drop(ev *Event) {
+	name := ev.ServiceName
+	if name == "" {
+		name = ev.Node + "/" + ev.Name
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropped++
+	if c.droppedNames == nil {
+		c.droppedNames = map[string]bool{}
+	}
+	c.droppedNames[name] = true
+}
+
+// droppedSummary returns a summary of events dropped since
+// the previous call, nil is returned when none have been.
+func (c *Consul) droppedSummary() *Event {
+	c.mu.Lock()
+	n := c.dropped - c.droppedReported
+	names := make([]string, 0, len(c.droppedNames))
+	for name := range c.droppedNames {
+		names = append(names, name)
+	}
+	c.droppedReported, c.droppedNames = c.dropped, nil
+	c.mu.Unlock()
+	if n == 0 {
+		return nil
+	}
+
+	sort.Strings(names)
+	if len(names) > maxDroppedNames {
+		names = append(names[:maxDroppedNames], fmt.Sprintf("%d more", len(names)-maxDroppedNames))
+	}
+	return &Event{
+		Node:    c.hostname,
+		CheckID: droppedCheckID,
+		Name:    "consul-slack",
+		Status:  Warning,
+		Output: fmt.Sprintf("%d events have been dropped because notifications are delivered too slowly: %s",
+			n, strings.Join(names, ", ")),
+		Cluster: c.cluster,
+		Time:    time.Now(),
+	}
+}
+
+// unspill moves spilled events to the events buffer in order.
+func (c *Consul) unspill() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.spill.ready:
+		case <-c.stopCh:
+			return
+		case <-c.failCh:
+			return
+		}
+		for {
+			ev, err := c.spill.peek()
+			if err != nil {
+				c.warnf("read spilled event: %v", err)
+				c.spill.reset()
+				break
+			}
+			if ev == nil {
+				break
+			}
+			select {
+			case c.events <- ev:
+				c.spill.pop()
+			case <-c.stopCh:
+				return
+			case <-c.failCh:
+				return
+			}
+		}
+	}
+}
+
+// spill is a file events are appended to when the events buffer is full.
+type spill struct {
+	mu    sync.Mutex
+	w     *os.File
+	r     *os.File
+	dec   *json.Decoder
+	next  *Event // decoded but not yet moved to the buffer
+	n     int    // number of spilled events including next
+	ready chan struct{}
+}
+
+// openSpill opens and truncates the named spill file.
+func openSpill(name string) (*spill, error) {
+	w, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r, err := os.Open(name)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &spill{w: w, r: r, dec: json.NewDecoder(r), ready: make(chan struct{}, 1)}, nil
+}
+
+// push sends the event to the events buffer when it has room and nothing
+// is spilled, otherwise it's appended to the file to keep events in order.
+func (s *spill) push(ev *Event, events chan<- *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 {
+		select {
+		case events <- ev:
+			return nil
+		default:
+		}
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err = s.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	s.n++
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// peek returns the oldest spilled event, nil when there are none.
+func (s *spill) peek() (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 || s.next != nil {
+		return s.next, nil
+	}
+	var ev Event
+	if err := s.dec.Decode(&ev); err != nil {
+		return nil, err
+	}
+	s.next = &ev
+	return s.next, nil
+}
+
+// pop removes the oldest spilled event, the file is
+// truncated once all of them are moved to the buffer.
+func (s *spill) pop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = nil
+	s.n--
+	if s.n == 0 {
+		s.truncate()
+	}
+}
+
+// reset drops all spilled events after a read failure.
+func (s *spill) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next, s.n = nil, 0
+	s.truncate()
+}
+
+// truncate empties the file, s.mu must be held.
+func (s *spill) truncate() {
+	s.w.Truncate(0)
+	s.r.Seek(0, 0)
+	s.dec = json.NewDecoder(s.r)
+}
+
+// close closes the spill file.
+func (s *spill) close() error {
+	s.w.Close()
+	return s.r.Close()
+}
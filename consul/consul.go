@@ -1,13 +1,24 @@
 package consul
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/amenzhinsky/consul-slack/tracing"
 	"github.com/hashicorp/consul/api"
 )
 
@@ -19,20 +30,40 @@ const (
 // Option is a configuration option.
 type Option func(c *Consul)
 
-// WithAddress sets consul address.
+// WithAddress sets consul address, it can be unix:///path/to/socket,
+// CONSUL_HTTP_ADDR is used when it's empty.
 func WithAddress(address string) Option {
 	return func(c *Consul) {
 		c.address = address
 	}
 }
 
-// WithScheme sets consul connection scheme http or https.
+// WithScheme sets consul connection scheme http or https,
+// CONSUL_HTTP_SSL is used when it's empty.
 func WithScheme(schema string) Option {
 	return func(c *Consul) {
 		c.scheme = schema
 	}
 }
 
+// WithBasicAuth sets http basic auth credentials for
+// clusters behind authenticating reverse proxies.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Consul) {
+		c.httpAuth = &api.HttpBasicAuth{
+			Username: username,
+			Password: password,
+		}
+	}
+}
+
+// WithToken sets the acl token, CONSUL_HTTP_TOKEN is used when it's empty.
+func WithToken(token string) Option {
+	return func(c *Consul) {
+		c.token = token
+	}
+}
+
 // WithDatacenter sets datacenter name.
 func WithDatacenter(dc string) Option {
 	return func(c *Consul) {
@@ -40,6 +71,502 @@ func WithDatacenter(dc string) Option {
 	}
 }
 
+// AllDatacenters makes WithDatacenters watch every known datacenter.
+const AllDatacenters = "all"
+
+// WithDatacenters sets list of datacenters to watch, each one has its own
+// watcher and state, it can be AllDatacenters, by default only
+// the datacenter set by WithDatacenter (or the agent's one) is watched.
+func WithDatacenters(dcs ...string) Option {
+	return func(c *Consul) {
+		c.datacenters = dcs
+	}
+}
+
+// minInterval is the minimum allowed interval between health queries.
+const minInterval = 100 * time.Millisecond
+
+// WithInterval sets the minimum interval between health queries, changes
+// are picked up with blocking queries so it's only a fallback limiting
+// request rate when blocking queries return immediately.
+func WithInterval(d time.Duration) Option {
+	return func(c *Consul) {
+		c.interval = d
+	}
+}
+
+// WithJitter adds a random delay up to d to every interval between health
+// queries and retry, so instances and watchers started at the same time
+// don't query consul servers in lockstep.
+func WithJitter(d time.Duration) Option {
+	return func(c *Consul) {
+		c.jitter = d
+	}
+}
+
+// Health queries consistency modes, see consul consistency docs.
+const (
+	// ConsistencyDefault is served by the leader but may be
+	// stale for a short window during leader elections.
+	ConsistencyDefault = "default"
+
+	// ConsistencyStale can be served by any server including followers,
+	// it reduces the leader load in large clusters.
+	ConsistencyStale = "stale"
+
+	// ConsistencyConsistent makes the leader verify its
+	// leadership with a quorum before serving requests.
+	ConsistencyConsistent = "consistent"
+)
+
+// WithConsistency sets health queries consistency mode,
+// it's ConsistencyDefault when not set.
+func WithConsistency(mode string) Option {
+	return func(c *Consul) {
+		c.consistency = mode
+	}
+}
+
+// WithSessionTTL sets TTL of the session holding the lock, the lower it is
+// the faster a standby instance takes over, consul requires it to be
+// between 10s and 24h, default is 15s.
+func WithSessionTTL(d time.Duration) Option {
+	return func(c *Consul) {
+		c.sessionTTL = d
+	}
+}
+
+// WithLockDelay sets the lock-delay of the session, for how long consul refuses
+// to give the lock to another session after the session expires without
+// releasing it, so an active instance that's stuck rather than dead has time
+// to notice it's lost the lock. The lock is released when stopping normally,
+// so standby instances take over right away then. It must be between 0s
+// and 60s, default is 1s.
+func WithLockDelay(d time.Duration) Option {
+	return func(c *Consul) {
+		c.lockDelay = d
+	}
+}
+
+// WithRenewInterval sets how often the session is renewed,
+// it must be less than the session TTL, default is half of it.
+func WithRenewInterval(d time.Duration) Option {
+	return func(c *Consul) {
+		c.renewInterval = d
+	}
+}
+
+// WithWaitTime sets maximum duration of blocking health and lock queries,
+// longer waits mean fewer requests to idle clusters but delay Close,
+// consul caps it at 10m, default is 5s.
+func WithWaitTime(d time.Duration) Option {
+	return func(c *Consul) {
+		c.waitTime = d
+	}
+}
+
+// WithBackoff sets minimum and maximum delays between retries of
+// failed consul requests, the delay doubles after each failure,
+// default is 1s-1m.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Consul) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithServices sets service names or regular expressions to watch
+// and to ignore, by default all services are watched.
+func WithServices(watch, ignore []string) Option {
+	return func(c *Consul) {
+		c.watchServices = watch
+		c.ignoreServices = ignore
+	}
+}
+
+// WithServiceWatchers makes every service set by WithServices watched
+// by its own blocking query in parallel instead of a single query of all
+// health checks, it scales better when only a few services of a big
+// cluster are watched. Services have to be plain names then, node-level
+// checks aren't reported and filter expressions aren't applied.
+func WithServiceWatchers(enabled bool) Option {
+	return func(c *Consul) {
+		c.serviceWatchers = enabled
+	}
+}
+
+// WithAgentCache makes health reads of service watchers and peers
+// served from the local agent cache that's refreshed in the background,
+// it cuts load on consul servers of large fleets. maxAge limits
+// staleness of cached responses when it's positive.
+func WithAgentCache(enabled bool, maxAge time.Duration) Option {
+	return func(c *Consul) {
+		c.useCache = enabled
+		c.cacheMaxAge = maxAge
+	}
+}
+
+// WithExternalNodes marks events of external nodes registered by
+// consul-esm with the External field, they're checked by esm
+// instead of a local agent so they don't have serfHealth checks.
+func WithExternalNodes(enabled bool) Option {
+	return func(c *Consul) {
+		c.externalNodes = enabled
+	}
+}
+
+// WithStateGC makes state saved by watchers that are no longer
+// configured, e.g. of removed datacenters, peers or watched services,
+// dropped every interval, with notify Deleted events are sent for
+// their failing checks. State of configured watchers is reconciled
+// with health checks on every poll so it never goes stale.
+func WithStateGC(interval time.Duration, notify bool) Option {
+	return func(c *Consul) {
+		c.gcInterval = interval
+		c.gcNotify = notify
+	}
+}
+
+// WithReadOnly makes the instance active right away without creating
+// a session and acquiring the lock, the saved state is loaded but never
+// written and state garbage collection is disabled, so it can run next
+// to the active instance without interfering with it.
+func WithReadOnly(enabled bool) Option {
+	return func(c *Consul) {
+		c.readOnly = enabled
+	}
+}
+
+// WithCluster labels events with the cluster name, so events
+// of several clusters watched in one process can be told apart.
+func WithCluster(name string) Option {
+	return func(c *Consul) {
+		c.cluster = name
+	}
+}
+
+// WithProfile keeps the state and the lock of the watch profile under
+// their own keys, so several watchers with different options can watch
+// the same cluster independently, e.g. noisy low priority services and
+// quiet critical ones. Its events are usually labeled with WithCluster.
+func WithProfile(name string) Option {
+	return func(c *Consul) {
+		c.profile = name
+	}
+}
+
+// WithShards splits watched checks into n shards by service or node name,
+// every shard is watched by one of the running instances that acquires its
+// own lock, so several instances share the load instead of one watching
+// everything. Shards are spread evenly among instances with rendezvous hashing
+// and move to other instances when one fails or a new one joins.
+//
+// All instances have to use the same number of shards, it cannot be
+// combined with the read-only mode, dependencies and node down suppression
+// that need to see all checks at once.
+func WithShards(n int) Option {
+	return func(c *Consul) {
+		c.shards = n
+	}
+}
+
+// WithMaintenanceSuppression makes checks of services and nodes in
+// maintenance mode, including node checks like serfHealth, ignored
+// while it lasts, their last status before maintenance is kept so
+// only checks that end up in a different status are reported after.
+func WithMaintenanceSuppression(enabled bool) Option {
+	return func(c *Consul) {
+		c.suppressMaint = enabled
+	}
+}
+
+// WithNodeDownSuppression makes service checks of nodes with critical
+// serfHealth ignored like WithMaintenanceSuppression does, services
+// of the node are listed in the Dependents field of the serfHealth event.
+func WithNodeDownSuppression(enabled bool) Option {
+	return func(c *Consul) {
+		c.suppressNodeDown = enabled
+	}
+}
+
+// WithNodeGrouping makes status changes of checks of the same node detected
+// in one poll sent as a single event with the rest in its Grouped field.
+func WithNodeGrouping(enabled bool) Option {
+	return func(c *Consul) {
+		c.groupNodes = enabled
+	}
+}
+
+// WithDependencies sets upstream services every service depends on,
+// while an upstream is critical checks of its dependents are ignored
+// like WithMaintenanceSuppression does and the failing ones are listed
+// in the Dependents field of the event about the upstream going critical.
+func WithDependencies(deps map[string][]string) Option {
+	return func(c *Consul) {
+		c.dependencies = deps
+	}
+}
+
+// WithServiceAggregation makes services reported as a whole instead
+// of every instance on its own, with the worst status of instances and
+// output like "3/5 instances are critical on nodes a, b, c".
+func WithServiceAggregation(enabled bool) Option {
+	return func(c *Consul) {
+		c.aggregateServices = enabled
+	}
+}
+
+// WithInstanceThreshold makes services reported as a whole like
+// WithServiceAggregation does, but a service is critical when more than
+// n of its instances are critical, or n percent of them when percent
+// is true, and it recovers when they drop back to n or below.
+func WithInstanceThreshold(n int, percent bool) Option {
+	return func(c *Consul) {
+		c.aggregateServices = true
+		c.thresholdEnabled = true
+		c.threshold = n
+		c.thresholdPercent = percent
+	}
+}
+
+// WithMinSeverity makes status changes reported only when the new or
+// the previous status is at least as severe as the given one, with Critical
+// warnings and their recoveries are ignored while criticals turning into
+// warnings are still reported. Default is Warning that reports everything.
+func WithMinSeverity(status string) Option {
+	return func(c *Consul) {
+		c.minSeverity = status
+	}
+}
+
+// WithStateSeeding makes an empty state, e.g. of a fresh deployment, seeded
+// with current statuses of checks without reporting them, so checks failing
+// already aren't reported all at once and only subsequent changes are.
+func WithStateSeeding(enabled bool) Option {
+	return func(c *Consul) {
+		c.seedState = enabled
+	}
+}
+
+// WithSeedSummary makes an empty state seeded like WithStateSeeding does
+// and passes checks failing already to fn instead of reporting them one
+// by one, e.g. to post a single summary on startup. It's called by every
+// watcher whose state is seeded with at least one failing check.
+func WithSeedSummary(fn func(evs []*Event)) Option {
+	return func(c *Consul) {
+		c.seedState, c.seedSummary = true, fn
+	}
+}
+
+// WithUnknownStatusDrop makes changes to statuses consul-slack doesn't
+// know about, e.g. ones added by newer consul releases, recorded without
+// being reported, by default they're reported as they are. Either way
+// they're logged as warnings.
+func WithUnknownStatusDrop(enabled bool) Option {
+	return func(c *Consul) {
+		c.dropUnknown = enabled
+	}
+}
+
+// WithNoiseFilters sets regular expressions matched anywhere in outputs and
+// notes of failing checks, e.g. known benign timeouts, changes to a matching
+// failing status are neither reported nor saved, so recoveries from them
+// aren't reported either, see NoiseSuppressed.
+func WithNoiseFilters(patterns ...string) Option {
+	return func(c *Consul) {
+		c.noisePatterns = patterns
+	}
+}
+
+// WithRedaction sets regular expressions of secrets replaced in outputs
+// and notes of checks before events leave the package, e.g. DefaultRedactions,
+// only the first group is replaced when a pattern has one.
+func WithRedaction(patterns ...string) Option {
+	return func(c *Consul) {
+		c.redactPatterns = patterns
+	}
+}
+
+// WithConfirmations makes a warning or critical status reported only
+// after it's observed on n consecutive polls, it eliminates blips of
+// slow or flaky checks, while it's pending checks are polled every
+// interval. Default is 1 that reports every status change at once.
+func WithConfirmations(n int) Option {
+	return func(c *Consul) {
+		c.confirmations = n
+	}
+}
+
+// WithRecoveryConfirmations makes recoveries of warning and critical
+// checks reported only after the check is observed passing on n consecutive
+// polls, so checks going back and forth aren't announced as recovered too
+// early. Default is 1 that reports recoveries at once.
+func WithRecoveryConfirmations(n int) Option {
+	return func(c *Consul) {
+		c.recoveryConfirmations = n
+	}
+}
+
+// WithNodes sets node names or regular expressions to watch and to ignore,
+// e.g. "canary-.*" to skip canary nodes, by default all nodes are watched.
+func WithNodes(watch, ignore []string) Option {
+	return func(c *Consul) {
+		c.watchNodes = watch
+		c.ignoreNodes = ignore
+	}
+}
+
+// WithFilter sets a filter expression applied by consul to health
+// checks of local datacenters, e.g. `ServiceTags contains "prod"`,
+// it reduces payload size of big clusters, requires consul 1.5+.
+func WithFilter(expr string) Option {
+	return func(c *Consul) {
+		c.filter = expr
+	}
+}
+
+// WithPartition sets admin partition of all requests including
+// the lock and state ones, so every partition needs its own
+// instance, it's a consul enterprise feature.
+func WithPartition(name string) Option {
+	return func(c *Consul) {
+		c.partition = name
+	}
+}
+
+// WithPeers sets cluster peers whose imported services are watched too,
+// events of them have the Peer field set. Unlike local services they're
+// polled every interval because health of imported services can't be
+// watched with a single blocking query.
+func WithPeers(peers ...string) Option {
+	return func(c *Consul) {
+		c.peers = peers
+	}
+}
+
+// WithNodeMeta limits watched checks to nodes having all of
+// the given metadata key/value pairs, e.g. env=prod.
+func WithNodeMeta(meta map[string]string) Option {
+	return func(c *Consul) {
+		c.nodeMeta = meta
+	}
+}
+
+// WithServiceMeta sets service meta fields looked up in the catalog
+// and attached to events of services, e.g. owner or runbook_url,
+// instances missing a field are reported without it.
+func WithServiceMeta(keys ...string) Option {
+	return func(c *Consul) {
+		c.serviceMeta = keys
+	}
+}
+
+// WithNodeChecks enables or disables events of node-level checks
+// like serfHealth, they're enabled by default.
+func WithNodeChecks(enabled bool) Option {
+	return func(c *Consul) {
+		c.nodeChecks = enabled
+	}
+}
+
+// WithPerCheck makes every service check tracked and reported on its own
+// instead of aggregating statuses of all checks of a service instance.
+func WithPerCheck(enabled bool) Option {
+	return func(c *Consul) {
+		c.perCheck = enabled
+	}
+}
+
+// WithOutputChanges makes failing checks reported again when their output
+// changes without a status change, e.g. a disk filling up further. Hashes
+// of reported outputs are saved with the state, so byte-identical outputs
+// are never reported twice during an incident, even after a failover.
+func WithOutputChanges(enabled bool) Option {
+	return func(c *Consul) {
+		c.outputChanges = enabled
+	}
+}
+
+// WithOutputIgnore sets regular expressions of parts of outputs ignored
+// when they're compared by WithOutputChanges, e.g. timestamps and latencies,
+// so only material changes are reported.
+func WithOutputIgnore(patterns ...string) Option {
+	return func(c *Consul) {
+		c.ignorePatterns = patterns
+	}
+}
+
+// WithRegistrations enables Added and Deleted events for all
+// services, by default only failing services deregistration is reported.
+func WithRegistrations(enabled bool) Option {
+	return func(c *Consul) {
+		c.registrations = enabled
+	}
+}
+
+// WithLockEvents enables LockAcquired and LockLost events sent when
+// this instance acquires or loses the lock, so it's clear which one
+// of multiple instances is active after a failover.
+func WithLockEvents(enabled bool) Option {
+	return func(c *Consul) {
+		c.lockEvents = enabled
+	}
+}
+
+// WithHeartbeat sets a function called after every successful poll of
+// consul, active reports whether it's a watcher of the lock holder or
+// a standby waiting for the lock, that makes it suitable for watchdogs.
+// It's called concurrently by all watchers and has to be fast.
+func WithHeartbeat(fn func(active bool)) Option {
+	return func(c *Consul) {
+		c.heartbeat = fn
+	}
+}
+
+// WithTracer records polls of consul and comparisons of their results
+// with the state as spans, events carry the span of the comparison
+// they've been detected by in Trace.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(c *Consul) {
+		c.tracer = t
+	}
+}
+
+// Level is a logging level.
+type Level int
+
+// Logging levels, messages below the configured level are dropped.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// ParseLevel parses a logging level name.
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("consul: unknown log level %q", s)
+	}
+}
+
+// WithLogLevel sets minimum level of logged messages, default is LevelInfo,
+// lock and session internals are logged at LevelDebug, retried errors at LevelWarn.
+func WithLogLevel(level Level) Option {
+	return func(c *Consul) {
+		c.level = level
+	}
+}
+
 // WithLogger sets logger.
 func WithLogger(l *log.Logger) Option {
 	return func(c *Consul) {
@@ -50,10 +577,21 @@ func WithLogger(l *log.Logger) Option {
 // New creates new consul client
 func New(opts ...Option) (*Consul, error) {
 	c := &Consul{
-		events:    make(chan *Event),
-		stopCh:    make(chan struct{}),
-		stoppedCh: make(chan struct{}),
-		logger:    log.New(os.Stdout, "[consul] ", log.LstdFlags),
+		events:      make(chan *Event),
+		stopCh:      make(chan struct{}),
+		stoppedCh:   make(chan struct{}),
+		failCh:      make(chan struct{}),
+		lockCh:      make(chan *Event, 4),
+		interval:    time.Second,
+		consistency: ConsistencyDefault,
+		sessionTTL:  15 * time.Second,
+		lockDelay:   time.Second,
+		waitTime:    5 * time.Second,
+		minBackoff:  time.Second,
+		maxBackoff:  time.Minute,
+		nodeChecks:  true,
+		logger:      log.New(os.Stdout, "[consul] ", log.LstdFlags),
+		level:       LevelInfo,
 	}
 
 	// apply configuration options
@@ -61,197 +599,1458 @@ func New(opts ...Option) (*Consul, error) {
 		opt(c)
 	}
 
+	if c.sessionTTL < 10*time.Second || c.sessionTTL > 24*time.Hour {
+		return nil, fmt.Errorf("consul: session ttl %s is out of 10s-24h range", c.sessionTTL)
+	}
+	if c.lockDelay < 0 || c.lockDelay > time.Minute {
+		return nil, fmt.Errorf("consul: lock delay %s is out of 0s-60s range", c.lockDelay)
+	}
+	// blocking queries return immediately when the index goes
+	// backwards, a too short interval spins the loop then
+	if c.interval < minInterval {
+		return nil, fmt.Errorf("consul: interval %s is less than %s", c.interval, minInterval)
+	}
+	if c.wanInterval != 0 && c.wanInterval < minInterval {
+		return nil, fmt.Errorf("consul: wan interval %s is less than %s", c.wanInterval, minInterval)
+	}
+	if c.raftInterval != 0 && c.raftInterval < minInterval {
+		return nil, fmt.Errorf("consul: raft interval %s is less than %s", c.raftInterval, minInterval)
+	}
+	if c.autopilotInterval != 0 && c.autopilotInterval < minInterval {
+		return nil, fmt.Errorf("consul: autopilot interval %s is less than %s", c.autopilotInterval, minInterval)
+	}
+	if c.profile != "" && c.shards > 0 {
+		return nil, errors.New("consul: profiles cannot be sharded")
+	}
+	if c.selfName != "" && c.selfTTL <= c.waitTime {
+		return nil, fmt.Errorf("consul: self check ttl %s has to be longer than wait time %s", c.selfTTL, c.waitTime)
+	}
+	if c.waitTime <= 0 || c.waitTime > 10*time.Minute {
+		return nil, fmt.Errorf("consul: wait time %s is out of consul's (0s-10m] range", c.waitTime)
+	}
+	if c.renewInterval == 0 {
+		c.renewInterval = c.sessionTTL / 2
+	}
+	if c.renewInterval < 0 || c.renewInterval >= c.sessionTTL {
+		return nil, fmt.Errorf("consul: renew interval %s must be less than session ttl", c.renewInterval)
+	}
+	switch c.minSeverity {
+	case "", Warning, Critical:
+	default:
+		return nil, fmt.Errorf("consul: min severity %q is neither warning nor critical", c.minSeverity)
+	}
+	if c.thresholdEnabled && (c.threshold < 0 || c.thresholdPercent && c.threshold >= 100) {
+		return nil, fmt.Errorf("consul: instance threshold %d is out of range", c.threshold)
+	}
+	if c.shards < 0 {
+		return nil, fmt.Errorf("consul: negative number of shards %d", c.shards)
+	}
+	if c.shards > 0 && (c.readOnly || len(c.dependencies) != 0 || c.suppressNodeDown) {
+		return nil, errors.New("consul: shards cannot be combined with read-only mode, dependencies and node down suppression")
+	}
+	if c.minBackoff <= 0 || c.maxBackoff < c.minBackoff {
+		return nil, fmt.Errorf("consul: invalid backoff range %s-%s", c.minBackoff, c.maxBackoff)
+	}
+
+	switch c.consistency {
+	case ConsistencyDefault, ConsistencyStale, ConsistencyConsistent:
+	default:
+		return nil, fmt.Errorf("consul: unknown consistency mode %q", c.consistency)
+	}
+
+	if c.serviceWatchers {
+		if len(c.watchServices) == 0 {
+			return nil, errors.New("consul: service watchers need services to watch")
+		}
+		for _, s := range c.watchServices {
+			if regexp.QuoteMeta(s) != s {
+				return nil, fmt.Errorf("consul: service watchers need plain service names, got %q", s)
+			}
+		}
+	}
+
 	var err error
+	c.services, err = newMatcher(c.watchServices, c.ignoreServices)
+	if err != nil {
+		return nil, err
+	}
+	c.nodes, err = newMatcher(c.watchNodes, c.ignoreNodes)
+	if err != nil {
+		return nil, err
+	}
+	if c.noise, err = compilePatterns(c.noisePatterns); err != nil {
+		return nil, err
+	}
+	c.noiseCounts = map[string]uint64{}
+	if c.outputIgnore, err = compilePatterns(c.ignorePatterns); err != nil {
+		return nil, err
+	}
+	if c.redactions, err = compilePatterns(c.redactPatterns); err != nil {
+		return nil, err
+	}
+	if err = c.setupBuffer(); err != nil {
+		return nil, err
+	}
+
 	c.api, err = connect(c)
 	if err != nil {
 		return nil, err
 	}
+	if c.hostname, err = os.Hostname(); err != nil {
+		c.hostname = "unknown"
+	}
 
-	if err = c.createSession(); err != nil {
+	dcs, err := c.watchedDatacenters()
+	if err != nil {
 		return nil, err
 	}
 
-	go c.watch()
-	return c, nil
+	local, err := c.localDatacenter()
+	if err != nil {
+		return nil, err
+	}
+
+	ws := make([]*watcher, 0, len(dcs)+len(c.peers))
+	for _, dc := range dcs {
+		w := watcher{dc: dc, datacenter: dc, partition: c.partition, profile: c.profile}
+		if dc == "" {
+			w.datacenter = local
+		}
+		if !c.serviceWatchers {
+			ws = append(ws, &w)
+			continue
+		}
+		for _, s := range c.watchServices {
+			sw := w
+			sw.service = s
+			ws = append(ws, &sw)
+		}
+	}
+	for _, peer := range c.peers {
+		ws = append(ws, &watcher{peer: peer, datacenter: local, partition: c.partition, profile: c.profile})
+	}
+	if c.shards > 0 {
+		sharded := make([]*watcher, 0, len(ws)*c.shards)
+		for _, w := range ws {
+			for i := 0; i < c.shards; i++ {
+				sw := *w
+				sw.sharded, sw.shard = true, i
+				sharded = append(sharded, &sw)
+			}
+		}
+		ws = sharded
+		c.shardMu = make([]sync.RWMutex, c.shards)
+		c.shardEpochs = make([]uint64, c.shards)
+	}
+
+	c.watchers = ws
+	return c, nil
+}
+
+// Run acquires the lock and watches health checks until ctx is canceled,
+// Close is called or watching fails, events are read with Next.
+// The returned error is the one watching failed with, nil otherwise.
+func (c *Consul) Run(ctx context.Context) error {
+	c.mu.Lock()
+	if c.running || c.handling {
+		c.mu.Unlock()
+		return errors.New("consul: already running")
+	}
+	c.running = true
+	c.mu.Unlock()
+
+	// watchers beat as soon as they're started
+	if c.selfName != "" {
+		c.selfBeats = make(chan bool, 1)
+	}
+
+	switch {
+	case c.readOnly:
+		c.epoch++
+		c.setActive(true)
+	case c.shards > 0:
+		// watchers of shards that aren't held are idle
+		c.wg.Add(1)
+		go c.balance()
+	default:
+		// watchers are paused until the lock is acquired
+		c.lockMu.Lock()
+		go c.lock()
+	}
+
+	c.wg.Add(len(c.watchers) + 1)
+	for _, w := range c.watchers {
+		go c.watch(w)
+	}
+	go c.forwardLockEvents()
+	if c.spill != nil {
+		c.wg.Add(1)
+		go c.unspill()
+	}
+	if c.gcInterval > 0 && !c.readOnly {
+		keys := make(map[string]bool, len(c.watchers))
+		for _, w := range c.watchers {
+			keys[w.stateKey()] = true
+		}
+		c.wg.Add(1)
+		go c.gc(keys)
+	}
+	if c.wanInterval > 0 {
+		c.wg.Add(1)
+		go c.watchWAN()
+	}
+	if c.raftInterval > 0 {
+		c.wg.Add(1)
+		go c.watchRaft()
+	}
+	if c.autopilotInterval > 0 {
+		c.wg.Add(1)
+		go c.watchAutopilot()
+	}
+	if c.selfName != "" {
+		c.wg.Add(1)
+		go c.registerSelf()
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-c.stopCh:
+	case <-c.failCh:
+	}
+	c.stop()
+	c.wg.Wait()
+	if c.spill != nil {
+		c.spill.close()
+	}
+	close(c.events)
+	close(c.stoppedCh)
+	return c.Err()
+}
+
+// watchedDatacenters returns list of datacenters to watch,
+// an empty string stands for the client's default datacenter.
+func (c *Consul) watchedDatacenters() ([]string, error) {
+	switch {
+	case len(c.datacenters) == 0:
+		return []string{""}, nil
+	case len(c.datacenters) == 1 && c.datacenters[0] == AllDatacenters:
+		return c.api.Catalog().Datacenters()
+	default:
+		return c.datacenters, nil
+	}
+}
+
+// localDatacenter returns name of the default datacenter.
+func (c *Consul) localDatacenter() (string, error) {
+	if c.datacenter != "" {
+		return c.datacenter, nil
+	}
+	self, err := c.api.Agent().Self()
+	if err != nil {
+		return "", err
+	}
+	dc, _ := self["Config"]["Datacenter"].(string)
+	return dc, nil
 }
 
 // Consul is the consul server client
 type Consul struct {
 	api *api.Client
-	err error
 
+	mu     sync.Mutex
+	err    error
+	active bool
+	rand   *rand.Rand // seeded on first use, see jittered
+
+	wg        sync.WaitGroup
 	events    chan *Event
 	stopCh    chan struct{}
 	stoppedCh chan struct{}
+	failOnce  sync.Once
+	stopOnce  sync.Once
+	running   bool          // Run has been called
+	failCh    chan struct{} // closed when a watcher fails
 
-	address    string
-	scheme     string
-	datacenter string
-	logger     *log.Logger
-}
+	address     string
+	scheme      string
+	cluster     string
+	profile     string
+	httpAuth    *api.HttpBasicAuth
+	token       string
+	datacenter  string
+	datacenters []string
+	peers       []string
+	partition   string
+	filter      string
+	interval    time.Duration
+	jitter      time.Duration
+	consistency string
+	nodeChecks  bool
+	logger      *log.Logger
+	level       Level
 
-var (
-	waitTime = 5 * time.Second
-	ttl      = "15s"
-)
+	sessionTTL    time.Duration
+	lockDelay     time.Duration
+	renewInterval time.Duration
+	waitTime      time.Duration
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+
+	lockMu     sync.RWMutex // write-locked while the session is re-established
+	epoch      uint64       // incremented every time the session is re-established
+	lockEvents bool
+	lockCh     chan *Event
+	hostname   string
+
+	perCheck       bool
+	outputChanges  bool
+	ignorePatterns []string
+	outputIgnore   []*regexp.Regexp
+	registrations  bool
+
+	watchServices   []string
+	ignoreServices  []string
+	services        *matcher
+	serviceWatchers bool
+	useCache        bool
+	cacheMaxAge     time.Duration
+
+	watchNodes  []string
+	ignoreNodes []string
+	nodes       *matcher
+	nodeMeta    map[string]string
+	serviceMeta []string
+
+	externalNodes         bool
+	confirmations         int
+	recoveryConfirmations int
+
+	gcInterval time.Duration
+	gcNotify   bool
+
+	wanInterval       time.Duration
+	raftInterval      time.Duration
+	autopilotInterval time.Duration
+	readOnly          bool
+	handling          bool // Handle is used instead of Run
+	heartbeat         func(active bool)
+	tracer            *tracing.Tracer // nil when tracing is disabled
+	selfName          string
+	selfTTL           time.Duration
+	selfBeats         chan bool // heartbeats of the self check, nil when it's disabled
+
+	shards      int
+	shardMu     []sync.RWMutex // write-locked while a shard is released
+	shardEpochs []uint64       // epochs shards have been acquired in, zero when not held
+
+	suppressMaint    bool
+	suppressNodeDown bool
+	groupNodes       bool
 
+	dependencies      map[string][]string
+	aggregateServices bool
+	thresholdEnabled  bool
+	threshold         int
+	thresholdPercent  bool
+	minSeverity       string
+	dropUnknown       bool
+	seedState         bool
+	seedSummary       func(evs []*Event)
+
+	noisePatterns []string
+	noise         []*regexp.Regexp
+	noiseCounts   map[string]uint64 // changes suppressed by every pattern
+
+	redactPatterns []string
+	redactions     []*regexp.Regexp
+
+	bufferSize      int
+	overflow        string
+	spillFile       string
+	spill           *spill
+	dropped         uint64          // events dropped from the full buffer
+	droppedReported uint64          // dropped events in summaries
+	droppedNames    map[string]bool // services of dropped events since the last summary
+
+	watchers []*watcher
+}
+
+// connect creates an api client, the standard CONSUL_* environment
+// variables are honored unless overridden by options.
 func connect(c *Consul) (*api.Client, error) {
-	a, err := api.NewClient(&api.Config{
-		Address:    c.address,
-		Scheme:     c.scheme,
-		Datacenter: c.datacenter,
-	})
+	cfg := api.DefaultConfig()
+	if c.address != "" {
+		cfg.Address = c.address
+	}
+	if c.scheme != "" {
+		cfg.Scheme = c.scheme
+	}
+	if c.httpAuth != nil {
+		cfg.HttpAuth = c.httpAuth
+	}
+	if c.token != "" {
+		cfg.Token = c.token
+	}
+	cfg.Datacenter = c.datacenter
+
+	a, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the client shares cfg.HttpClient, its transport adds
+	// query parameters the vendored api doesn't support
+	rt := cfg.HttpClient.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t := &paramsTransport{rt: rt, params: url.Values{}}
+	if c.partition != "" {
+		t.params.Set("partition", c.partition)
+	}
+	cfg.HttpClient.Transport = t
+
+	// check agent connection
+	_, err = a.Status().Leader()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// errStopped is returned when Close is called during a long operation.
+var errStopped = errors.New("consul: stopped")
+
+// newSession creates a session deleting keys it holds when it's invalidated.
+// The lock-delay is always sent, api.Session leaves out a zero one and
+// consul applies its 15s default then.
+func (c *Consul) newSession() (string, error) {
+	var out struct{ ID string }
+	if _, err := c.api.Raw().Write("/v1/session/create", map[string]string{
+		"Behavior":  "delete",
+		"TTL":       c.sessionTTL.String(),
+		"LockDelay": c.lockDelay.String(),
+	}, &out, nil); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+// createSession creates new consul session and holds an unique lock
+func (c *Consul) createSession() error {
+	sess, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	c.debugf("session created")
+
+	// renew in the background
+	held, done := make(chan struct{}), make(chan struct{})
+	go c.renew(sess, held, done)
+
+	if err = c.acquire(sess); err != nil {
+		close(done)
+		return err
+	}
+	close(held)
+	return nil
+}
+
+// Holder is the lock value identifying the active instance.
+type Holder struct {
+	Session string    `json:"session"`
+	Host    string    `json:"host"`
+	Since   time.Time `json:"since"`
+}
+
+// lockKey returns the key of the lock, profiles have their own ones.
+func (c *Consul) lockKey() string {
+	if c.profile != "" {
+		return lockKey + "/" + c.profile
+	}
+	return lockKey
+}
+
+// Holder returns the instance holding the lock, nil when there's none.
+func (c *Consul) Holder() (*Holder, error) {
+	kv, _, err := c.api.KV().Get(c.lockKey(), nil)
 	if err != nil {
 		return nil, err
 	}
-
-	// check agent connection
-	_, err = a.Status().Leader()
-	if err != nil {
-		return nil, err
+	if kv == nil || kv.Session == "" {
+		return nil, nil
+	}
+	var h Holder
+	if err = json.Unmarshal(kv.Value, &h); err != nil {
+		h.Host = "unknown" // value of older versions is just session id
+	}
+	h.Session = kv.Session
+	return &h, nil
+}
+
+// lockRetry is how soon acquiring the free lock is retried when
+// consul refuses it within the lock-delay of the previous holder.
+const lockRetry = 250 * time.Millisecond
+
+// acquire blocks until the lock is acquired by the given session,
+// meanwhile the instance is a standby and reports the active one.
+// The lock key is watched with blocking queries, so it's acquired
+// as soon as it's released or deleted along with the holder's session.
+func (c *Consul) acquire(sess string) error {
+	c.debugf("try lock")
+
+	b, err := json.Marshal(&Holder{
+		Session: sess,
+		Host:    c.hostname,
+		Since:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	lock := &api.KVPair{
+		Key:     c.lockKey(),
+		Value:   b,
+		Session: sess,
+	}
+
+	var (
+		waitIndex uint64
+		holder    string
+	)
+
+	for {
+		select {
+		case <-c.stopCh:
+			return errStopped
+		default:
+		}
+
+		kv, meta, err := c.api.KV().Get(c.lockKey(), &api.QueryOptions{
+			WaitTime:  c.waitTime,
+			WaitIndex: waitIndex,
+		})
+
+		if err != nil {
+			return err
+		}
+		c.beat(false)
+
+		// the index of the query and not of the key, so the next one
+		// returns once the key is deleted rather than right away,
+		// it starts over when the index goes backwards
+		if meta.LastIndex < waitIndex {
+			waitIndex = 0
+		} else {
+			waitIndex = meta.LastIndex
+		}
+		if kv != nil && kv.Session != "" {
+			if kv.Session != holder {
+				holder = kv.Session
+				var l Holder
+				if err = json.Unmarshal(kv.Value, &l); err != nil {
+					l.Host = "unknown" // value of older versions is just session id
+				}
+				c.debugf("standby, lock is held by %s (session %s)", l.Host, kv.Session)
+			}
+			continue
+		}
+
+		ok, _, err := c.api.KV().Acquire(lock, nil)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			c.logf("lock acquired")
+			break
+		}
+		// nothing changes when the lock-delay is over, so
+		// the key is read again without blocking after a while
+		waitIndex = 0
+		select {
+		case <-time.After(lockRetry):
+		case <-c.stopCh:
+			return errStopped
+		}
+	}
+	return nil
+}
+
+// renew renews the session every renewInterval until Close is called
+// or done is closed, then the session is destroyed. held is closed once
+// the lock is acquired, only then an expired session is re-established,
+// before that acquire fails on its own.
+func (c *Consul) renew(sess string, held, done chan struct{}) {
+	t := time.NewTicker(c.renewInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			entry, _, err := c.api.Session().Renew(sess, nil)
+			if err != nil {
+				c.warnf("renew session error: %v", err)
+				continue
+			}
+			if entry == nil {
+				c.warnf("renew session error: %v", api.ErrSessionExpired)
+				select {
+				case <-held:
+					c.warnf("lock lost")
+					c.lockEvent(LockLost)
+					c.reestablish()
+				default:
+				}
+				return
+			}
+		case <-done:
+			c.destroy(sess)
+			return
+		case <-c.stopCh:
+			select {
+			case <-held:
+				c.release(sess)
+			default:
+			}
+			c.destroy(sess)
+			return
+		}
+	}
+}
+
+// release releases the lock held by the session, unlike destroying
+// the session it doesn't set off the lock-delay, so a standby
+// instance acquires the lock right away.
+func (c *Consul) release(sess string) {
+	ok, _, err := c.api.KV().Release(&api.KVPair{Key: c.lockKey(), Session: sess}, nil)
+	if err != nil {
+		c.warnf("release lock error: %v", err)
+		return
+	}
+	if ok {
+		c.debugf("lock released")
+	}
+}
+
+// destroy destroys the session releasing the lock.
+func (c *Consul) destroy(sess string) {
+	if _, err := c.api.Session().Destroy(sess, nil); err != nil {
+		c.warnf("destroy session error: %v", err)
+		return
+	}
+	c.debugf("session destroyed")
+}
+
+// reestablish creates a new session after the previous one
+// has expired, e.g. when the agent was restarted, watchers are
+// paused until the lock is acquired again since another
+// instance may have taken over in the meantime.
+func (c *Consul) reestablish() {
+	c.setActive(false)
+	c.lockMu.Lock()
+	c.lock()
+}
+
+// lock creates a session and acquires the lock retrying errors,
+// lockMu has to be write-locked by the caller, it's unlocked once done.
+func (c *Consul) lock() {
+	defer c.lockMu.Unlock()
+
+	for n := 0; ; n++ {
+		err := c.createSession()
+		if err == nil {
+			c.epoch++
+			c.setActive(true)
+			c.lockEvent(LockAcquired)
+			return
+		}
+		if err == errStopped {
+			return
+		}
+		if permanent(err) {
+			c.fail(err)
+			return
+		}
+		c.warnf("create session error: %v", err)
+		if !c.sleep(n) {
+			return
+		}
+	}
+}
+
+// Ping checks that consul is reachable and the cluster has a leader.
+func (c *Consul) Ping() error {
+	leader, err := c.api.Status().Leader()
+	if err != nil {
+		return err
+	}
+	if leader == "" {
+		return errors.New("consul: no cluster leader")
+	}
+	return nil
+}
+
+// Active reports whether this instance holds the lock and sends
+// notifications, otherwise it's a standby waiting for the lock.
+func (c *Consul) Active() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// minor reports whether the event is below the min severity.
+func (c *Consul) minor(ev *Event) bool {
+	if c.minSeverity != Critical {
+		return false
+	}
+	switch ev.Status {
+	case Passing, Warning:
+		return ev.PrevStatus == "" || ev.PrevStatus == Passing || ev.PrevStatus == Warning
+	default:
+		return false
+	}
+}
+
+// beat calls the heartbeat function if it's set
+// and passes the beat to the self check if it's enabled.
+func (c *Consul) beat(active bool) {
+	if c.heartbeat != nil {
+		c.heartbeat(active)
+	}
+	if c.selfBeats != nil {
+		select {
+		case c.selfBeats <- active:
+		default:
+		}
+	}
+}
+
+func (c *Consul) setActive(active bool) {
+	c.mu.Lock()
+	c.active = active
+	c.mu.Unlock()
+}
+
+// lockEvent queues a lock event when they're enabled.
+func (c *Consul) lockEvent(status string) {
+	if !c.lockEvents {
+		return
+	}
+	ev := &Event{
+		Node:    c.hostname,
+		CheckID: c.lockKey(),
+		Name:    "consul-slack",
+		Status:  status,
+	}
+	select {
+	case c.lockCh <- ev:
+	default:
+		c.warnf("%s event dropped", status)
+	}
+}
+
+// forwardLockEvents sends queued lock events, they're queued by
+// session goroutines that cannot block on the events channel.
+func (c *Consul) forwardLockEvents() {
+	defer c.wg.Done()
+	for {
+		select {
+		case ev := <-c.lockCh:
+			if !c.send(ev) {
+				return
+			}
+		case <-c.stopCh:
+			return
+		case <-c.failCh:
+			return
+		}
+	}
+}
+
+// Err is an error encountered during iteration.
+func (c *Consul) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// fail records the first watcher error and stops all other watchers.
+func (c *Consul) fail(err error) {
+	c.failOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.failCh)
+	})
+}
+
+// Next returns the next event, nil is returned when watching is stopped
+// along with the error it failed with, or when ctx is done with its error.
+func (c *Consul) Next(ctx context.Context) (*Event, error) {
+	// pending events are left alone once ctx is canceled
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	// dropped events are summarized once the buffer is drained
+	if len(c.events) == 0 {
+		if ev := c.droppedSummary(); ev != nil {
+			return ev, nil
+		}
+	}
+	select {
+	case ev, ok := <-c.events:
+		if !ok {
+			return nil, c.Err()
+		}
+		return ev, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// watcher is the per datacenter watching state.
+type watcher struct {
+	dc         string // empty for the default datacenter
+	datacenter string // dc name resolved for events
+	partition  string
+	peer       string
+	service    string // set when it watches a single service
+	profile    string
+	sharded    bool
+	shard      int             // shard of checks it watches when sharded
+	external   map[string]bool // consul-esm external nodes
+	pending    map[string]*pending
+	noisy      map[string]string // noise patterns suppressed checks match
+	state      state
+	since      map[string]time.Time // times checks went critical at
+	failing    map[string]time.Time // times checks started failing at, see Event.Incident
+	outputs    map[string]string    // hashes of the last reported outputs of failing checks
+	timelines  map[string]*Timeline // courses of incidents of failing checks
+	epoch      uint64               // session epoch the state was loaded in, zero when not loaded yet
+	dirty      bool                 // state changed but hasn't been saved yet
+	seeded     bool                 // the state has been seeded with checks
+	stateIndex uint64               // modify index of the saved state
+	trace      tracing.SpanContext  // span of the comparison in progress, see WithTracer
+}
+
+// watch watches for changes in the watcher's datacenter or peer,
+// consul errors are retried with exponential backoff.
+func (c *Consul) watch(w *watcher) {
+	defer c.wg.Done()
+
+	var (
+		index   uint64
+		last    time.Time
+		retries int
+	)
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-c.failCh:
+			return
+		case <-time.After(c.interval - time.Since(last) + c.jittered()):
+		}
+		last = time.Now()
+
+		// the shard is watched by another instance
+		if w.sharded && c.shardEpoch(w.shard) == 0 {
+			index = 0
+			continue
+		}
+
+		q := c.queryOptions(w)
+		q.NodeMeta = c.nodeMeta
+		q.WaitIndex = index
+		if len(w.pending) != 0 {
+			q.WaitIndex = 0 // poll pending confirmations every interval
+		}
+		q.WaitTime = c.waitTime // if we call Close() we'll still have to wait
+		poll := c.tracer.Start(tracing.SpanContext{}, "poll")
+		w.annotate(poll)
+		poll.SetAttribute("consul.wait_index", q.WaitIndex)
+		data, meta, err := c.checks(w, q)
+		poll.SetError(err)
+		poll.End()
+		if err == nil {
+			c.beat(c.Active())
+
+			// blocking query timed out, nothing has changed
+			if index != 0 && meta.LastIndex == index && !w.dirty && len(w.pending) == 0 {
+				continue
+			}
+
+			// the index went backwards e.g. after a snapshot restore,
+			// start over as recommended by the blocking queries docs
+			if meta.LastIndex < index {
+				index = 0
+			} else {
+				index = meta.LastIndex
+			}
+
+			mu := &c.lockMu
+			if w.sharded {
+				mu = &c.shardMu[w.shard]
+			}
+			diff := c.tracer.Start(poll.Context(), "diff")
+			diff.SetAttribute("consul.checks", len(data))
+			w.trace = diff.Context()
+			mu.RLock()
+			err = c.process(w, data)
+			mu.RUnlock()
+			w.trace = tracing.SpanContext{}
+			if err != errStopped {
+				diff.SetError(err)
+			}
+			diff.End()
+		}
+
+		switch err {
+		case nil:
+			retries = 0
+		case errStopped:
+			return
+		default:
+			if permanent(err) {
+				c.fail(err)
+				return
+			}
+			c.warnf("%swatch error: %v, retrying", w.prefix(), err)
+			index = 0
+			if !c.sleep(retries) {
+				return
+			}
+			retries++
+		}
+	}
+}
+
+// process compares health checks with the watcher state
+// and sends events for all changes.
+func (c *Consul) process(w *watcher, data api.HealthChecks) error {
+	epoch := c.epoch
+	if w.sharded {
+		// the shard has been released while querying checks
+		if epoch = c.shardEpoch(w.shard); epoch == 0 {
+			return nil
+		}
+	} else if !c.Active() {
+		// the lock couldn't be acquired, watching has been stopped
+		return errStopped
+	}
+
+	// the lock has been just acquired, another instance
+	// could have changed the state in the meantime
+	quiet, seeding := false, false
+	if w.epoch != epoch {
+		s, index, err := c.load(w.stateKey())
+		if _, ok := err.(*stateError); ok {
+			// starting over with an empty state would report every check,
+			// so checks are recorded without events on this poll instead
+			c.warnf("%s%v, starting with a fresh state", w.prefix(), err)
+			quiet, err = true, nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.seedState && !w.seeded && len(s.Checks) == 0 {
+			c.logf("%sseeding empty state with current checks", w.prefix())
+			quiet, seeding = true, true
+		}
+		w.stateIndex = index
+		c.debugf("%sstate is %v", w.prefix(), s.Checks)
+		old := s.Version != stateVersion
+		w.state, w.since, w.failing, w.outputs, w.timelines = s.Checks, s.Since, s.Failing, s.Outputs, s.Timelines
+		w.epoch, w.dirty = epoch, quiet || old
+		w.pending, w.noisy = nil, nil
+		if old {
+			c.logf("%smigrating state to version %d", w.prefix(), stateVersion)
+		}
+
+		// without a saved state everything looks newly registered
+		w.seeded = w.seeded || len(s.Checks) != 0
+	}
+
+	if c.externalNodes {
+		external, err := c.external(w)
+		if err != nil {
+			return err
+		}
+		w.external = external
+	}
+
+	silences, err := c.Silences()
+	if err != nil {
+		return err
+	}
+	acks, err := c.Acks()
+	if err != nil {
+		return err
+	}
+	resolved := map[string]bool{} // acked services that have recovered
+	var seeded []*Event           // failing checks the state is seeded with
+	var changed []*Event          // status changes grouped by node, see WithNodeGrouping
+
+	now := time.Now()
+	cache := metaCache{}
+	data = c.filterChecks(data)
+	maint := maintenanceNodes(data)
+	if w.sharded {
+		data = c.shardChecks(w, data)
+	}
+	hcs := aggregateStatus(data, c.nodeChecks && w.service == "", c.perCheck)
+	if c.aggregateServices {
+		hcs = c.serviceStatus(hcs)
+	}
+	roots := c.rootCauses(hcs)
+	deps := dependents(roots, hcs)
+	var down map[string][]string
+	if c.suppressNodeDown {
+		down = nodeServices(downNodes(data), hcs)
+	}
+	for id, hc := range hcs {
+		// health check status hasn't changed
+		prev, known := w.state[id]
+		if prev == hc.Status {
+			delete(w.pending, id)
+			delete(w.noisy, id)
+			if !c.outputChanges || !w.outputChanged(id, hc.Status, c.outputHash(hc.Output)) || quiet {
+				continue
+			}
+			if c.suppressMaint && maint[hc.Node] {
+				continue
+			}
+			if _, ok := roots[hc.ServiceName]; ok && hc.ServiceID != "" {
+				continue
+			}
+			ev := w.newEvent(id, hc, prev)
+			ev.CriticalSince = w.since[id]
+			ev.setIncident(w.failing[id])
+			if c.minor(ev) {
+				continue
+			}
+			if tl, ok := w.timelines[id]; ok {
+				tl.Outputs++
+			}
+			c.logf("%s%s: %s output changed", w.prefix(), id, ev.Status)
+			c.enrich(w, ev, cache)
+			if !c.silenced(w, id, ev, silences) && !c.send(ev) {
+				return errStopped
+			}
+			continue
+		}
+
+		// the state is frozen until maintenance is over
+		if c.suppressMaint && (hc.Status == Maintenance || maint[hc.Node]) {
+			delete(w.pending, id)
+			continue
+		}
+		if _, ok := roots[hc.ServiceName]; ok && hc.ServiceID != "" {
+			delete(w.pending, id)
+			continue
+		}
+		if _, ok := down[hc.Node]; ok && hc.ServiceID != "" {
+			delete(w.pending, id)
+			continue
+		}
+		if c.suppressNoise(w, id, hc) {
+			delete(w.pending, id)
+			continue
+		}
+		if w.seeded && !c.confirmed(w, id, prev, hc.Status) {
+			continue
+		}
+
+		w.dirty = true
+		w.state[id] = hc.Status
+
+		// checks recorded quietly have been critical for an unknown time
+		since := w.since[id]
+		switch {
+		case hc.Status != Critical:
+			delete(w.since, id)
+		case since.IsZero() && !quiet:
+			since = now
+			w.since[id] = since
+		}
+		start, tl := w.failing[id], w.timelines[id]
+		switch {
+		case hc.Status != Warning && hc.Status != Critical:
+			delete(w.failing, id)
+			delete(w.timelines, id)
+		case start.IsZero() && !quiet:
+			start = now
+			w.failing[id] = start
+			w.timelines[id] = &Timeline{}
+		case tl != nil && (prev == Warning || prev == Critical):
+			tl.Flaps++
+		}
+		if hc.Status != Warning && hc.Status != Critical {
+			delete(w.outputs, id)
+		} else if c.outputChanges {
+			w.outputs[id] = c.outputHash(hc.Output)
+		}
+		if quiet {
+			if seeding && c.seedSummary != nil && (hc.Status == Warning || hc.Status == Critical) {
+				ev := w.newEvent(id, hc, "")
+				ev.Cluster, ev.Time = c.cluster, now
+				c.redact(ev)
+				seeded = append(seeded, ev)
+			}
+			continue
+		}
+		if !known && w.seeded && c.registrations {
+			ev := w.newEvent(id, hc, "")
+			ev.Status = Added
+			c.enrich(w, ev, cache)
+			c.logf("%s%s: registered", w.prefix(), id)
+			if !c.silenced(w, id, ev, silences) && !c.send(ev) {
+				return errStopped
+			}
+
+			// new passing services are announced only once
+			if hc.Status == Passing {
+				continue
+			}
+		}
+
+		ev := w.newEvent(id, hc, prev)
+		ev.CriticalSince = since
+		ev.setIncident(start)
+		if ev.Resolved() {
+			ev.setTimeline(tl, start)
+		}
+		if ev.Status == Critical && ev.ServiceID != "" {
+			ev.Dependents = deps[ev.ServiceName]
+		}
+		if ev.Status == Critical && ev.CheckID == SerfHealth {
+			ev.Dependents = down[ev.Node]
+		}
+		c.logf("%s%s: %s -> %s", w.prefix(), id, ev.PrevStatus, ev.Status)
+		if _, ok := statuses[ev.Status]; !ok {
+			c.warnf("%s%s: unknown status %q", w.prefix(), id, ev.Status)
+			if c.dropUnknown {
+				continue
+			}
+		}
+		if c.minor(ev) {
+			continue
+		}
+		c.enrich(w, ev, cache)
+		if ack, ok := acks[ev.ServiceName]; ok && ev.Resolved() && ev.ServiceName != "" {
+			ev.Ack = ack
+			resolved[ev.ServiceName] = true
+		}
+		if c.silenced(w, id, ev, silences) {
+			continue
+		}
+		if c.groupNodes {
+			changed = append(changed, ev)
+			continue
+		}
+		if !c.send(ev) {
+			return errStopped
+		}
+	}
+	if len(changed) != 0 {
+		for _, ev := range groupByNode(changed) {
+			for _, e := range ev.Grouped {
+				c.redact(e)
+				e.Cluster, e.Time = c.cluster, now
+			}
+			if !c.send(ev) {
+				return errStopped
+			}
+		}
 	}
-	return a, nil
-}
-
-// createSession creates new consul session and holds an unique lock
-func (c *Consul) createSession() error {
-	sess, _, err := c.api.Session().Create(&api.SessionEntry{
-		Behavior:  "delete",
-		TTL:       ttl,
-		LockDelay: time.Second,
-	}, nil)
-
-	if err != nil {
-		return err
+	w.seeded = true
+	if len(seeded) != 0 {
+		sortEvents(seeded)
+		c.seedSummary(seeded)
 	}
-	c.logf("session created")
 
-	// renew in the background
-	go func() {
-		if err := c.api.Session().RenewPeriodic(ttl, sess, nil, c.stopCh); err != nil {
-			c.logf("renew session error: %v\n", err)
-			return
+	// drop confirmations of vanished checks
+	for id := range w.pending {
+		if _, ok := hcs[id]; !ok {
+			delete(w.pending, id)
+		}
+	}
+	for id := range w.noisy {
+		if _, ok := hcs[id]; !ok {
+			delete(w.noisy, id)
 		}
-		c.logf("session destroyed")
-	}()
-
-	// acquire lock
-	c.logf("try lock")
-
-	lock := &api.KVPair{
-		Key:     lockKey,
-		Value:   []byte(sess),
-		Session: sess,
 	}
 
-	var waitIndex uint64
-
-	for {
-		kv, _, err := c.api.KV().Get(lockKey, &api.QueryOptions{
-			WaitTime:  waitTime,
-			WaitIndex: waitIndex,
-		})
+	for id, status := range w.state {
+		if _, ok := hcs[id]; ok {
+			continue
+		}
 
+		// a failing service disappeared, it's not a recovery
+		if status == Passing && !c.registrations {
+			w.dirty = true
+			delete(w.state, id)
+			continue
+		}
+		ev, err := c.deregistered(w, id, status)
 		if err != nil {
 			return err
 		}
-
-		if kv != nil {
-			waitIndex = kv.ModifyIndex
+		since, start, tl := w.since[id], w.failing[id], w.timelines[id]
+		w.dirty = true
+		delete(w.state, id)
+		delete(w.since, id)
+		delete(w.failing, id)
+		delete(w.outputs, id)
+		delete(w.timelines, id)
+		if ev == nil {
+			continue
+		}
+		ev.CriticalSince = since
+		ev.setIncident(start)
+		ev.setTimeline(tl, start)
+		if ack, ok := acks[ev.ServiceName]; ok && ev.ServiceName != "" {
+			ev.Ack = ack
+			resolved[ev.ServiceName] = true
+		}
+		c.logf("%s%s: deregistered", w.prefix(), id)
+		if !c.silenced(w, id, ev, silences) && !c.send(ev) {
+			return errStopped
 		}
+	}
 
-		ok, _, err := c.api.KV().Acquire(lock, nil)
+	if err = c.clearAcks(w, resolved, hcs); err != nil {
+		return err
+	}
+
+	// save state only when it's changed.
+	if w.dirty {
+		index, err := c.dump(w.stateKey(), w.saved(), w.stateIndex)
 		if err != nil {
 			return err
 		}
-
-		if ok {
-			c.logf("lock acquired")
-			break
-		}
+		w.stateIndex = index
+		w.dirty = false
 	}
 	return nil
 }
 
-// Err is an error encountered during iteration.
-func (c *Consul) Err() error {
-	return c.err
+// permanent reports whether the error cannot be fixed by retrying,
+// like acl denials, the api client doesn't expose status codes.
+func permanent(err error) bool {
+	return strings.Contains(err.Error(), "Unexpected response code: 403")
+}
+
+// backoff returns delay before the n-th retry, it doubles
+// every time starting from minBackoff up to maxBackoff.
+func (c *Consul) backoff(n int) time.Duration {
+	d := c.minBackoff
+	for i := 0; i < n && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return d
 }
 
-// Next returns next event or nil when an error was encountered.
-func (c *Consul) Next() *Event {
-	return <-c.events
+// jittered returns a random delay up to the jitter, see WithJitter.
+func (c *Consul) jittered() time.Duration {
+	if c.jitter <= 0 {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rand == nil {
+		c.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return time.Duration(c.rand.Int63n(int64(c.jitter)))
 }
 
-// watch watches for changes.
-func (c *Consul) watch() {
-	defer close(c.events)
+// pending is a failing or recovered status waiting for confirmation.
+type pending struct {
+	status string
+	count  int
+}
 
-	// load state
-	state, err := c.load()
-	if err != nil {
-		c.logf("load state error %v", err)
+// confirmed reports whether the failing status or the recovery from the
+// previous one has been observed on enough consecutive polls, other
+// statuses are confirmed at once.
+func (c *Consul) confirmed(w *watcher, id, prev, status string) bool {
+	n := 1
+	switch {
+	case status == Warning || status == Critical:
+		n = c.confirmations
+	case status == Passing && (prev == Warning || prev == Critical):
+		n = c.recoveryConfirmations
+	}
+	if n < 2 {
+		delete(w.pending, id)
+		return true
+	}
+	if w.pending == nil {
+		w.pending = map[string]*pending{}
+	}
+	p, ok := w.pending[id]
+	if !ok || p.status != status {
+		p = &pending{status: status}
+		w.pending[id] = p
 	}
-	c.logf("state is %v", state)
+	p.count++
+	if p.count < n {
+		c.debugf("%s%s: %s %d/%d", w.prefix(), id, status, p.count, n)
+		return false
+	}
+	delete(w.pending, id)
+	return true
+}
 
-	meta := &api.QueryMeta{}
-	data := api.HealthChecks{}
+// sleep waits before the n-th retry,
+// false is returned when watching is stopped.
+func (c *Consul) sleep(n int) bool {
+	select {
+	case <-time.After(c.backoff(n) + c.jittered()):
+		return true
+	case <-c.stopCh:
+		return false
+	case <-c.failCh:
+		return false
+	}
+}
 
-	for {
-		select {
-		case <-c.stopCh:
-			close(c.stoppedCh)
-			return
-		default:
-		}
+// queryOptions returns health query options of the watcher.
+func (c *Consul) queryOptions(w *watcher) *api.QueryOptions {
+	q := &api.QueryOptions{
+		Datacenter:        w.dc,
+		AllowStale:        c.consistency == ConsistencyStale,
+		RequireConsistent: c.consistency == ConsistencyConsistent,
+	}
+	if w.peer != "" {
+		q = withParams(q, url.Values{"peer": {w.peer}})
+	}
+	return q
+}
 
-		data, meta, err = c.api.Health().State(api.HealthAny, &api.QueryOptions{
-			AllowStale: false,
-			WaitIndex:  meta.LastIndex,
-			WaitTime:   waitTime, // if we call Close() we'll still have to wait
-		})
+// checks returns all health checks of the watcher.
+func (c *Consul) checks(w *watcher, q *api.QueryOptions) (api.HealthChecks, *api.QueryMeta, error) {
+	if w.peer != "" {
+		return c.peerChecks(q)
+	}
+	if w.service != "" {
+		return c.serviceChecks(w.service, c.cached(q))
+	}
+	if c.filter != "" {
+		q = withParams(q, url.Values{"filter": {c.filter}})
+	}
+	return c.healthState(w, q)
+}
 
-		if err != nil {
-			c.err = err
-			return
-		}
+// send redacts secrets of the event, labels it with the cluster name and the current
+// time and sends it to the events channel, false is returned when watching is stopped.
+func (c *Consul) send(ev *Event) bool {
+	c.redact(ev)
+	ev.Cluster = c.cluster
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	return c.enqueue(ev)
+}
 
-		save := false
-		hcs := aggregateStatus(data)
-		for id, hc := range hcs {
-			// health check status hasn't changed
-			if state[id] == hc.Status {
-				continue
-			}
+// cached makes q served from the agent cache when it's enabled,
+// only a few endpoints like health service support it.
+func (c *Consul) cached(q *api.QueryOptions) *api.QueryOptions {
+	if !c.useCache {
+		return q
+	}
+	q = withParams(q, url.Values{"cached": {""}})
+	if c.cacheMaxAge > 0 {
+		q = withHeader(q, "Cache-Control", fmt.Sprintf("max-age=%d", int(c.cacheMaxAge/time.Second)))
+	}
+	return q
+}
 
-			save = true
-			state[id] = hc.Status
-			c.logf("%s: %s", id, hc.Status)
-			c.events <- (*Event)(hc)
-		}
+// externalNodeMeta is the node metadata consul-esm marks external nodes with.
+var externalNodeMeta = map[string]string{"external-node": "true"}
 
-		for id, _ := range state {
-			if _, ok := hcs[id]; !ok {
-				save = true
-				delete(state, id)
-			}
-		}
+// external returns set of external nodes of the watcher.
+func (c *Consul) external(w *watcher) (map[string]bool, error) {
+	q := c.queryOptions(w)
+	q.NodeMeta = externalNodeMeta
+	nodes, _, err := c.api.Catalog().Nodes(q)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		m[n.Node] = true
+	}
+	return m, nil
+}
 
-		// save state only when it's changed.
-		if save {
-			if err = c.dump(state); err != nil {
-				c.err = err
-				return
+// serviceChecks returns checks of all instances of the service
+// along with node maintenance checks affecting them.
+func (c *Consul) serviceChecks(name string, q *api.QueryOptions) (api.HealthChecks, *api.QueryMeta, error) {
+	entries, meta, err := c.api.Health().Service(name, "", false, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	var hcs api.HealthChecks
+	for _, e := range entries {
+		for _, hc := range e.Checks {
+			if hc.ServiceID != "" || hc.CheckID == api.NodeMaint {
+				hcs = append(hcs, hc)
 			}
 		}
 	}
+	return hcs, meta, nil
+}
+
+// annotate sets attributes of the span telling which checks the watcher watches.
+func (w *watcher) annotate(s *tracing.Span) {
+	s.SetAttribute("consul.datacenter", w.datacenter)
+	if w.peer != "" {
+		s.SetAttribute("consul.peer", w.peer)
+	}
+	if w.service != "" {
+		s.SetAttribute("consul.service", w.service)
+	}
+	if w.sharded {
+		s.SetAttribute("consul.shard", w.shard)
+	}
+}
+
+// prefix returns log prefix of the watcher.
+func (w *watcher) prefix() string {
+	var p string
+	switch {
+	case w.peer != "":
+		p = "[peer " + w.peer + "] "
+	case w.dc != "":
+		p = "[" + w.dc + "] "
+	}
+	if w.service != "" {
+		p += "[" + w.service + "] "
+	}
+	if w.sharded {
+		p += "[shard " + strconv.Itoa(w.shard) + "] "
+	}
+	return p
 }
 
 const (
-	// TODO
+	// Added and Deleted are pseudo statuses of services
+	// that have been registered or deregistered.
 	Added   = "added"
 	Deleted = "deleted"
 
+	// LockAcquired and LockLost are pseudo statuses of events about
+	// this instance acquiring or losing the lock, see WithLockEvents.
+	LockAcquired = "lock-acquired"
+	LockLost     = "lock-lost"
+
 	Passing     = api.HealthPassing
 	Warning     = api.HealthWarning
 	Critical    = api.HealthCritical
@@ -266,25 +2065,65 @@ var statuses = map[string]int{
 	Maintenance: 3,
 }
 
-// state is current state
+// state maps ids of services and checks to their last reported statuses,
+// it's saved as a flat json object only when it's changed during a poll.
+//
+// Check outputs aren't part of it on purpose, they often contain timings
+// or timestamps that change on every run and would cause a write per poll.
 type state map[string]string
 
 // aggregateStatus converts a health checks list into ids map
 // aggregating their statuses maintenance > critical > warning > passing.
-func aggregateStatus(hcs api.HealthChecks) map[string]*api.HealthCheck {
+//
+// Services of nodes in maintenance mode are reported as under maintenance
+// too, with the maintenance reason taken from the node check notes.
+//
+// When nodeChecks is true node-level checks such as serfHealth are
+// included as is, they aren't aggregated and are keyed by node/check id.
+//
+// When perCheck is true service checks aren't aggregated either
+// and are keyed by node:service id/check id, all checks of services
+// in maintenance mode are reported as under maintenance then.
+func aggregateStatus(hcs api.HealthChecks, nodeChecks, perCheck bool) map[string]*api.HealthCheck {
+	maint := map[string]*api.HealthCheck{}
+	smaint := map[string]bool{}
+	for _, hc := range hcs {
+		if hc.CheckID == api.NodeMaint {
+			maint[hc.Node] = hc
+		}
+		if strings.HasPrefix(hc.CheckID, api.ServiceMaintPrefix) {
+			smaint[hc.Node+":"+hc.ServiceID] = true
+		}
+	}
+
 	r := make(map[string]*api.HealthCheck, len(hcs))
 	for _, hc := range hcs {
-		// ignore serf heal status
 		if hc.ServiceID == "" {
+			if nodeChecks && hc.CheckID != api.NodeMaint {
+				r[hc.Node+"/"+hc.CheckID] = hc
+			}
 			continue
 		}
 
-		// the service is under maintenance
+		// the service or its node is under maintenance
 		if strings.HasPrefix(hc.CheckID, api.ServiceMaintPrefix) {
 			hc.Status = Maintenance
+		} else if perCheck && smaint[hc.Node+":"+hc.ServiceID] {
+			c := *hc
+			c.Status = Maintenance
+			hc = &c
+		} else if m, ok := maint[hc.Node]; ok {
+			c := *hc
+			c.Status = Maintenance
+			c.Notes = m.Notes
+			hc = &c
 		}
 
 		id := hc.Node + ":" + hc.ServiceID
+		if perCheck {
+			r[id+"/"+hc.CheckID] = hc
+			continue
+		}
 		if h, ok := r[id]; !ok || statuses[h.Status] < statuses[hc.Status] {
 			r[id] = hc
 		}
@@ -292,54 +2131,467 @@ func aggregateStatus(hcs api.HealthChecks) map[string]*api.HealthCheck {
 	return r
 }
 
+// downNodes returns names of nodes with critical serfHealth.
+func downNodes(hcs api.HealthChecks) map[string]bool {
+	nodes := map[string]bool{}
+	for _, hc := range hcs {
+		if hc.CheckID == SerfHealth && hc.Status == Critical {
+			nodes[hc.Node] = true
+		}
+	}
+	return nodes
+}
+
+// maintenanceNodes returns names of nodes in maintenance mode.
+func maintenanceNodes(hcs api.HealthChecks) map[string]bool {
+	nodes := map[string]bool{}
+	for _, hc := range hcs {
+		if hc.CheckID == api.NodeMaint {
+			nodes[hc.Node] = true
+		}
+	}
+	return nodes
+}
+
 // Event is a service state change.
-type Event api.HealthCheck
+type Event struct {
+	Node        string
+	CheckID     string
+	Name        string
+	Status      string
+	PrevStatus  string // empty when the service is seen for the first time
+	Notes       string
+	Output      string
+	ServiceID   string
+	ServiceName string
+	ServiceTags []string
+	Cluster     string // cluster name, empty unless set with WithCluster
+	Datacenter  string
+	Partition   string    // admin partition, empty when not set
+	Peer        string    // cluster peer the service is imported from, empty for local ones
+	External    bool      // node is an external one monitored by consul-esm, see WithExternalNodes
+	ID          string    // state id of the service or check, unique within the datacenter or peer
+	Time        time.Time // when the change has been detected, zero for summaries
+
+	// Incident identifies the failure the event is part of, it's the same
+	// for the event of the check starting to fail, following ones and
+	// the recovery, so they can be correlated across notifiers. It's
+	// empty for passing checks and when it's not known when the check
+	// started failing, e.g. it's been failing since before an upgrade.
+	Incident string
+
+	// CriticalSince is when the check went critical, it's set on
+	// critical events, reminders and events of checks leaving the
+	// critical state, zero when it's not known, e.g. the check has
+	// been critical since before an upgrade from an older release.
+	CriticalSince time.Time
+
+	// Ack is the acknowledgment of the service attached
+	// to the event resolving it, nil when it's not acked.
+	Ack *Ack
+
+	// Timeline is the course of the incident attached to the event
+	// resolving it, nil when it's not known when the check started failing.
+	Timeline *Timeline
+
+	// Meta is service meta fields picked with WithServiceMeta,
+	// nil when the service has none of them or it's a node check.
+	Meta map[string]string
+
+	// Dependents are failing services that depend on this critical
+	// one and aren't reported on their own, see WithDependencies,
+	// for serfHealth it's services of the node, see WithNodeDownSuppression.
+	Dependents []string
+
+	// Reminder is number of the reminder about a long-standing critical
+	// status, it's zero for status changes. Escalated is set when
+	// reminders have been sent enough times to escalate.
+	Reminder  int
+	Escalated bool
+
+	// Mention is prepended to chat messages, e.g. @oncall,
+	// it's never set by this package but by routing rules.
+	Mention string
+
+	// Grouped are other status changes of the node detected in the
+	// same poll, the event is the most severe of them, see WithNodeGrouping.
+	Grouped []*Event `json:",omitempty"`
+
+	// Trace is the span of the comparison the event has been detected by,
+	// zero when tracing is disabled or the event isn't a status change.
+	Trace tracing.SpanContext `json:"-"`
+}
+
+// setIncident sets Incident to the hash of the check's location
+// and the time it started failing at, it's unset when that's zero.
+func (ev *Event) setIncident(start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	h := sha256.New()
+	for _, s := range []string{ev.Datacenter, ev.Peer, ev.Node, ev.CheckID, start.UTC().Format(time.RFC3339Nano)} {
+		h.Write([]byte(s + "\x00"))
+	}
+	ev.Incident = hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Resolved reports whether the event ends an incident,
+// that is the check is passing or has been deregistered.
+func (ev *Event) Resolved() bool {
+	return ev.Status == Passing || ev.Status == Deleted
+}
+
+// Failing reports whether the check is in warning or critical state.
+func (ev *Event) Failing() bool {
+	return ev.Status == Warning || ev.Status == Critical
+}
+
+// CriticalFor returns for how long the check has been critical by the time
+// of the event rounded down to minutes, e.g. "23m" or "1h5m", it's empty
+// when it's not known or the check isn't and hasn't just been critical.
+func (ev *Event) CriticalFor() string {
+	if ev.CriticalSince.IsZero() || ev.Time.IsZero() {
+		return ""
+	}
+	if ev.Status != Critical && ev.PrevStatus != Critical {
+		return ""
+	}
+	return Minutes(ev.Time.Sub(ev.CriticalSince))
+}
+
+// FailingFor returns for how long the check had been failing by the time of
+// the event resolving the incident rounded down to minutes like CriticalFor,
+// it's empty when the event has no timeline.
+func (ev *Event) FailingFor() string {
+	if ev.Timeline == nil || ev.Time.IsZero() {
+		return ""
+	}
+	return Minutes(ev.Time.Sub(ev.Timeline.Since))
+}
+
+// Minutes formats the duration rounded down to minutes, e.g. "23m" or "1h5m".
+func Minutes(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	s := d.Truncate(time.Minute).String()
+	s = strings.TrimSuffix(s, "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// IsNode reports whether the event is of a node-level check.
+func (ev *Event) IsNode() bool {
+	return ev.ServiceID == ""
+}
+
+// Location returns "datacenter/node" with the partition and the peer
+// name in between and the cluster name in front when they're set.
+func (ev *Event) Location() string {
+	parts := make([]string, 0, 5)
+	for _, p := range []string{ev.Cluster, ev.Datacenter, ev.Partition, ev.Peer, ev.Node} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Key identifies the check the event is of across clusters,
+// datacenters, partitions and peers, unlike ID it's globally unique.
+// Like in Location empty ones are left out, so it's the ID when none is set.
+func (ev *Event) Key() string {
+	parts := make([]string, 0, 5)
+	for _, p := range []string{ev.Cluster, ev.Datacenter, ev.Partition, ev.Peer} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(append(parts, ev.ID), "/")
+}
+
+// IsLock reports whether the event is about the lock, Node is the instance hostname then.
+func (ev *Event) IsLock() bool {
+	return ev.Status == LockAcquired || ev.Status == LockLost
+}
+
+// SerfHealth is id of the check that consul uses to track node liveness.
+const SerfHealth = "serfHealth"
+
+// newEvent creates an event of the health check transition from prev status,
+// id is the health check state id.
+func (w *watcher) newEvent(id string, hc *api.HealthCheck, prev string) *Event {
+	return &Event{
+		ID:          id,
+		Datacenter:  w.datacenter,
+		Partition:   w.partition,
+		Peer:        w.peer,
+		External:    w.external[hc.Node],
+		Node:        hc.Node,
+		CheckID:     hc.CheckID,
+		Name:        hc.Name,
+		Status:      hc.Status,
+		PrevStatus:  prev,
+		Notes:       hc.Notes,
+		Output:      hc.Output,
+		ServiceID:   hc.ServiceID,
+		ServiceName: hc.ServiceName,
+		ServiceTags: hc.ServiceTags,
+		Trace:       w.trace,
+	}
+}
+
+// saved returns the watcher's state to be saved.
+func (w *watcher) saved() *savedState {
+	return &savedState{Checks: w.state, Since: w.since, Failing: w.failing, Outputs: w.outputs, Timelines: w.timelines}
+}
+
+// outputChanged reports whether the output hash of the failing check differs
+// from the last reported one and records it. Outputs of checks that have been
+// failing since before output changes are enabled are recorded quietly.
+func (w *watcher) outputChanged(id, status, sum string) bool {
+	if status != Warning && status != Critical {
+		return false
+	}
+	old, ok := w.outputs[id]
+	if old == sum {
+		return false
+	}
+	w.outputs[id] = sum
+	w.dirty = true
+	return ok
+}
+
+// outputHash returns the hash outputs are compared by, see WithOutputIgnore.
+func (c *Consul) outputHash(output string) string {
+	for _, re := range c.outputIgnore {
+		output = re.ReplaceAllString(output, "")
+	}
+	sum := sha256.Sum256([]byte(output))
+	return hex.EncodeToString(sum[:])
+}
+
+// stateKey returns state key of the watcher, the default
+// datacenter keeps using the original single-datacenter key.
+func (w *watcher) stateKey() string {
+	key := stateKey
+	if w.profile != "" {
+		key += "/profile/" + w.profile
+	}
+	switch {
+	case w.peer != "":
+		key += "/peer/" + w.peer
+	case w.dc != "":
+		key += "/" + w.dc
+	}
+	if w.service != "" {
+		key += "/service/" + w.service
+	}
+	if w.sharded {
+		key += "/shard/" + strconv.Itoa(w.shard)
+	}
+	return key
+}
+
+// splitID splits state id into node and service id or node check id.
+func splitID(id string) (node, serviceID, checkID string) {
+	i := strings.IndexAny(id, ":/")
+	if i == -1 {
+		return id, "", ""
+	}
+	if id[i] == '/' {
+		return id[:i], "", id[i+1:]
+	}
+	return id[:i], id[i+1:], ""
+}
 
-// load loads consul state from the kv store.
-func (c *Consul) load() (state, error) {
-	kv, _, err := c.api.KV().Get(stateKey, nil)
+// deregistered confirms with the catalog that the service or node check
+// that's gone from health checks has been deregistered and returns
+// a Deleted event for it, nil is returned when it's still registered.
+func (c *Consul) deregistered(w *watcher, id, prev string) (*Event, error) {
+	node, serviceID, checkID := c.splitID(id)
+
+	// services reported as a whole have no node
+	if node == "" {
+		services, _, err := c.api.Catalog().Service(serviceID, "", c.queryOptions(w))
+		if err != nil || len(services) != 0 {
+			return nil, err
+		}
+		return c.deletedEvent(w, id, prev), nil
+	}
+
+	cn, _, err := c.api.Catalog().Node(node, c.queryOptions(w))
 	if err != nil {
 		return nil, err
 	}
+	if cn != nil && serviceID != "" && checkID == "" {
+		if _, ok := cn.Services[serviceID]; ok {
+			return nil, nil
+		}
+	}
+	if cn != nil && checkID != "" {
+		checks, _, err := c.api.Health().Node(node, c.queryOptions(w))
+		if err != nil {
+			return nil, err
+		}
+		for _, hc := range checks {
+			if hc.CheckID == checkID {
+				return nil, nil
+			}
+		}
+	}
+	return c.deletedEvent(w, id, prev), nil
+}
+
+// deletedEvent returns a Deleted event of the given state id.
+func (c *Consul) deletedEvent(w *watcher, id, prev string) *Event {
+	node, serviceID, checkID := c.splitID(id)
+	return &Event{
+		Node:        node,
+		CheckID:     checkID,
+		Name:        checkID,
+		Status:      Deleted,
+		PrevStatus:  prev,
+		ServiceID:   serviceID,
+		ServiceName: serviceID,
+		Datacenter:  w.datacenter,
+		Partition:   w.partition,
+		Peer:        w.peer,
+		External:    w.external[node],
+		ID:          id,
+		Trace:       w.trace,
+	}
+}
 
-	s := state{}
-	if kv != nil {
-		err = json.Unmarshal(kv.Value, &s)
+// splitID is splitID that also splits service check ids of per-check state.
+func (c *Consul) splitID(id string) (node, serviceID, checkID string) {
+	node, serviceID, checkID = splitID(id)
+	if c.perCheck && serviceID != "" {
+		if i := strings.LastIndexByte(serviceID, '/'); i != -1 {
+			serviceID, checkID = serviceID[:i], serviceID[i+1:]
+		}
 	}
-	return s, err
+	return node, serviceID, checkID
 }
 
-// dump saves consul state to the kv store.
-func (c *Consul) dump(s state) error {
-	b, err := json.Marshal(s)
+// load loads consul state stored under the given key along with its
+// modify index, it's zero when there's no state, the state's version tells
+// whether it's saved in an older format. A state that cannot be decoded
+// is a *stateError, the index is returned anyway so it can be overwritten.
+func (c *Consul) load(key string) (*savedState, uint64, error) {
+	kv, _, err := c.api.KV().Get(key, nil)
 	if err != nil {
-		return err
+		return newSavedState(), 0, err
+	}
+	if kv == nil {
+		return newSavedState(), 0, nil
+	}
+	s, err := decodeState(kv.Value)
+	if err != nil {
+		return newSavedState(), kv.ModifyIndex, &stateError{key: key, err: err}
 	}
+	return s, kv.ModifyIndex, nil
+}
+
+// dump saves consul state under the given key if it hasn't been
+// changed since the given modify index and returns the new index.
+//
+// When it's been changed by another instance, e.g. during a lock handoff,
+// the other state is merged into ss, see savedState.merge, and the write
+// is retried with the index the other state's been read at, unless the lock is lost.
+func (c *Consul) dump(key string, ss *savedState, index uint64) (uint64, error) {
+	if c.readOnly {
+		return index, nil
+	}
+
+	for {
+		b, err := encodeState(ss)
+		if err != nil {
+			return index, err
+		}
+		ok, _, err := c.api.KV().CAS(&api.KVPair{
+			Key:         key,
+			Value:       b,
+			ModifyIndex: index,
+		}, nil)
+		if err != nil {
+			return index, err
+		}
 
-	_, err = c.api.KV().Put(&api.KVPair{
-		Key:   stateKey,
-		Value: b,
-	}, nil)
+		// cas doesn't return the new index, the value is read
+		// along with it, so it's exactly what the retry overwrites
+		kv, _, err := c.api.KV().Get(key, nil)
+		if err != nil {
+			return index, err
+		}
+		index = 0
+		if kv != nil {
+			index = kv.ModifyIndex
+		}
+		if ok {
+			return index, nil
+		}
 
-	return err
+		if !c.Active() {
+			return index, errStopped
+		}
+		if c.handling {
+			return index, errConcurrentHandle
+		}
+		if kv == nil {
+			c.warnf("state %s has been deleted concurrently, saving it again", key)
+			continue
+		}
+		other, err := decodeState(kv.Value)
+		if err != nil {
+			c.warnf("state %s has been changed concurrently and cannot be decoded, overwriting it: %v", key, err)
+			continue
+		}
+		c.warnf("state %s has been changed concurrently, merging it", key)
+		ss.merge(other)
+	}
 }
 
-// Close closes C channel.
+// Close stops watching and waits until Run returns if it's running,
+// it's safe to call it multiple times and after the Run context is canceled.
 func (c *Consul) Close() error {
-	select {
-	case <-c.stopCh:
-		return errors.New("already closed")
-	default:
+	c.stop()
+	c.mu.Lock()
+	running := c.running
+	c.mu.Unlock()
+	if running {
+		<-c.stoppedCh
 	}
-
-	close(c.stopCh)
-	<-c.stoppedCh
 	return nil
 }
 
-// logf prints a debug message when debug mode is enabled.
+// stop makes all watchers stop.
+func (c *Consul) stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
+}
+
+// debugf prints a message of lock and session internals.
+func (c *Consul) debugf(format string, v ...interface{}) {
+	c.printf(LevelDebug, format, v...)
+}
+
+// logf prints an informational message.
 func (c *Consul) logf(format string, v ...interface{}) {
-	if c.logger != nil {
+	c.printf(LevelInfo, format, v...)
+}
+
+// warnf prints a message about an error that's retried.
+func (c *Consul) warnf(format string, v ...interface{}) {
+	c.printf(LevelWarn, format, v...)
+}
+
+// printf prints the message when level is enabled.
+func (c *Consul) printf(level Level, format string, v ...interface{}) {
+	if c.logger != nil && level >= c.level {
 		c.logger.Printf(format, v...)
 	}
 }
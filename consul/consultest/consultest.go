@@ -0,0 +1,354 @@
+// Package consultest provides a fake consul agent for tests of code
+// built on the consul package, it serves health checks, the KV and
+// sessions over http and answers blocking queries as consul does.
+package consultest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Datacenter is the datacenter the fake agent reports it's in.
+const Datacenter = "dc1"
+
+// maxWait limits blocking queries, so tests don't hang.
+const maxWait = 10 * time.Second
+
+// Server is a fake consul agent, only endpoints consul-slack needs to
+// watch health checks with the default options are served, others respond
+// with 404. Query filters like node meta and the filter expression are ignored.
+type Server struct {
+	URL string // base url to pass to consul.WithAddress
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	index    uint64
+	changed  chan struct{} // closed and replaced on every change
+	checks   map[string]*api.HealthCheck
+	kv       map[string]*api.KVPair
+	sessions map[string]*api.SessionEntry
+	delays   map[string]time.Time // keys cannot be acquired until, see session
+}
+
+// NewServer starts a fake agent with no checks, Close stops it.
+func NewServer() *Server {
+	s := &Server{
+		index:    1,
+		changed:  make(chan struct{}),
+		checks:   map[string]*api.HealthCheck{},
+		kv:       map[string]*api.KVPair{},
+		sessions: map[string]*api.SessionEntry{},
+		delays:   map[string]time.Time{},
+	}
+	m := http.NewServeMux()
+	m.HandleFunc("/v1/status/leader", s.leader)
+	m.HandleFunc("/v1/agent/self", s.self)
+	m.HandleFunc("/v1/catalog/datacenters", s.datacenters)
+	m.HandleFunc("/v1/health/state/", s.state)
+	m.HandleFunc("/v1/kv/", s.keyValue)
+	m.HandleFunc("/v1/session/", s.session)
+	s.srv = httptest.NewServer(m)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetCheck adds the check or replaces the one with
+// the same node and check id, blocked watchers wake up.
+func (s *Server) SetCheck(hc *api.HealthCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *hc
+	s.checks[c.Node+"/"+c.CheckID] = &c
+	s.change()
+}
+
+// SetStatus changes the status and the output of the check,
+// false is returned when there's no such check.
+func (s *Server) SetStatus(node, checkID, status, output string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hc, ok := s.checks[node+"/"+checkID]
+	if !ok {
+		return false
+	}
+	hc.Status, hc.Output = status, output
+	s.change()
+	return true
+}
+
+// DeleteCheck removes the check as if it was deregistered.
+func (s *Server) DeleteCheck(node, checkID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checks, node+"/"+checkID)
+	s.change()
+}
+
+// Value returns the value of the key, false is returned when it's not set.
+func (s *Server) Value(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kv, ok := s.kv[key]
+	if !ok {
+		return nil, false
+	}
+	return kv.Value, true
+}
+
+// SetValue sets the value of the key.
+func (s *Server) SetValue(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value)
+}
+
+// change bumps the index and wakes up blocking queries, s.mu has to be held.
+func (s *Server) change() {
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// put sets the value of the key, s.mu has to be held.
+func (s *Server) put(key string, value []byte) *api.KVPair {
+	s.change()
+	kv, ok := s.kv[key]
+	if !ok {
+		kv = &api.KVPair{Key: key, CreateIndex: s.index}
+		s.kv[key] = kv
+	}
+	kv.Value, kv.ModifyIndex = value, s.index
+	return kv
+}
+
+// block waits until something changes after the index
+// given with the request, the wait time or maxWait passes.
+func (s *Server) block(r *http.Request) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	wait, err := time.ParseDuration(r.URL.Query().Get("wait"))
+	if err != nil || wait > maxWait {
+		wait = maxWait
+	}
+	s.mu.Lock()
+	if index == 0 || index < s.index {
+		s.mu.Unlock()
+		return
+	}
+	changed := s.changed
+	s.mu.Unlock()
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-changed:
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
+
+// reply writes v as json with headers of a query response, s.mu has to be held.
+func (s *Server) reply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	w.WriteHeader(code)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func (s *Server) leader(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`"127.0.0.1:8300"`))
+}
+
+func (s *Server) self(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, `{"Config":{"Datacenter":%q,"NodeName":"consultest"}}`, Datacenter)
+}
+
+func (s *Server) datacenters(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, `[%q]`, Datacenter)
+}
+
+// state serves all checks sorted by node and check id, the state isn't filtered.
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hcs := make(api.HealthChecks, 0, len(s.checks))
+	for _, hc := range s.checks {
+		hcs = append(hcs, hc)
+	}
+	sort.Slice(hcs, func(i, j int) bool {
+		if hcs[i].Node != hcs[j].Node {
+			return hcs[i].Node < hcs[j].Node
+		}
+		return hcs[i].CheckID < hcs[j].CheckID
+	})
+	s.reply(w, http.StatusOK, hcs)
+}
+
+// keyValue serves gets, lists, puts with cas and locks and deletes of keys.
+func (s *Server) keyValue(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	q := r.URL.Query()
+	if r.Method == http.MethodGet {
+		s.block(r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		var pairs api.KVPairs
+		for k, kv := range s.kv {
+			if k == key || isSet(q, "recurse") && strings.HasPrefix(k, key) {
+				pairs = append(pairs, kv)
+			}
+		}
+		if len(pairs) == 0 {
+			s.reply(w, http.StatusNotFound, nil)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].Key < pairs[j].Key
+		})
+		s.reply(w, http.StatusOK, pairs)
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		kv, exists := s.kv[key]
+		switch {
+		case isSet(q, "cas"):
+			cas, _ := strconv.ParseUint(q.Get("cas"), 10, 64)
+			if cas == 0 && exists || cas != 0 && (!exists || kv.ModifyIndex != cas) {
+				s.reply(w, http.StatusOK, false)
+				return
+			}
+			s.put(key, value)
+		case isSet(q, "acquire"):
+			id := q.Get("acquire")
+			if _, ok := s.sessions[id]; !ok || exists && kv.Session != "" && kv.Session != id ||
+				time.Now().Before(s.delays[key]) {
+				s.reply(w, http.StatusOK, false)
+				return
+			}
+			kv = s.put(key, value)
+			if kv.Session != id {
+				kv.Session = id
+				kv.LockIndex++
+			}
+		case isSet(q, "release"):
+			if !exists || kv.Session != q.Get("release") {
+				s.reply(w, http.StatusOK, false)
+				return
+			}
+			s.put(key, value).Session = ""
+		default:
+			s.put(key, value)
+		}
+		s.reply(w, http.StatusOK, true)
+	case http.MethodDelete:
+		for k := range s.kv {
+			if k == key || isSet(q, "recurse") && strings.HasPrefix(k, key) {
+				delete(s.kv, k)
+			}
+		}
+		s.change()
+		s.reply(w, http.StatusOK, true)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// session serves creating, renewing and destroying sessions,
+// they never expire, keys of destroyed sessions are released
+// or deleted according to the session behavior and cannot be
+// acquired within the lock-delay of the session, as consul does
+// when sessions are invalidated.
+func (s *Server) session(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/session/")
+	switch {
+	case path == "create":
+		// durations are strings in requests, so only names are decoded
+		var req struct{ Name, Node, Behavior, TTL, LockDelay string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		delay := 15 * time.Second // consul's default
+		if req.LockDelay != "" {
+			var err error
+			if delay, err = time.ParseDuration(req.LockDelay); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		sess := &api.SessionEntry{
+			ID:          fmt.Sprintf("00000000-0000-0000-0000-%012x", s.index),
+			CreateIndex: s.index,
+			Name:        req.Name,
+			Node:        req.Node,
+			Behavior:    req.Behavior,
+			TTL:         req.TTL,
+			LockDelay:   delay,
+		}
+		s.sessions[sess.ID] = sess
+		s.change()
+		s.reply(w, http.StatusOK, map[string]string{"ID": sess.ID})
+	case strings.HasPrefix(path, "renew/"):
+		sess, ok := s.sessions[strings.TrimPrefix(path, "renew/")]
+		if !ok {
+			s.reply(w, http.StatusNotFound, nil)
+			return
+		}
+		s.reply(w, http.StatusOK, []*api.SessionEntry{sess})
+	case strings.HasPrefix(path, "destroy/"):
+		id := strings.TrimPrefix(path, "destroy/")
+		if sess, ok := s.sessions[id]; ok {
+			for k, kv := range s.kv {
+				if kv.Session != id {
+					continue
+				}
+				s.delays[k] = time.Now().Add(sess.LockDelay)
+				if sess.Behavior == api.SessionBehaviorDelete {
+					delete(s.kv, k)
+				} else {
+					kv.Session = ""
+				}
+			}
+			delete(s.sessions, id)
+			s.change()
+		}
+		s.reply(w, http.StatusOK, true)
+	default:
+		s.reply(w, http.StatusNotFound, nil)
+	}
+}
+
+// isSet reports whether the query parameter is given, even without a value.
+func isSet(q map[string][]string, name string) bool {
+	_, ok := q[name]
+	return ok
+}
@@ -0,0 +1,88 @@
+package consul
+
+import (
+	"sort"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// rootCauses returns services that depend on critical services
+// mapped to the topmost critical service they depend on.
+func (c *Consul) rootCauses(hcs map[string]*api.HealthCheck) map[string]string {
+	critical := map[string]bool{}
+	for _, hc := range hcs {
+		if hc.ServiceID != "" && hc.Status == Critical {
+			critical[hc.ServiceName] = true
+		}
+	}
+
+	roots := map[string]string{}
+	for service := range c.dependencies {
+		if root := c.rootCause(service, critical, map[string]bool{}); root != "" {
+			roots[service] = root
+		}
+	}
+	return roots
+}
+
+// rootCause returns the topmost critical upstream of the service,
+// seen guards against dependency cycles.
+func (c *Consul) rootCause(service string, critical, seen map[string]bool) string {
+	seen[service] = true
+	for _, upstream := range c.dependencies[service] {
+		if seen[upstream] {
+			continue
+		}
+		if root := c.rootCause(upstream, critical, seen); root != "" {
+			return root
+		}
+		if critical[upstream] {
+			return upstream
+		}
+	}
+	return ""
+}
+
+// dependents returns root cause services mapped to their failing
+// dependents, it's done in one pass over health checks to keep
+// polls linear in the number of checks during mass failures.
+func dependents(roots map[string]string, hcs map[string]*api.HealthCheck) map[string][]string {
+	if len(roots) == 0 {
+		return nil
+	}
+	failing := map[string]bool{}
+	for _, hc := range hcs {
+		if _, ok := roots[hc.ServiceName]; ok && hc.ServiceID != "" && hc.Status != Passing {
+			failing[hc.ServiceName] = true
+		}
+	}
+	r := map[string][]string{}
+	for name := range failing {
+		r[roots[name]] = append(r[roots[name]], name)
+	}
+	for _, names := range r {
+		sort.Strings(names)
+	}
+	return r
+}
+
+// nodeServices returns the given nodes mapped to ids of their services.
+func nodeServices(nodes map[string]bool, hcs map[string]*api.HealthCheck) map[string][]string {
+	r := make(map[string][]string, len(nodes))
+	seen := map[string]bool{}
+	for node := range nodes {
+		r[node] = []string{}
+	}
+	for _, hc := range hcs {
+		// per-check ids have several entries per service
+		if !nodes[hc.Node] || hc.ServiceID == "" || seen[hc.Node+":"+hc.ServiceID] {
+			continue
+		}
+		seen[hc.Node+":"+hc.ServiceID] = true
+		r[hc.Node] = append(r[hc.Node], hc.ServiceID)
+	}
+	for _, ids := range r {
+		sort.Strings(ids)
+	}
+	return r
+}
@@ -0,0 +1,77 @@
+package consul
+
+import (
+	"regexp"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// matcher matches names against include and exclude patterns,
+// patterns are regular expressions matching the whole name
+// so plain names work as exact matches.
+type matcher struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newMatcher compiles the given patterns, an empty include
+// list means that everything not excluded matches.
+func newMatcher(include, exclude []string) (*matcher, error) {
+	m := &matcher{}
+	for _, p := range []struct {
+		src []string
+		dst *[]*regexp.Regexp
+	}{
+		{include, &m.include},
+		{exclude, &m.exclude},
+	} {
+		for _, s := range p.src {
+			if s == "" {
+				continue
+			}
+			re, err := regexp.Compile("^(?:" + s + ")$")
+			if err != nil {
+				return nil, err
+			}
+			*p.dst = append(*p.dst, re)
+		}
+	}
+	return m, nil
+}
+
+// match reports whether name is included and not excluded.
+func (m *matcher) match(name string) bool {
+	if m == nil {
+		return true
+	}
+	for _, re := range m.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(m.include) == 0 {
+		return true
+	}
+	for _, re := range m.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterChecks drops checks of services and nodes that aren't watched.
+func (c *Consul) filterChecks(hcs api.HealthChecks) api.HealthChecks {
+	r := hcs[:0]
+	for _, hc := range hcs {
+		if !c.nodes.match(hc.Node) {
+			continue
+		}
+		// node checks are kept since they affect all services on the node
+		if hc.ServiceID != "" && !c.services.match(hc.ServiceName) {
+			continue
+		}
+		r = append(r, hc)
+	}
+	return r
+}
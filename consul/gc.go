@@ -0,0 +1,108 @@
+package consul
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gc drops state keys that don't belong to any of the
+// configured watchers every gc interval until stopped.
+func (c *Consul) gc(keys map[string]bool) {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-c.failCh:
+			return
+		case <-time.After(c.gcInterval):
+		}
+
+		c.lockMu.RLock()
+		err := c.collect(keys)
+		c.lockMu.RUnlock()
+
+		switch err {
+		case nil:
+		case errStopped:
+			return
+		default:
+			// it's retried on the next run
+			c.warnf("state gc error: %v", err)
+		}
+	}
+}
+
+// collect deletes stale state keys, failing checks they hold
+// are reported as deleted when gc notifications are enabled.
+func (c *Consul) collect(keys map[string]bool) error {
+	// with shards it's up to the holder of the first one
+	if !c.Active() || c.shards > 0 && c.shardEpoch(0) == 0 {
+		return nil
+	}
+
+	pairs, _, err := c.api.KV().List(stateKey, nil)
+	if err != nil {
+		return err
+	}
+	for _, kv := range pairs {
+		if keys[kv.Key] || kv.Key != stateKey && !strings.HasPrefix(kv.Key, stateKey+"/") {
+			continue
+		}
+		// states of other profiles are up to them
+		if parseStateKey(kv.Key).profile != c.profile {
+			continue
+		}
+
+		if c.gcNotify {
+			s, err := decodeState(kv.Value)
+			if err != nil {
+				return err
+			}
+			w := parseStateKey(kv.Key)
+			w.partition = c.partition
+			for id, status := range s.Checks {
+				if status == Passing {
+					continue
+				}
+				c.logf("%s%s: disappeared", w.prefix(), id)
+				if !c.send(c.deletedEvent(w, id, status)) {
+					return errStopped
+				}
+			}
+		}
+
+		c.logf("dropping stale state %s", kv.Key)
+		if _, err = c.api.KV().Delete(kv.Key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStateKey returns a watcher the given state key belongs to,
+// it's the reverse of the watcher's stateKey method.
+func parseStateKey(key string) *watcher {
+	w := &watcher{}
+	parts := strings.Split(strings.TrimPrefix(key, stateKey), "/")[1:]
+	if len(parts) >= 2 && parts[0] == "profile" {
+		w.profile, parts = parts[1], parts[2:]
+	}
+	if n := len(parts); n >= 2 && parts[n-2] == "shard" {
+		if i, err := strconv.Atoi(parts[n-1]); err == nil {
+			w.sharded, w.shard, parts = true, i, parts[:n-2]
+		}
+	}
+	switch {
+	case len(parts) >= 2 && parts[0] == "peer":
+		w.peer, parts = parts[1], parts[2:]
+	case len(parts) >= 1 && parts[0] != "service":
+		w.dc, parts = parts[0], parts[1:]
+	}
+	if len(parts) == 2 && parts[0] == "service" {
+		w.service = parts[1]
+	}
+	w.datacenter = w.dc
+	return w
+}
@@ -0,0 +1,54 @@
+package consul
+
+// groupRanks orders statuses of grouped events, the most severe one leads the group.
+var groupRanks = map[string]int{
+	Critical:    3,
+	Warning:     2,
+	Maintenance: 1,
+}
+
+// groupByNode merges events of the same node into one event per node,
+// it's a copy of the most severe one with the rest in the Grouped field.
+func groupByNode(evs []*Event) []*Event {
+	sortEvents(evs)
+	r := make([]*Event, 0, len(evs))
+	for i := 0; i < len(evs); {
+		j := i + 1
+		for j < len(evs) && evs[j].Location() == evs[i].Location() {
+			j++
+		}
+		if j-i == 1 {
+			r = append(r, evs[i])
+			i = j
+			continue
+		}
+
+		lead := i
+		for k := i + 1; k < j; k++ {
+			if groupRanks[evs[k].Status] > groupRanks[evs[lead].Status] {
+				lead = k
+			}
+		}
+		ev := *evs[lead]
+		ev.Grouped = make([]*Event, 0, j-i-1)
+		for k := i; k < j; k++ {
+			if k != lead {
+				ev.Grouped = append(ev.Grouped, evs[k])
+			}
+		}
+		r = append(r, &ev)
+		i = j
+	}
+	return r
+}
+
+// Ungroup returns the event without grouped events followed
+// by them, it's only the event itself when it's not a group.
+func (ev *Event) Ungroup() []*Event {
+	if len(ev.Grouped) == 0 {
+		return []*Event{ev}
+	}
+	e := *ev
+	e.Grouped = nil
+	return append([]*Event{&e}, ev.Grouped...)
+}
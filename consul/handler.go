@@ -0,0 +1,62 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// errConcurrentHandle is returned by Handle when another handler
+// has saved the state in the meantime.
+var errConcurrentHandle = errors.New("consul: state has been changed by another handler")
+
+// Handle reads a json health checks array, that `consul watch -type=checks`
+// passes to its handler on stdin, compares it with the saved state and
+// returns events for all changes, the state is saved before returning.
+//
+// It's meant for a single datacenter, no lock is taken so when the state
+// is changed concurrently by another handler an error is returned
+// without events, so that the same changes aren't reported twice.
+// Every call reloads the state, it cannot be mixed with Run.
+func (c *Consul) Handle(r io.Reader) ([]*Event, error) {
+	var data api.HealthChecks
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, err
+	}
+	if len(c.watchers) != 1 || c.watchers[0].peer != "" || c.watchers[0].service != "" {
+		return nil, errors.New("consul: handler supports a single datacenter without peers and service watchers")
+	}
+	if c.confirmations > 1 {
+		return nil, errors.New("consul: handler doesn't support confirmations")
+	}
+
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return nil, errors.New("consul: already running")
+	}
+	c.handling = true
+	c.active = true
+	c.mu.Unlock()
+	c.epoch++
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.process(c.watchers[0], data)
+	}()
+
+	var evs []*Event
+	for {
+		select {
+		case ev := <-c.events:
+			evs = append(evs, ev)
+		case err := <-done:
+			if err != nil {
+				return nil, err
+			}
+			return evs, nil
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package consul
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const heartbeatKey = "consul-slack/heartbeat"
+
+// Beat is a heartbeat written to the KV by the active instance,
+// so monitoring can notice when consul-slack itself is gone.
+type Beat struct {
+	Host string    `json:"host"`
+	Time time.Time `json:"time"`
+}
+
+// Beat writes the current time to the heartbeat key,
+// read-only instances write nothing.
+func (c *Consul) Beat() error {
+	if c.readOnly {
+		return nil
+	}
+	b, err := json.Marshal(&Beat{Host: c.hostname, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = c.api.KV().Put(&api.KVPair{Key: heartbeatKey, Value: b}, nil)
+	return err
+}
+
+// LastBeat returns the last written heartbeat, nil when there's none.
+func (c *Consul) LastBeat() (*Beat, error) {
+	kv, _, err := c.api.KV().Get(heartbeatKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	if kv == nil {
+		return nil, nil
+	}
+	var b Beat
+	if err = json.Unmarshal(kv.Value, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
@@ -0,0 +1,79 @@
+package consul
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Value returns the value of the KV key with surrounding whitespace
+// trimmed, it's empty when the key doesn't exist.
+func (c *Consul) Value(key string) (string, error) {
+	kv, _, err := c.api.KV().Get(key, nil)
+	if err != nil || kv == nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(kv.Value)), nil
+}
+
+// SetValue sets the value of the KV key, nothing is written in read-only mode.
+func (c *Consul) SetValue(key, value string) error {
+	if c.readOnly {
+		return nil
+	}
+	_, err := c.api.KV().Put(&api.KVPair{Key: key, Value: []byte(value)}, nil)
+	return err
+}
+
+// WatchPrefix calls fn with values of keys under the KV prefix, keyed by
+// names relative to it, right away and then every time they change until
+// ctx is done. It watches them with blocking queries, errors are logged
+// and retried with backoff.
+func (c *Consul) WatchPrefix(ctx context.Context, prefix string, fn func(values map[string]string)) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	var (
+		index   uint64
+		retries int
+	)
+	for {
+		q := (&api.QueryOptions{WaitIndex: index, WaitTime: c.waitTime}).WithContext(ctx)
+		pairs, meta, err := c.api.KV().List(prefix, q)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.warnf("watch %s: %v, retrying", prefix, err)
+			index = 0
+			select {
+			case <-time.After(c.backoff(retries) + c.jittered()):
+			case <-ctx.Done():
+				return
+			}
+			retries++
+			continue
+		}
+		retries = 0
+
+		// blocking query timed out, nothing has changed
+		if index != 0 && meta.LastIndex == index {
+			continue
+		}
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+		if index == 0 {
+			index = 1 // zero doesn't block
+		}
+		values := make(map[string]string, len(pairs))
+		for _, kv := range pairs {
+			if name := strings.TrimPrefix(kv.Key, prefix); name != "" {
+				values[name] = string(kv.Value)
+			}
+		}
+		fn(values)
+	}
+}
@@ -0,0 +1,70 @@
+package consul
+
+import (
+	"net/url"
+)
+
+// catalogService is a catalog service instance along with its meta,
+// the vendored api client predates service meta and drops it.
+type catalogService struct {
+	Node        string
+	ServiceID   string
+	ServiceMeta map[string]string
+}
+
+// metaCache caches service instances for a single poll, so a service
+// failing on many nodes at once is looked up only once.
+type metaCache map[string][]*catalogService
+
+// enrich sets Meta of the service event to the service meta fields set
+// with WithServiceMeta, lookup errors are only logged since an event
+// without meta is better than no event at all.
+func (c *Consul) enrich(w *watcher, ev *Event, cache metaCache) {
+	if len(c.serviceMeta) == 0 || ev.ServiceName == "" {
+		return
+	}
+	instances, ok := cache[ev.ServiceName]
+	if !ok {
+		var err error
+		instances, err = c.catalogService(w, ev.ServiceName)
+		if err != nil {
+			c.warnf("%s%s: service meta error: %v", w.prefix(), ev.ID, err)
+			return
+		}
+		cache[ev.ServiceName] = instances
+	}
+	ev.Meta = pickMeta(instances, ev, c.serviceMeta)
+}
+
+// catalogService returns all instances of the named service.
+func (c *Consul) catalogService(w *watcher, name string) ([]*catalogService, error) {
+	var instances []*catalogService
+	_, err := c.api.Raw().Query("/v1/catalog/service/"+url.PathEscape(name), &instances, c.cached(c.queryOptions(w)))
+	return instances, err
+}
+
+// pickMeta returns the given meta fields of the event's instance, aggregated
+// services have no instance and get fields of the first instance having them.
+func pickMeta(instances []*catalogService, ev *Event, keys []string) map[string]string {
+	var m map[string]string
+	for _, inst := range instances {
+		if inst.Node == ev.Node && inst.ServiceID == ev.ServiceID {
+			m = inst.ServiceMeta
+			break
+		}
+		if m == nil && len(inst.ServiceMeta) != 0 {
+			m = inst.ServiceMeta
+		}
+	}
+
+	var r map[string]string
+	for _, k := range keys {
+		if v, ok := m[k]; ok && v != "" {
+			if r == nil {
+				r = map[string]string{}
+			}
+			r[k] = v
+		}
+	}
+	return r
+}
@@ -0,0 +1,69 @@
+package consul
+
+import (
+	"regexp"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// compilePatterns compiles noise and redaction patterns,
+// unlike name patterns they match anywhere in outputs and notes.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	r := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, re)
+	}
+	return r, nil
+}
+
+// noisy returns the noise pattern output or notes of the failing check match,
+// false is returned when there's none or the check isn't failing.
+func (c *Consul) noisy(hc *api.HealthCheck) (string, bool) {
+	if hc.Status != Warning && hc.Status != Critical {
+		return "", false
+	}
+	for _, re := range c.noise {
+		if re.MatchString(hc.Output) || re.MatchString(hc.Notes) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
+
+// suppressNoise reports whether the check is noisy, it's counted once
+// every time it starts matching a pattern rather than on every poll.
+func (c *Consul) suppressNoise(w *watcher, id string, hc *api.HealthCheck) bool {
+	p, ok := c.noisy(hc)
+	if !ok {
+		delete(w.noisy, id)
+		return false
+	}
+	if w.noisy[id] == p {
+		return true
+	}
+	if w.noisy == nil {
+		w.noisy = map[string]string{}
+	}
+	w.noisy[id] = p
+	c.mu.Lock()
+	c.noiseCounts[p]++
+	c.mu.Unlock()
+	c.logf("%s%s: %s suppressed by noise filter %q", w.prefix(), id, hc.Status, p)
+	return true
+}
+
+// NoiseSuppressed returns numbers of changes suppressed by noise filters
+// by pattern, see WithNoiseFilters.
+func (c *Consul) NoiseSuppressed() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]uint64, len(c.noise))
+	for _, re := range c.noise {
+		m[re.String()] = c.noiseCounts[re.String()]
+	}
+	return m
+}
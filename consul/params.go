@@ -0,0 +1,107 @@
+package consul
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// extrasKey is the request context key of extra request parameters.
+type extrasKey struct{}
+
+// extras are query parameters and headers the vendored api client lacks.
+type extras struct {
+	params   url.Values
+	header   http.Header
+	okStatus int // response status treated as 200 OK, zero when there's none
+}
+
+// extrasOf returns a copy of extras of q.
+func extrasOf(q *api.QueryOptions) *extras {
+	x := &extras{params: url.Values{}, header: http.Header{}}
+	if v, ok := q.Context().Value(extrasKey{}).(*extras); ok {
+		for k, vs := range v.params {
+			x.params[k] = vs
+		}
+		for k, vs := range v.header {
+			x.header[k] = vs
+		}
+		x.okStatus = v.okStatus
+	}
+	return x
+}
+
+// withParams returns a copy of q that adds the given parameters to the
+// request url, it's for parameters the vendored api client lacks.
+func withParams(q *api.QueryOptions, params url.Values) *api.QueryOptions {
+	x := extrasOf(q)
+	for k, vs := range params {
+		x.params[k] = vs
+	}
+	return q.WithContext(context.WithValue(q.Context(), extrasKey{}, x))
+}
+
+// withHeader returns a copy of q that sets the given request header.
+func withHeader(q *api.QueryOptions, key, value string) *api.QueryOptions {
+	x := extrasOf(q)
+	x.header.Set(key, value)
+	return q.WithContext(context.WithValue(q.Context(), extrasKey{}, x))
+}
+
+// withOKStatus returns a copy of q that treats responses with the status as
+// successful ones, e.g. endpoints that report unhealthy state with 429.
+func withOKStatus(q *api.QueryOptions, status int) *api.QueryOptions {
+	x := extrasOf(q)
+	x.okStatus = status
+	return q.WithContext(context.WithValue(q.Context(), extrasKey{}, x))
+}
+
+// paramsTransport adds query parameters and headers set by withParams
+// and withHeader to requests, params are added to all requests, and
+// makes responses with the status set by withOKStatus successful.
+type paramsTransport struct {
+	rt     http.RoundTripper
+	params url.Values
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *paramsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	x, ok := r.Context().Value(extrasKey{}).(*extras)
+	if !ok && len(t.params) == 0 {
+		return t.rt.RoundTrip(r)
+	}
+	if !ok {
+		x = &extras{}
+	}
+
+	// requests must not be modified by round trippers
+	u := *r.URL
+	q := u.Query()
+	for k, vs := range t.params {
+		q[k] = vs
+	}
+	for k, vs := range x.params {
+		q[k] = vs
+	}
+	u.RawQuery = q.Encode()
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = &u
+	if len(x.header) != 0 {
+		r2.Header = make(http.Header, len(r.Header)+len(x.header))
+		for k, vs := range r.Header {
+			r2.Header[k] = vs
+		}
+		for k, vs := range x.header {
+			r2.Header[k] = vs
+		}
+	}
+	resp, err := t.rt.RoundTrip(r2)
+	if err == nil && x.okStatus != 0 && resp.StatusCode == x.okStatus {
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+	}
+	return resp, err
+}
@@ -0,0 +1,32 @@
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+)
+
+// peerChecks returns health checks of all services imported from
+// the peer set in q, the vendored api client doesn't support peering,
+// so the peer parameter is added by paramsTransport.
+func (c *Consul) peerChecks(q *api.QueryOptions) (api.HealthChecks, *api.QueryMeta, error) {
+	// health of imported services doesn't change the catalog index
+	q.WaitIndex = 0
+
+	services, _, err := c.api.Catalog().Services(q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var hcs api.HealthChecks
+	for name := range services {
+		entries, _, err := c.api.Health().Service(name, "", false, c.cached(q))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, e := range entries {
+			hcs = append(hcs, e.Checks...)
+		}
+	}
+
+	// zero index makes every poll processed
+	return hcs, &api.QueryMeta{}, nil
+}
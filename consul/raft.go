@@ -0,0 +1,136 @@
+package consul
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Check ids of events about the raft leader and quorum of
+// servers of the local datacenter, see WithRaftMonitoring.
+const (
+	LeaderCheckID = "consul-slack/leader"
+	QuorumCheckID = "consul-slack/quorum"
+)
+
+// WithRaftMonitoring polls the raft leader and peers every interval and sends
+// critical events when the datacenter has no leader or fewer alive servers than
+// the quorum of its peers and passing ones when it recovers, zero disables it.
+// Problems found by the first poll are reported as well.
+func WithRaftMonitoring(interval time.Duration) Option {
+	return func(c *Consul) {
+		c.raftInterval = interval
+	}
+}
+
+// watchRaft reports changes of the leader and quorum every raft interval until stopped.
+// Sessions cannot be renewed without a leader, so the lock is lost then and the instance
+// that was active before keeps reporting until there's a leader again.
+func (c *Consul) watchRaft() {
+	defer c.wg.Done()
+	var (
+		statuses = map[string]string{}
+		reporter = false
+	)
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-c.failCh:
+			return
+		case <-time.After(c.raftInterval):
+		}
+
+		evs, leader, err := c.raftEvents(statuses)
+		if err != nil {
+			// it's retried on the next run
+			c.warnf("raft status error: %v", err)
+			continue
+		}
+		if c.Active() || leader {
+			reporter = c.Active() && (c.shards == 0 || c.shardEpoch(0) != 0)
+		}
+		if !reporter {
+			continue
+		}
+		for _, ev := range evs {
+			c.logf("%s: %s -> %s", ev.Name, ev.PrevStatus, ev.Status)
+			if !c.send(ev) {
+				return
+			}
+		}
+	}
+}
+
+// raftEvents returns events about the leader and quorum that changed their statuses,
+// statuses keeps the last ones by check id. The quorum isn't checked without a leader
+// since peers cannot be listed then, leader is true when the datacenter has one.
+func (c *Consul) raftEvents(statuses map[string]string) ([]*Event, bool, error) {
+	dc, err := c.localDatacenter()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// agents respond with an empty string or an error when there's no leader
+	leader, err := c.api.Status().Leader()
+	if err != nil && !strings.Contains(err.Error(), "No cluster leader") {
+		return nil, false, err
+	}
+	checks := map[string]*Event{}
+	if leader == "" {
+		checks[LeaderCheckID] = &Event{Status: Critical, Output: "no cluster leader"}
+	} else {
+		checks[LeaderCheckID] = &Event{Status: Passing, Output: "leader is " + leader}
+
+		peers, err := c.api.Status().Peers()
+		if err != nil {
+			return nil, false, err
+		}
+		members, err := c.api.Agent().Members(false)
+		if err != nil {
+			return nil, false, err
+		}
+		alive := map[string]bool{}
+		for _, m := range members {
+			if m.Tags["role"] == "consul" && m.Status == serfAlive {
+				alive[net.JoinHostPort(m.Addr, m.Tags["port"])] = true
+			}
+		}
+		n := 0
+		for _, p := range peers {
+			if alive[p] {
+				n++
+			}
+		}
+		quorum := len(peers)/2 + 1
+		ev := &Event{
+			Status: Passing,
+			Output: fmt.Sprintf("%d of %d servers are alive, quorum is %d", n, len(peers), quorum),
+		}
+		if n < quorum {
+			ev.Status = Critical
+		}
+		checks[QuorumCheckID] = ev
+	}
+
+	var evs []*Event
+	for _, id := range []string{LeaderCheckID, QuorumCheckID} {
+		ev, ok := checks[id]
+		if !ok {
+			continue
+		}
+		prev, seen := statuses[id]
+		statuses[id] = ev.Status
+		if prev == ev.Status || !seen && ev.Status == Passing {
+			continue
+		}
+		ev.Datacenter = dc
+		ev.CheckID = id
+		ev.Name = strings.TrimPrefix(id, "consul-slack/")
+		ev.ID = id
+		ev.PrevStatus = prev
+		evs = append(evs, ev)
+	}
+	return evs, leader != "", nil
+}
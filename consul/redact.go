@@ -0,0 +1,52 @@
+package consul
+
+import (
+	"regexp"
+)
+
+// DefaultRedactions are patterns of common credentials leaking into
+// check outputs: passwords of urls, key=value and key: value secrets,
+// authorization headers, aws access keys and private key blocks.
+var DefaultRedactions = []string{
+	`[a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]+:([^@/\s]+)@`,
+	`(?i)(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key)"?\s*[=:]\s*"?([^\s"'&;,]+)`,
+	`(?i)authorization:\s*(?:bearer|basic|token)\s+(\S+)`,
+	`\bAKIA[0-9A-Z]{16}\b`,
+	`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// redacted replaces secrets matched by redaction patterns.
+const redacted = "[REDACTED]"
+
+// redact replaces secrets in output and notes of the event.
+func (c *Consul) redact(ev *Event) {
+	for _, re := range c.redactions {
+		ev.Output = redactString(re, ev.Output)
+		ev.Notes = redactString(re, ev.Notes)
+	}
+}
+
+// redactString replaces matches of re in s, only the first group
+// is replaced when the pattern has one, e.g. to keep the key of
+// a key=value pair, otherwise the whole match is.
+func redactString(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, redacted)
+	}
+	var (
+		r    []byte
+		last int
+	)
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		if m[2] < 0 {
+			continue
+		}
+		r = append(r, s[last:m[2]]...)
+		r = append(r, redacted...)
+		last = m[3]
+	}
+	if r == nil {
+		return s
+	}
+	return string(append(r, s[last:]...))
+}
@@ -0,0 +1,91 @@
+package consul
+
+import (
+	"os"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// WithSelfRegistration registers the process as a service with the name on
+// the agent along with a ttl check kept passing by successful polls, so
+// when it dies or gets stuck the check turns critical and other instances
+// notify about it. It's deregistered when the process stops normally,
+// but not when watching fails. Empty name disables it.
+func WithSelfRegistration(name string, ttl time.Duration) Option {
+	return func(c *Consul) {
+		c.selfName = name
+		c.selfTTL = ttl
+	}
+}
+
+// selfID returns the service id of the process, instances
+// registered on the same agent are told apart by hostnames.
+func (c *Consul) selfID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return c.selfName
+	}
+	return c.selfName + "-" + host
+}
+
+// registerSelf registers the service and passes its ttl check on
+// heartbeats not more often than a third of the ttl until stopped.
+func (c *Consul) registerSelf() {
+	defer c.wg.Done()
+	id := c.selfID()
+	reg := &api.AgentServiceRegistration{
+		ID:   id,
+		Name: c.selfName,
+		Check: &api.AgentServiceCheck{
+			TTL:   c.selfTTL.String(),
+			Notes: "updated by consul-slack on every successful poll",
+		},
+	}
+	for n := 0; ; n++ {
+		err := c.api.Agent().ServiceRegister(reg)
+		if err == nil {
+			break
+		}
+		c.warnf("self registration error: %v", err)
+		if !c.sleep(n) {
+			return
+		}
+	}
+	c.debugf("registered as %s", id)
+
+	var (
+		last   time.Time
+		status string
+	)
+	for {
+		select {
+		case active := <-c.selfBeats:
+			output := "standby"
+			if active {
+				output = "active"
+			}
+			if output == status && time.Since(last) < c.selfTTL/3 {
+				continue
+			}
+			if err := c.api.Agent().UpdateTTL("service:"+id, output, api.HealthPassing); err != nil {
+				// the check turns critical when it fails for the whole ttl
+				c.warnf("self check update error: %v", err)
+				continue
+			}
+			last, status = time.Now(), output
+		case <-c.failCh:
+			return
+		case <-c.stopCh:
+			select {
+			case <-c.failCh:
+				return
+			default:
+			}
+			if err := c.api.Agent().ServiceDeregister(id); err != nil {
+				c.warnf("self deregistration error: %v", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package consul
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// serviceStatus replaces statuses of service instances with statuses
+// of their services keyed by :service name, node checks are kept as is.
+//
+// With an instance threshold a service is critical when number of its
+// critical instances is over the threshold, otherwise it's passing,
+// without it a service has the worst status of its instances.
+func (c *Consul) serviceStatus(hcs map[string]*api.HealthCheck) map[string]*api.HealthCheck {
+	type group struct {
+		hc        *api.HealthCheck
+		instances map[string]string // worst status of every instance
+	}
+
+	r := make(map[string]*api.HealthCheck, len(hcs))
+	groups := map[string]*group{}
+	for id, hc := range hcs {
+		if hc.ServiceID == "" {
+			r[id] = hc
+			continue
+		}
+		g, ok := groups[hc.ServiceName]
+		if !ok {
+			g = &group{hc: hc, instances: map[string]string{}}
+			groups[hc.ServiceName] = g
+		}
+
+		// per-check ids have several entries per instance
+		inst := hc.Node + ":" + hc.ServiceID
+		if s, ok := g.instances[inst]; !ok || statuses[s] < statuses[hc.Status] {
+			g.instances[inst] = hc.Status
+		}
+	}
+
+	for name, g := range groups {
+		status := Passing
+		for _, s := range g.instances {
+			if statuses[status] < statuses[s] {
+				status = s
+			}
+		}
+
+		// instances of the status the output is about
+		counted := status
+		if c.thresholdEnabled {
+			counted = Critical
+		}
+		var nodes []string
+		for inst, s := range g.instances {
+			if s == counted {
+				nodes = append(nodes, inst[:strings.IndexByte(inst, ':')])
+			}
+		}
+		if c.thresholdEnabled {
+			status = Passing
+			if c.overThreshold(len(nodes), len(g.instances)) {
+				status = Critical
+			}
+		}
+
+		output := fmt.Sprintf("%d/%d instances are %s", len(nodes), len(g.instances), counted)
+		if len(nodes) != 0 && counted != Passing {
+			sort.Strings(nodes)
+			output += " on nodes " + strings.Join(nodes, ", ")
+		}
+		r[":"+name] = &api.HealthCheck{
+			ServiceID:   name,
+			ServiceName: name,
+			ServiceTags: g.hc.ServiceTags,
+			Status:      status,
+			Output:      output,
+		}
+	}
+	return r
+}
+
+// overThreshold reports whether number of critical instances
+// out of total is more than the configured threshold.
+func (c *Consul) overThreshold(critical, total int) bool {
+	if c.thresholdPercent {
+		return critical*100 > c.threshold*total
+	}
+	return critical > c.threshold
+}
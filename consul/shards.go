@@ -0,0 +1,297 @@
+package consul
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const shardsKey = "consul-slack/shards"
+
+// ShardHolders returns hostnames of instances holding shards.
+func (c *Consul) ShardHolders() (map[int]string, error) {
+	pairs, _, err := c.api.KV().List(shardsKey+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	m := map[int]string{}
+	for _, kv := range pairs {
+		i, err := strconv.Atoi(strings.TrimPrefix(kv.Key, shardsKey+"/"))
+		if err != nil || kv.Session == "" {
+			continue
+		}
+		m[i] = string(kv.Value)
+	}
+	return m, nil
+}
+
+// shardOf returns the shard the check belongs to, services are hashed
+// by name so all instances of a service end up in the same shard,
+// node checks are hashed by node name.
+func (c *Consul) shardOf(hc *api.HealthCheck) int {
+	key := hc.ServiceName
+	if key == "" {
+		key = hc.Node
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(c.shards))
+}
+
+// shardChecks returns checks that belong to the watcher's shard.
+func (c *Consul) shardChecks(w *watcher, data api.HealthChecks) api.HealthChecks {
+	r := make(api.HealthChecks, 0, len(data)/c.shards+1)
+	for _, hc := range data {
+		if c.shardOf(hc) == w.shard {
+			r = append(r, hc)
+		}
+	}
+	return r
+}
+
+// owner returns the member the shard belongs to using rendezvous
+// hashing, so only shards of a failed or a new member move around.
+func owner(members []string, shard int) string {
+	var (
+		best string
+		max  uint64
+	)
+	for _, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(m + "/" + strconv.Itoa(shard)))
+		if sum := fmix64(h.Sum64()); best == "" || sum > max {
+			best, max = m, sum
+		}
+	}
+	return best
+}
+
+// fmix64 is the murmur3 finalizer, fnv alone doesn't spread
+// similar keys like ones differing in the last byte evenly.
+func fmix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// shardEpoch returns the epoch the shard has been acquired in, zero when it's not held.
+func (c *Consul) shardEpoch(shard int) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.shardEpochs[shard]
+}
+
+// setShard marks the shard held or released and updates the active flag,
+// the instance is active while it holds at least one shard.
+func (c *Consul) setShard(shard int, held bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if held {
+		c.epoch++
+		c.shardEpochs[shard] = c.epoch
+	} else {
+		c.shardEpochs[shard] = 0
+	}
+	c.active = false
+	for _, epoch := range c.shardEpochs {
+		if epoch != 0 {
+			c.active = true
+			break
+		}
+	}
+}
+
+// balance keeps a session registered as a shard member and retries
+// errors until stopped, a new session is created when the previous one
+// expires, shards held by it are acquired by other members in the meantime.
+func (c *Consul) balance() {
+	defer c.wg.Done()
+	for n := 0; ; {
+		err := c.member()
+		switch {
+		case err == errStopped:
+			return
+		case err == api.ErrSessionExpired:
+			c.warnf("shard session expired")
+			n = 0
+			continue
+		case permanent(err):
+			c.fail(err)
+			return
+		}
+		c.warnf("shard session error: %v", err)
+		if !c.sleep(n) {
+			return
+		}
+		n++
+	}
+}
+
+// member creates a session, registers it as a shard member and keeps
+// acquiring shards it owns and releasing the ones it doesn't until
+// the session expires or watching is stopped, the session is destroyed
+// on return and all shards it holds are released.
+func (c *Consul) member() error {
+	sess, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	c.debugf("shard session created")
+	defer func() {
+		for i := range c.shardEpochs {
+			c.releaseShard(i, "")
+		}
+		c.destroy(sess)
+	}()
+
+	ok, _, err := c.api.KV().Acquire(&api.KVPair{
+		Key:     shardsKey + "/members/" + sess,
+		Value:   []byte(c.hostname),
+		Session: sess,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("consul: cannot register shard member %s", sess)
+	}
+
+	var (
+		index   uint64
+		renewed = time.Now()
+		retries int
+	)
+	for {
+		select {
+		case <-c.stopCh:
+			return errStopped
+		case <-c.failCh:
+			return errStopped
+		default:
+		}
+
+		if time.Since(renewed) >= c.renewInterval {
+			entry, _, err := c.api.Session().Renew(sess, nil)
+			switch {
+			case err != nil:
+				c.warnf("renew session error: %v", err)
+			case entry == nil:
+				return api.ErrSessionExpired
+			default:
+				renewed = time.Now()
+			}
+		}
+
+		// wake up in time to renew the session
+		wait := c.renewInterval - time.Since(renewed)
+		if wait > c.waitTime {
+			wait = c.waitTime
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+		pairs, meta, err := c.api.KV().List(shardsKey+"/", &api.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  wait,
+		})
+		if err == nil {
+			c.beat(c.Active())
+			index = meta.LastIndex
+			err = c.rebalance(sess, pairs)
+		}
+		if err == nil {
+			retries = 0
+			continue
+		}
+		if permanent(err) {
+			return err
+		}
+		c.warnf("shards error: %v, retrying", err)
+		index = 0
+		if !c.sleep(retries) {
+			return errStopped
+		}
+		retries++
+	}
+}
+
+// rebalance acquires free shards the session owns
+// and releases the ones it holds but doesn't own.
+func (c *Consul) rebalance(sess string, pairs api.KVPairs) error {
+	var members []string
+	holders := map[int]string{}
+	for _, kv := range pairs {
+		name := strings.TrimPrefix(kv.Key, shardsKey+"/")
+		if strings.HasPrefix(name, "members/") {
+			if kv.Session != "" {
+				members = append(members, kv.Session)
+			}
+			continue
+		}
+		if i, err := strconv.Atoi(name); err == nil {
+			holders[i] = kv.Session
+		}
+	}
+
+	for i := range c.shardEpochs {
+		mine := owner(members, i) == sess
+		held := c.shardEpoch(i) != 0
+		switch {
+		case held && holders[i] != sess:
+			// the session has been invalidated
+			c.releaseShard(i, "")
+		case held && !mine:
+			c.releaseShard(i, sess)
+		case !held && holders[i] == sess:
+			// releasing it failed before
+			if _, _, err := c.api.KV().Release(&api.KVPair{
+				Key:     shardsKey + "/" + strconv.Itoa(i),
+				Session: sess,
+			}, nil); err != nil {
+				return err
+			}
+		case !held && mine && holders[i] == "":
+			ok, _, err := c.api.KV().Acquire(&api.KVPair{
+				Key:     shardsKey + "/" + strconv.Itoa(i),
+				Value:   []byte(c.hostname),
+				Session: sess,
+			}, nil)
+			if err != nil {
+				return err
+			}
+			if ok {
+				c.setShard(i, true)
+				c.logf("shard %d acquired", i)
+			}
+		}
+	}
+	return nil
+}
+
+// releaseShard waits until the shard's watchers are done processing
+// and marks it released, the lock is released too unless sess is empty.
+func (c *Consul) releaseShard(shard int, sess string) {
+	if c.shardEpoch(shard) == 0 {
+		return
+	}
+	c.shardMu[shard].Lock()
+	defer c.shardMu[shard].Unlock()
+	if sess != "" {
+		_, _, err := c.api.KV().Release(&api.KVPair{
+			Key:     shardsKey + "/" + strconv.Itoa(shard),
+			Session: sess,
+		}, nil)
+		if err != nil {
+			c.warnf("release shard %d error: %v", shard, err)
+		}
+	}
+	c.setShard(shard, false)
+	c.logf("shard %d released", shard)
+}
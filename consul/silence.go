@@ -0,0 +1,69 @@
+package consul
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const silenceKey = "consul-slack/silences"
+
+// silence is a silence value, silences are kept in the KV so all
+// instances share them and a standby taking over honors them too.
+type silence struct {
+	Until time.Time `json:"until"`
+}
+
+// Silence silences notifications about the service for the given
+// duration, a non-positive one lifts the silence. Statuses of silenced
+// services are still tracked, so only changes that happen after the
+// silence is over are reported.
+func (c *Consul) Silence(service string, d time.Duration) error {
+	key := silenceKey + "/" + service
+	if d <= 0 {
+		_, err := c.api.KV().Delete(key, nil)
+		return err
+	}
+	b, err := json.Marshal(&silence{Until: time.Now().Add(d)})
+	if err != nil {
+		return err
+	}
+	_, err = c.api.KV().Put(&api.KVPair{Key: key, Value: b}, nil)
+	return err
+}
+
+// silenced reports whether the event is about a silenced service.
+func (c *Consul) silenced(w *watcher, id string, ev *Event, silences map[string]time.Time) bool {
+	until, ok := silences[ev.ServiceName]
+	if !ok || ev.ServiceName == "" {
+		return false
+	}
+	c.logf("%s%s: silenced until %s", w.prefix(), id, until.Format(time.RFC3339))
+	return true
+}
+
+// Silences returns silenced services along with the time
+// their silences are over, expired silences are omitted.
+func (c *Consul) Silences() (map[string]time.Time, error) {
+	pairs, _, err := c.api.KV().List(silenceKey+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	m := make(map[string]time.Time, len(pairs))
+	for _, kv := range pairs {
+		if !strings.HasPrefix(kv.Key, silenceKey+"/") {
+			continue
+		}
+		var s silence
+		if err = json.Unmarshal(kv.Value, &s); err != nil {
+			return nil, err
+		}
+		if s.Until.After(now) {
+			m[strings.TrimPrefix(kv.Key, silenceKey+"/")] = s.Until
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,91 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// snapshotVersion is the current version of the snapshot format.
+const snapshotVersion = 1
+
+// Snapshot is the KV data of all instances: saved states of every
+// datacenter, peer and shard watcher, silences and acks.
+type Snapshot struct {
+	Version  int                        `json:"version"`
+	States   map[string]json.RawMessage `json:"states"` // saved states by KV key
+	Silences map[string]time.Time       `json:"silences"`
+	Acks     map[string]*Ack            `json:"acks"`
+}
+
+// Export returns the snapshot of the KV data, expired silences are omitted.
+func (c *Consul) Export() (*Snapshot, error) {
+	pairs, _, err := c.api.KV().List(stateKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Version: snapshotVersion, States: map[string]json.RawMessage{}}
+	for _, kv := range pairs {
+		if kv.Key != stateKey && !strings.HasPrefix(kv.Key, stateKey+"/") {
+			continue
+		}
+		s.States[kv.Key] = json.RawMessage(kv.Value)
+	}
+	if s.Silences, err = c.Silences(); err != nil {
+		return nil, err
+	}
+	if s.Acks, err = c.Acks(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Import writes the snapshot to the KV overwriting keys it contains,
+// other keys are left as is. States are migrated to the current format.
+//
+// Active instances keep states they've loaded and overwrite imported
+// ones on the next change, so they have to be stopped beforehand.
+func (c *Consul) Import(s *Snapshot) error {
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unknown snapshot version %d", s.Version)
+	}
+
+	// everything is validated before anything is written
+	states := make(map[string][]byte, len(s.States))
+	for key, raw := range s.States {
+		if key != stateKey && !strings.HasPrefix(key, stateKey+"/") {
+			return fmt.Errorf("state key %s is outside of %s", key, stateKey)
+		}
+		ss, err := decodeState(raw)
+		if err != nil {
+			return &stateError{key: key, err: err}
+		}
+		if states[key], err = encodeState(ss); err != nil {
+			return err
+		}
+	}
+
+	for key, b := range states {
+		if _, err := c.api.KV().Put(&api.KVPair{Key: key, Value: b}, nil); err != nil {
+			return err
+		}
+	}
+	for service, until := range s.Silences {
+		b, err := json.Marshal(&silence{Until: until})
+		if err != nil {
+			return err
+		}
+		if _, err = c.api.KV().Put(&api.KVPair{Key: silenceKey + "/" + service, Value: b}, nil); err != nil {
+			return err
+		}
+	}
+	for service, ack := range s.Acks {
+		if err := c.Acknowledge(service, ack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
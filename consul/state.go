@@ -0,0 +1,150 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// stateVersion is the current version of the saved state format.
+//
+// Version 1 is a flat json object of ids and statuses saved by older
+// releases, it has no version field and is migrated when it's loaded.
+// Version 2 states may lack critical_since and failing_since, times checks
+// went critical and started failing at are unknown then and newer releases
+// start recording them, output_hash is only saved with WithOutputChanges.
+const stateVersion = 2
+
+// savedState is the saved state format.
+type savedState struct {
+	Version int                  `json:"version"`
+	Checks  state                `json:"checks"`
+	Since   map[string]time.Time `json:"critical_since,omitempty"`
+	Failing map[string]time.Time `json:"failing_since,omitempty"`
+	Outputs map[string]string    `json:"output_hash,omitempty"`
+
+	Timelines map[string]*Timeline `json:"timelines,omitempty"`
+}
+
+// newSavedState returns an empty state of the current version.
+func newSavedState() *savedState {
+	return &savedState{
+		Version: stateVersion,
+		Checks:  state{},
+		Since:   map[string]time.Time{},
+		Failing: map[string]time.Time{},
+		Outputs: map[string]string{},
+
+		Timelines: map[string]*Timeline{},
+	}
+}
+
+// stateError is returned when a saved state cannot be decoded,
+// e.g. when it's been saved by a newer release in an unknown format.
+type stateError struct {
+	key string
+	err error
+}
+
+func (e *stateError) Error() string {
+	return fmt.Sprintf("state %s cannot be decoded: %v", e.key, e.err)
+}
+
+// encodeState encodes the state in the current format.
+func encodeState(ss *savedState) ([]byte, error) {
+	s := *ss
+	s.Version = stateVersion
+	return json.Marshal(&s)
+}
+
+// decodeState decodes a state saved in any known format,
+// its Version is the version it's been saved in.
+func decodeState(b []byte) (*savedState, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+
+	// "version" of a version 1 state would be a state id with a string status
+	version := 1
+	if v, ok := raw["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			version = 1
+		}
+	}
+
+	ss := newSavedState()
+	switch version {
+	case 1:
+		if err := json.Unmarshal(b, &ss.Checks); err != nil {
+			return nil, err
+		}
+	case stateVersion:
+		if err := json.Unmarshal(b, ss); err != nil {
+			return nil, err
+		}
+		if ss.Checks == nil {
+			ss.Checks = state{}
+		}
+		if ss.Since == nil {
+			ss.Since = map[string]time.Time{}
+		}
+		if ss.Failing == nil {
+			ss.Failing = map[string]time.Time{}
+		}
+		if ss.Outputs == nil {
+			ss.Outputs = map[string]string{}
+		}
+		if ss.Timelines == nil {
+			ss.Timelines = map[string]*Timeline{}
+		}
+	default:
+		return nil, fmt.Errorf("unknown version %d", version)
+	}
+	ss.Version = version
+	return ss, nil
+}
+
+// merge merges a state another instance has saved concurrently into
+// the state in place. Its entries of checks the state doesn't know and
+// of failing checks whose incident started later there are newer and
+// taken over, the state's own ones are from the latest poll and kept otherwise.
+func (ss *savedState) merge(other *savedState) {
+	if ss.Since == nil {
+		ss.Since = map[string]time.Time{}
+	}
+	if ss.Failing == nil {
+		ss.Failing = map[string]time.Time{}
+	}
+	if ss.Outputs == nil {
+		ss.Outputs = map[string]string{}
+	}
+	if ss.Timelines == nil {
+		ss.Timelines = map[string]*Timeline{}
+	}
+	for id, status := range other.Checks {
+		if _, ok := ss.Checks[id]; ok {
+			since, failing := ss.Failing[id]
+			if !failing || !other.Failing[id].After(since) {
+				continue
+			}
+		}
+		ss.Checks[id] = status
+		delete(ss.Since, id)
+		if t, ok := other.Since[id]; ok {
+			ss.Since[id] = t
+		}
+		delete(ss.Failing, id)
+		if t, ok := other.Failing[id]; ok {
+			ss.Failing[id] = t
+		}
+		delete(ss.Outputs, id)
+		if s, ok := other.Outputs[id]; ok {
+			ss.Outputs[id] = s
+		}
+		delete(ss.Timelines, id)
+		if tl, ok := other.Timelines[id]; ok {
+			ss.Timelines[id] = tl
+		}
+	}
+}
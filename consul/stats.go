@@ -0,0 +1,130 @@
+package consul
+
+import (
+	"sort"
+	"time"
+)
+
+// ServiceStats are statistics of incidents of a service.
+type ServiceStats struct {
+	Service   string        // service name, node checks are counted for "node NAME"
+	Incidents int           // incidents that recovered within the period
+	Downtime  time.Duration // total duration of the incidents
+	MTTR      time.Duration // mean time to recovery
+	MTBF      time.Duration // mean time between failures, the period without downtime per incident
+}
+
+// CheckStats are status changes of a check.
+type CheckStats struct {
+	Location string // e.g. dc1/node1
+	Service  string // empty for node checks
+	Check    string
+	Changes  int
+}
+
+// Stats are statistics of incidents of the period from From to To, see NewStats.
+type Stats struct {
+	From      time.Time
+	To        time.Time
+	Incidents int
+	MTTR      time.Duration   // mean time to recovery of all incidents
+	Services  []*ServiceStats // by downtime, worst offenders first
+	Flappy    []*CheckStats   // by status changes, flappiest checks first
+}
+
+// NewStats aggregates incidents of the events of the period from
+// from to to sorted by time, e.g. ones of the history file. Incidents
+// count when they recover within the period, when it's not known when
+// the check started failing they start at the critical time or the
+// beginning of the period. Reminders and lock events are skipped.
+func NewStats(evs []*Event, from, to time.Time) *Stats {
+	type check struct {
+		stats *CheckStats
+		start time.Time
+	}
+	var (
+		checks   = map[string]*check{}
+		services = map[string]*ServiceStats{}
+		s        = &Stats{From: from, To: to}
+		downtime time.Duration
+	)
+	for _, lead := range evs {
+		for _, ev := range lead.Ungroup() {
+			if ev.Reminder != 0 || ev.IsLock() || ev.Time.Before(from) || ev.Time.After(to) {
+				continue
+			}
+			id := ev.Cluster + "/" + ev.Datacenter + "/" + ev.Partition + "/" + ev.Peer + "/" + ev.ID
+			c, ok := checks[id]
+			if !ok {
+				c = &check{stats: &CheckStats{Location: ev.Location(), Service: ev.ServiceName, Check: ev.Name}}
+				checks[id] = c
+			}
+			if ev.Status != ev.PrevStatus && ev.PrevStatus != "" {
+				c.stats.Changes++
+			}
+
+			switch {
+			case ev.Failing() && c.start.IsZero():
+				c.start = ev.Time
+			case ev.Resolved() && (ev.PrevStatus == Warning || ev.PrevStatus == Critical):
+				start := c.start
+				if start.IsZero() {
+					start = ev.CriticalSince
+				}
+				if start.IsZero() || start.Before(from) {
+					start = from
+				}
+				c.start = time.Time{}
+
+				name := ev.ServiceName
+				if ev.IsNode() {
+					name = "node " + ev.Node
+				}
+				svc, ok := services[name]
+				if !ok {
+					svc = &ServiceStats{Service: name}
+					services[name] = svc
+				}
+				d := ev.Time.Sub(start)
+				svc.Incidents++
+				svc.Downtime += d
+				s.Incidents++
+				downtime += d
+			}
+		}
+	}
+
+	period := to.Sub(from)
+	for _, svc := range services {
+		svc.MTTR = svc.Downtime / time.Duration(svc.Incidents)
+		if up := period - svc.Downtime; up > 0 {
+			svc.MTBF = up / time.Duration(svc.Incidents)
+		}
+		s.Services = append(s.Services, svc)
+	}
+	if s.Incidents != 0 {
+		s.MTTR = downtime / time.Duration(s.Incidents)
+	}
+	sort.Slice(s.Services, func(i, j int) bool {
+		if s.Services[i].Downtime != s.Services[j].Downtime {
+			return s.Services[i].Downtime > s.Services[j].Downtime
+		}
+		return s.Services[i].Service < s.Services[j].Service
+	})
+	for _, c := range checks {
+		if c.stats.Changes != 0 {
+			s.Flappy = append(s.Flappy, c.stats)
+		}
+	}
+	sort.Slice(s.Flappy, func(i, j int) bool {
+		a, b := s.Flappy[i], s.Flappy[j]
+		if a.Changes != b.Changes {
+			return a.Changes > b.Changes
+		}
+		if a.Location != b.Location {
+			return a.Location < b.Location
+		}
+		return a.Service+a.Check < b.Service+b.Check
+	})
+	return s
+}
@@ -0,0 +1,69 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// checkStream is the health state response decoded one check at a time,
+// checks keep rejects are dropped right away, so on large clusters
+// checks of other shards and unwatched services and nodes never take
+// up memory as decoded checks along with the response body.
+type checkStream struct {
+	keep   func(hc *api.HealthCheck) bool
+	checks api.HealthChecks
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *checkStream) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("health checks array expected, got %v", tok)
+	}
+	for dec.More() {
+		hc := &api.HealthCheck{}
+		if err = dec.Decode(hc); err != nil {
+			return err
+		}
+		if s.keep == nil || s.keep(hc) {
+			s.checks = append(s.checks, hc)
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// keepCheck reports whether the check is watched by the watcher,
+// it's filterChecks and shardChecks applied to a single check.
+func (c *Consul) keepCheck(w *watcher) func(hc *api.HealthCheck) bool {
+	return func(hc *api.HealthCheck) bool {
+		if !c.nodes.match(hc.Node) {
+			return false
+		}
+		if hc.ServiceID != "" && !c.services.match(hc.ServiceName) {
+			return false
+		}
+		return !w.sharded || c.shardOf(hc) == w.shard
+	}
+}
+
+// healthState queries checks of all statuses like the api's
+// Health().State but decodes them with checkStream.
+func (c *Consul) healthState(w *watcher, q *api.QueryOptions) (api.HealthChecks, *api.QueryMeta, error) {
+	s := &checkStream{keep: c.keepCheck(w)}
+	meta, err := c.api.Raw().Query("/v1/health/state/"+api.HealthAny, s, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.checks, meta, nil
+}
@@ -0,0 +1,128 @@
+package consul
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Report is the health of all watched checks at the moment.
+type Report struct {
+	Checks  map[string]int // numbers of watched checks by location, e.g. dc1 or prod/dc1
+	Failing []*Event
+}
+
+// Total returns the number of watched checks.
+func (r *Report) Total() int {
+	var n int
+	for _, v := range r.Checks {
+		n += v
+	}
+	return n
+}
+
+// Locations returns sorted locations of checks with their numbers
+// when there are several of them, e.g. "dc1 (214), dc2 (12)".
+func (r *Report) Locations() string {
+	locs := make([]string, 0, len(r.Checks))
+	for loc := range r.Checks {
+		locs = append(locs, loc)
+	}
+	sort.Strings(locs)
+	if len(locs) < 2 {
+		return strings.Join(locs, "")
+	}
+	for i, loc := range locs {
+		locs[i] = fmt.Sprintf("%s (%d)", loc, r.Checks[loc])
+	}
+	return strings.Join(locs, ", ")
+}
+
+// Merge adds checks of another report to r.
+func (r *Report) Merge(o *Report) {
+	if r.Checks == nil {
+		r.Checks = map[string]int{}
+	}
+	for loc, n := range o.Checks {
+		r.Checks[loc] += n
+	}
+	r.Failing = append(r.Failing, o.Failing...)
+}
+
+// Failing returns events of all currently failing checks according
+// to a fresh query of every watched datacenter, peer and service
+// bypassing the saved state, so it's unaffected by any drift of it.
+func (c *Consul) Failing() ([]*Event, error) {
+	r, err := c.Report()
+	if err != nil {
+		return nil, err
+	}
+	return r.Failing, nil
+}
+
+// Report returns the report of all watched checks queried like Failing.
+func (c *Consul) Report() (*Report, error) {
+	r := &Report{Checks: map[string]int{}}
+	for _, w := range c.watchers {
+		// watchers are being used concurrently, only their
+		// configuration that never changes is safe to copy
+		w = &watcher{
+			dc:         w.dc,
+			datacenter: w.datacenter,
+			partition:  w.partition,
+			peer:       w.peer,
+			service:    w.service,
+			sharded:    w.sharded,
+			shard:      w.shard,
+		}
+		if c.externalNodes {
+			external, err := c.external(w)
+			if err != nil {
+				return nil, err
+			}
+			w.external = external
+		}
+
+		q := c.queryOptions(w)
+		q.NodeMeta = c.nodeMeta
+		data, _, err := c.checks(w, q)
+		if err != nil {
+			return nil, err
+		}
+		data = c.filterChecks(data)
+		maint := maintenanceNodes(data)
+		if w.sharded {
+			data = c.shardChecks(w, data)
+		}
+		hcs := aggregateStatus(data, c.nodeChecks && w.service == "", c.perCheck)
+		if c.aggregateServices {
+			hcs = c.serviceStatus(hcs)
+		}
+		loc := (&Event{Cluster: c.cluster, Datacenter: w.datacenter, Partition: w.partition, Peer: w.peer}).Location()
+		for id, hc := range hcs {
+			if c.suppressMaint && (hc.Status == Maintenance || maint[hc.Node]) {
+				continue
+			}
+			r.Checks[loc]++
+			if hc.Status != Passing {
+				ev := w.newEvent(id, hc, "")
+				ev.Cluster = c.cluster
+				c.redact(ev)
+				r.Failing = append(r.Failing, ev)
+			}
+		}
+	}
+
+	sortEvents(r.Failing)
+	return r, nil
+}
+
+// sortEvents sorts events by location and state id.
+func sortEvents(evs []*Event) {
+	sort.Slice(evs, func(i, j int) bool {
+		if evs[i].Location() != evs[j].Location() {
+			return evs[i].Location() < evs[j].Location()
+		}
+		return evs[i].ID < evs[j].ID
+	})
+}
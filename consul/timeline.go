@@ -0,0 +1,27 @@
+package consul
+
+import "time"
+
+// Timeline is the course of an incident, it's recorded while the
+// check is failing and attached to the event resolving it.
+type Timeline struct {
+	// Since is when the check started failing, it's set only on events.
+	Since time.Time `json:"-"`
+
+	// Flaps is the number of changes between warning and critical.
+	Flaps int `json:"flaps,omitempty"`
+
+	// Outputs is the number of output changes reported, see WithOutputChanges.
+	Outputs int `json:"outputs,omitempty"`
+}
+
+// setTimeline attaches a copy of the timeline of the incident
+// that started at start, nothing is attached when it's not known.
+func (ev *Event) setTimeline(tl *Timeline, start time.Time) {
+	if tl == nil || start.IsZero() {
+		return
+	}
+	t := *tl
+	t.Since = start
+	ev.Timeline = &t
+}
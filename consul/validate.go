@@ -0,0 +1,47 @@
+package consul
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Validate checks that consul is reachable and the token has all
+// permissions needed for watching: reading health checks of every
+// watched datacenter, peer and service, creating sessions and reading
+// and writing keys under consul-slack/, a temporary key is written for that.
+func (c *Consul) Validate() error {
+	if err := c.Ping(); err != nil {
+		return err
+	}
+	for _, w := range c.watchers {
+		q := c.queryOptions(w)
+		q.NodeMeta = c.nodeMeta
+		if _, _, err := c.checks(w, q); err != nil {
+			return fmt.Errorf("consul: %sread health checks: %v", w.prefix(), err)
+		}
+	}
+	if _, _, err := c.api.KV().Get(c.lockKey(), nil); err != nil {
+		return fmt.Errorf("consul: read %s: %v", c.lockKey(), err)
+	}
+
+	key := "consul-slack/.validate"
+	if _, err := c.api.KV().Put(&api.KVPair{Key: key, Value: []byte(c.hostname)}, nil); err != nil {
+		return fmt.Errorf("consul: write %s: %v", key, err)
+	}
+	if _, err := c.api.KV().Delete(key, nil); err != nil {
+		return fmt.Errorf("consul: delete %s: %v", key, err)
+	}
+
+	sess, _, err := c.api.Session().Create(&api.SessionEntry{
+		Behavior: "delete",
+		TTL:      c.sessionTTL.String(),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("consul: create session: %v", err)
+	}
+	if _, err = c.api.Session().Destroy(sess, nil); err != nil {
+		return fmt.Errorf("consul: destroy session: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package consul
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WANCheckID is the check id of events about federated datacenters
+// becoming unreachable over WAN gossip and rejoining, see WithWANMonitoring.
+const WANCheckID = "consul-slack/wan"
+
+// serf member statuses reported by the agent members endpoint.
+const (
+	serfAlive   = 1
+	serfLeaving = 2
+	serfLeft    = 3
+	serfFailed  = 4
+)
+
+var serfStatuses = map[int]string{
+	serfAlive:   "alive",
+	serfLeaving: "leaving",
+	serfLeft:    "left",
+	serfFailed:  "failed",
+}
+
+// WithWANMonitoring polls WAN gossip members every interval and sends
+// critical events when none of the servers of a federated datacenter
+// are alive and passing ones when it rejoins, zero disables it.
+// Datacenters unreachable by the first poll are reported as well.
+func WithWANMonitoring(interval time.Duration) Option {
+	return func(c *Consul) {
+		c.wanInterval = interval
+	}
+}
+
+// watchWAN reports changes of datacenter reachability every wan interval until stopped.
+func (c *Consul) watchWAN() {
+	defer c.wg.Done()
+	reachable := map[string]bool{}
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-c.failCh:
+			return
+		case <-time.After(c.wanInterval):
+		}
+		// with shards it's up to the holder of the first one
+		if !c.Active() || c.shards > 0 && c.shardEpoch(0) == 0 {
+			continue
+		}
+
+		evs, err := c.wanEvents(reachable)
+		if err != nil {
+			// it's retried on the next run
+			c.warnf("wan members error: %v", err)
+			continue
+		}
+		for _, ev := range evs {
+			c.logf("%s: %s -> %s", ev.Datacenter, ev.PrevStatus, ev.Status)
+			if !c.send(ev) {
+				return
+			}
+		}
+	}
+}
+
+// wanEvents lists WAN members and returns events about datacenters
+// that changed their reachability since the last call, reachable is
+// updated with the current state, datacenters that are gone are forgotten.
+func (c *Consul) wanEvents(reachable map[string]bool) ([]*Event, error) {
+	members, err := c.api.Agent().Members(true)
+	if err != nil {
+		return nil, err
+	}
+	servers := map[string][]string{}
+	alive := map[string]bool{}
+	for _, m := range members {
+		dc := m.Tags["dc"]
+		if dc == "" {
+			continue
+		}
+		status, ok := serfStatuses[m.Status]
+		if !ok {
+			status = fmt.Sprintf("status %d", m.Status)
+		}
+		servers[dc] = append(servers[dc], m.Name+" ("+status+")")
+		if m.Status == serfAlive {
+			alive[dc] = true
+		}
+	}
+
+	var evs []*Event
+	for dc, list := range servers {
+		was, seen := reachable[dc]
+		reachable[dc] = alive[dc]
+		if seen && was == alive[dc] || !seen && alive[dc] {
+			continue
+		}
+		sort.Strings(list)
+		ev := &Event{
+			Datacenter: dc,
+			CheckID:    WANCheckID,
+			Name:       "WAN gossip",
+			ID:         WANCheckID + "/" + dc,
+			Status:     Critical,
+			Output:     "servers: " + strings.Join(list, ", "),
+		}
+		if seen {
+			ev.PrevStatus = Passing
+		}
+		if alive[dc] {
+			ev.Status, ev.PrevStatus = Passing, Critical
+		}
+		evs = append(evs, ev)
+	}
+	for dc := range reachable {
+		if _, ok := servers[dc]; !ok {
+			delete(reachable, dc)
+		}
+	}
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].Datacenter < evs[j].Datacenter
+	})
+	return evs, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// holderer reports the instance holding the lock, clusters don't
+// since every one of them has its own lock.
+type holderer interface {
+	Holder() (*consul.Holder, error)
+}
+
+// dashboardLister is what the dashboard is rendered from.
+type dashboardLister interface {
+	alertLister
+	roler
+}
+
+// dashboard is the data the dashboard template is executed with.
+type dashboard struct {
+	Time     time.Time
+	Role     string
+	Holder   *consul.Holder
+	Alerts   []*apiAlert
+	Silences []*apiSilence
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>consul-slack</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 12px; text-align: left; border-bottom: 1px solid #ddd; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; }
+.critical { color: #d00000; }
+.warning { color: #daa038; }
+.silenced { color: #888; }
+</style>
+</head>
+<body>
+<h1>consul-slack</h1>
+<p>This instance is {{.Role}}{{with .Holder}}, the lock is held by {{.Host}} since {{.Since.Format "2006-01-02 15:04:05 MST"}}{{end}}.</p>
+<h2>Failing checks</h2>
+{{if .Alerts}}<table>
+<tr><th>Location</th><th>Service</th><th>Check</th><th>Status</th><th>Failing for</th><th>Output</th></tr>
+{{range .Alerts}}<tr{{if .Silenced}} class="silenced"{{end}}>
+<td>{{.Location}}</td>
+<td>{{.ServiceName}}</td>
+<td>{{.Check}} ({{.CheckID}})</td>
+<td class="{{.Status}}">{{.Status}}{{if .Silenced}}, silenced{{end}}</td>
+<td>{{if .Duration}}{{.Duration}}{{else}}unknown{{end}}</td>
+<td><pre>{{.Output}}</pre></td>
+</tr>
+{{end}}</table>
+{{else}}<p>All checks are passing.</p>
+{{end}}<h2>Silences</h2>
+{{if .Silences}}<table>
+<tr><th>Service</th><th>Until</th></tr>
+{{range .Silences}}<tr><td>{{.Service}}</td><td>{{.Until.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+{{end}}</table>
+{{else}}<p>No services are silenced.</p>
+{{end}}<p><small>Updated at {{.Time.Format "2006-01-02 15:04:05 MST"}}, the page reloads every 30 seconds.</small></p>
+</body>
+</html>
+`))
+
+// dashboardHandler serves a page with failing checks, for how long they've
+// been failing, silences and the active instance for people who don't live
+// in the chat, it's the html counterpart of the api. Errors of querying
+// consul are served with the 502 status code as the api does.
+func dashboardHandler(l dashboardLister, a *alerts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		d, err := newDashboard(l, a, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		var b bytes.Buffer
+		if err = dashboardTemplate.Execute(&b, d); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		b.WriteTo(w)
+	})
+}
+
+// newDashboard collects the dashboard data at now.
+func newDashboard(l dashboardLister, a *alerts, now time.Time) (*dashboard, error) {
+	alerts, err := listAlerts(l, a, now)
+	if err != nil {
+		return nil, err
+	}
+	silences, err := listSilences(l)
+	if err != nil {
+		return nil, err
+	}
+	d := &dashboard{
+		Time:     now,
+		Role:     role(l),
+		Alerts:   alerts,
+		Silences: silences,
+	}
+	if h, ok := l.(holderer); ok {
+		if d.Holder, err = h.Holder(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
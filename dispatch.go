@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+	"github.com/amenzhinsky/consul-slack/tracing"
+	"github.com/amenzhinsky/consul-slack/watcher"
+)
+
+// filter decides whether an event is delivered to a notifier.
+type filter struct {
+	statuses map[string]bool // nil matches any status
+	services *regexp.Regexp  // nil matches any service
+	expr     *expr           // nil matches any event
+}
+
+// match reports whether ev passes the filter.
+func (f *filter) match(ev *consul.Event) bool {
+	if f == nil {
+		return true
+	}
+	if f.statuses != nil && !f.statuses[ev.Status] {
+		return false
+	}
+	if f.services != nil && !f.services.MatchString(ev.ServiceName) {
+		return false
+	}
+	if f.expr != nil && !f.expr.match(ev) {
+		return false
+	}
+	return true
+}
+
+// filtersFlag is a repeatable NOTIFIER:KEY=VALUE command-line flag,
+// supported keys are statuses (comma-separated list), services (regexp)
+// and expr (expression), see parseExpr.
+type filtersFlag map[string]*filter
+
+func (f filtersFlag) String() string {
+	return ""
+}
+
+func (f filtersFlag) Set(s string) error {
+	i := strings.IndexByte(s, ':')
+	j := strings.IndexByte(s, '=')
+	if i < 1 || j < i {
+		return fmt.Errorf("malformed filter %q, want NOTIFIER:KEY=VALUE", s)
+	}
+	name, key, val := s[:i], s[i+1:j], s[j+1:]
+
+	flt, ok := f[name]
+	if !ok {
+		flt = &filter{}
+		f[name] = flt
+	}
+
+	switch key {
+	case "statuses":
+		statuses := map[string]bool{}
+		for _, status := range strings.Split(val, ",") {
+			switch status {
+			case consul.Passing, consul.Warning, consul.Critical, consul.Maintenance, consul.Added, consul.Deleted,
+				consul.LockAcquired, consul.LockLost:
+				statuses[status] = true
+			default:
+				return fmt.Errorf("unknown status %q", status)
+			}
+		}
+		flt.statuses = statuses
+	case "services":
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return err
+		}
+		flt.services = re
+	case "expr":
+		e, err := parseExpr(val)
+		if err != nil {
+			return err
+		}
+		flt.expr = e
+	default:
+		return fmt.Errorf("unknown filter key %q", key)
+	}
+	return nil
+}
+
+// target is a named notifier with an optional filter.
+type target struct {
+	name     string
+	profile  string // empty for notifiers configured outside of profiles
+	notifier notifier
+	filter   *filter
+	windows  *windows // nil when there are no maintenance windows
+	outage   *outage  // nil when mass failures aren't rolled up
+	rules    []*rule
+	limiter  *limiter        // nil when notifications aren't rate limited
+	cooldown *cooldown       // nil when checks have no cooldown
+	recovery *recovery       // nil when all recoveries are sent
+	oncall   *oncall         // nil when on-call mentions aren't looked up
+	owners   *owners         // nil when service owners aren't looked up
+	audit    *auditLog       // nil when deliveries aren't audited
+	ledger   *ledger         // nil when deliveries aren't remembered
+	kv       *kvRouting      // nil when routing isn't read from the KV
+	watch    *string         // name of the watch profile events come from, nil for any
+	steps    []*step         // -middleware steps events go through first
+	tracer   *tracing.Tracer // nil when routing and deliveries aren't traced
+
+	// policies creates channels services declare in their meta
+	// fields, nil when policies of services are ignored
+	policies *policyChannels
+
+	// inChannel creates the notifier posting to another
+	// channel, nil when the notifier has no channels
+	inChannel func(channel string) (notifier, error)
+	channels  map[string]notifier
+}
+
+// label returns the name the target is reported with.
+func (t *target) label() string {
+	if t.profile == "" {
+		return t.name
+	}
+	return t.profile + "/" + t.name
+}
+
+// grouper is a notifier that can deliver status changes
+// of checks of the same node as a single message.
+type grouper interface {
+	NotifyGroup(evs []*consul.Event) error
+}
+
+// contextNotifier is a notifier that can deliver events with a context,
+// the one of the notify span, so its requests are traced as its children.
+type contextNotifier interface {
+	NotifyContext(ctx context.Context, ev *consul.Event) error
+}
+
+// contextGrouper is a grouper that can deliver events with a context.
+type contextGrouper interface {
+	NotifyGroupContext(ctx context.Context, evs []*consul.Event) error
+}
+
+// Notify delivers the event if it matches the filter, it's not suppressed
+// by a maintenance window or the rate limit and the first matching rule allows it,
+// lock events are never rate limited. Status changes of a check within
+// the cooldown are held, the latest one is delivered once it's over.
+// Recoveries are held back according to -recoveries, see recovery.
+// Critical events the rule doesn't mention anyone in mention owners
+// of the service or the current on-call engineer.
+// Events of other watches than the target's one are skipped.
+// Notifications the ledger has seen delivered already are skipped, deliveries
+// are recorded in the ledger and the audit log. Policies services declare in
+// their meta fields apply when they're enabled, see parsePolicy.
+//
+// Events of a node grouped by consul go through all that one by one and
+// the ones routed to the same channel are sent as one message when the
+// notifier is a grouper, otherwise they're sent as separate notifications.
+// Before all that events go through the -middleware steps, see parseStep.
+func (t *target) Notify(ev *consul.Event) error {
+	if len(t.steps) == 0 {
+		return t.notify(ev)
+	}
+	var evs []*consul.Event
+	n := watcher.Chain(watcher.NotifierFunc(func(ev *consul.Event) error {
+		evs = append(evs, ev)
+		return nil
+	}), middleware(t.steps)...)
+	for _, ev := range ev.Ungroup() {
+		n.Notify(ev) // steps don't fail
+	}
+	if len(ev.Grouped) != 0 {
+		return t.notifyGroup(evs)
+	}
+	if len(evs) == 0 {
+		return nil
+	}
+	return t.notify(evs[0])
+}
+
+// notify delivers the event that has gone through the middleware, see Notify.
+func (t *target) notify(ev *consul.Event) error {
+	if len(ev.Grouped) != 0 {
+		return t.notifyGroup(ev.Ungroup())
+	}
+	n, channel, ev := t.route(ev)
+	if n == nil {
+		return nil
+	}
+	return t.deliver(n, channel, []*consul.Event{ev})
+}
+
+// notifyGroup routes the events and delivers them grouped by channels.
+func (t *target) notifyGroup(evs []*consul.Event) error {
+	var channels []string
+	groups := map[string][]*consul.Event{}
+	ns := map[string]notifier{}
+	for _, ev := range evs {
+		n, channel, ev := t.route(ev)
+		if n == nil {
+			continue
+		}
+		if _, ok := groups[channel]; !ok {
+			channels = append(channels, channel)
+		}
+		groups[channel] = append(groups[channel], ev)
+		ns[channel] = n
+	}
+
+	var err error
+	for _, channel := range channels {
+		n, evs := ns[channel], groups[channel]
+		if _, ok := n.(grouper); ok {
+			if e := t.deliver(n, channel, evs); e != nil {
+				err = e
+			}
+			continue
+		}
+		for _, ev := range evs {
+			if e := t.deliver(n, channel, []*consul.Event{ev}); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// route returns the notifier and the channel the event is delivered to
+// and the event with the rule and mentions applied, the notifier is nil
+// when the event isn't delivered. With tracing enabled routing is a child
+// span of the comparison the event's been detected by and the routed
+// event carries it, so the delivery is its child in turn.
+func (t *target) route(ev *consul.Event) (n notifier, channel string, routed *consul.Event) {
+	if span := t.tracer.Start(ev.Trace, "route"); span != nil {
+		span.SetAttribute("consul_slack.target", t.label())
+		defer func() {
+			span.SetAttribute("consul_slack.routed", n != nil)
+			if n != nil {
+				if channel != "" {
+					span.SetAttribute("consul_slack.channel", channel)
+				}
+				e := *routed
+				e.Trace = span.Context()
+				routed = &e
+			}
+			span.End()
+		}()
+	}
+	if t.watch != nil && ev.Cluster != *t.watch {
+		return nil, "", nil
+	}
+	if !t.recovery.allow(ev) {
+		return nil, "", nil
+	}
+	defer func() {
+		if n == nil {
+			t.recovery.record(ev, false)
+		}
+	}()
+
+	rules, kf, kc := t.kv.route(t)
+	if !t.filter.match(ev) || !kf.match(ev) {
+		return nil, "", nil
+	}
+	if len(rules) != 0 {
+		rules = append(rules[:len(rules):len(rules)], t.rules...)
+	} else {
+		rules = t.rules
+	}
+	r := findRule(rules, ev)
+	if r != nil && !r.allows(t.name) {
+		return nil, "", nil
+	}
+	var p *policy
+	if t.policies != nil {
+		var err error
+		if p, err = parsePolicy(ev.Meta); err != nil {
+			notifyError("policy", fmt.Errorf("%s: %v", ev.ServiceName, err))
+		}
+		if p.minor(ev) {
+			return nil, "", nil
+		}
+	}
+	routed = ev
+	if r != nil {
+		routed = r.apply(ev)
+	}
+	if t.ledger.delivered(t.label(), routed) {
+		return nil, "", nil
+	}
+	if t.windows != nil && t.windows.suppress(ev, time.Now()) {
+		return nil, "", nil
+	}
+	if t.outage != nil && t.outage.hold(ev, time.Now()) {
+		return nil, "", nil
+	}
+	if t.cools(ev) && t.cooldown.hold(ev, time.Now()) {
+		return nil, "", nil
+	}
+	if t.limiter != nil && !ev.IsLock() && !t.limiter.allow(time.Now()) {
+		return nil, "", nil
+	}
+
+	n, channel = t.notifier, ""
+	if r != nil {
+		name := r.channelOf(ev)
+		if c, ok := t.channels[name]; ok {
+			n, channel = c, name
+		} else if c, ok := kc[name]; ok {
+			n, channel = c, name
+		}
+	}
+	if p != nil {
+		if channel == "" && p.channel != "" {
+			c, err := t.policies.channel(t, p.channel)
+			if err != nil {
+				notifyError(t.label(), fmt.Errorf("channel %s: %v", p.channel, err))
+			} else if c != nil {
+				n, channel = c, p.channel
+			}
+		}
+		routed = withoutPolicy(routed)
+	}
+	routed = t.owners.mention(routed)
+	routed = t.oncall.mention(routed, time.Now())
+	return n, channel, routed
+}
+
+// cools reports whether the event is subject to the cooldown,
+// reminders and lock events are never held.
+func (t *target) cools(ev *consul.Event) bool {
+	return t.cooldown != nil && !ev.IsLock() && ev.Reminder == 0
+}
+
+// deliver sends the events with the notifier, a single message when
+// there are several of them, and records deliveries and results.
+func (t *target) deliver(n notifier, channel string, evs []*consul.Event) error {
+	span := t.tracer.Start(evs[0].Trace, "notify")
+	span.SetAttribute("consul_slack.target", t.label())
+	if channel != "" {
+		span.SetAttribute("consul_slack.channel", channel)
+	}
+	span.SetAttribute("consul_slack.events", len(evs))
+	ctx := tracing.ContextWithSpan(context.Background(), span)
+
+	var err error
+	switch {
+	case len(evs) == 1:
+		if cn, ok := n.(contextNotifier); ok {
+			err = cn.NotifyContext(ctx, evs[0])
+		} else {
+			err = n.Notify(evs[0])
+		}
+	default:
+		if cg, ok := n.(contextGrouper); ok {
+			err = cg.NotifyGroupContext(ctx, evs)
+		} else {
+			err = n.(grouper).NotifyGroup(evs)
+		}
+	}
+	span.SetError(err)
+	span.End()
+	for _, ev := range evs {
+		if err == nil {
+			t.ledger.record(t.label(), ev, time.Now())
+			if t.cools(ev) {
+				t.cooldown.start(ev, time.Now())
+			}
+		}
+		t.recovery.record(ev, err == nil)
+		t.audit.record(t.label(), channel, ev, err)
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	stats.inc("notifications", "notifier", t.label(), "result", result)
+	return err
+}
+
+// notifiers converts targets to watcher notifiers.
+func notifiers(targets []*target) []watcher.Notifier {
+	r := make([]watcher.Notifier, 0, len(targets))
+	for _, t := range targets {
+		r = append(r, t)
+	}
+	return r
+}
+
+// startPools starts -notify-workers workers delivering events to
+// every target, failures are reported with notifyError.
+func startPools(targets []*target) ([]watcher.Notifier, []*watcher.Pool) {
+	ns := make([]watcher.Notifier, 0, len(targets))
+	pools := make([]*watcher.Pool, 0, len(targets))
+	for _, t := range targets {
+		label := t.label()
+		p := watcher.NewPool(t, notifyWorkersFlag, notifyQueueFlag, func(_ *consul.Event, err error) {
+			notifyError(label, err)
+		})
+		ns = append(ns, p)
+		pools = append(pools, p)
+	}
+	return ns, pools
+}
+
+// dispatch delivers the event to all matching targets concurrently
+// and waits until all of them are done, delivery errors are passed to onErr.
+func dispatch(targets []*target, ev *consul.Event, onErr func(name string, err error)) {
+	report(watcher.Notify(ev, notifiers(targets)...), onErr)
+}
+
+// report passes delivery errors of targets to onErr.
+func report(errs watcher.Errors, onErr func(name string, err error)) {
+	for _, err := range errs {
+		onErr(err.Notifier.(*target).label(), err.Err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// dryRunTransport prints requests of http notifiers instead of sending them
+// and responds with an empty successful response, only the host is printed
+// because webhook urls and bot tokens are secrets.
+type dryRunTransport struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	t.mu.Lock()
+	fmt.Fprintf(t.w, "[dry-run] %s %s://%s\n", req.Method, req.URL.Scheme, req.URL.Host)
+	if len(body) != 0 {
+		t.w.Write(bytes.TrimRight(body, "\n"))
+		fmt.Fprintln(t.w)
+	}
+	t.mu.Unlock()
+
+	// null decodes into anything, so notifiers reading
+	// responses like jira and github don't fail
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewBufferString("null")),
+		ContentLength: 4,
+		Request:       req,
+	}, nil
+}
+
+// dryRunNotifier prints events of notifiers that don't talk http,
+// like kafka and nats, as json instead of publishing them.
+type dryRunNotifier struct {
+	mu   sync.Mutex
+	name string
+	w    io.Writer
+}
+
+func (n *dryRunNotifier) Notify(ev *consul.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err = fmt.Fprintf(n.w, "[dry-run] %s\n%s\n", n.name, b)
+	return err
+}
+
+// dryRun makes targets print what they'd send to w instead of sending it.
+func dryRun(targets []*target, w io.Writer) {
+	http.DefaultClient.Transport = &dryRunTransport{w: w}
+	for _, t := range targets {
+		switch t.name {
+		case "kafka", "nats":
+			t.notifier = &dryRunNotifier{name: t.name, w: w}
+		}
+	}
+}
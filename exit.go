@@ -0,0 +1,40 @@
+package main
+
+// Exit codes telling supervisors why the process has stopped,
+// invalid flags make the flag package exit with exitConfig too.
+const (
+	exitOK       = 0
+	exitFailure  = 1 // unclassified errors
+	exitConfig   = 2 // invalid flags or configuration, restarting won't help
+	exitConsul   = 3 // watching consul has failed
+	exitDelivery = 4 // notifications haven't been delivered
+)
+
+// codeError is an error the process exits with the code on.
+type codeError struct {
+	code int
+	err  error
+}
+
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+// withCode attaches the exit code to err, nil is returned when err is nil.
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codeError{code: code, err: err}
+}
+
+// exitCode returns the code the process exits on err with.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if e, ok := err.(*codeError); ok {
+		return e.code
+	}
+	return exitFailure
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// expr is a boolean expression over event fields in a small subset of cel,
+// e.g. event.Status == "critical" && event.Node.startsWith("db"). It supports
+// string, int and bool literals, ==, !=, <, <=, >, >=, in, &&, || and !,
+// startsWith, endsWith, contains and matches string methods and indexing
+// of event.Meta. Expressions are type checked when they're parsed.
+type expr struct {
+	src      string
+	eval     func(ev *consul.Event) interface{}
+	metaKeys []string // service meta fields the expression indexes
+}
+
+// match reports whether the event satisfies the expression.
+func (e *expr) match(ev *consul.Event) bool {
+	return e.eval(ev).(bool)
+}
+
+// exprType is a type of an expression value.
+type exprType int
+
+const (
+	exprString exprType = iota
+	exprInt
+	exprBool
+	exprList // []string
+	exprMap  // map[string]string
+)
+
+func (t exprType) String() string {
+	return [...]string{"string", "int", "bool", "list", "map"}[t]
+}
+
+// exprNode is a type checked expression compiled to a function.
+type exprNode struct {
+	typ  exprType
+	eval func(ev *consul.Event) interface{}
+	lit  bool // the node is a literal that ignores the event
+}
+
+// constNode returns a literal node.
+func constNode(typ exprType, v interface{}) exprNode {
+	return exprNode{typ: typ, lit: true, eval: func(*consul.Event) interface{} { return v }}
+}
+
+// exprFields are event fields accessible in expressions.
+var exprFields = map[string]exprNode{
+	"Node":        {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Node }},
+	"CheckID":     {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.CheckID }},
+	"Name":        {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Name }},
+	"Status":      {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Status }},
+	"PrevStatus":  {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.PrevStatus }},
+	"Notes":       {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Notes }},
+	"Output":      {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Output }},
+	"ServiceID":   {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.ServiceID }},
+	"ServiceName": {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.ServiceName }},
+	"ServiceTags": {typ: exprList, eval: func(ev *consul.Event) interface{} { return ev.ServiceTags }},
+	"Cluster":     {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Cluster }},
+	"Datacenter":  {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Datacenter }},
+	"Partition":   {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Partition }},
+	"Peer":        {typ: exprString, eval: func(ev *consul.Event) interface{} { return ev.Peer }},
+	"External":    {typ: exprBool, eval: func(ev *consul.Event) interface{} { return ev.External }},
+	"Reminder":    {typ: exprInt, eval: func(ev *consul.Event) interface{} { return int64(ev.Reminder) }},
+	"Meta":        {typ: exprMap, eval: func(ev *consul.Event) interface{} { return ev.Meta }},
+}
+
+// parseExpr parses a boolean expression.
+func parseExpr(s string) (*expr, error) {
+	toks, err := lexExpr(s)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %v", s, err)
+	}
+	p := &exprParser{toks: toks}
+	n, err := p.or()
+	if err == nil && p.peek() != "" {
+		err = fmt.Errorf("unexpected %q", p.peek())
+	}
+	if err == nil && n.typ != exprBool {
+		err = fmt.Errorf("result is %s, want bool", n.typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %v", s, err)
+	}
+	return &expr{src: s, eval: n.eval, metaKeys: p.metaKeys}, nil
+}
+
+// lexExpr splits the expression into tokens, string
+// literals keep their quotes to tell them from identifiers.
+func lexExpr(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			toks = append(toks, s[i:j+1])
+			i = j + 1
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="),
+			strings.HasPrefix(s[i:], "<="), strings.HasPrefix(s[i:], ">="):
+			toks = append(toks, s[i:i+2])
+			i += 2
+		case strings.IndexByte("!<>().,[]", c) != -1:
+			toks = append(toks, s[i:i+1])
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return toks, nil
+}
+
+// exprParser is a recursive descent parser of expressions.
+type exprParser struct {
+	toks     []string
+	pos      int
+	metaKeys []string
+}
+
+func (p *exprParser) peek() string {
+	if p.pos == len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		if got == "" {
+			return fmt.Errorf("want %q, got end of expression", tok)
+		}
+		return fmt.Errorf("want %q, got %q", tok, got)
+	}
+	return nil
+}
+
+// or parses a || b.
+func (p *exprParser) or() (exprNode, error) {
+	l, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var r exprNode
+		if r, err = p.and(); err == nil {
+			l, err = logical("||", l, r)
+		}
+	}
+	return l, err
+}
+
+// and parses a && b.
+func (p *exprParser) and() (exprNode, error) {
+	l, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var r exprNode
+		if r, err = p.unary(); err == nil {
+			l, err = logical("&&", l, r)
+		}
+	}
+	return l, err
+}
+
+func logical(op string, l, r exprNode) (exprNode, error) {
+	if l.typ != exprBool || r.typ != exprBool {
+		return exprNode{}, fmt.Errorf("%s of %s and %s, want bools", op, l.typ, r.typ)
+	}
+	if op == "&&" {
+		return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+			return l.eval(ev).(bool) && r.eval(ev).(bool)
+		}}, nil
+	}
+	return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+		return l.eval(ev).(bool) || r.eval(ev).(bool)
+	}}, nil
+}
+
+// unary parses !a.
+func (p *exprParser) unary() (exprNode, error) {
+	if p.peek() != "!" {
+		return p.comparison()
+	}
+	p.next()
+	n, err := p.unary()
+	if err != nil {
+		return n, err
+	}
+	if n.typ != exprBool {
+		return exprNode{}, fmt.Errorf("! of %s, want bool", n.typ)
+	}
+	return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+		return !n.eval(ev).(bool)
+	}}, nil
+}
+
+// comparison parses a == b, a < b, a in b and so on.
+func (p *exprParser) comparison() (exprNode, error) {
+	l, err := p.postfix()
+	if err != nil {
+		return l, err
+	}
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "in":
+	default:
+		return l, nil
+	}
+	p.next()
+	r, err := p.postfix()
+	if err != nil {
+		return r, err
+	}
+
+	switch {
+	case op == "in":
+		if l.typ != exprString || (r.typ != exprList && r.typ != exprMap) {
+			return exprNode{}, fmt.Errorf("%s in %s, want string in list or map", l.typ, r.typ)
+		}
+		return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+			s := l.eval(ev).(string)
+			if m, ok := r.eval(ev).(map[string]string); ok {
+				_, ok = m[s]
+				return ok
+			}
+			for _, v := range r.eval(ev).([]string) {
+				if v == s {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	case l.typ != r.typ:
+		return exprNode{}, fmt.Errorf("%s %s %s, want the same types", l.typ, op, r.typ)
+	case op == "==" || op == "!=":
+		if l.typ == exprList || l.typ == exprMap {
+			return exprNode{}, fmt.Errorf("%s of %ss isn't supported", op, l.typ)
+		}
+		return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+			return (l.eval(ev) == r.eval(ev)) == (op == "==")
+		}}, nil
+	case l.typ == exprInt:
+		return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+			a, b := l.eval(ev).(int64), r.eval(ev).(int64)
+			return compare(op, a < b, a == b)
+		}}, nil
+	case l.typ == exprString:
+		return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+			a, b := l.eval(ev).(string), r.eval(ev).(string)
+			return compare(op, a < b, a == b)
+		}}, nil
+	default:
+		return exprNode{}, fmt.Errorf("%s %s %s isn't supported", l.typ, op, r.typ)
+	}
+}
+
+// compare evaluates an ordering operator given whether a < b and a == b.
+func compare(op string, less, equal bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	default:
+		return !less
+	}
+}
+
+// postfix parses method calls and indexing, e.g. a.startsWith("b") and a["b"].
+func (p *exprParser) postfix() (exprNode, error) {
+	n, err := p.primary()
+	for err == nil {
+		switch p.peek() {
+		case ".":
+			p.next()
+			n, err = p.method(n)
+		case "[":
+			p.next()
+			n, err = p.index(n)
+		default:
+			return n, nil
+		}
+	}
+	return n, err
+}
+
+// stringMethods are methods of strings that take a string and return a bool.
+var stringMethods = map[string]func(s, arg string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+}
+
+// method parses a string method call after the dot.
+func (p *exprParser) method(recv exprNode) (exprNode, error) {
+	name := p.next()
+	if recv.typ != exprString {
+		return exprNode{}, fmt.Errorf("method %q of %s, only strings have methods", name, recv.typ)
+	}
+	if err := p.expect("("); err != nil {
+		return exprNode{}, err
+	}
+	arg, err := p.or()
+	if err != nil {
+		return arg, err
+	}
+	if err = p.expect(")"); err != nil {
+		return exprNode{}, err
+	}
+	if arg.typ != exprString {
+		return exprNode{}, fmt.Errorf("%s argument of %q, want string", arg.typ, name)
+	}
+
+	if name == "matches" {
+		// patterns are mostly literals, they're compiled once then
+		s, ok := constString(arg)
+		if !ok {
+			return exprNode{}, fmt.Errorf("argument of %q has to be a string literal", name)
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return exprNode{}, err
+		}
+		return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+			return re.MatchString(recv.eval(ev).(string))
+		}}, nil
+	}
+	fn, ok := stringMethods[name]
+	if !ok {
+		return exprNode{}, fmt.Errorf("unknown method %q", name)
+	}
+	return exprNode{typ: exprBool, eval: func(ev *consul.Event) interface{} {
+		return fn(recv.eval(ev).(string), arg.eval(ev).(string))
+	}}, nil
+}
+
+// index parses a map index after the opening bracket,
+// missing keys are empty strings like in rule conditions.
+func (p *exprParser) index(m exprNode) (exprNode, error) {
+	key, err := p.or()
+	if err != nil {
+		return key, err
+	}
+	if err = p.expect("]"); err != nil {
+		return exprNode{}, err
+	}
+	if m.typ != exprMap || key.typ != exprString {
+		return exprNode{}, fmt.Errorf("%s index of %s, want string index of map", key.typ, m.typ)
+	}
+	if s, ok := constString(key); ok {
+		p.metaKeys = append(p.metaKeys, s)
+	}
+	return exprNode{typ: exprString, eval: func(ev *consul.Event) interface{} {
+		return m.eval(ev).(map[string]string)[key.eval(ev).(string)]
+	}}, nil
+}
+
+// constString returns the value of a string literal node.
+func constString(n exprNode) (string, bool) {
+	if !n.lit || n.typ != exprString {
+		return "", false
+	}
+	return n.eval(nil).(string), true
+}
+
+// primary parses literals, parenthesized expressions and event fields.
+func (p *exprParser) primary() (exprNode, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return exprNode{}, fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		n, err := p.or()
+		if err != nil {
+			return n, err
+		}
+		return n, p.expect(")")
+	case tok[0] == '"':
+		s, err := strconv.Unquote(tok)
+		if err != nil {
+			return exprNode{}, fmt.Errorf("malformed string %s", tok)
+		}
+		return constNode(exprString, s), nil
+	case tok == "true" || tok == "false":
+		return constNode(exprBool, tok == "true"), nil
+	case tok[0] >= '0' && tok[0] <= '9':
+		n, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return exprNode{}, fmt.Errorf("malformed number %q", tok)
+		}
+		return constNode(exprInt, n), nil
+	case tok == "event":
+		if err := p.expect("."); err != nil {
+			return exprNode{}, err
+		}
+		name := p.next()
+		f, ok := exprFields[name]
+		if !ok {
+			return exprNode{}, fmt.Errorf("unknown event field %q", name)
+		}
+		return f, nil
+	default:
+		return exprNode{}, fmt.Errorf("unexpected %q", tok)
+	}
+}
@@ -0,0 +1,231 @@
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(g *GitHub)
+
+// WithAPIURL sets api base url, for github enterprise it's https://host/api/v3.
+func WithAPIURL(url string) Option {
+	return func(g *GitHub) {
+		g.apiURL = strings.TrimRight(url, "/")
+	}
+}
+
+// WithLabel sets label that marks all issues opened by consul-slack.
+func WithLabel(label string) Option {
+	return func(g *GitHub) {
+		g.label = label
+	}
+}
+
+// WithSeverityLabel sets label added to issues of the given status.
+func WithSeverityLabel(status, label string) Option {
+	return func(g *GitHub) {
+		g.severities[status] = label
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(g *GitHub) {
+		g.logger = l
+	}
+}
+
+// New creates new github issues client for the owner/name repository.
+func New(token, repo string, opts ...Option) (*GitHub, error) {
+	if token == "" {
+		return nil, fmt.Errorf("github: token is empty")
+	}
+	if strings.Count(repo, "/") != 1 {
+		return nil, fmt.Errorf("github: malformed repository %q, want owner/name", repo)
+	}
+	g := &GitHub{
+		token:  token,
+		repo:   repo,
+		apiURL: "https://api.github.com",
+		label:  "consul",
+		severities: map[string]string{
+			consul.Warning:  "warning",
+			consul.Critical: "critical",
+		},
+		logger: log.New(os.Stdout, "[github] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g, nil
+}
+
+// GitHub opens an issue per failing check and closes it on recovery.
+type GitHub struct {
+	token      string
+	repo       string
+	apiURL     string
+	label      string
+	severities map[string]string
+	logger     *log.Logger
+
+	mu     sync.Mutex
+	issues map[string]int // check -> issue number, nil until loaded
+}
+
+// issue is a subset of issue fields.
+type issue struct {
+	Number int           `json:"number,omitempty"`
+	Title  string        `json:"title,omitempty"`
+	Body   string        `json:"body,omitempty"`
+	State  string        `json:"state,omitempty"`
+	Labels []interface{} `json:"labels,omitempty"`
+}
+
+// markerRegexp finds check id in issue bodies,
+// so open issues survive restarts and aren't duplicated.
+var markerRegexp = regexp.MustCompile(`<!-- consul-slack:(\S+) -->`)
+
+func marker(id string) string {
+	return "<!-- consul-slack:" + id + " -->"
+}
+
+// Notify opens an issue when a check starts failing, relabels
+// it on severity changes and closes it when the check is passing.
+func (g *GitHub) Notify(ev *consul.Event) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.issues == nil {
+		if err := g.load(); err != nil {
+			return err
+		}
+	}
+
+	id := ev.Key()
+	num, ok := g.issues[id]
+	switch {
+	case ev.Resolved():
+		if !ok {
+			return nil
+		}
+		if err := g.do("POST", g.path(num)+"/comments", &issue{
+			Body: fmt.Sprintf("Back to normal.\n\n```\n%s\n```", ev.Output),
+		}, nil); err != nil {
+			return err
+		}
+		if err := g.do("PATCH", g.path(num), &issue{State: "closed"}, nil); err != nil {
+			return err
+		}
+		delete(g.issues, id)
+		g.infof("#%d closed", num)
+	case !ev.Failing():
+		return nil
+	case ok:
+		return g.do("PATCH", g.path(num), &issue{Labels: g.labels(ev.Status)}, nil)
+	default:
+		var res issue
+		if err := g.do("POST", "/repos/"+g.repo+"/issues", &issue{
+			Title: fmt.Sprintf("[%s] %s is %s", ev.Node, ev.ServiceID, ev.Status),
+			Body: fmt.Sprintf("Notes: %s\n\n```\n%s\n```\n\n%s",
+				ev.Notes, ev.Output, marker(id)),
+			Labels: g.labels(ev.Status),
+		}, &res); err != nil {
+			return err
+		}
+		g.issues[id] = res.Number
+		g.infof("#%d opened for %s", res.Number, id)
+	}
+	return nil
+}
+
+// labels returns issue labels of the given status.
+func (g *GitHub) labels(status string) []interface{} {
+	labels := []interface{}{g.label}
+	if l, ok := g.severities[status]; ok {
+		labels = append(labels, l)
+	}
+	return labels
+}
+
+// load finds all open issues opened by consul-slack.
+func (g *GitHub) load() error {
+	var res []*issue
+	if err := g.do("GET", "/repos/"+g.repo+"/issues?state=open&per_page=100&labels="+g.label, nil, &res); err != nil {
+		return err
+	}
+	g.issues = map[string]int{}
+	for _, is := range res {
+		if m := markerRegexp.FindStringSubmatch(is.Body); m != nil {
+			g.issues[m[1]] = is.Number
+		}
+	}
+	return nil
+}
+
+func (g *GitHub) path(num int) string {
+	return "/repos/" + g.repo + "/issues/" + strconv.Itoa(num)
+}
+
+// do performs an api request with json encoded in and out bodies.
+func (g *GitHub) do(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, g.apiURL+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	g.infof("%s %s", method, path)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	if out != nil {
+		return json.NewDecoder(r.Body).Decode(out)
+	}
+	return nil
+}
+
+// infof prints a debug message.
+func (g *GitHub) infof(format string, v ...interface{}) {
+	if g.logger != nil {
+		g.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("github responded with %d status code", r.r.StatusCode)
+}
@@ -0,0 +1,57 @@
+package github
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer token")
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/o/r/issues":
+			// already open issue from a previous run
+			json.NewEncoder(w).Encode([]*issue{{Number: 7, Body: "...\n" + marker("n1:db")}})
+		case "POST /repos/o/r/issues":
+			w.Write([]byte(`{"number":8}`))
+		}
+	}))
+	defer ts.Close()
+
+	g, err := New("token", "o/r", WithAPIURL(ts.URL), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ev := range []*consul.Event{
+		{Node: "n1", ServiceID: "db", ID: "n1:db", Status: consul.Critical},
+		{Node: "n1", ServiceID: "web", ID: "n1:web", Status: consul.Warning},
+		{Node: "n1", ServiceID: "db", ID: "n1:db", Status: consul.Passing},
+	} {
+		if err = g.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"GET /repos/o/r/issues",
+		"PATCH /repos/o/r/issues/7",
+		"POST /repos/o/r/issues",
+		"POST /repos/o/r/issues/7/comments",
+		"PATCH /repos/o/r/issues/7",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// roler reports whether the instance is active or a standby.
+type roler interface {
+	Active() bool
+}
+
+// pingRoler is a roler that can check whether consul is reachable.
+type pingRoler interface {
+	roler
+	Ping() error
+}
+
+// healthHandler answers health checks with the instance role,
+// standby instances are ready too since they take over on failover.
+func healthHandler(r roler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeHealth(w, http.StatusOK, map[string]string{
+			"status": "ready",
+			"role":   role(r),
+		})
+	})
+}
+
+// livenessHandler answers liveness probes, the process is alive
+// as long as it serves requests, watching failures make it exit.
+func livenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeHealth(w, http.StatusOK, map[string]string{"status": "alive"})
+	})
+}
+
+// readinessHandler answers readiness probes, the instance is ready when
+// consul is reachable, the active one holds the lock by definition.
+func readinessHandler(r pingRoler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := r.Ping(); err != nil {
+			writeHealth(w, http.StatusServiceUnavailable, map[string]string{
+				"status": "unavailable",
+				"error":  err.Error(),
+			})
+			return
+		}
+		writeHealth(w, http.StatusOK, map[string]string{
+			"status": "ready",
+			"role":   role(r),
+		})
+	})
+}
+
+// role returns the instance role name.
+func role(r roler) string {
+	if r.Active() {
+		return "active"
+	}
+	return "standby"
+}
+
+// writeHealth writes the health response as json.
+func writeHealth(w http.ResponseWriter, code int, v map[string]string) {
+	writeJSON(w, code, v)
+}
+
+// serveHealth starts serving health checks, the read-only api, the dashboard and metrics on
+// the given address in the background, /healthz and /readyz are meant for kubernetes or nomad
+// probes, recv is served at /api/v1/events and hm at /slack/events unless they're nil.
+func serveHealth(addr string, r source, a *alerts, recv *receiver, hm *home) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	m := http.NewServeMux()
+	m.Handle("/health", healthHandler(r))
+	m.Handle("/healthz", livenessHandler())
+	m.Handle("/readyz", readinessHandler(r))
+	m.Handle("/api/v1/alerts", alertsHandler(r, a))
+	m.Handle("/api/v1/silences", silencesHandler(r))
+	m.Handle("/metrics", metricsHandler(r, stats))
+	m.Handle("/", dashboardHandler(r, a))
+	if recv != nil {
+		m.Handle("/api/v1/events", recv)
+	}
+	if hm != nil && hm.secret != nil {
+		m.Handle("/slack/events", hm)
+	}
+	if pprofFlag {
+		handlePprof(m)
+	}
+	go http.Serve(lis, m)
+	return nil
+}
+
+// handlePprof serves cpu, heap, goroutine and other profiles under /debug/pprof/.
+func handlePprof(m *http.ServeMux) {
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// beater writes heartbeats of the active instance.
+type beater interface {
+	Active() bool
+	Beat() error
+}
+
+// heartbeatPoster is a notifier that can post a heartbeat message.
+type heartbeatPoster interface {
+	Heartbeat(msg string) error
+}
+
+// beat writes a heartbeat every interval, posts msg to the targets
+// and pings url unless they're empty, until done is closed.
+// Standby instances do nothing, so the heartbeat stops when no
+// instance is active either.
+func beat(c beater, targets []*target, msg, url string, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-done:
+			return
+		}
+		if !c.Active() {
+			continue
+		}
+		if err := c.Beat(); err != nil {
+			notifyError("heartbeat", err)
+		}
+		if msg != "" {
+			sendHeartbeat(targets, msg)
+		}
+		if url != "" {
+			if err := ping(url); err != nil {
+				notifyError("heartbeat", err)
+			}
+		}
+	}
+}
+
+// sendHeartbeat posts the heartbeat message to all targets that support it.
+func sendHeartbeat(targets []*target, msg string) {
+	for _, t := range targets {
+		p, ok := t.notifier.(heartbeatPoster)
+		if !ok {
+			continue
+		}
+		if err := p.Heartbeat(msg); err != nil {
+			notifyError(t.label(), err)
+		}
+	}
+}
+
+// ping requests a dead man's switch url, like the ones of healthchecks.io.
+func ping(url string) error {
+	res, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("heartbeat url responded with %s", res.Status)
+	}
+	return nil
+}
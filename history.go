@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// readHistory returns events of the -history-file detected at or after since.
+func readHistory(r io.Reader, since time.Time) ([]*consul.Event, error) {
+	var evs []*consul.Event
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var ev consul.Event
+		if err := dec.Decode(&ev); err == io.EOF {
+			return evs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("history line %d: %v", line, err)
+		}
+		if !ev.Time.Before(since) {
+			evs = append(evs, &ev)
+		}
+	}
+}
+
+func replayCommand(args []string) error {
+	url, err := webhookURL(args)
+	if err != nil {
+		return err
+	}
+	if historyFileFlag == "" {
+		return errors.New("-history-file is required")
+	}
+	if err = setupLogging(); err != nil {
+		return err
+	}
+	f, err := os.Open(historyFileFlag)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	evs, err := readHistory(f, time.Now().Add(-replaySinceFlag))
+	if err != nil {
+		return err
+	}
+	targets, err := allTargets(url)
+	if err != nil {
+		return err
+	}
+	if !replaySendFlag {
+		dryRun(targets, os.Stdout)
+	}
+
+	var failed int
+	for _, ev := range evs {
+		dispatch(targets, ev, func(name string, err error) {
+			failed++
+			notifyError(name, err)
+		})
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d notifications haven't been delivered", failed)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/slack"
+)
+
+// homeDelay is the least time between publishing home tabs,
+// changes in between are published together.
+const homeDelay = 5 * time.Second
+
+// maxHomeAlerts limits the number of checks listed in home tabs.
+const maxHomeAlerts = 50
+
+// homePublisher publishes text to App Home tabs of slack users.
+type homePublisher interface {
+	PublishHome(user, text string) error
+}
+
+// homeLister is what home tabs are rendered from.
+type homeLister interface {
+	alertLister
+	roler
+}
+
+// home keeps App Home tabs of slack users up to date with failing
+// checks, users are given with -slack-home-users or learned from
+// app_home_opened events slack sends to the events endpoint.
+type home struct {
+	p       homePublisher
+	secret  []byte
+	changed chan struct{}
+
+	mu    sync.Mutex
+	users map[string]bool
+}
+
+func newHome(p homePublisher, users []string, secret string) *home {
+	h := &home{p: p, changed: make(chan struct{}, 1), users: map[string]bool{}}
+	for _, u := range users {
+		h.users[u] = true
+	}
+	if secret != "" {
+		h.secret = []byte(secret)
+	}
+	return h
+}
+
+// newSlackHome creates the home of -slack-home, nil is returned when it's disabled.
+func newSlackHome() (*home, error) {
+	if !slackHomeFlag {
+		if slackHomeUsersFlag != "" || slackSigningFlag != "" {
+			return nil, errors.New("-slack-home-users and -slack-signing-secret require -slack-home")
+		}
+		return nil, nil
+	}
+	if slackTokenFlag == "" {
+		return nil, errors.New("-slack-home requires -slack-token")
+	}
+	if slackHomeUsersFlag == "" && slackSigningFlag == "" {
+		return nil, errors.New("-slack-home requires -slack-home-users or -slack-signing-secret")
+	}
+	if slackSigningFlag != "" && listenFlag == "" {
+		return nil, errors.New("-slack-signing-secret requires -listen")
+	}
+	s, err := slack.New("",
+		slack.WithToken(slackTokenFlag),
+		slack.WithLogger(debugLogger("[slack] ")),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return newHome(s, splitList(slackHomeUsersFlag), slackSigningFlag), nil
+}
+
+// notify schedules publishing home tabs, it never blocks.
+func (h *home) notify() {
+	select {
+	case h.changed <- struct{}{}:
+	default:
+	}
+}
+
+// add adds the user to publish home tabs to, tabs are published
+// again anyway, since it's been opened and may be empty.
+func (h *home) add(user string) {
+	h.mu.Lock()
+	h.users[user] = true
+	h.mu.Unlock()
+	h.notify()
+}
+
+// list returns users sorted by id.
+func (h *home) list() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	users := make([]string, 0, len(h.users))
+	for u := range h.users {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// slackEvent is an event of the events api, only app_home_opened is handled.
+type slackEvent struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Event     struct {
+		Type string `json:"type"`
+		User string `json:"user"`
+		Tab  string `json:"tab"`
+	} `json:"event"`
+}
+
+// ServeHTTP accepts events of the slack events api signed with the signing
+// secret, it answers url verification and publishes home tabs of users
+// opening them, they're published to them on changes from then on.
+func (h *home) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAlertSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err = slack.Verify(h.secret, r.Header, b, time.Now()); err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return
+	}
+	var ev slackEvent
+	if err = json.Unmarshal(b, &ev); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	switch {
+	case ev.Type == "url_verification":
+		writeJSON(w, http.StatusOK, map[string]string{"challenge": ev.Challenge})
+		return
+	case ev.Type == "event_callback" && ev.Event.Type == "app_home_opened" && ev.Event.Tab == "home" && ev.Event.User != "":
+		h.add(ev.Event.User)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// publishHome publishes home tabs on changes and every minute, so durations
+// are up to date, until done is closed, standby instances publish nothing.
+func publishHome(h *home, l homeLister, a *alerts, done <-chan struct{}) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.changed:
+		case <-t.C:
+		case <-done:
+			return
+		}
+		if !l.Active() {
+			continue
+		}
+
+		now := time.Now()
+		alerts, err := listAlerts(l, a, now)
+		if err != nil {
+			notifyError("home", err)
+			continue
+		}
+		text := homeText(alerts, now)
+		for _, u := range h.list() {
+			if err = h.p.PublishHome(u, text); err != nil {
+				notifyError("home", err)
+			}
+		}
+
+		select {
+		case <-time.After(homeDelay):
+		case <-done:
+			return
+		}
+	}
+}
+
+// homeText renders failing checks as mrkdwn.
+func homeText(alerts []*apiAlert, now time.Time) string {
+	var b bytes.Buffer
+	if len(alerts) == 0 {
+		b.WriteString(":white_check_mark: *All checks are passing*\n")
+	} else {
+		fmt.Fprintf(&b, ":red_circle: *%d checks are failing*\n", len(alerts))
+	}
+	for i, alert := range alerts {
+		if i == maxHomeAlerts {
+			fmt.Fprintf(&b, "and %d more\n", len(alerts)-i)
+			break
+		}
+		fmt.Fprintf(&b, "• %s `%s`", alert.Status, escapeMrkdwn(alert.Location))
+		if alert.ServiceName != "" {
+			fmt.Fprintf(&b, " %s", escapeMrkdwn(alert.ServiceName))
+		}
+		fmt.Fprintf(&b, " %s", escapeMrkdwn(alert.Check))
+		if alert.Duration != "" {
+			fmt.Fprintf(&b, " for %s", alert.Duration)
+		}
+		if alert.Silenced {
+			fmt.Fprintf(&b, ", silenced until %s", alert.SilencedUntil.Format("2006-01-02 15:04 MST"))
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "_Updated at %s_", now.Format("2006-01-02 15:04:05 MST"))
+	return b.String()
+}
+
+// escapeMrkdwn escapes characters slack treats as control ones.
+func escapeMrkdwn(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,222 @@
+package jira
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(j *Jira)
+
+// WithBasicAuth sets user email and api token.
+func WithBasicAuth(user, token string) Option {
+	return func(j *Jira) {
+		j.user = user
+		j.token = token
+	}
+}
+
+// WithIssueType sets type of created issues, "Bug" by default.
+func WithIssueType(name string) Option {
+	return func(j *Jira) {
+		j.issueType = name
+	}
+}
+
+// WithLabels sets labels of created issues.
+func WithLabels(labels ...string) Option {
+	return func(j *Jira) {
+		j.labels = labels
+	}
+}
+
+// WithTransition sets name of the transition applied to issues
+// on recovery, e.g. "Done", issues are only commented when empty.
+func WithTransition(name string) Option {
+	return func(j *Jira) {
+		j.transition = name
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(j *Jira) {
+		j.logger = l
+	}
+}
+
+// New creates new jira client, url is the jira base url.
+func New(baseURL, project string, opts ...Option) (*Jira, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("jira: url is empty")
+	}
+	if project == "" {
+		return nil, fmt.Errorf("jira: project is empty")
+	}
+	j := &Jira{
+		url:       strings.TrimRight(baseURL, "/"),
+		project:   project,
+		issueType: "Bug",
+		issues:    map[string]string{},
+		logger:    log.New(os.Stdout, "[jira] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j, nil
+}
+
+// Jira opens an issue when a service goes critical,
+// comments and transitions it when the service recovers.
+type Jira struct {
+	url        string
+	user       string
+	token      string
+	project    string
+	issueType  string
+	labels     []string
+	transition string
+	logger     *log.Logger
+
+	mu     sync.Mutex
+	issues map[string]string // check -> issue key
+}
+
+// Notify ignores everything but critical events and recoveries of them.
+func (j *Jira) Notify(ev *consul.Event) error {
+	id := ev.Key()
+
+	j.mu.Lock()
+	key, ok := j.issues[id]
+	j.mu.Unlock()
+
+	switch {
+	case ev.Status == consul.Critical && !ok:
+		key, err := j.create(ev)
+		if err != nil {
+			return err
+		}
+		j.mu.Lock()
+		j.issues[id] = key
+		j.mu.Unlock()
+		j.infof("%s opened for %s", key, id)
+	case ev.Resolved() && ok:
+		if err := j.resolve(key, ev); err != nil {
+			return err
+		}
+		j.mu.Lock()
+		delete(j.issues, id)
+		j.mu.Unlock()
+		j.infof("%s resolved", key)
+	}
+	return nil
+}
+
+// create creates an issue and returns its key.
+func (j *Jira) create(ev *consul.Event) (string, error) {
+	var res struct {
+		Key string `json:"key"`
+	}
+	err := j.do("POST", "/rest/api/2/issue", map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": j.project},
+			"issuetype":   map[string]string{"name": j.issueType},
+			"summary":     fmt.Sprintf("[%s] %s is critical", ev.Node, ev.ServiceID),
+			"description": fmt.Sprintf("Notes: %s\n{noformat}\n%s\n{noformat}", ev.Notes, ev.Output),
+			"labels":      j.labels,
+		},
+	}, &res)
+	return res.Key, err
+}
+
+// resolve comments the issue and applies the configured transition.
+func (j *Jira) resolve(key string, ev *consul.Event) error {
+	path := "/rest/api/2/issue/" + url.PathEscape(key)
+	if err := j.do("POST", path+"/comment", map[string]string{
+		"body": fmt.Sprintf("[%s] %s is back to normal\n{noformat}\n%s\n{noformat}", ev.Node, ev.ServiceID, ev.Output),
+	}, nil); err != nil {
+		return err
+	}
+	if j.transition == "" {
+		return nil
+	}
+
+	var res struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	if err := j.do("GET", path+"/transitions", nil, &res); err != nil {
+		return err
+	}
+	for _, t := range res.Transitions {
+		if strings.EqualFold(t.Name, j.transition) {
+			return j.do("POST", path+"/transitions", map[string]interface{}{
+				"transition": map[string]string{"id": t.ID},
+			}, nil)
+		}
+	}
+	return fmt.Errorf("jira: %s has no %q transition", key, j.transition)
+}
+
+// do performs an api request, in and out are json encoded request
+// and response bodies, nil values are omitted.
+func (j *Jira) do(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, j.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if j.user != "" {
+		req.SetBasicAuth(j.user, j.token)
+	}
+
+	j.infof("%s %s", method, path)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	if out != nil {
+		return json.NewDecoder(r.Body).Decode(out)
+	}
+	return nil
+}
+
+// infof prints a debug message.
+func (j *Jira) infof(format string, v ...interface{}) {
+	if j.logger != nil {
+		j.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("jira responded with %d status code", r.r.StatusCode)
+}
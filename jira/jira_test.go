@@ -0,0 +1,68 @@
+package jira
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "bot" || pass != "token" {
+			t.Errorf("basic auth = %q:%q, want bot:token", user, pass)
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "POST /rest/api/2/issue":
+			var req struct {
+				Fields struct {
+					Project struct{ Key string }
+					Labels  []string
+				}
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			if req.Fields.Project.Key != "OPS" || len(req.Fields.Labels) != 1 {
+				t.Errorf("unexpected issue fields %+v", req.Fields)
+			}
+			w.Write([]byte(`{"key":"OPS-1"}`))
+		case "GET /rest/api/2/issue/OPS-1/transitions":
+			w.Write([]byte(`{"transitions":[{"id":"11","name":"In Progress"},{"id":"31","name":"Done"}]}`))
+		}
+	}))
+	defer ts.Close()
+
+	j, err := New(ts.URL, "OPS",
+		WithBasicAuth("bot", "token"),
+		WithLabels("consul"),
+		WithTransition("done"),
+		WithLogger(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []string{consul.Warning, consul.Critical, consul.Critical, consul.Passing} {
+		if err = j.Notify(&consul.Event{Node: "n1", ServiceID: "web", ID: "n1:web", Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"POST /rest/api/2/issue",
+		"POST /rest/api/2/issue/OPS-1/comment",
+		"GET /rest/api/2/issue/OPS-1/transitions",
+		"POST /rest/api/2/issue/OPS-1/transitions",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+}
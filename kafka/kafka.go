@@ -0,0 +1,247 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(k *Kafka)
+
+// WithClientID sets client id reported to brokers.
+func WithClientID(id string) Option {
+	return func(k *Kafka) {
+		k.clientID = id
+	}
+}
+
+// WithTimeout sets network and produce acknowledgement timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(k *Kafka) {
+		k.timeout = d
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(k *Kafka) {
+		k.logger = l
+	}
+}
+
+// New creates new kafka producer, brokers are used only
+// for bootstrapping, partition leaders are discovered from metadata.
+func New(brokers []string, topic string, opts ...Option) (*Kafka, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("kafka: no brokers given")
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("kafka: topic is empty")
+	}
+	k := &Kafka{
+		brokers:  brokers,
+		topic:    topic,
+		clientID: "consul-slack",
+		timeout:  10 * time.Second,
+		logger:   log.New(os.Stdout, "[kafka] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	return k, nil
+}
+
+// Kafka is a minimal single-topic kafka producer that waits for leader
+// acknowledgement of every message, enough for a low rate event stream.
+type Kafka struct {
+	brokers  []string
+	topic    string
+	clientID string
+	timeout  time.Duration
+	logger   *log.Logger
+
+	mu            sync.Mutex
+	correlationID int32
+	leaders       []string // partition leaders addresses, nil when unknown
+}
+
+// Notify produces the event as json keyed by its service id,
+// so all transitions of a service land in the same partition.
+func (k *Kafka) Notify(ev *consul.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return k.Produce([]byte(ev.ServiceID), b)
+}
+
+// Produce sends a message to the topic partition chosen by key.
+func (k *Kafka) Produce(key, value []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.leaders == nil {
+		leaders, err := k.metadata()
+		if err != nil {
+			return err
+		}
+		k.leaders = leaders
+	}
+
+	partition := int32(int(murmur2(key)&0x7fffffff) % len(k.leaders))
+	if err := k.produce(k.leaders[partition], partition, key, value); err != nil {
+		k.leaders = nil // leadership might have moved, refresh next time
+		return err
+	}
+	k.infof("produced %s to %s/%d", key, k.topic, partition)
+	return nil
+}
+
+// metadata returns addresses of the topic partition leaders
+// asking bootstrap brokers one by one until one of them answers.
+func (k *Kafka) metadata() ([]string, error) {
+	var body encoder
+	body.int32(1)
+	body.string(k.topic)
+	body.int8(1) // allow auto topic creation
+
+	var err error
+	for _, addr := range k.brokers {
+		var d *decoder
+		d, err = k.roundTrip(addr, apiMetadata, apiMetadataVersion, body.Bytes())
+		if err != nil {
+			k.infof("metadata %s error: %v", addr, err)
+			continue
+		}
+		return k.parseMetadata(d)
+	}
+	return nil, err
+}
+
+func (k *Kafka) parseMetadata(d *decoder) ([]string, error) {
+	d.int32() // throttle time
+	brokers := map[int32]string{}
+	for i, n := 0, d.array(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.string() // cluster id
+	d.int32()  // controller id
+
+	var leaders []string
+	for i, n := 0, d.array(); i < n; i++ {
+		if code := d.int16(); code != 0 {
+			return nil, &Error{code}
+		}
+		name := d.string()
+		d.int8() // is internal
+		for j, m := 0, d.array(); j < m; j++ {
+			d.int16() // partition error, leader is checked instead
+			index := d.int32()
+			leader := d.int32()
+			for skip := d.array(); skip > 0; skip-- { // replicas
+				d.int32()
+			}
+			for skip := d.array(); skip > 0; skip-- { // isr
+				d.int32()
+			}
+			if name != k.topic {
+				continue
+			}
+			if index < 0 || int(index) >= m {
+				return nil, fmt.Errorf("kafka: partition %d out of range", index)
+			}
+			if leaders == nil {
+				leaders = make([]string, m)
+			}
+			addr, ok := brokers[leader]
+			if !ok {
+				return nil, fmt.Errorf("kafka: partition %d has no leader", index)
+			}
+			leaders[index] = addr
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(leaders) == 0 {
+		return nil, fmt.Errorf("kafka: topic %q has no partitions", k.topic)
+	}
+	return leaders, nil
+}
+
+// produce sends the message to the partition leader.
+func (k *Kafka) produce(addr string, partition int32, key, value []byte) error {
+	var body encoder
+	body.nullableString(nil) // transactional id
+	body.int16(1)            // acks, leader only
+	body.int32(int32(k.timeout / time.Millisecond))
+	body.int32(1)
+	body.string(k.topic)
+	body.int32(1)
+	body.int32(partition)
+	body.bytes(recordBatch(key, value, time.Now().UnixNano()/int64(time.Millisecond)))
+
+	d, err := k.roundTrip(addr, apiProduce, apiProduceVersion, body.Bytes())
+	if err != nil {
+		return err
+	}
+	for i, n := 0, d.array(); i < n; i++ {
+		d.string() // topic
+		for j, m := 0, d.array(); j < m; j++ {
+			d.int32() // partition
+			if code := d.int16(); code != 0 {
+				return &Error{code}
+			}
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+	return d.err
+}
+
+// roundTrip sends a request to the broker and reads its response.
+func (k *Kafka) roundTrip(addr string, apiKey, apiVersion int16, body []byte) (*decoder, error) {
+	conn, err := net.DialTimeout("tcp", addr, k.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(2 * k.timeout)); err != nil {
+		return nil, err
+	}
+
+	k.correlationID++
+	if _, err = conn.Write(request(apiKey, apiVersion, k.correlationID, k.clientID, body)); err != nil {
+		return nil, err
+	}
+	return readResponse(conn, k.correlationID)
+}
+
+// infof prints a debug message.
+func (k *Kafka) infof(format string, v ...interface{}) {
+	if k.logger != nil {
+		k.logger.Printf(format, v...)
+	}
+}
+
+// Error is a kafka protocol error code returned by a broker.
+type Error struct {
+	Code int16
+}
+
+// Error is a string representation.
+func (e *Error) Error() string {
+	return fmt.Sprintf("kafka: broker returned error code %d", e.Code)
+}
@@ -0,0 +1,140 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestMurmur2(t *testing.T) {
+	t.Parallel()
+
+	// values from the java client's test suite
+	for s, want := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if got := murmur2([]byte(s)); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestRecordBatch(t *testing.T) {
+	t.Parallel()
+
+	b := recordBatch([]byte("key"), []byte("value"), 1)
+	if got, want := int(binary.BigEndian.Uint32(b[8:])), len(b)-12; got != want {
+		t.Errorf("batch length = %d, want %d", got, want)
+	}
+	if b[16] != 2 {
+		t.Errorf("magic = %d, want 2", b[16])
+	}
+	if got, want := binary.BigEndian.Uint32(b[17:]), crc32.Checksum(b[21:], castagnoli); got != want {
+		t.Errorf("crc = %x, want %x", got, want)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	produced := make(chan []byte, 1)
+	go serveBroker(t, lis, produced)
+
+	k, err := New([]string{lis.Addr().String()}, "events", WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.Notify(&consul.Event{ServiceID: "web", Status: consul.Critical}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &decoder{b: <-produced}
+	d.nullableString()
+	if acks := d.int16(); acks != 1 {
+		t.Errorf("acks = %d, want 1", acks)
+	}
+}
+
+// serveBroker is a fake single broker cluster with one partition.
+func serveBroker(t *testing.T, lis net.Listener, produced chan<- []byte) {
+	host, port, _ := net.SplitHostPort(lis.Addr().String())
+	p, _ := strconv.Atoi(port)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+
+		var size [4]byte
+		if _, err = io.ReadFull(conn, size[:]); err != nil {
+			t.Error(err)
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err = io.ReadFull(conn, b); err != nil {
+			t.Error(err)
+			return
+		}
+
+		d := &decoder{b: b}
+		apiKey := d.int16()
+		d.int16()
+		correlationID := d.int32()
+		d.string()
+
+		var e encoder
+		e.int32(0)
+		e.int32(correlationID)
+		switch apiKey {
+		case apiMetadata:
+			e.int32(0) // throttle
+			e.int32(1) // brokers
+			e.int32(1)
+			e.string(host)
+			e.int32(int32(p))
+			e.int16(-1)
+			e.int16(-1) // cluster id
+			e.int32(1)  // controller
+			e.int32(1)  // topics
+			e.int16(0)
+			e.string("events")
+			e.int8(0)
+			e.int32(1) // partitions
+			e.int16(0)
+			e.int32(0)
+			e.int32(1)
+			e.int32(0)
+			e.int32(0)
+		case apiProduce:
+			produced <- d.b
+			e.int32(1)
+			e.string("events")
+			e.int32(1)
+			e.int32(0)
+			e.int16(0)
+			e.int64(0)
+			e.int64(-1)
+			e.int32(0)
+		}
+		out := e.Bytes()
+		binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+		conn.Write(out)
+		conn.Close()
+	}
+}
@@ -0,0 +1,259 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// api keys and versions, produce v3 and metadata v4 are
+// the lowest versions supported by both 0.11 and 4.x brokers.
+const (
+	apiProduce         = 0
+	apiProduceVersion  = 3
+	apiMetadata        = 3
+	apiMetadataVersion = 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encoder writes kafka protocol primitives.
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+func (e *encoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.string(*s)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+func (e *encoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.Write(b)
+}
+
+// decoder reads kafka protocol primitives, the first error
+// is sticky and makes all subsequent reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+var errShortBuffer = errors.New("kafka: short buffer")
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errShortBuffer
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) nullableString() *string {
+	n := d.int16()
+	if n < 0 {
+		return nil
+	}
+	s := string(d.next(int(n)))
+	return &s
+}
+
+// array reads array length, null arrays are treated as empty.
+func (d *decoder) array() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	return int(n)
+}
+
+// request is a request header v1 followed by body.
+func request(apiKey, apiVersion int16, correlationID int32, clientID string, body []byte) []byte {
+	var e encoder
+	e.int32(0) // size placeholder
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(correlationID)
+	e.string(clientID)
+	e.Write(body)
+
+	b := e.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+// readResponse reads a size delimited response and checks its correlation id.
+func readResponse(r io.Reader, correlationID int32) (*decoder, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	d := &decoder{b: b}
+	if id := d.int32(); id != correlationID {
+		return nil, errors.New("kafka: correlation id mismatch")
+	}
+	return d, nil
+}
+
+// recordBatch encodes a single record into a v2 (magic 2) record batch.
+func recordBatch(key, value []byte, timestamp int64) []byte {
+	var rec encoder
+	rec.int8(0)   // attributes
+	rec.varint(0) // timestamp delta
+	rec.varint(0) // offset delta
+	rec.varbytes(key)
+	rec.varbytes(value)
+	rec.varint(0) // headers count
+
+	// the part covered by crc
+	var body encoder
+	body.int16(0)         // attributes
+	body.int32(0)         // last offset delta
+	body.int64(timestamp) // base timestamp
+	body.int64(timestamp) // max timestamp
+	body.int64(-1)        // producer id
+	body.int16(-1)        // producer epoch
+	body.int32(-1)        // base sequence
+	body.int32(1)         // records count
+	body.varint(int64(rec.Len()))
+	body.Write(rec.Bytes())
+
+	var e encoder
+	e.int64(0)                             // base offset
+	e.int32(int32(4 + 1 + 4 + body.Len())) // batch length
+	e.int32(-1)                            // partition leader epoch
+	e.int8(2)                              // magic
+	e.int32(int32(crc32.Checksum(body.Bytes(), castagnoli)))
+	e.Write(body.Bytes())
+	return e.Bytes()
+}
+
+// murmur2 is the hash used by the java client's default partitioner,
+// using it keeps same keys in same partitions regardless of producer.
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
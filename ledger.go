@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// ledgerPrefix is the KV prefix notifications delivered by
+// targets are remembered under, a key per target.
+const ledgerPrefix = "consul-slack/notified"
+
+// kvSetter writes values of KV keys.
+type kvSetter interface {
+	SetValue(key, value string) error
+}
+
+// ledger remembers the last notification about every incident each target
+// has delivered in the KV, so an instance that takes over after a restart or
+// failover before the state has been saved and sees the same change again
+// doesn't re-send it. Incidents are remembered for ttl since the last one,
+// that's long enough to outlast a failover.
+type ledger struct {
+	kv  kvSetter
+	ttl time.Duration
+
+	mu   sync.Mutex
+	sent map[string]map[string]delivery // by target and incident
+}
+
+// delivery is the last notification delivered about an incident.
+type delivery struct {
+	Fingerprint string    `json:"fingerprint"`
+	Time        time.Time `json:"time"`
+}
+
+func newLedger(kv kvSetter, ttl time.Duration) *ledger {
+	return &ledger{kv: kv, ttl: ttl, sent: map[string]map[string]delivery{}}
+}
+
+// fingerprint identifies the notification about the event within its incident.
+func fingerprint(ev *consul.Event) string {
+	fp := ev.PrevStatus + "/" + ev.Status
+	switch {
+	case ev.Reminder != 0:
+		fp += "/reminder/" + strconv.Itoa(ev.Reminder)
+	case ev.Status == ev.PrevStatus:
+		// output changes are told apart by the output
+		sum := sha256.Sum256([]byte(ev.Output))
+		fp += "/" + hex.EncodeToString(sum[:8])
+	}
+	return fp
+}
+
+// delivered reports whether the notification is the last one the target
+// has delivered about the incident, events of no incidents never are.
+func (l *ledger) delivered(label string, ev *consul.Event) bool {
+	if l == nil || ev.Incident == "" {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d, ok := l.sent[label][ev.Incident]
+	return ok && d.Fingerprint == fingerprint(ev)
+}
+
+// record remembers the notification delivered by the target at now,
+// expired incidents of the target are dropped meanwhile.
+func (l *ledger) record(label string, ev *consul.Event, now time.Time) {
+	if l == nil || ev.Incident == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sent := l.sent[label]
+	if sent == nil {
+		sent = map[string]delivery{}
+		l.sent[label] = sent
+	}
+	sent[ev.Incident] = delivery{Fingerprint: fingerprint(ev), Time: now}
+	for id, d := range sent {
+		if now.Sub(d.Time) >= l.ttl {
+			delete(sent, id)
+		}
+	}
+	b, err := json.Marshal(sent)
+	if err != nil {
+		notifyError("ledger", err)
+		return
+	}
+	if err = l.kv.SetValue(ledgerPrefix+"/"+label, string(b)); err != nil {
+		notifyError("ledger", err)
+	}
+}
+
+// update merges deliveries saved by any instance, it's
+// called by WatchPrefix with values keyed by target labels.
+func (l *ledger) update(values map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for label, v := range values {
+		var saved map[string]delivery
+		if err := json.Unmarshal([]byte(v), &saved); err != nil {
+			notifyError("ledger", err)
+			continue
+		}
+		sent := l.sent[label]
+		if sent == nil {
+			sent = map[string]delivery{}
+			l.sent[label] = sent
+		}
+		for id, d := range saved {
+			if d.Time.After(sent[id].Time) {
+				sent[id] = d
+			}
+		}
+	}
+}
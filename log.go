@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Log formats.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logLevel is the minimum level of logged messages.
+var logLevel = consul.LevelInfo
+
+// debugLogger is newLogger for components that log only
+// debug messages, nil is returned when they're disabled.
+func debugLogger(prefix string) *log.Logger {
+	if logLevel > consul.LevelDebug {
+		return nil
+	}
+	return newLogger(prefix)
+}
+
+// logOutput is where all components write their logs to.
+var logOutput io.Writer = os.Stdout
+
+// errorOutput is where notifier errors are reported to,
+// it's the log file when there's one, stderr otherwise.
+var errorOutput io.Writer = os.Stderr
+
+// newLogger creates a logger with the given prefix writing to logOutput,
+// in json format the prefix becomes the component field.
+func newLogger(prefix string) *log.Logger {
+	if logFormatFlag == logFormatJSON {
+		return log.New(&jsonLogWriter{
+			w:         logOutput,
+			component: strings.Trim(prefix, "[] "),
+		}, "", 0)
+	}
+	return log.New(logOutput, prefix, log.LstdFlags)
+}
+
+// logEntry is a json log line.
+type logEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Component string `json:"component"`
+	Msg       string `json:"msg"`
+	Error     string `json:"error,omitempty"`
+}
+
+// logMu serializes json log writes.
+var logMu sync.Mutex
+
+// writeJSONLog writes the entry as a single json line.
+func writeJSONLog(w io.Writer, e logEntry) error {
+	if e.Time == "" {
+		e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// jsonLogWriter turns lines written by a log.Logger into json log entries.
+type jsonLogWriter struct {
+	w         io.Writer
+	component string
+}
+
+// Write implements io.Writer.
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	err := writeJSONLog(w.w, logEntry{
+		Level:     "info",
+		Component: w.component,
+		Msg:       strings.TrimSuffix(string(p), "\n"),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
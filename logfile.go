@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// logFile is a log file that's rotated when it grows over maxSize
+// bytes or gets older than maxAge, the rotated file is renamed to
+// NAME.1, the previous ones are shifted and at most maxBackups kept.
+type logFile struct {
+	name       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+}
+
+// openLogFile opens the named log file for appending, zero maxSize
+// and maxAge disable rotation by size and age respectively.
+func openLogFile(name string, maxSize int64, maxAge time.Duration, maxBackups int) (*logFile, error) {
+	l := &logFile{
+		name:       name,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the log file, an existing file's age counts from its last modification.
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, fi.Size(), l.now()
+	if fi.Size() != 0 && fi.ModTime().Before(l.opened) {
+		l.opened = fi.ModTime()
+	}
+	return nil
+}
+
+// Write implements io.Writer, the file is rotated before
+// writing p when it would exceed the limits otherwise.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size != 0 && (l.maxSize > 0 && l.size+int64(len(p)) > l.maxSize ||
+		l.maxAge > 0 && l.now().Sub(l.opened) >= l.maxAge) {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file, shifts backups and opens a new one.
+func (l *logFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if l.maxBackups < 1 {
+		if err := os.Remove(l.name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return l.open()
+	}
+	if err := os.Remove(l.backup(l.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := l.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(l.backup(i), l.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.name, l.backup(1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// backup returns the name of the i-th rotated file.
+func (l *logFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", l.name, i)
+}
+
+// Close closes the log file.
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,2575 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+	"github.com/amenzhinsky/consul-slack/ndjson"
+	"github.com/amenzhinsky/consul-slack/tracing"
+	"github.com/amenzhinsky/consul-slack/vault"
+	"github.com/amenzhinsky/consul-slack/webhook"
+)
+
+type notifierFunc func(ev *consul.Event) error
+
+func (f notifierFunc) Notify(ev *consul.Event) error {
+	return f(ev)
+}
+
+func TestFiltersFlag(t *testing.T) {
+	t.Parallel()
+
+	f := filtersFlag{}
+	for _, s := range []string{"slack:statuses=critical,passing", "slack:services=^api-"} {
+		if err := f.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []string{"slack", "slack:statuses=down", "slack:foo=bar", "slack:services=("} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) expected to fail", s)
+		}
+	}
+
+	for ev, want := range map[*consul.Event]bool{
+		{ServiceName: "api-users", Status: consul.Critical}: true,
+		{ServiceName: "api-users", Status: consul.Warning}:  false,
+		{ServiceName: "web", Status: consul.Passing}:        false,
+	} {
+		if got := f["slack"].match(ev); got != want {
+			t.Errorf("match(%s, %s) = %t, want %t", ev.ServiceName, ev.Status, got, want)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	record := func(name string) notifier {
+		return notifierFunc(func(ev *consul.Event) error {
+			mu.Lock()
+			got = append(got, name)
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	f := filtersFlag{}
+	if err := f.Set("b:statuses=warning"); err != nil {
+		t.Fatal(err)
+	}
+
+	var failed string
+	dispatch([]*target{
+		{name: "a", notifier: record("a")},
+		{name: "b", notifier: record("b"), filter: f["b"]},
+		{name: "c", notifier: record("c")},
+		{name: "d", notifier: notifierFunc(func(*consul.Event) error {
+			return errors.New("boom")
+		})},
+	}, &consul.Event{Status: consul.Critical}, func(name string, err error) {
+		failed = name
+	})
+
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("delivered to %v, want [a c]", got)
+	}
+	if failed != "d" {
+		t.Errorf("failed = %q, want %q", failed, "d")
+	}
+}
+
+type rolerFunc func() bool
+
+func (f rolerFunc) Active() bool {
+	return f()
+}
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+
+	for active, want := range map[bool]string{true: "active", false: "standby"} {
+		active := active
+		w := httptest.NewRecorder()
+		healthHandler(rolerFunc(func() bool {
+			return active
+		})).ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+
+		var v map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != 200 || v["role"] != want {
+			t.Errorf("active = %t: code = %d, role = %q, want 200 and %q", active, w.Code, v["role"], want)
+		}
+	}
+}
+
+type pingRolerFunc func() error
+
+func (f pingRolerFunc) Active() bool {
+	return true
+}
+
+func (f pingRolerFunc) Ping() error {
+	return f()
+}
+
+func TestReadinessHandler(t *testing.T) {
+	t.Parallel()
+
+	for err, want := range map[error]int{
+		nil:                              200,
+		errors.New("connection refused"): 503,
+	} {
+		err := err
+		w := httptest.NewRecorder()
+		readinessHandler(pingRolerFunc(func() error {
+			return err
+		})).ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != want {
+			t.Errorf("ping error = %v: code = %d, want %d", err, w.Code, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	livenessHandler().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != 200 {
+		t.Errorf("liveness code = %d, want 200", w.Code)
+	}
+}
+
+func TestParseNodeMeta(t *testing.T) {
+	t.Parallel()
+
+	meta, err := parseNodeMeta("env=prod,role=db,empty=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta) != 3 || meta["env"] != "prod" || meta["role"] != "db" || meta["empty"] != "" {
+		t.Errorf("meta = %v", meta)
+	}
+	for _, s := range []string{"env", "=prod", "env=prod,"} {
+		if _, err = parseNodeMeta(s); err == nil {
+			t.Errorf("parseNodeMeta(%q) expected to fail", s)
+		}
+	}
+}
+
+func TestReminders(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	r := newReminders(time.Minute)
+	r.track(&consul.Event{ID: "web", Datacenter: "dc1", Status: consul.Critical}, now)
+	r.track(&consul.Event{ID: "db", Datacenter: "dc1", Status: consul.Critical}, now)
+	r.track(&consul.Event{ID: "db", Datacenter: "dc1", Status: consul.Passing}, now)
+
+	if evs := r.due(now.Add(time.Second)); len(evs) != 0 {
+		t.Fatalf("due = %d, want none before the interval", len(evs))
+	}
+	for i := 1; i <= 2; i++ {
+		evs := r.due(now.Add(time.Duration(i) * time.Minute))
+		if len(evs) != 1 || evs[0].ID != "web" || evs[0].Reminder != i {
+			t.Fatalf("due = %v, want reminder #%d about web", evs, i)
+		}
+	}
+
+	// reminders don't restart reminding
+	r.track(&consul.Event{ID: "web", Datacenter: "dc1", Status: consul.Critical, Reminder: 2}, now)
+	if evs := r.due(now.Add(3 * time.Minute)); len(evs) != 1 || evs[0].Reminder != 3 {
+		t.Fatalf("due = %v, want reminder #3", evs)
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	t.Parallel()
+
+	targets := []*target{{name: "slack"}, {name: "telegram"}, {name: "jira"}}
+	got := selectTargets(targets, []string{"jira", "slack", "rocketchat"})
+	if len(got) != 2 || got[0].name != "slack" || got[1].name != "jira" {
+		t.Fatalf("selectTargets = %v", got)
+	}
+}
+
+type summarizerFunc func(evs []*consul.Event) error
+
+func (f summarizerFunc) Notify(ev *consul.Event) error {
+	return nil
+}
+
+func (f summarizerFunc) Summary(evs []*consul.Event) error {
+	return f(evs)
+}
+
+func TestSendSummary(t *testing.T) {
+	t.Parallel()
+
+	var got []*consul.Event
+	sendSummary([]*target{
+		{name: "slack", notifier: summarizerFunc(func(evs []*consul.Event) error {
+			got = evs
+			return nil
+		})},
+		{name: "jira", notifier: notifierFunc(func(ev *consul.Event) error {
+			t.Error("jira doesn't support summaries")
+			return nil
+		})},
+	}, []*consul.Event{{Node: "node1", Datacenter: "dc1", ServiceID: "web", Status: consul.Critical}})
+
+	if len(got) != 1 {
+		t.Fatalf("summary = %v, want one event", got)
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]struct {
+		n       int
+		percent bool
+	}{
+		"3":   {3, false},
+		"50%": {50, true},
+	} {
+		n, percent, err := parseThreshold(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want.n || percent != want.percent {
+			t.Errorf("parseThreshold(%q) = %d, %t, want %d, %t", s, n, percent, want.n, want.percent)
+		}
+	}
+	for _, s := range []string{"", "%", "half"} {
+		if _, _, err := parseThreshold(s); err == nil {
+			t.Errorf("parseThreshold(%q) expected an error", s)
+		}
+	}
+}
+
+func TestParseDependencies(t *testing.T) {
+	t.Parallel()
+
+	deps, err := parseDependencies("web=api,web=db,api=db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 2 || strings.Join(deps["web"], ",") != "api,db" || strings.Join(deps["api"], ",") != "db" {
+		t.Errorf("parseDependencies = %v", deps)
+	}
+	for _, s := range []string{"web", "=api", "web="} {
+		if _, err = parseDependencies(s); err == nil {
+			t.Errorf("parseDependencies(%q) expected an error", s)
+		}
+	}
+}
+
+func TestParseStatusPrefixes(t *testing.T) {
+	t.Parallel()
+
+	prefixes, err := parseStatusPrefixes("critical=🔴 CRITICAL,passing=🟢 RESOLVED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 || prefixes[consul.Critical] != "🔴 CRITICAL" || prefixes[consul.Passing] != "🟢 RESOLVED" {
+		t.Errorf("parseStatusPrefixes = %v", prefixes)
+	}
+	for _, s := range []string{"critical", "=CRITICAL", "critical=", "down=DOWN"} {
+		if _, err = parseStatusPrefixes(s); err == nil {
+			t.Errorf("parseStatusPrefixes(%q) expected an error", s)
+		}
+	}
+}
+
+func TestJSONLogWriter(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	l := log.New(&jsonLogWriter{w: &b, component: "consul"}, "", 0)
+	l.Printf("dc1: node1:web: %s -> %s", consul.Passing, consul.Critical)
+
+	var e logEntry
+	if err := json.Unmarshal(b.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Component != "consul" || e.Level != "info" || e.Msg != "dc1: node1:web: passing -> critical" || e.Time == "" {
+		t.Errorf("entry = %+v", e)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	f, err := ioutil.TempFile("", "consul-slack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString(`
+# slack settings
+slack-channel = "#alerts"
+slack-username = Consul
+filter = slack:statuses=critical
+filter = "slack:services=^api-"
+`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var channel, username string
+	filters := filtersFlag{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&channel, "slack-channel", "", "")
+	fs.StringVar(&username, "slack-username", "", "")
+	fs.Var(filters, "filter", "")
+	if err = fs.Parse([]string{"-slack-username", "bot"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = loadConfig(fs, f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if channel != "#alerts" || username != "bot" {
+		t.Errorf("channel = %q, username = %q, want #alerts and bot", channel, username)
+	}
+	if flt := filters["slack"]; flt == nil || !flt.statuses[consul.Critical] || flt.services == nil {
+		t.Errorf("filter = %+v, want statuses and services", flt)
+	}
+
+	if err = ioutil.WriteFile(f.Name(), []byte("slack-icon = x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = loadConfig(fs, f.Name()); err == nil {
+		t.Error("loadConfig expected an unknown option error")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Parallel()
+
+	var channel, username string
+	var confirmations int
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&channel, "slack-channel", "", "")
+	fs.StringVar(&username, "slack-username", "", "")
+	fs.IntVar(&confirmations, "confirmations", 1, "")
+	if err := fs.Parse([]string{"-slack-username", "bot"}); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"CONSUL_SLACK_SLACK_CHANNEL":  "#alerts",
+		"CONSUL_SLACK_SLACK_USERNAME": "Consul",
+		"CONSUL_SLACK_CONFIRMATIONS":  "3",
+	}
+	if err := loadEnv(fs, func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if channel != "#alerts" || username != "bot" || confirmations != 3 {
+		t.Errorf("channel = %q, username = %q, confirmations = %d", channel, username, confirmations)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.IntVar(&confirmations, "confirmations", 1, "")
+	if err := loadEnv(fs, func(string) (string, bool) {
+		return "many", true
+	}); err == nil {
+		t.Error("loadEnv expected a malformed value error")
+	}
+}
+
+type secretReaderFunc func(path, field string) (*vault.Secret, error)
+
+func (f secretReaderFunc) Read(path, field string) (*vault.Secret, error) {
+	return f(path, field)
+}
+
+func TestResolveSecrets(t *testing.T) {
+	t.Parallel()
+
+	f, err := ioutil.TempFile("", "consul-slack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString("xoxb-1\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var slackToken, consulToken, channel string
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&slackToken, "slack-token", "file:"+f.Name(), "")
+	fs.StringVar(&consulToken, "consul-token", "vault:consul/creds/consul-slack#token", "")
+	fs.StringVar(&channel, "slack-channel", "file:#alerts", "")
+	created := 0
+	newVault := func() (secretReader, error) {
+		created++
+		return secretReaderFunc(func(path, field string) (*vault.Secret, error) {
+			if path != "consul/creds/consul-slack" || field != "token" {
+				return nil, fmt.Errorf("unknown secret %s#%s", path, field)
+			}
+			return &vault.Secret{Value: "acl-1"}, nil
+		}), nil
+	}
+	if err = resolveSecrets(fs, []string{"slack-token", "consul-token", "missing"}, newVault); err != nil {
+		t.Fatal(err)
+	}
+	if slackToken != "xoxb-1" || consulToken != "acl-1" || channel != "file:#alerts" || created != 1 {
+		t.Errorf("slack token = %q, consul token = %q, channel = %q, vault clients = %d", slackToken, consulToken, channel, created)
+	}
+
+	for _, val := range []string{"vault:consul/creds/consul-slack", "vault:unknown#token", "file:/nonexistent"} {
+		fs.Set("consul-token", val)
+		if err = resolveSecrets(fs, []string{"consul-token"}, newVault); err == nil {
+			t.Errorf("resolveSecrets of %q expected to fail", val)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	c := &http.Client{Transport: &dryRunTransport{w: &b}}
+	r, err := c.Post("https://hooks.slack.com/services/SECRET", "application/json", strings.NewReader(`{"text":"web is critical"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err = json.NewDecoder(r.Body).Decode(&v); err != nil || r.StatusCode != http.StatusOK {
+		t.Errorf("response = %d, %v, want 200 with json", r.StatusCode, err)
+	}
+	r.Body.Close()
+
+	n := &dryRunNotifier{name: "kafka", w: &b}
+	if err = n.Notify(&consul.Event{Node: "n1", Status: consul.Critical}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := b.String()
+	for _, s := range []string{
+		"[dry-run] POST https://hooks.slack.com\n",
+		`{"text":"web is critical"}`,
+		"[dry-run] kafka\n",
+		`"Node":"n1"`,
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("output %q doesn't contain %q", got, s)
+		}
+	}
+	if strings.Contains(got, "SECRET") {
+		t.Errorf("output %q contains the webhook secret", got)
+	}
+}
+
+func TestSystemd(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "consul-slack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addr := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	env := map[string]string{"NOTIFY_SOCKET": addr, "WATCHDOG_USEC": "10000000"}
+	s := newSystemd(func(k string) string { return env[k] })
+	if s.watchdog != 10*time.Second {
+		t.Fatalf("watchdog = %s, want 10s", s.watchdog)
+	}
+
+	s.heartbeat(false)
+	s.heartbeat(false) // too early to pet the watchdog again
+	s.heartbeat(true)
+
+	b := make([]byte, 256)
+	for _, want := range []string{
+		"READY=1\nSTATUS=standby\nWATCHDOG=1\n",
+		"READY=1\nSTATUS=active\n",
+	} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b[:n]); got != want {
+			t.Errorf("state = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if drain(&wg, 10*time.Millisecond) {
+		t.Error("drain = true, want false while a delivery is in flight")
+	}
+	wg.Done()
+	if !drain(&wg, time.Second) {
+		t.Error("drain = false, want true once deliveries are done")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	for err, want := range map[error]int{
+		nil:                                   exitOK,
+		errors.New("boom"):                    exitFailure,
+		withCode(exitConsul, errors.New("x")): exitConsul,
+	} {
+		if got := exitCode(err); got != want {
+			t.Errorf("exitCode(%v) = %d, want %d", err, got, want)
+		}
+	}
+	if err := withCode(exitConfig, nil); err != nil {
+		t.Errorf("withCode(exitConfig, nil) = %v, want nil", err)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		args []string
+		name string
+		rest int
+	}{
+		{nil, "run", 0},
+		{[]string{"-slack-channel", "#ops", "https://hooks"}, "run", 3},
+		{[]string{"status", "-consul-address", "consul:8500"}, "status", 2},
+		{[]string{"silence", "web", "1h"}, "silence", 2},
+	} {
+		name, rest := parseCommand(tc.args)
+		if name != tc.name || len(rest) != tc.rest {
+			t.Errorf("parseCommand(%q) = %q, %q, want %q with %d args", tc.args, name, rest, tc.name, tc.rest)
+		}
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	t.Parallel()
+
+	since := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	var b bytes.Buffer
+	printStatus(&b, &consul.Holder{Host: "host1", Session: "s1", Since: since},
+		map[string]time.Time{"db": since.Add(time.Hour)},
+		[]*consul.Event{
+			{Node: "n1", ServiceID: "web", ServiceName: "web", Status: consul.Critical},
+			{Node: "n2", CheckID: consul.SerfHealth, Name: "Serf Health Status", Status: consul.Critical},
+		},
+	)
+	want := "lock is held by host1 since 2017-09-01T12:00:00Z (session s1)\n" +
+		"db is silenced until 2017-09-01T13:00:00Z\n" +
+		"[n1] web is critical\n" +
+		"[n2] node check Serf Health Status is critical\n"
+	if b.String() != want {
+		t.Errorf("status = %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	printStatus(&b, nil, nil, nil)
+	if want = "lock is not held\nall checks are passing\n"; b.String() != want {
+		t.Errorf("status = %q, want %q", b.String(), want)
+	}
+}
+
+type ackerStub map[string]*consul.Ack
+
+func (s ackerStub) Acks() (map[string]*consul.Ack, error) {
+	return s, nil
+}
+
+func TestAcks(t *testing.T) {
+	t.Parallel()
+
+	at := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	acks := ackerStub{"web": {By: "alice", Time: at, Comment: "restarting"}, "db": {By: "bob", Time: at}}
+	evs := unacked(acks, []*consul.Event{
+		{ServiceName: "web", Status: consul.Critical},
+		{ServiceName: "api", Status: consul.Critical},
+		{Node: "n1", CheckID: consul.SerfHealth, Status: consul.Critical},
+	})
+	if len(evs) != 2 || evs[0].ServiceName != "api" || evs[1].CheckID != consul.SerfHealth {
+		t.Errorf("unacked = %v, want api and serfHealth", evs)
+	}
+
+	var b bytes.Buffer
+	printAcks(&b, acks)
+	want := "db is acknowledged by bob at 2017-09-01T12:00:00Z\n" +
+		"web is acknowledged by alice at 2017-09-01T12:00:00Z: restarting\n"
+	if b.String() != want {
+		t.Errorf("acks = %q, want %q", b.String(), want)
+	}
+}
+
+func TestSendTest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		statuses []string
+	)
+	targets := []*target{
+		{name: "slack", notifier: notifierFunc(func(ev *consul.Event) error {
+			mu.Lock()
+			statuses = append(statuses, ev.Status)
+			mu.Unlock()
+			return nil
+		})},
+		{name: "telegram", notifier: notifierFunc(func(ev *consul.Event) error {
+			if ev.Status == consul.Critical {
+				return errors.New("chat not found")
+			}
+			return nil
+		})},
+	}
+
+	var b bytes.Buffer
+	if sendTest(&b, targets) {
+		t.Error("sendTest = true, want false when a notifier fails")
+	}
+	if len(statuses) != 3 {
+		t.Errorf("slack got %v, want every status", statuses)
+	}
+	want := "passing:\n  slack: ok\n  telegram: ok\n" +
+		"warning:\n  slack: ok\n  telegram: ok\n" +
+		"critical:\n  slack: ok\n  telegram: chat not found\n"
+	if b.String() != want {
+		t.Errorf("output = %q, want %q", b.String(), want)
+	}
+}
+
+func TestPrintRoutes(t *testing.T) {
+	targets := []*target{
+		{name: "slack", filter: &filter{}},
+		{name: "opsgenie"},
+	}
+
+	remindIntervalFlag, escalateAfterFlag, escalateTargetsFlag = time.Hour, 2, "opsgenie"
+	defer func() {
+		remindIntervalFlag, escalateAfterFlag, escalateTargetsFlag = 0, 0, ""
+	}()
+
+	var b bytes.Buffer
+	if err := printRoutes(&b, targets); err != nil {
+		t.Fatal(err)
+	}
+	want := "events go to slack (filtered), opsgenie\n" +
+		"reminders go to slack (filtered)\n" +
+		"escalations go to slack (filtered), opsgenie\n"
+	if b.String() != want {
+		t.Errorf("routes = %q, want %q", b.String(), want)
+	}
+
+	if err := printRoutes(&b, targets[1:]); err == nil {
+		t.Error("expected an error when reminders have no notifiers")
+	}
+}
+
+type silencerFunc func() (map[string]time.Time, error)
+
+func (f silencerFunc) Silences() (map[string]time.Time, error) {
+	return f()
+}
+
+func TestUnsilenced(t *testing.T) {
+	t.Parallel()
+
+	evs := []*consul.Event{
+		{Node: "n1", ServiceName: "web"},
+		{Node: "n1", ServiceName: "db"},
+		{Node: "n1", CheckID: consul.SerfHealth},
+	}
+	got := unsilenced(silencerFunc(func() (map[string]time.Time, error) {
+		return map[string]time.Time{"web": time.Now().Add(time.Hour)}, nil
+	}), evs)
+	if len(got) != 2 || got[0].ServiceName != "db" || got[1].CheckID != consul.SerfHealth {
+		t.Errorf("unsilenced = %v, want db and serfHealth", got)
+	}
+
+	// reminders are sent anyway when silences are unavailable
+	got = unsilenced(silencerFunc(func() (map[string]time.Time, error) {
+		return nil, errors.New("consul is down")
+	}), evs)
+	if len(got) != 3 {
+		t.Errorf("unsilenced = %v, want all events", got)
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	t.Parallel()
+
+	w, err := parseWindow("web:sat-mon 23:00-01:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.service != "web" || w.start != 23*time.Hour || w.end != 90*time.Minute {
+		t.Errorf("parseWindow = %+v, want web 23:00-01:30", w)
+	}
+	if want := [7]bool{true, true, false, false, false, false, true}; w.days != want {
+		t.Errorf("days = %v, want %v", w.days, want)
+	}
+
+	// 2017-09-04 is monday
+	for _, tc := range []struct {
+		time   string
+		active bool
+	}{
+		{"2017-09-04 23:30", true},
+		{"2017-09-05 01:00", true},  // monday's window ends on tuesday
+		{"2017-09-06 00:30", false}, // tuesday's window doesn't exist
+		{"2017-09-04 22:59", false},
+	} {
+		now, _ := time.Parse("2006-01-02 15:04", tc.time)
+		if got := w.active(now); got != tc.active {
+			t.Errorf("active(%s) = %t, want %t", tc.time, got, tc.active)
+		}
+	}
+
+	for _, s := range []string{"sun", "sun 02:00", "funday 02:00-03:00", "daily 2-3"} {
+		if _, err = parseWindow(s); err == nil {
+			t.Errorf("parseWindow(%q) expected to fail", s)
+		}
+	}
+}
+
+func TestWindows(t *testing.T) {
+	t.Parallel()
+
+	w, err := parseWindow("daily 02:00-04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := newWindows([]*window{w})
+	at := func(s string) time.Time {
+		now, _ := time.Parse("15:04", s)
+		return now
+	}
+
+	ev := &consul.Event{Node: "n1", ServiceName: "web", Status: consul.Critical}
+	if !ws.suppress(ev, at("03:00")) || !ws.suppress(ev, at("03:00")) {
+		t.Error("event expected to be suppressed during the window")
+	}
+	if ws.suppress(&consul.Event{CheckID: "consul-slack/.lock", Status: consul.LockAcquired}, at("03:00")) {
+		t.Error("lock events expected not to be suppressed")
+	}
+	if len(ws.ended(at("03:30"))) != 0 {
+		t.Error("window expected not to be over yet")
+	}
+	if got := ws.ended(at("04:00")); len(got[w]) != 1 {
+		t.Errorf("ended = %v, want a single suppressed event", got)
+	}
+	if len(ws.ended(at("04:01"))) != 0 {
+		t.Error("suppressed events expected to be reported once")
+	}
+}
+
+func TestOutage(t *testing.T) {
+	t.Parallel()
+
+	o := newOutage(2, 5*time.Minute, time.Minute)
+	now := time.Now()
+	critical := func(node string) *consul.Event {
+		return &consul.Event{Node: node, ID: node + ":web", ServiceName: "web", Status: consul.Critical, PrevStatus: consul.Passing}
+	}
+	for _, node := range []string{"n1", "n2"} {
+		if o.hold(critical(node), now) {
+			t.Fatalf("%s expected to be alerted about before the outage", node)
+		}
+	}
+	ev := critical("n3")
+	if !o.hold(ev, now) || !o.hold(ev, now) {
+		t.Fatal("third check going critical expected to be held")
+	}
+	if o.hold(&consul.Event{CheckID: "consul-slack/.lock", Status: consul.LockAcquired}, now) {
+		t.Error("lock events expected not to be held")
+	}
+	if !o.hold(&consul.Event{Node: "n1", ID: "n1:web", ServiceName: "web", Status: consul.Passing, PrevStatus: consul.Critical}, now) {
+		t.Error("recovery expected to be held during the outage")
+	}
+	since, evs, over, due := o.update(now)
+	if !due || over || !since.Equal(now) || len(evs) != 2 || evs[0].Node != "n2" || evs[1].Node != "n3" {
+		t.Fatalf("update = %v, %v, %t, %t, want n2 and n3 failing", since, evs, over, due)
+	}
+	o.hold(critical("n4"), now.Add(30*time.Second))
+	if _, _, _, due = o.update(now.Add(30 * time.Second)); due {
+		t.Error("update expected not to be due before the interval")
+	}
+	if _, evs, over, due = o.update(now.Add(time.Minute)); !due || over || len(evs) != 3 {
+		t.Errorf("update = %v, %t, %t, want three failing", evs, over, due)
+	}
+	if _, evs, over, due = o.update(now.Add(6 * time.Minute)); !due || !over || len(evs) != 3 {
+		t.Errorf("update = %v, %t, %t, want the outage over", evs, over, due)
+	}
+	if o.hold(critical("n5"), now.Add(6*time.Minute)) {
+		t.Error("alerts expected to resume after the outage")
+	}
+}
+
+func TestOutageGrouped(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var sent int
+	o := newOutage(3, 5*time.Minute, time.Minute)
+	targets := make([]*target, 2)
+	for i := range targets {
+		targets[i] = &target{name: "slack", outage: o, notifier: notifierFunc(func(ev *consul.Event) error {
+			mu.Lock()
+			sent++
+			mu.Unlock()
+			return nil
+		})}
+	}
+	now := time.Now()
+	critical := func(check string) *consul.Event {
+		return &consul.Event{Node: "n1", CheckID: check, ID: "n1/" + check, Status: consul.Critical, PrevStatus: consul.Passing, Time: now}
+	}
+	ev := critical("serfHealth")
+	ev.Grouped = []*consul.Event{critical("disk"), critical("mem")}
+	dispatch(targets, ev, func(name string, err error) {
+		t.Errorf("%s: %v", name, err)
+	})
+	if !o.since.IsZero() || sent != 6 {
+		t.Errorf("outage started by %d checks of a grouped event, %d notifications sent", len(o.recent), sent)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"drop", "drop event.Node == \"n1\"", "keep if event.Node", "set Node=n1",
+		"set Status=fatal", "set meta.=x", "set Mention", "rewrite Notes=x"} {
+		if _, err := parseStep(s); err == nil {
+			t.Errorf("parseStep(%q) expected to fail", s)
+		}
+	}
+
+	var steps middlewareFlag
+	for _, s := range []string{
+		`drop if event.ServiceName == "canary"`,
+		`set meta.team=sre if event.Node.startsWith("db")`,
+		`set Status=warning if event.Meta["team"] == "sre"`,
+		`keep if event.Status != "passing"`,
+	} {
+		if err := steps.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := parseRule("meta.team=sre -> channel=#sre")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	record := func(name string) notifier {
+		return notifierFunc(func(ev *consul.Event) error {
+			got = append(got, name+" "+ev.Node+" "+ev.ServiceName+" "+ev.Status)
+			return nil
+		})
+	}
+	targets := []*target{{name: "slack", notifier: record("slack#consul"), rules: []*rule{r}, steps: steps,
+		channels: map[string]notifier{"#sre": record("slack#sre")}}}
+	for _, ev := range []*consul.Event{
+		{Node: "n1", ServiceName: "canary", Status: consul.Critical},
+		{Node: "n1", ServiceName: "web", Status: consul.Passing},
+		{Node: "db1", ServiceName: "pg", Status: consul.Critical, Grouped: []*consul.Event{
+			{Node: "db1", ServiceName: "canary", Status: consul.Critical},
+			{Node: "db1", ServiceName: "pgbouncer", Status: consul.Critical},
+		}},
+		{Node: "n1", ServiceName: "web", Status: consul.Critical},
+	} {
+		dispatch(targets, ev, func(name string, err error) { t.Fatal(err) })
+	}
+	want := "slack#sre db1 pg warning,slack#sre db1 pgbouncer warning,slack#consul n1 web critical"
+	if s := strings.Join(got, ","); s != want {
+		t.Errorf("delivered %q, want %q", s, want)
+	}
+	if keys := ruleMetaKeys(targets); strings.Join(keys, ",") != "team" {
+		t.Errorf("ruleMetaKeys = %v, want [team]", keys)
+	}
+}
+
+func TestRules(t *testing.T) {
+	t.Parallel()
+
+	var rules []*rule
+	for _, s := range []string{
+		"service=db status=warning -> suppress",
+		"service=api-.* tag=prod -> notifiers=slack channel=#api mention=@oncall severity=critical",
+		"meta.team=payments|billing -> notifiers=slack channel=#payments",
+	} {
+		r, err := parseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	for _, s := range []string{"service=db", "service=db ->", "host=a -> suppress", "service=db -> severity=fatal", "meta.=x -> suppress"} {
+		if _, err := parseRule(s); err == nil {
+			t.Errorf("parseRule(%q) expected to fail", s)
+		}
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	record := func(name string) notifier {
+		return notifierFunc(func(ev *consul.Event) error {
+			mu.Lock()
+			got = append(got, name+" "+ev.ServiceName+" "+ev.Status+" "+ev.Mention)
+			mu.Unlock()
+			return nil
+		})
+	}
+	slackTarget := &target{name: "slack", notifier: record("slack#consul"), inChannel: func(channel string) (notifier, error) {
+		return record("slack" + channel), nil
+	}}
+	targets := []*target{slackTarget, {name: "opsgenie", notifier: record("opsgenie")}}
+	if err := applyRules(targets, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		ev   *consul.Event
+		want string
+	}{
+		{&consul.Event{ServiceName: "db", Status: consul.Warning}, ""},
+		{&consul.Event{ServiceName: "db", Status: consul.Critical}, "opsgenie db critical ,slack#consul db critical "},
+		{&consul.Event{ServiceName: "api-users", ServiceTags: []string{"prod"}, Status: consul.Warning}, "slack#api api-users critical @oncall"},
+		{&consul.Event{ServiceName: "api-users", Status: consul.Warning}, "opsgenie api-users warning ,slack#consul api-users warning "},
+		{&consul.Event{ServiceName: "cards", Meta: map[string]string{"team": "payments"}, Status: consul.Critical}, "slack#payments cards critical "},
+		{&consul.Event{ServiceName: "cards", Meta: map[string]string{"team": "payments-eu"}, Status: consul.Critical}, "opsgenie cards critical ,slack#consul cards critical "},
+	} {
+		got = nil
+		dispatch(targets, tc.ev, func(name string, err error) { t.Fatal(err) })
+		sort.Strings(got)
+		if s := strings.Join(got, ","); s != tc.want {
+			t.Errorf("%+v delivered as %q, want %q", tc.ev, s, tc.want)
+		}
+	}
+	if keys := ruleMetaKeys(targets); len(keys) != 1 || keys[0] != "team" {
+		t.Errorf("ruleMetaKeys = %v, want [team]", keys)
+	}
+}
+
+func TestRules_Escalation(t *testing.T) {
+	t.Parallel()
+
+	var rules []*rule
+	for _, s := range []string{
+		"service=api -> channel=#api mention=@api escalate=2 escalate-channel=#incidents escalate-mention=<!channel>",
+		"service=db -> channel=#db escalate=1h escalate-mention=@dba-lead",
+	} {
+		r, err := parseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	for _, s := range []string{"service=db -> escalate=0", "service=db -> escalate=soon", "service=db -> escalate-channel=#x"} {
+		if _, err := parseRule(s); err == nil {
+			t.Errorf("parseRule(%q) expected to fail", s)
+		}
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	slackTarget := &target{name: "slack", notifier: notifierFunc(func(ev *consul.Event) error {
+		t.Errorf("%+v delivered to the default channel", ev)
+		return nil
+	}), inChannel: func(channel string) (notifier, error) {
+		return notifierFunc(func(ev *consul.Event) error {
+			mu.Lock()
+			got = append(got, channel+" "+ev.ServiceName+" "+ev.Mention+" "+strconv.FormatBool(ev.Escalated))
+			mu.Unlock()
+			return nil
+		}), nil
+	}}
+	if err := applyRules([]*target{slackTarget}, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, tc := range []struct {
+		ev   *consul.Event
+		want string
+	}{
+		{&consul.Event{ServiceName: "api", Status: consul.Critical}, "#api api @api false"},
+		{&consul.Event{ServiceName: "api", Status: consul.Critical, Reminder: 2}, "#api api @api false"},
+		{&consul.Event{ServiceName: "api", Status: consul.Critical, Reminder: 3}, "#incidents api <!channel> true"},
+		{&consul.Event{ServiceName: "db", Status: consul.Critical, Reminder: 5, Time: now, CriticalSince: now.Add(-time.Minute)}, "#db db  false"},
+		{&consul.Event{ServiceName: "db", Status: consul.Critical, Reminder: 5}, "#db db  false"},
+		{&consul.Event{ServiceName: "db", Status: consul.Critical, Reminder: 1, Time: now, CriticalSince: now.Add(-time.Hour)}, "#db db @dba-lead true"},
+	} {
+		got = nil
+		dispatch([]*target{slackTarget}, tc.ev, func(name string, err error) { t.Fatal(err) })
+		if s := strings.Join(got, ","); s != tc.want {
+			t.Errorf("%+v delivered as %q, want %q", tc.ev, s, tc.want)
+		}
+	}
+}
+
+func TestRules_Hours(t *testing.T) {
+	t.Parallel()
+
+	var rules []*rule
+	for _, s := range []string{
+		"days=mon-fri hours=09:00-18:00 tz=Europe/Berlin -> notifiers=slack channel=#team",
+		"days=sat,sun -> notifiers=opsgenie",
+		"hours=18:00-09:00 tz=Europe/Berlin -> notifiers=opsgenie",
+	} {
+		r, err := parseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	for _, s := range []string{"days=weekdays -> suppress", "hours=9-18 -> suppress", "tz=Nowhere/Atlantis -> suppress"} {
+		if _, err := parseRule(s); err == nil {
+			t.Errorf("parseRule(%q) expected to fail", s)
+		}
+	}
+
+	for at, want := range map[string]int{
+		"2024-05-06T08:00:00Z": 0, // monday 10:00 in berlin
+		"2024-05-06T17:00:00Z": 2, // monday 19:00
+		"2024-05-07T06:00:00Z": 2, // tuesday 08:00, after hours of monday
+		"2024-05-11T12:00:00Z": 1, // saturday
+	} {
+		now, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := findRule(rules, &consul.Event{ServiceName: "web", Status: consul.Critical, Time: now})
+		if r == nil || r != rules[want] {
+			t.Errorf("%s matched %v, want rule %d", at, r, want)
+		}
+	}
+}
+
+func TestKVRouting(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	record := func(name string) notifier {
+		return notifierFunc(func(ev *consul.Event) error {
+			mu.Lock()
+			got = append(got, name+" "+ev.ServiceName)
+			mu.Unlock()
+			return nil
+		})
+	}
+	slackTarget := &target{name: "slack", notifier: record("slack#consul"), inChannel: func(channel string) (notifier, error) {
+		return record("slack" + channel), nil
+	}}
+	targets := []*target{slackTarget, {name: "opsgenie", notifier: record("opsgenie")}}
+	r, err := parseRule("service=db -> channel=#flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = applyRules(targets, []*rule{r}); err != nil {
+		t.Fatal(err)
+	}
+	kv := newKVRouting(targets)
+
+	deliver := func(service string) string {
+		got = nil
+		dispatch(targets, &consul.Event{ServiceName: service, Status: consul.Critical}, func(name string, err error) { t.Fatal(err) })
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+	if s := deliver("db"); s != "opsgenie db,slack#flags db" {
+		t.Errorf("db delivered as %q before reloading", s)
+	}
+
+	if err = kv.update(map[string]string{
+		"rules":   "# kv rules go first\nservice=db -> channel=#kv\n\nservice=web -> notifiers=slack channel=#web",
+		"filters": "opsgenie:services=db|web",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for service, want := range map[string]string{
+		"db":    "opsgenie db,slack#kv db",
+		"web":   "slack#web web",
+		"cache": "slack#consul cache",
+	} {
+		if s := deliver(service); s != want {
+			t.Errorf("%s delivered as %q, want %q", service, s, want)
+		}
+	}
+
+	// invalid configurations are ignored
+	for _, values := range []map[string]string{
+		{"rules": "service=db"},
+		{"filters": "opsgenie:hosts=x"},
+		{"routes": ""},
+	} {
+		if err = kv.update(values); err == nil {
+			t.Errorf("update(%v) expected to fail", values)
+		}
+	}
+	if s := deliver("web"); s != "slack#web web" {
+		t.Errorf("web delivered as %q after failed updates", s)
+	}
+
+	if err = kv.update(map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := deliver("db"); s != "opsgenie db,slack#flags db" {
+		t.Errorf("db delivered as %q after the kv routing is cleared", s)
+	}
+}
+
+func TestExpr(t *testing.T) {
+	t.Parallel()
+
+	ev := &consul.Event{
+		Node: "db-3", ServiceName: "postgres", Status: consul.Critical, PrevStatus: consul.Passing,
+		ServiceTags: []string{"prod", "primary"}, Meta: map[string]string{"team": "storage"}, Reminder: 2,
+	}
+	for s, want := range map[string]bool{
+		`event.Status == "critical" && event.Node.startsWith("db")`:       true,
+		`event.Status == "critical" && event.Node.startsWith("web")`:      false,
+		`event.Status == "warning" || event.Node.endsWith("-3")`:          true,
+		`!(event.PrevStatus != "passing")`:                                true,
+		`"prod" in event.ServiceTags && !("canary" in event.ServiceTags)`: true,
+		`event.Meta["team"] == "storage" && event.Meta["owner"] == ""`:    true,
+		`"team" in event.Meta`:                              true,
+		`event.Reminder >= 2 && event.Reminder < 3`:         true,
+		`event.ServiceName.matches("^post(gres|gis)$")`:     true,
+		`event.Output.contains("timeout")`:                  false,
+		`event.External == false && event.Datacenter <= ""`: true,
+	} {
+		e, err := parseExpr(s)
+		if err != nil {
+			t.Errorf("parseExpr(%q) error: %v", s, err)
+			continue
+		}
+		if got := e.match(ev); got != want {
+			t.Errorf("%s = %t, want %t", s, got, want)
+		}
+	}
+
+	for _, s := range []string{
+		``, `event.Status`, `event.Status == 1`, `event.Host == "a"`, `event.Status == "critical" &&`,
+		`event.Node.startsWith(1)`, `event.Reminder.contains("1")`, `event.ServiceName.matches(event.Node)`,
+		`event.ServiceName.matches("(")`, `event.Meta[1] == ""`, `(event.External`, `"unterminated`, `event.Node # 1`,
+	} {
+		if _, err := parseExpr(s); err == nil {
+			t.Errorf("parseExpr(%q) expected to fail", s)
+		}
+	}
+
+	r, err := parseRule(`if event.Meta["team"] == "storage" && event.Status == "critical" -> channel=#storage`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.match(ev) || r.channel != "#storage" {
+		t.Errorf("rule %q doesn't route the event to #storage", r.spec)
+	}
+	f := filtersFlag{}
+	if err = f.Set(`opsgenie:expr=event.Node.startsWith("web")`); err != nil {
+		t.Fatal(err)
+	}
+	if f["opsgenie"].match(ev) {
+		t.Errorf("filter matches %s", ev.Node)
+	}
+	targets := []*target{{name: "slack", rules: []*rule{r}}, {name: "opsgenie", filter: f["opsgenie"]}}
+	if keys := ruleMetaKeys(targets); strings.Join(keys, ",") != "team" {
+		t.Errorf("ruleMetaKeys = %v, want [team]", keys)
+	}
+}
+
+func TestTagChannelFlag(t *testing.T) {
+	defer func(rules rulesFlag) {
+		routingRulesFlag = rules
+	}(routingRulesFlag)
+	routingRulesFlag = nil
+
+	var f tagChannelFlag
+	for _, s := range []string{"team-payments", "=#payments", "team-payments=", "team payments=#payments"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) expected to fail", s)
+		}
+	}
+	if err := f.Set("team-payments=#payments-alerts"); err != nil {
+		t.Fatal(err)
+	}
+	if len(routingRulesFlag) != 1 {
+		t.Fatalf("rules = %v, want one", routingRulesFlag)
+	}
+	r := routingRulesFlag[0]
+	if !r.match(&consul.Event{ServiceTags: []string{"prod", "team-payments"}}) || r.channel != "#payments-alerts" {
+		t.Errorf("rule %q doesn't route team-payments to #payments-alerts", r.spec)
+	}
+	if r.match(&consul.Event{ServiceTags: []string{"team-billing"}}) {
+		t.Errorf("rule %q matches team-billing", r.spec)
+	}
+}
+
+func TestOncall(t *testing.T) {
+	t.Parallel()
+
+	var (
+		lookups int
+		err     error
+	)
+	o := &oncall{ttl: time.Minute, lookup: func() (string, error) {
+		lookups++
+		return "<@U024BE7LH>", err
+	}}
+	var got *consul.Event
+	tg := &target{name: "slack", oncall: o, notifier: notifierFunc(func(ev *consul.Event) error {
+		got = ev
+		return nil
+	})}
+
+	for _, test := range []struct {
+		ev      *consul.Event
+		mention string
+	}{
+		{&consul.Event{ServiceName: "web", Status: consul.Critical}, "<@U024BE7LH>"},
+		{&consul.Event{ServiceName: "web", Status: consul.Critical, Mention: "@web"}, "@web"},
+		{&consul.Event{ServiceName: "web", Status: consul.Warning}, ""},
+		{&consul.Event{ServiceName: "web", Status: consul.Passing}, ""},
+	} {
+		if err := tg.Notify(test.ev); err != nil {
+			t.Fatal(err)
+		}
+		if got.Mention != test.mention {
+			t.Errorf("%s mention = %q, want %q", test.ev.Status, got.Mention, test.mention)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("lookups = %d, want 1", lookups)
+	}
+
+	// the last known mention is used while lookups fail
+	err = errors.New("schedule is unavailable")
+	if m := o.get(time.Now().Add(2 * time.Minute)); m != "<@U024BE7LH>" || lookups != 2 {
+		t.Errorf("get = %q after %d lookups, want the last known mention after 2", m, lookups)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	a := newAuditLog(&b)
+	fail := &target{name: "opsgenie", audit: a, notifier: notifierFunc(func(ev *consul.Event) error {
+		return errors.New("503 Service Unavailable")
+	})}
+	routed := &target{name: "slack", audit: a, notifier: notifierFunc(func(ev *consul.Event) error {
+		return nil
+	})}
+	r, err := parseRule("service=web -> channel=#web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routed.rules = []*rule{r}
+	routed.channels = map[string]notifier{"#web": routed.notifier}
+
+	ev := &consul.Event{ServiceID: "web", ServiceName: "web", Status: consul.Critical}
+	if err := fail.Notify(ev); err == nil {
+		t.Fatal("Notify expected to fail")
+	}
+	if err := routed.Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []*auditEntry
+	dec := json.NewDecoder(&b)
+	for dec.More() {
+		var e auditEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, &e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit log has %d entries, want 2", len(entries))
+	}
+	if e := entries[0]; e.Target != "opsgenie" || e.Error != "503 Service Unavailable" || e.Event.ServiceID != "web" || e.Time.IsZero() {
+		t.Errorf("entry = %+v, want a failed opsgenie delivery", e)
+	}
+	if e := entries[1]; e.Target != "slack" || e.Channel != "#web" || e.Error != "" {
+		t.Errorf("entry = %+v, want a slack delivery to #web", e)
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "consul-slack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "consul-slack.log")
+	l, err := openLogFile(name, 10, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	// rotated by size twice, the oldest backup is removed
+	for _, s := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := l.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for f, want := range map[string]string{
+		name:        "fourth\n",
+		name + ".1": "third\n",
+		name + ".2": "second\n",
+	} {
+		if b, err := ioutil.ReadFile(f); err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(f), b, err, want)
+		}
+	}
+	if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want at most 2 backups", filepath.Base(name))
+	}
+
+	// rotated by age
+	now = now.Add(time.Hour)
+	if _, err := l.Write([]byte("fifth\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(name + ".1"); err != nil || string(b) != "fourth\n" {
+		t.Errorf("%s.1 = %q, %v, want %q", filepath.Base(name), b, err, "fourth\n")
+	}
+}
+
+func TestProfileTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-slack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "team-a.conf")
+	if err = ioutil.WriteFile(path, []byte(`
+webhook-url = http://team-a.example.com
+filter = webhook:statuses=critical
+rule = "service=db -> suppress"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&webhookURLFlag, "webhook-url", "", "")
+	fs.Var(notifierFiltersFlag, "filter", "")
+	fs.Var(&routingRulesFlag, "rule", "")
+	if err = fs.Parse([]string{"-webhook-url", "http://platform.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		webhookURLFlag = ""
+	}()
+
+	targets, err := profileTargets(fs, profile{name: "team-a", path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0].label() != "team-a/webhook" {
+		t.Fatalf("targets = %v, want team-a/webhook", targets)
+	}
+	if targets[0].filter == nil || len(targets[0].rules) != 1 {
+		t.Errorf("profile filter or rule is not applied")
+	}
+	if webhookURLFlag != "http://platform.example.com" {
+		t.Errorf("webhook-url = %q, not restored", webhookURLFlag)
+	}
+	if len(notifierFiltersFlag) != 0 || len(routingRulesFlag) != 0 {
+		t.Errorf("profile filters or rules leaked to the command-line ones")
+	}
+
+	var p profilesFlag
+	for _, s := range []string{"team-a", "=a.conf", "team-a="} {
+		if err = p.Set(s); err == nil {
+			t.Errorf("Set(%q) expected to fail", s)
+		}
+	}
+	if err = p.Set("team-a=a.conf"); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Set("team-a=b.conf"); err == nil {
+		t.Error("duplicate profile expected to fail")
+	}
+}
+
+func TestWatchProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-slack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "staging.conf")
+	if err = ioutil.WriteFile(path, []byte(`
+webhook-url = http://staging.example.com
+watch-services = staging-.*
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&webhookURLFlag, "webhook-url", "", "")
+	fs.StringVar(&watchServicesFlag, "watch-services", "", "")
+	fs.StringVar(&consulAddressFlag, "consul-address", "", "")
+	if err = fs.Parse([]string{"-watch-services", "web", "-consul-address", "127.0.0.1:8501"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		webhookURLFlag, watchServicesFlag, consulAddressFlag = "", "", ""
+	}()
+
+	p := profile{name: "staging", path: path}
+	targets, err := watchTargets(fs, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0].label() != "staging/webhook" {
+		t.Fatalf("targets = %v, want staging/webhook", targets)
+	}
+
+	var got []string
+	targets[0].notifier = notifierFunc(func(ev *consul.Event) error {
+		got = append(got, ev.Cluster+":"+ev.CheckID)
+		return nil
+	})
+	for _, ev := range []*consul.Event{
+		{Cluster: "", CheckID: "web", Status: consul.Critical},
+		{Cluster: "staging", CheckID: "staging-api", Status: consul.Critical},
+	} {
+		if err = targets[0].Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"staging:staging-api"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered = %v, want %v", got, want)
+	}
+
+	watchProfilesFlag = profilesFlag{p}
+	defer func() {
+		watchProfilesFlag = nil
+	}()
+	list, err := watchClusters(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].name != "" || list[0].opts != nil || list[1].name != "staging" || list[1].opts == nil {
+		t.Errorf("watchClusters = %v, want the default watch and staging", list)
+	}
+	if watchServicesFlag != "web" || consulAddressFlag != "127.0.0.1:8501" {
+		t.Errorf("watch-services = %q, consul-address = %q, not restored", watchServicesFlag, consulAddressFlag)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("consul-address = 127.0.0.1:8502\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = watchClusters(fs, nil); err == nil {
+		t.Error("shared flags set in a watch profile expected to fail")
+	}
+}
+
+type overflowRecorder struct {
+	notifierFunc
+	n int
+}
+
+func (r *overflowRecorder) Overflow(n int) error {
+	r.n = n
+	return nil
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	sent := 0
+	r := &overflowRecorder{notifierFunc: func(ev *consul.Event) error {
+		sent++
+		return nil
+	}}
+	targets := []*target{{name: "slack", notifier: r, limiter: newLimiter(2)}}
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := targets[0].Notify(&consul.Event{ServiceName: "web", Status: consul.Critical}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := targets[0].Notify(&consul.Event{Status: consul.LockLost}); err != nil {
+		t.Fatal(err)
+	}
+	if sent != 3 {
+		t.Errorf("sent = %d, want 3", sent)
+	}
+
+	sendOverflow(targets, now.Add(30*time.Second))
+	if r.n != 0 {
+		t.Fatalf("overflow reported before the minute is over")
+	}
+	sendOverflow(targets, now.Add(time.Minute+time.Second))
+	if r.n != 3 {
+		t.Errorf("overflow = %d, want 3", r.n)
+	}
+
+	// the report takes one slot of the new minute
+	l := targets[0].limiter
+	if !l.allow(now.Add(time.Minute+2*time.Second)) || l.allow(now.Add(time.Minute+3*time.Second)) {
+		t.Errorf("limit isn't reset after the overflow report")
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	targets := []*target{{name: "slack", cooldown: newCooldown(10 * time.Minute), notifier: notifierFunc(func(ev *consul.Event) error {
+		got = append(got, ev.ID+" "+ev.Status)
+		return nil
+	})}}
+	for _, ev := range []*consul.Event{
+		{ID: "web", Status: consul.Critical},
+		{ID: "web", Status: consul.Passing},
+		{ID: "db", Status: consul.Critical},
+		{ID: "web", Status: consul.Critical, Reminder: 1},
+		{ID: "web", Status: consul.Warning},
+		{ID: "db", Status: consul.Passing},
+		{ID: "db", Status: consul.Critical},
+	} {
+		if err := targets[0].Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "web critical,db critical,web critical"; strings.Join(got, ",") != want {
+		t.Fatalf("notifications = %s, want %s", strings.Join(got, ","), want)
+	}
+
+	// db is back critical, web is sent in the latest status
+	got = nil
+	now := time.Now()
+	sendCooldowns(targets, now.Add(5*time.Minute))
+	sendCooldowns(targets, now.Add(11*time.Minute))
+	if want := "web warning"; strings.Join(got, ",") != want {
+		t.Errorf("released = %s, want %s", strings.Join(got, ","), want)
+	}
+	if !targets[0].cooldown.hold(&consul.Event{ID: "web", Status: consul.Passing}, now.Add(5*time.Minute)) {
+		t.Error("released event doesn't start a new cooldown")
+	}
+
+	// a failed delivery doesn't start the cooldown, the next change is sent right away
+	got = nil
+	fail := true
+	targets[0].notifier = notifierFunc(func(ev *consul.Event) error {
+		if fail {
+			return errors.New("unavailable")
+		}
+		got = append(got, ev.ID+" "+ev.Status)
+		return nil
+	})
+	if err := targets[0].Notify(&consul.Event{ID: "api", Status: consul.Critical}); err == nil {
+		t.Fatal("expected a delivery error")
+	}
+	fail = false
+	if err := targets[0].Notify(&consul.Event{ID: "api", Status: consul.Warning}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "api warning"; strings.Join(got, ",") != want {
+		t.Errorf("notifications = %s, want %s after the failed delivery", strings.Join(got, ","), want)
+	}
+}
+
+func TestRecoveries(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newRecovery("some"); err == nil {
+		t.Error("newRecovery expected to fail")
+	}
+	evs := []*consul.Event{
+		{ID: "web", Status: consul.Warning, PrevStatus: consul.Passing},
+		{ID: "web", Status: consul.Critical, PrevStatus: consul.Warning}, // filtered out
+		{ID: "db", Status: consul.Critical, PrevStatus: consul.Passing},  // filtered out
+		{ID: "web", Status: consul.Passing, PrevStatus: consul.Critical},
+		{ID: "db", Status: consul.Passing, PrevStatus: consul.Critical},
+		{ID: "api", Status: consul.Passing, PrevStatus: consul.Critical}, // failed before a restart
+	}
+	for mode, want := range map[string]string{
+		recoveriesNotified: "web warning,web passing,api passing",
+		recoveriesNone:     "web warning",
+	} {
+		r, err := newRecovery(mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		tg := &target{name: "slack", recovery: r, filter: &filter{statuses: map[string]bool{
+			consul.Warning: true, consul.Passing: true,
+		}}, notifier: notifierFunc(func(ev *consul.Event) error {
+			got = append(got, ev.ID+" "+ev.Status)
+			return nil
+		})}
+		for _, ev := range evs {
+			if err = tg.Notify(ev); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s: notifications = %s, want %s", mode, strings.Join(got, ","), want)
+		}
+	}
+}
+
+type beaterFunc func() error
+
+func (f beaterFunc) Active() bool {
+	return true
+}
+
+func (f beaterFunc) Beat() error {
+	return f()
+}
+
+func TestBeat(t *testing.T) {
+	t.Parallel()
+
+	pings := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		select {
+		case pings <- struct{}{}:
+		default:
+		}
+	}))
+	defer ts.Close()
+
+	beats := make(chan struct{}, 1)
+	c := beaterFunc(func() error {
+		select {
+		case beats <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go beat(c, nil, "", ts.URL, 10*time.Millisecond, done)
+
+	for _, ch := range []chan struct{}{beats, pings} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("no heartbeat")
+		}
+	}
+	if err := ping(ts.URL + "/fail"); err == nil {
+		t.Error("ping of a failing url expected to fail")
+	}
+}
+
+func TestClusters(t *testing.T) {
+	t.Parallel()
+
+	var f clustersFlag
+	for _, s := range []string{"prod", "=127.0.0.1:8500", "prod="} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) expected to fail", s)
+		}
+	}
+	for _, name := range []string{"prod", "staging"} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/status/leader":
+				w.Write([]byte(`"10.0.0.1:8300"`))
+			case "/v1/health/state/any":
+				w.Write([]byte(`[{"Node":"n1","CheckID":"service:web","Status":"critical","ServiceID":"web","ServiceName":"web"}]`))
+			default:
+				t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			}
+		}))
+		defer ts.Close()
+		if err := f.Set(name + "=" + ts.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Set("prod=127.0.0.1:8500"); err == nil {
+		t.Error("duplicate cluster expected to fail")
+	}
+
+	cs, err := newClusters(f, []consul.Option{consul.WithDatacenter("dc1"), consul.WithLogger(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	evs, err := cs.Failing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range evs {
+		got = append(got, ev.Location())
+	}
+	if s := strings.Join(got, ","); s != "prod/dc1/n1,staging/dc1/n1" {
+		t.Errorf("failing checks are at %q, want prod/dc1/n1,staging/dc1/n1", s)
+	}
+}
+
+type alertListerStub struct {
+	failing  []*consul.Event
+	silences map[string]time.Time
+	holder   *consul.Holder
+	active   bool
+}
+
+func (s *alertListerStub) Active() bool {
+	return s.active
+}
+
+func (s *alertListerStub) Holder() (*consul.Holder, error) {
+	return s.holder, nil
+}
+
+func (s *alertListerStub) Failing() ([]*consul.Event, error) {
+	return s.failing, nil
+}
+
+func (s *alertListerStub) Silences() (map[string]time.Time, error) {
+	return s.silences, nil
+}
+
+type sourceCounterStub struct {
+	noise   map[string]uint64
+	dropped uint64
+}
+
+func (s *sourceCounterStub) NoiseSuppressed() map[string]uint64 {
+	return s.noise
+}
+
+func (s *sourceCounterStub) EventsDropped() uint64 {
+	return s.dropped
+}
+
+func TestPprof(t *testing.T) {
+	t.Parallel()
+
+	m := http.NewServeMux()
+	handlePprof(m)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/heap"} {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	t.Parallel()
+
+	c := newCounters()
+	c.inc("notifications", "notifier", "slack", "result", "ok")
+	c.inc("notifications", "notifier", "slack", "result", "ok")
+	c.inc("notifications", "notifier", "opsgenie", "result", "error")
+	c.inc("events", "status", consul.Critical)
+
+	w := httptest.NewRecorder()
+	metricsHandler(&sourceCounterStub{noise: map[string]uint64{`"quoted"`: 2, "i/o timeout": 17}, dropped: 3}, c).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, s := range []string{
+		"# TYPE consul_slack_noise_suppressed_total counter\n",
+		`consul_slack_noise_suppressed_total{pattern="\"quoted\""} 2` + "\n" +
+			`consul_slack_noise_suppressed_total{pattern="i/o timeout"} 17` + "\n",
+		"# TYPE consul_slack_events_total counter\n" + `consul_slack_events_total{status="critical"} 1` + "\n",
+		"# TYPE consul_slack_events_dropped_total counter\nconsul_slack_events_dropped_total 3\n",
+		`consul_slack_notifications_total{notifier="opsgenie",result="error"} 1` + "\n" +
+			`consul_slack_notifications_total{notifier="slack",result="ok"} 2` + "\n",
+	} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("metrics %q expected to include %q", w.Body.String(), s)
+		}
+	}
+}
+
+func TestStatsd(t *testing.T) {
+	t.Parallel()
+
+	c := newCounters()
+	c.inc("events", "status", consul.Critical)
+	c.inc("notifications", "notifier", "team-a/slack", "result", "ok")
+	n := &sourceCounterStub{noise: map[string]uint64{"i/o timeout": 3}}
+
+	var b bytes.Buffer
+	s := &statsd{w: &b, prefix: "consul_slack", last: map[string]uint64{}}
+	if err := s.flush(c.snapshot(n)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "consul_slack.events.critical:1|c\n" +
+		"consul_slack.noise_suppressed.i/o_timeout:3|c\n" +
+		"consul_slack.notifications.team-a/slack.ok:1|c"; b.String() != want {
+		t.Errorf("statsd = %q, want %q", b.String(), want)
+	}
+
+	// only increments are sent
+	b.Reset()
+	c.inc("events", "status", consul.Critical)
+	c.inc("events", "status", consul.Critical)
+	if err := s.flush(c.snapshot(n)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "consul_slack.events.critical:2|c"; b.String() != want {
+		t.Errorf("statsd = %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	s = &statsd{w: &b, prefix: "cs", dogstatsd: true, tags: []string{"env:prod"}, last: map[string]uint64{}}
+	if err := s.flush(c.snapshot(n)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "cs.events:3|c|#env:prod,status:critical\n" +
+		"cs.noise_suppressed:3|c|#env:prod,pattern:i/o_timeout\n" +
+		"cs.notifications:1|c|#env:prod,notifier:team-a/slack,result:ok"; b.String() != want {
+		t.Errorf("dogstatsd = %q, want %q", b.String(), want)
+	}
+
+	if _, err := newStatsd("127.0.0.1:8125", "cs", false, []string{"env:prod"}); err == nil {
+		t.Error("newStatsd with tags in the plain format expected to fail")
+	}
+}
+
+func TestAPI(t *testing.T) {
+	t.Parallel()
+
+	web := &consul.Event{ID: "n1:web", Datacenter: "dc1", Node: "n1", ServiceID: "web", ServiceName: "web", Status: consul.Critical}
+	db := &consul.Event{ID: "n1:db", Datacenter: "dc1", Node: "n1", ServiceID: "db", ServiceName: "db", Status: consul.Warning}
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	l := &alertListerStub{failing: []*consul.Event{web, db}, silences: map[string]time.Time{"db": until}}
+
+	a := newAlerts()
+	started := time.Now().Add(-time.Hour)
+	a.track(web, started)
+	a.track(&consul.Event{ID: "n1:web", Datacenter: "dc1", Status: consul.Warning}, time.Now())
+	a.track(&consul.Event{ID: "n1:db", Datacenter: "dc1", Status: consul.Passing}, time.Now())
+
+	w := httptest.NewRecorder()
+	alertsHandler(l, a).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/alerts", nil))
+	var alerts []*apiAlert
+	if err := json.NewDecoder(w.Body).Decode(&alerts); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 200 || len(alerts) != 2 {
+		t.Fatalf("code = %d, alerts = %d, want 200 and 2", w.Code, len(alerts))
+	}
+	if alerts[0].Location != "dc1/n1" || alerts[0].Since == nil || !alerts[0].Since.Equal(started) ||
+		!strings.HasPrefix(alerts[0].Duration, "1h0m") || alerts[0].Silenced {
+		t.Errorf("web alert = %+v, want one failing for an hour", alerts[0])
+	}
+	if alerts[1].Since != nil || !alerts[1].Silenced || !alerts[1].SilencedUntil.Equal(until) {
+		t.Errorf("db alert = %+v, want a silenced one with unknown duration", alerts[1])
+	}
+
+	w = httptest.NewRecorder()
+	silencesHandler(l).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/silences", nil))
+	if want := `[{"service":"db","until":"` + until.Format(time.RFC3339) + `"}]` + "\n"; w.Body.String() != want {
+		t.Errorf("silences = %s, want %s", w.Body, want)
+	}
+
+	w = httptest.NewRecorder()
+	silencesHandler(l).ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/silences", nil))
+	if w.Code != 405 {
+		t.Errorf("POST code = %d, want 405", w.Code)
+	}
+}
+
+type homeRecorder chan string
+
+func (r homeRecorder) PublishHome(user, text string) error {
+	r <- user + ": " + text
+	return nil
+}
+
+func TestHome(t *testing.T) {
+	t.Parallel()
+
+	rec := make(homeRecorder, 10)
+	h := newHome(rec, []string{"U1"}, "secret")
+	post := func(body, secret string) *httptest.ResponseRecorder {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		m := hmac.New(sha256.New, []byte(secret))
+		m.Write([]byte("v0:" + ts + ":" + body))
+		r := httptest.NewRequest("POST", "/slack/events", strings.NewReader(body))
+		r.Header.Set("X-Slack-Request-Timestamp", ts)
+		r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(m.Sum(nil)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := post(`{"type":"url_verification","challenge":"abc"}`, "secret"); w.Code != 200 || !strings.Contains(w.Body.String(), `"challenge":"abc"`) {
+		t.Errorf("url verification = %d %s, want the challenge", w.Code, w.Body)
+	}
+	if w := post(`{"type":"event_callback","event":{"type":"app_home_opened","user":"U3","tab":"home"}}`, "other"); w.Code != 401 {
+		t.Errorf("bad signature code = %d, want 401", w.Code)
+	}
+	if w := post(`{"type":"event_callback","event":{"type":"app_home_opened","user":"U2","tab":"home"}}`, "secret"); w.Code != 200 {
+		t.Errorf("app_home_opened code = %d, want 200", w.Code)
+	}
+
+	web := &consul.Event{ID: "n1:web", Datacenter: "dc1", Node: "n1", ServiceName: "web", Name: "<http>", Status: consul.Critical}
+	a := newAlerts()
+	a.track(web, time.Now().Add(-time.Hour))
+	done := make(chan struct{})
+	defer close(done)
+	go publishHome(h, &alertListerStub{failing: []*consul.Event{web}, active: true}, a, done)
+	for _, u := range []string{"U1", "U2"} {
+		select {
+		case got := <-rec:
+			want := u + ": :red_circle: *1 checks are failing*\n• critical `dc1/n1` web &lt;http&gt; for 1h0m"
+			if !strings.HasPrefix(got, want) {
+				t.Errorf("published %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("home of %s isn't published", u)
+		}
+	}
+
+	if got := homeText(nil, time.Now()); !strings.HasPrefix(got, ":white_check_mark: *All checks are passing*\n") {
+		t.Errorf("homeText = %q, want all passing", got)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	t.Parallel()
+
+	web := &consul.Event{ID: "n1:web", Datacenter: "dc1", Node: "n1", ServiceName: "web", Name: "http",
+		Status: consul.Critical, Output: "<timeout>"}
+	l := &alertListerStub{
+		failing:  []*consul.Event{web},
+		silences: map[string]time.Time{"db": time.Now().Add(time.Hour)},
+		holder:   &consul.Holder{Host: "host-a", Since: time.Now()},
+	}
+	a := newAlerts()
+	a.track(web, time.Now().Add(-time.Hour))
+
+	w := httptest.NewRecorder()
+	dashboardHandler(l, a).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("code = %d, content type = %q, want 200 and html", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, s := range []string{"standby", "held by host-a", "dc1/n1", "1h0m", "&lt;timeout&gt;", "<td>db</td>"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("dashboard doesn't contain %q:\n%s", s, w.Body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	dashboardHandler(l, a).ServeHTTP(w, httptest.NewRequest("GET", "/favicon.ico", nil))
+	if w.Code != 404 {
+		t.Errorf("/favicon.ico code = %d, want 404", w.Code)
+	}
+}
+
+// groupRecorder is a notifier recording messages it's sent, a group is one message.
+type groupRecorder struct {
+	msgs [][]string
+}
+
+func (r *groupRecorder) Notify(ev *consul.Event) error {
+	r.msgs = append(r.msgs, []string{ev.ServiceID})
+	return nil
+}
+
+func (r *groupRecorder) NotifyGroup(evs []*consul.Event) error {
+	var msg []string
+	for _, ev := range evs {
+		msg = append(msg, ev.ServiceID)
+	}
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func TestTarget_NotifyGroup(t *testing.T) {
+	t.Parallel()
+
+	group := &consul.Event{Node: "n1", ServiceID: "web", ServiceName: "web", Status: consul.Critical, Grouped: []*consul.Event{
+		{Node: "n1", ServiceID: "db", ServiceName: "db", Status: consul.Critical},
+		{Node: "n1", ServiceID: "api", ServiceName: "api", Status: consul.Warning},
+		{Node: "n1", ServiceID: "cache", ServiceName: "cache", Status: consul.Warning},
+	}}
+
+	r, err := parseRule("service=cache -> channel=#cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := &groupRecorder{}
+	flt := &filter{services: regexp.MustCompile("^(web|db|cache)$")}
+	tg := &target{name: "slack", notifier: chat, filter: flt, rules: []*rule{r},
+		channels: map[string]notifier{"#cache": chat}}
+	if err := tg.Notify(group); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"web", "db"}, {"cache"}}; !reflect.DeepEqual(chat.msgs, want) {
+		t.Errorf("chat messages = %v, want %v", chat.msgs, want)
+	}
+
+	var got []string
+	pager := &target{name: "opsgenie", notifier: notifierFunc(func(ev *consul.Event) error {
+		got = append(got, ev.ServiceID)
+		return nil
+	})}
+	if err := pager.Notify(group); err != nil {
+		t.Fatal(err)
+	}
+	if want := "web db api cache"; strings.Join(got, " ") != want {
+		t.Errorf("opsgenie notifications = %v, want %s", got, want)
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	t.Parallel()
+
+	var got []*consul.Event
+	var fail bool
+	r := newReceiver([]*target{{name: "slack", notifier: notifierFunc(func(ev *consul.Event) error {
+		if fail {
+			return errors.New("slack is down")
+		}
+		got = append(got, ev)
+		return nil
+	})}}, "secret")
+
+	post := func(body string, signed bool) int {
+		req := httptest.NewRequest("POST", "/api/v1/events", strings.NewReader(body))
+		if signed {
+			req.Header.Set(webhook.SignatureHeader, webhook.Sign([]byte("secret"), []byte(body)))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	critical := `{"name":"backup","status":"critical","source":"cron","output":"disk full"}`
+	passing := `{"name":"backup","status":"passing","source":"cron"}`
+	if code := post(critical, false); code != 401 {
+		t.Errorf("unsigned code = %d, want 401", code)
+	}
+	if code := post(`{"name":"backup","status":"down"}`, true); code != 400 {
+		t.Errorf("bad status code = %d, want 400", code)
+	}
+
+	fail = true
+	if code := post(critical, true); code != 502 {
+		t.Errorf("undelivered code = %d, want 502", code)
+	}
+	fail = false
+	for _, body := range []string{critical, critical, passing} {
+		if code := post(body, true); code != 200 {
+			t.Errorf("code = %d, want 200", code)
+		}
+	}
+	if len(got) != 2 || got[0].Status != consul.Critical || got[0].PrevStatus != "" ||
+		got[0].Node != "cron" || got[0].CheckID != "backup" || got[0].Output != "disk full" ||
+		got[1].Status != consul.Passing || got[1].PrevStatus != consul.Critical {
+		t.Errorf("delivered = %v, want the retried critical alert and its recovery", got)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	defer func() {
+		timestampsFlag, timezoneFlag, slackDateTokensFlag = false, "", false
+	}()
+
+	plain, slack, err := timestamps()
+	if err != nil || plain != nil || slack != nil {
+		t.Fatalf("timestamps are expected to be disabled by default")
+	}
+
+	timestampsFlag, timezoneFlag, slackDateTokensFlag = true, "UTC", true
+	if plain, slack, err = timestamps(); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2017, 9, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	if got, want := plain(ts), "2017-09-01 10:00:00 UTC"; got != want {
+		t.Errorf("plain = %q, want %q", got, want)
+	}
+	if got, want := slack(ts), "<!date^1504260000^{date_short_pretty} {time_secs}|2017-09-01 10:00:00 UTC>"; got != want {
+		t.Errorf("slack = %q, want %q", got, want)
+	}
+
+	timezoneFlag = "Nowhere/Atlantis"
+	if _, _, err = timestamps(); err == nil {
+		t.Error("unknown timezone expected to fail")
+	}
+}
+
+func TestOwners(t *testing.T) {
+	t.Parallel()
+
+	var lookups []string
+	o := &owners{key: "owner", lookup: func(email string) (string, error) {
+		lookups = append(lookups, email)
+		switch email {
+		case "jane@corp.com":
+			return "U024BE7LH", nil
+		case "bob@corp.com":
+			return "U0G9QF9C6", nil
+		case "broken@corp.com":
+			return "", errors.New("slack is down")
+		}
+		return "", nil
+	}}
+
+	for _, test := range []struct {
+		ev      *consul.Event
+		mention string
+	}{
+		{&consul.Event{Status: consul.Critical, Meta: map[string]string{"owner": "jane@corp.com, bob@corp.com"}}, "<@U024BE7LH> <@U0G9QF9C6>"},
+		{&consul.Event{Status: consul.Critical, Meta: map[string]string{"owner": "nobody@corp.com,jane@corp.com"}}, "<@U024BE7LH>"},
+		{&consul.Event{Status: consul.Critical, Meta: map[string]string{"owner": "broken@corp.com"}}, ""},
+		{&consul.Event{Status: consul.Critical, Meta: map[string]string{"owner": "jane@corp.com"}, Mention: "@web"}, "@web"},
+		{&consul.Event{Status: consul.Warning, Meta: map[string]string{"owner": "jane@corp.com"}}, ""},
+		{&consul.Event{Status: consul.Critical}, ""},
+	} {
+		if got := o.mention(test.ev).Mention; got != test.mention {
+			t.Errorf("mention(%v) = %q, want %q", test.ev.Meta, got, test.mention)
+		}
+	}
+	if len(lookups) != 5 {
+		t.Errorf("lookups = %v, want 5 of them", lookups)
+	}
+
+	if keys := ruleMetaKeys([]*target{{name: "slack", owners: o}}); strings.Join(keys, ",") != "owner" {
+		t.Errorf("ruleMetaKeys = %v, want [owner]", keys)
+	}
+}
+
+func TestNextDaily(t *testing.T) {
+	t.Parallel()
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := 9*time.Hour + 30*time.Minute
+	for _, test := range []struct {
+		now, want time.Time
+	}{
+		{time.Date(2017, 5, 1, 8, 0, 0, 0, berlin), time.Date(2017, 5, 1, 9, 30, 0, 0, berlin)},
+		{time.Date(2017, 5, 1, 9, 30, 0, 0, berlin), time.Date(2017, 5, 2, 9, 30, 0, 0, berlin)},
+		{time.Date(2017, 12, 31, 23, 0, 0, 0, berlin), time.Date(2018, 1, 1, 9, 30, 0, 0, berlin)},
+		{time.Date(2017, 3, 25, 12, 0, 0, 0, berlin), time.Date(2017, 3, 26, 9, 30, 0, 0, berlin)}, // DST starts
+	} {
+		if got := nextDaily(test.now, at); !got.Equal(test.want) {
+			t.Errorf("nextDaily(%s) = %s, want %s", test.now, got, test.want)
+		}
+	}
+}
+
+// weeklyRecorder is a notifier recording weekly reports it's sent.
+type weeklyRecorder struct {
+	notifierFunc
+	channel string
+	stats   []*consul.Stats
+}
+
+func (r *weeklyRecorder) WeeklyReport(s *consul.Stats) error {
+	r.stats = append(r.stats, s)
+	return nil
+}
+
+func TestWeeklyReport(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]string{
+		"mon 09:00": "",
+		"monday":    `malformed "monday", want DAY HH:MM`,
+		"xyz 09:00": `unknown day "xyz"`,
+		"sun 9am":   `malformed time "9am", want HH:MM`,
+	} {
+		day, at, err := parseWeekly(s)
+		if err != nil && err.Error() != want || err == nil && (want != "" || day != time.Monday || at != 9*time.Hour) {
+			t.Errorf("parseWeekly(%q) = %s, %s, %v, want %q", s, day, at, err, want)
+		}
+	}
+
+	for now, want := range map[time.Time]time.Time{
+		time.Date(2017, 5, 1, 8, 0, 0, 0, time.UTC):  time.Date(2017, 5, 1, 9, 0, 0, 0, time.UTC), // monday
+		time.Date(2017, 5, 1, 9, 0, 0, 0, time.UTC):  time.Date(2017, 5, 8, 9, 0, 0, 0, time.UTC),
+		time.Date(2017, 5, 4, 12, 0, 0, 0, time.UTC): time.Date(2017, 5, 8, 9, 0, 0, 0, time.UTC),
+	} {
+		if got := nextWeekly(now, time.Monday, 9*time.Hour); !got.Equal(want) {
+			t.Errorf("nextWeekly(%s) = %s, want %s", now, got, want)
+		}
+	}
+
+	f, err := ioutil.TempFile("", "consul-slack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	now := time.Date(2017, 5, 8, 9, 0, 0, 0, time.UTC)
+	enc := json.NewEncoder(f)
+	for _, ev := range []*consul.Event{
+		{ID: "n1:web", ServiceID: "web", ServiceName: "web", Status: consul.Critical, PrevStatus: consul.Passing, Time: now.AddDate(0, 0, -8)},
+		{ID: "n1:web", ServiceID: "web", ServiceName: "web", Status: consul.Passing, PrevStatus: consul.Critical, Time: now.AddDate(0, 0, -6)},
+		{ID: "n1:db", ServiceID: "db", ServiceName: "db", Status: consul.Critical, PrevStatus: consul.Passing, Time: now.Add(-2 * time.Hour)},
+		{ID: "n1:db", ServiceID: "db", ServiceName: "db", Status: consul.Passing, PrevStatus: consul.Critical, Time: now.Add(-time.Hour)},
+	} {
+		if err = enc.Encode(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+	st, err := weeklyStats(f.Name(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Incidents != 2 || len(st.Services) != 2 || st.Services[0].Service != "web" || st.Services[0].Downtime != 24*time.Hour {
+		t.Errorf("stats = %+v, want web down for a day since the beginning of the week and db", st)
+	}
+
+	slack := &weeklyRecorder{channel: "#consul"}
+	targets, err := toChannel([]*target{
+		{name: "slack", notifier: slack, inChannel: func(channel string) (notifier, error) {
+			return &weeklyRecorder{channel: channel}, nil
+		}},
+		{name: "telegram", notifier: &weeklyRecorder{}},
+	}, "#reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := targets[0].notifier.(*weeklyRecorder); !ok || r.channel != "#reports" || targets[1].name != "telegram" {
+		t.Errorf("toChannel = %+v, want slack posting to #reports and telegram", targets)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	var traceparent string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer hook.Close()
+
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+	}
+	var spans []span
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	tr, err := tracing.New(collector.URL, tracing.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := webhook.New(hook.URL, webhook.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := tr.Start(tracing.SpanContext{}, "diff")
+	ev := &consul.Event{ID: "n1:web", Status: consul.Critical, Trace: diff.Context()}
+	if err = (&target{name: "webhook", notifier: w, tracer: tr}).Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+	diff.End()
+	if err = tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// diff → route → notify → HTTP POST
+	if len(spans) != 4 {
+		t.Fatalf("len(spans) = %d, want 4", len(spans))
+	}
+	byName := map[string]span{}
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	parent := byName["diff"]
+	for _, name := range []string{"route", "notify", "HTTP POST"} {
+		s := byName[name]
+		if s.TraceID != parent.TraceID || s.ParentSpanID != parent.SpanID {
+			t.Errorf("%s span = %+v, want a child of %+v", name, s, parent)
+		}
+		parent = s
+	}
+	if want := "00-" + parent.TraceID + "-" + parent.SpanID + "-01"; traceparent != want {
+		t.Errorf("traceparent = %q, want %q", traceparent, want)
+	}
+	if ev.Trace != diff.Context() {
+		t.Errorf("routing changed the trace of the event")
+	}
+}
+
+// kvMap is a kvSetter keeping values in memory.
+type kvMap map[string]string
+
+func (m kvMap) SetValue(key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func TestLedger(t *testing.T) {
+	t.Parallel()
+
+	var sent []string
+	newTarget := func(kv kvMap) *target {
+		return &target{name: "slack", ledger: newLedger(kv, time.Hour), notifier: notifierFunc(func(ev *consul.Event) error {
+			sent = append(sent, ev.PrevStatus+"->"+ev.Status)
+			return nil
+		})}
+	}
+	warning := &consul.Event{ID: "n1:web", Incident: "i1", PrevStatus: consul.Passing, Status: consul.Warning}
+	critical := &consul.Event{ID: "n1:web", Incident: "i1", PrevStatus: consul.Warning, Status: consul.Critical}
+
+	kv := kvMap{}
+	active := newTarget(kv)
+	for _, ev := range []*consul.Event{warning, critical} {
+		if err := active.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the instance taking over sees the last change again
+	standby := newTarget(kvMap{})
+	values := map[string]string{}
+	for k, v := range kv {
+		values[strings.TrimPrefix(k, ledgerPrefix+"/")] = v
+	}
+	standby.ledger.update(values)
+	standby.limiter = newLimiter(3)
+	standby.cooldown = newCooldown(time.Nanosecond)
+	if err := standby.Notify(critical); err != nil {
+		t.Fatal(err)
+	}
+	if standby.limiter.sent != 0 {
+		t.Errorf("replayed notification counted by the rate limit")
+	}
+	if len(standby.cooldown.checks) != 0 {
+		t.Errorf("replayed notification starts a cooldown")
+	}
+
+	// flapping within the incident is still reported
+	for _, ev := range []*consul.Event{
+		{ID: "n1:web", Incident: "i1", PrevStatus: consul.Critical, Status: consul.Warning},
+		critical,
+		{ID: "n1:db", Status: consul.Added},
+	} {
+		if err := standby.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "passing->warning,warning->critical,critical->warning,warning->critical,->added"
+	if got := strings.Join(sent, ","); got != want {
+		t.Errorf("sent = %s, want %s", got, want)
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	n := ndjson.New(&b)
+	now := time.Now()
+	for i, id := range []string{"web", "db", "api"} {
+		ev := &consul.Event{ServiceID: id, Status: consul.Critical, Time: now.Add(time.Duration(i-2) * time.Hour)}
+		if err := n.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evs, err := readHistory(&b, now.Add(-90*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 || evs[0].ServiceID != "db" || evs[1].ServiceID != "api" {
+		t.Errorf("readHistory = %v, want db and api", evs)
+	}
+
+	if _, err = readHistory(strings.NewReader("{}\nnot json\n"), now); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("readHistory error = %v, want one on line 2", err)
+	}
+}
+
+func TestMetaPolicy(t *testing.T) {
+	t.Parallel()
+
+	if _, err := parsePolicy(map[string]string{policyMinSeverityKey: "info", policyRemindersKey: "soon"}); err == nil {
+		t.Error("parsePolicy expected to fail")
+	}
+
+	var got []string
+	record := func(channel string) notifier {
+		return notifierFunc(func(ev *consul.Event) error {
+			if _, ok := ev.Meta[policyChannelKey]; ok {
+				t.Errorf("%s: policy fields expected to be hidden, meta = %v", ev.ServiceName, ev.Meta)
+			}
+			got = append(got, channel+" "+ev.ServiceName+" "+ev.Status)
+			return nil
+		})
+	}
+	tg := &target{name: "slack", notifier: record("#consul"), policies: newPolicyChannels(), inChannel: func(channel string) (notifier, error) {
+		return record(channel), nil
+	}}
+	meta := map[string]string{policyChannelKey: "#payments", policyMinSeverityKey: consul.Critical, "owner": "jane"}
+	for _, ev := range []*consul.Event{
+		{ServiceName: "pay", Status: consul.Warning, PrevStatus: consul.Passing, Meta: meta},
+		{ServiceName: "pay", Status: consul.Critical, PrevStatus: consul.Warning, Meta: meta},
+		{ServiceName: "pay", Status: consul.Warning, PrevStatus: consul.Critical, Meta: meta},
+		{ServiceName: "pay", Status: consul.Passing, PrevStatus: consul.Warning, Meta: meta},
+		{ServiceName: "web", Status: consul.Warning, PrevStatus: consul.Passing},
+	} {
+		if err := tg.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "#payments pay critical,#payments pay warning,#consul web warning"; strings.Join(got, ",") != want {
+		t.Errorf("notifications = %s, want %s", strings.Join(got, ","), want)
+	}
+
+	now := time.Now()
+	r := newReminders(0)
+	r.policies = true
+	r.track(&consul.Event{ID: "pay", Status: consul.Critical, Meta: map[string]string{policyRemindersKey: "30m"}}, now)
+	r.track(&consul.Event{ID: "web", Status: consul.Critical}, now)
+	if evs := r.due(now.Add(30 * time.Minute)); len(evs) != 1 || evs[0].ID != "pay" {
+		t.Errorf("due = %v, want a reminder about pay only", evs)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"p4 -> channel=#x", "p1 channel=#x", "p1 -> remind=soon", "p1 ->", "p1 -> unknown=x"} {
+		if _, err := parsePriority(s); err == nil {
+			t.Errorf("parsePriority(%q) expected to fail", s)
+		}
+	}
+	var f prioritiesFlag
+	for _, s := range []string{
+		"p1 -> notifiers=slack channel=#incidents mention=<!here> remind=15m",
+		"p3 -> channel=#low-priority remind=off",
+		"p2 -> remind=1h",
+	} {
+		if err := f.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Set("p2 -> channel=#x"); err == nil {
+		t.Error("Set expected to fail for a level given twice")
+	}
+
+	var got []string
+	record := func(channel string) notifier {
+		return notifierFunc(func(ev *consul.Event) error {
+			got = append(got, channel+" "+ev.ServiceName+" "+ev.Mention)
+			return nil
+		})
+	}
+	tg := &target{name: "slack", notifier: record("#consul"), inChannel: func(channel string) (notifier, error) {
+		return record(channel), nil
+	}}
+	r, err := parseRule("service=web -> channel=#web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = applyRules([]*target{tg}, append([]*rule{r}, f.rules()...)); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []*consul.Event{
+		{ServiceName: "pay", Status: consul.Critical, Meta: map[string]string{priorityKey: "p1"}},
+		{ServiceName: "web", Status: consul.Critical, Meta: map[string]string{priorityKey: "p1"}},
+		{ServiceName: "ads", Status: consul.Critical, Meta: map[string]string{priorityKey: "p3"}},
+		{ServiceName: "db", Status: consul.Critical, Meta: map[string]string{priorityKey: "p2"}},
+	} {
+		if err := tg.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "#incidents pay <!here>,#web web ,#low-priority ads ,#consul db "; strings.Join(got, ",") != want {
+		t.Errorf("notifications = %s, want %s", strings.Join(got, ","), want)
+	}
+
+	now := time.Now()
+	rem := newReminders(30 * time.Minute)
+	rem.priorities = f.reminders()
+	for _, level := range []string{"p1", "p2", "p3"} {
+		rem.track(&consul.Event{ID: level, Status: consul.Critical, Meta: map[string]string{priorityKey: level}}, now)
+	}
+	if evs := rem.due(now.Add(15 * time.Minute)); len(evs) != 1 || evs[0].ID != "p1" {
+		t.Errorf("due = %v, want a reminder about p1 only", evs)
+	}
+	if evs := rem.due(now.Add(time.Hour)); len(evs) != 2 {
+		t.Errorf("due = %v, want reminders about p1 and p2", evs)
+	}
+}
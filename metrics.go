@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// regexpsFlag is a repeatable regexp command-line flag, e.g. of noise filters.
+type regexpsFlag []string
+
+func (f *regexpsFlag) String() string {
+	return ""
+}
+
+func (f *regexpsFlag) Set(s string) error {
+	if _, err := regexp.Compile(s); err != nil {
+		return err
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// sourceCounter counts changes suppressed by noise filters
+// and events dropped from the full events buffer.
+type sourceCounter interface {
+	NoiseSuppressed() map[string]uint64
+	EventsDropped() uint64
+}
+
+// counter is a named counter with label name and value pairs.
+type counter struct {
+	name   string
+	labels []string
+	value  uint64
+}
+
+// counters counts events, deliveries and errors for /metrics and statsd.
+type counters struct {
+	mu sync.Mutex
+	m  map[string]*counter
+}
+
+func newCounters() *counters {
+	return &counters{m: map[string]*counter{}}
+}
+
+// stats are counters of the process.
+var stats = newCounters()
+
+// inc increments the counter with the given label name and value pairs.
+func (c *counters) inc(name string, labels ...string) {
+	key := name + "\x00" + strings.Join(labels, "\x00")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.m[key]; !ok {
+		c.m[key] = &counter{name: name, labels: labels}
+	}
+	c.m[key].value++
+}
+
+// snapshot returns current values of the counters and ones
+// of the source sorted by names and labels.
+func (c *counters) snapshot(n sourceCounter) []counter {
+	c.mu.Lock()
+	r := make([]counter, 0, len(c.m))
+	for _, cnt := range c.m {
+		r = append(r, *cnt)
+	}
+	c.mu.Unlock()
+	r = append(r, counter{name: "events_dropped", value: n.EventsDropped()})
+	for p, v := range n.NoiseSuppressed() {
+		r = append(r, counter{name: "noise_suppressed", labels: []string{"pattern", p}, value: v})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].name != r[j].name {
+			return r[i].name < r[j].name
+		}
+		return strings.Join(r[i].labels, "\x00") < strings.Join(r[j].labels, "\x00")
+	})
+	return r
+}
+
+// counterHelp are descriptions of counters.
+var counterHelp = map[string]string{
+	"errors":           "Errors of notifiers and other components.",
+	"events":           "Events by status.",
+	"events_dropped":   "Events dropped from the full events buffer.",
+	"noise_suppressed": "Check changes suppressed by noise filters.",
+	"notifications":    "Notifications sent by notifier and result.",
+}
+
+// labelEscaper escapes prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsHandler serves counters in the prometheus text format.
+func metricsHandler(n sourceCounter, c *counters) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		var prev string
+		for _, cnt := range c.snapshot(n) {
+			name := "consul_slack_" + cnt.name + "_total"
+			if cnt.name != prev {
+				fmt.Fprintf(w, "# HELP %s %s\n", name, counterHelp[cnt.name])
+				fmt.Fprintf(w, "# TYPE %s counter\n", name)
+				prev = cnt.name
+			}
+			labels := make([]string, 0, len(cnt.labels)/2)
+			for i := 0; i < len(cnt.labels); i += 2 {
+				labels = append(labels, fmt.Sprintf("%s=\"%s\"", cnt.labels[i], labelEscaper.Replace(cnt.labels[i+1])))
+			}
+			if len(labels) != 0 {
+				name += "{" + strings.Join(labels, ",") + "}"
+			}
+			fmt.Fprintf(w, "%s %d\n", name, cnt.value)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+	"github.com/amenzhinsky/consul-slack/watcher"
+)
+
+// step is a -middleware step events go through before targets route them.
+type step struct {
+	spec   string
+	drop   bool
+	field  string // field set by the step, Status, Mention, Notes or meta.KEY
+	value  string
+	cond   *expr // nil when the step applies to all events
+	keepIf bool  // drops events that don't match cond instead
+}
+
+// parseStep parses a middleware step, 'drop if EXPR' and 'keep if EXPR'
+// filter events, 'set FIELD=VALUE [if EXPR]' rewrites or enriches them
+// setting Status to passing, warning or critical, Mention, Notes or meta.KEY,
+// e.g. 'set meta.team=sre if event.Node.startsWith("db")'.
+func parseStep(s string) (*step, error) {
+	st := &step{spec: s}
+	action, rest := s, ""
+	if i := strings.IndexAny(s, " \t"); i != -1 {
+		action, rest = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	switch action {
+	case "drop", "keep":
+		if !strings.HasPrefix(rest, "if ") {
+			return nil, fmt.Errorf("malformed middleware %q, want %s if EXPR", s, action)
+		}
+		st.drop, st.keepIf = action == "drop", action == "keep"
+	case "set":
+		assign := rest
+		if i := strings.Index(rest, " if "); i != -1 {
+			assign, rest = rest[:i], strings.TrimSpace(rest[i+1:])
+		} else {
+			rest = ""
+		}
+		i := strings.IndexByte(assign, '=')
+		if i < 1 || strings.ContainsAny(assign, " \t") {
+			return nil, fmt.Errorf("malformed middleware %q, want set FIELD=VALUE [if EXPR]", s)
+		}
+		st.field, st.value = assign[:i], assign[i+1:]
+		switch {
+		case st.field == "Status":
+			if st.value != consul.Passing && st.value != consul.Warning && st.value != consul.Critical {
+				return nil, fmt.Errorf("middleware %q: status %q is not passing, warning or critical", s, st.value)
+			}
+		case st.field == "Mention", st.field == "Notes":
+		case strings.HasPrefix(st.field, "meta.") && len(st.field) > len("meta."):
+		default:
+			return nil, fmt.Errorf("middleware %q: field %q cannot be set", s, st.field)
+		}
+	default:
+		return nil, fmt.Errorf("middleware %q: unknown action %q", s, action)
+	}
+	if rest != "" {
+		var err error
+		if st.cond, err = parseExpr(rest[len("if"):]); err != nil {
+			return nil, fmt.Errorf("middleware %q: %v", s, err)
+		}
+	}
+	return st, nil
+}
+
+// handle is the watcher.Middleware of the step.
+func (st *step) handle(ev *consul.Event, next func(ev *consul.Event) error) error {
+	match := st.cond == nil || st.cond.match(ev)
+	switch {
+	case st.keepIf:
+		if !match {
+			return nil
+		}
+	case !match:
+	case st.drop:
+		return nil
+	default:
+		ev = st.apply(ev)
+	}
+	return next(ev)
+}
+
+// apply returns a copy of the event with the field set.
+func (st *step) apply(ev *consul.Event) *consul.Event {
+	e := *ev
+	switch st.field {
+	case "Status":
+		e.Status = st.value
+	case "Mention":
+		e.Mention = st.value
+	case "Notes":
+		e.Notes = st.value
+	default:
+		meta := make(map[string]string, len(ev.Meta)+1)
+		for k, v := range ev.Meta {
+			meta[k] = v
+		}
+		meta[st.field[len("meta."):]] = st.value
+		e.Meta = meta
+	}
+	return &e
+}
+
+// middleware returns the steps as middlewares of watcher.Chain.
+func middleware(steps []*step) []watcher.Middleware {
+	mws := make([]watcher.Middleware, 0, len(steps))
+	for _, st := range steps {
+		mws = append(mws, st.handle)
+	}
+	return mws
+}
+
+// middlewareFlag is a repeatable -middleware command-line flag,
+// steps apply in the order they're given.
+type middlewareFlag []*step
+
+func (f *middlewareFlag) String() string {
+	return ""
+}
+
+func (f *middlewareFlag) Set(s string) error {
+	st, err := parseStep(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, st)
+	return nil
+}
@@ -0,0 +1,243 @@
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(n *NATS)
+
+// WithSubjectPrefix sets subject prefix, "consul.health" by default.
+func WithSubjectPrefix(prefix string) Option {
+	return func(n *NATS) {
+		n.prefix = prefix
+	}
+}
+
+// WithDatacenter sets datacenter subject token used when the event has no datacenter.
+func WithDatacenter(dc string) Option {
+	return func(n *NATS) {
+		n.dc = dc
+	}
+}
+
+// WithTimeout sets connection and flush timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(n *NATS) {
+		n.timeout = d
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(n *NATS) {
+		n.logger = l
+	}
+}
+
+// New creates new nats publisher, addr is nats://[user:pass@]host:port.
+func New(addr string, opts ...Option) (*NATS, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("nats: malformed url %q", addr)
+	}
+	n := &NATS{
+		addr:    u.Host,
+		user:    u.User,
+		prefix:  "consul.health",
+		dc:      "dc1",
+		timeout: 5 * time.Second,
+		logger:  log.New(os.Stdout, "[nats] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n, nil
+}
+
+// NATS publishes events to subjects like consul.health.<dc>.<service>.<status>.
+// It speaks the core text protocol over a lazily established
+// connection that's reestablished on the next publish after a failure.
+type NATS struct {
+	addr    string
+	user    *url.Userinfo
+	prefix  string
+	dc      string
+	timeout time.Duration
+	logger  *log.Logger
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Subject returns subject the event is published to.
+func (n *NATS) Subject(ev *consul.Event) string {
+	dc := ev.Datacenter
+	if dc == "" {
+		dc = n.dc
+	}
+	return strings.Join([]string{n.prefix, token(dc), token(ev.ServiceName), token(ev.Status)}, ".")
+}
+
+// token makes s a valid single subject token.
+func token(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Notify publishes the event as json.
+func (n *NATS) Notify(ev *consul.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return n.Publish(n.Subject(ev), b)
+}
+
+// Publish publishes data to the subject and waits
+// for the server to acknowledge it with a PONG.
+func (n *NATS) Publish(subject string, data []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+	if err := n.publish(subject, data); err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return err
+	}
+	n.infof("published to %s", subject)
+	return nil
+}
+
+func (n *NATS) publish(subject string, data []byte) error {
+	if err := n.conn.SetDeadline(time.Now().Add(n.timeout)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\nPING\r\n", subject, len(data), data); err != nil {
+		return err
+	}
+	return n.waitPong()
+}
+
+// connect dials the server, reads INFO and sends CONNECT.
+func (n *NATS) connect() error {
+	conn, err := net.DialTimeout("tcp", n.addr, n.timeout)
+	if err != nil {
+		return err
+	}
+	if err = conn.SetDeadline(time.Now().Add(n.timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	n.conn, n.r = conn, bufio.NewReader(conn)
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "consul-slack",
+		"lang":     "go",
+		"version":  "1",
+	}
+	if n.user != nil {
+		opts["user"] = n.user.Username()
+		if pass, ok := n.user.Password(); ok {
+			opts["pass"] = pass
+		} else {
+			opts["auth_token"] = n.user.Username()
+			delete(opts, "user")
+		}
+	}
+	b, err := json.Marshal(opts)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err = fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", b); err != nil {
+		conn.Close()
+		return err
+	}
+	if err = n.waitPong(); err != nil {
+		conn.Close()
+		return err
+	}
+	n.infof("connected to %s", n.addr)
+	return nil
+}
+
+// waitPong reads server messages until PONG, answering PINGs.
+func (n *NATS) waitPong() error {
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err = n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(line[4:]))
+		}
+	}
+}
+
+// Close closes the connection.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
+
+// infof prints a debug message.
+func (n *NATS) infof(format string, v ...interface{}) {
+	if n.logger != nil {
+		n.logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,62 @@
+package nats
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	pub := make(chan string, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("INFO {}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "CONNECT "):
+				if !strings.Contains(line, `"pass":"secret"`) {
+					t.Errorf("CONNECT %q expected to include password", line)
+				}
+			case strings.HasPrefix(line, "PUB "):
+				body, _ := r.ReadString('\n')
+				pub <- line + body
+			case line == "PING\r\n":
+				conn.Write([]byte("PONG\r\n"))
+			}
+		}
+	}()
+
+	n, err := New("nats://user:secret@"+lis.Addr().String(), WithDatacenter("dc1"), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	if err = n.Notify(&consul.Event{ServiceName: "api.v2", Status: consul.Critical}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-pub; !strings.HasPrefix(got, "PUB consul.health.dc1.api_v2.critical ") {
+		t.Errorf("unexpected publish %q", got)
+	}
+}
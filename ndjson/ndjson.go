@@ -0,0 +1,28 @@
+package ndjson
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// New creates new notifier that writes every event
+// as a single line of json to w.
+func New(w io.Writer) *NDJSON {
+	return &NDJSON{enc: json.NewEncoder(w)}
+}
+
+// NDJSON is a newline delimited json writer.
+type NDJSON struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Notify writes the event followed by a newline.
+func (n *NDJSON) Notify(ev *consul.Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.enc.Encode(ev)
+}
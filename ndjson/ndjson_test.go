@@ -0,0 +1,28 @@
+package ndjson
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	n := New(&b)
+	for _, id := range []string{"foo", "bar"} {
+		if err := n.Notify(&consul.Event{ServiceID: id, Status: consul.Critical}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(b.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), b.String())
+	}
+	if !bytes.Contains(lines[1], []byte(`"ServiceID":"bar"`)) {
+		t.Errorf("line %q expected to include bar service", lines[1])
+	}
+}
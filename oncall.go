@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// valuer reads values of KV keys.
+type valuer interface {
+	Value(key string) (string, error)
+}
+
+// oncall resolves the mention of the current on-call engineer at send
+// time, it's looked up at most once per ttl and the last known mention
+// is used while lookups fail, so a schedule outage doesn't drop alerts.
+type oncall struct {
+	lookup func() (string, error)
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	current string
+	expires time.Time
+}
+
+// newOncall creates an on-call resolver configured by
+// command-line flags, nil is returned when it's not configured.
+func newOncall(v valuer) (*oncall, error) {
+	switch {
+	case oncallKVFlag != "" && oncallURLFlag != "":
+		return nil, errors.New("-oncall-kv and -oncall-url are mutually exclusive")
+	case oncallKVFlag != "":
+		return &oncall{ttl: oncallTTLFlag, lookup: func() (string, error) {
+			return v.Value(oncallKVFlag)
+		}}, nil
+	case oncallURLFlag != "":
+		return &oncall{ttl: oncallTTLFlag, lookup: func() (string, error) {
+			return fetchOncall(oncallURLFlag)
+		}}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// setOncall makes targets mention the on-call engineer when it's configured.
+func setOncall(targets []*target, v valuer) error {
+	o, err := newOncall(v)
+	if err != nil || o == nil {
+		return err
+	}
+	for _, t := range targets {
+		t.oncall = o
+	}
+	return nil
+}
+
+// get returns the current on-call mention, empty when it's not known.
+func (o *oncall) get(now time.Time) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if now.Before(o.expires) {
+		return o.current
+	}
+	mention, err := o.lookup()
+	if err != nil {
+		notifyError("oncall", err)
+	} else {
+		o.current = mention
+	}
+	o.expires = now.Add(o.ttl)
+	return o.current
+}
+
+// mention returns a copy of the critical event mentioning
+// the on-call engineer unless a rule mentions someone already.
+func (o *oncall) mention(ev *consul.Event, now time.Time) *consul.Event {
+	if o == nil || ev.Status != consul.Critical || ev.Mention != "" {
+		return ev
+	}
+	mention := o.get(now)
+	if mention == "" {
+		return ev
+	}
+	e := *ev
+	e.Mention = mention
+	return &e
+}
+
+// fetchOncall requests the url that responds with the mention as plain
+// text, e.g. a small service in front of a pagerduty or opsgenie schedule.
+func fetchOncall(url string) (string, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return "", fmt.Errorf("oncall url responded with %s", res.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,199 @@
+package opsgenie
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(o *OpsGenie)
+
+// WithAPIURL sets api base url, e.g. https://api.eu.opsgenie.com for EU accounts.
+func WithAPIURL(url string) Option {
+	return func(o *OpsGenie) {
+		o.apiURL = strings.TrimRight(url, "/")
+	}
+}
+
+// WithPriority sets alert priority P1-P5 for the given check status.
+func WithPriority(status, priority string) Option {
+	return func(o *OpsGenie) {
+		o.priorities[status] = priority
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(o *OpsGenie) {
+		o.logger = l
+	}
+}
+
+// New creates new opsgenie alert api client.
+func New(apiKey string, opts ...Option) (*OpsGenie, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("opsgenie: api key is empty")
+	}
+	o := &OpsGenie{
+		apiKey: apiKey,
+		apiURL: "https://api.opsgenie.com",
+		priorities: map[string]string{
+			consul.Critical: "P1",
+			consul.Warning:  "P3",
+		},
+		open:   map[string]string{},
+		logger: log.New(os.Stdout, "[opsgenie] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	for status, p := range o.priorities {
+		if len(p) != 2 || p[0] != 'P' || p[1] < '1' || p[1] > '5' {
+			return nil, fmt.Errorf("opsgenie: invalid %s priority %q", status, p)
+		}
+	}
+	return o, nil
+}
+
+// OpsGenie creates alerts for failing checks and closes them on recovery.
+type OpsGenie struct {
+	apiKey     string
+	apiURL     string
+	priorities map[string]string
+	logger     *log.Logger
+
+	mu   sync.Mutex
+	open map[string]string // alias -> last seen output
+}
+
+// alert is the create alert request body.
+type alert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Priority    string            `json:"priority,omitempty"`
+	Entity      string            `json:"entity,omitempty"`
+	Source      string            `json:"source"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+// note is the add note and close alert request body.
+type note struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+// Notify creates an alert when a check is failing, adds a note to it
+// when output changes and closes it once the check is passing again.
+func (o *OpsGenie) Notify(ev *consul.Event) error {
+	alias := ev.Key()
+
+	o.mu.Lock()
+	output, ok := o.open[alias]
+	o.mu.Unlock()
+
+	var err error
+	switch {
+	case ev.Resolved():
+		err = o.post("/v2/alerts/"+url.PathEscape(alias)+"/close?identifierType=alias", &note{
+			Source: "consul-slack",
+			Note:   ev.Output,
+		})
+		if err == nil {
+			o.forget(alias)
+		}
+		return err
+	case !ev.Failing():
+		return nil
+	case ok && output != ev.Output:
+		err = o.post("/v2/alerts/"+url.PathEscape(alias)+"/notes?identifierType=alias", &note{
+			Source: "consul-slack",
+			Note:   fmt.Sprintf("%s: %s", ev.Status, ev.Output),
+		})
+	case !ok:
+		err = o.post("/v2/alerts", &alert{
+			Message:     fmt.Sprintf("[%s] %s is %s", ev.Node, ev.ServiceID, ev.Status),
+			Alias:       alias,
+			Description: fmt.Sprintf("Notes: %s\nOutput: %s", ev.Notes, ev.Output),
+			Priority:    o.priorities[ev.Status],
+			Entity:      ev.ServiceID,
+			Source:      "consul-slack",
+			Tags:        ev.ServiceTags,
+			Details: map[string]string{
+				"node":    ev.Node,
+				"service": ev.ServiceName,
+				"status":  ev.Status,
+			},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.open[alias] = ev.Output
+	o.mu.Unlock()
+	return nil
+}
+
+// forget removes the alias from the open alerts list.
+func (o *OpsGenie) forget(alias string) {
+	o.mu.Lock()
+	delete(o.open, alias)
+	o.mu.Unlock()
+}
+
+// post sends v as json to the given api path.
+func (o *OpsGenie) post(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", o.apiURL+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+o.apiKey)
+
+	o.infof("%s: %s", path, b)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	o.infof("response: %s", r.Status)
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	return nil
+}
+
+// infof prints a debug message.
+func (o *OpsGenie) infof(format string, v ...interface{}) {
+	if o.logger != nil {
+		o.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("opsgenie responded with %d status code", r.r.StatusCode)
+}
@@ -0,0 +1,118 @@
+package opsgenie
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		requests []string
+		created  alert
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "GenieKey key" {
+			t.Errorf("Authorization = %q, want %q", got, "GenieKey key")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.URL.RequestURI())
+		if r.URL.Path == "/v2/alerts" {
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	o, err := New("key", WithAPIURL(ts.URL), WithPriority(consul.Critical, "P2"), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ev := range []*consul.Event{
+		{Node: "n1", ServiceID: "web", ID: "n1:web", Status: consul.Critical, Output: "timeout"},
+		{Node: "n1", ServiceID: "web", ID: "n1:web", Status: consul.Critical, Output: "refused"},
+		{Node: "n1", ServiceID: "web", ID: "n1:web", Status: consul.Passing, Output: "ok"},
+	} {
+		if err = o.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"/v2/alerts",
+		"/v2/alerts/n1:web/notes?identifierType=alias",
+		"/v2/alerts/n1:web/close?identifierType=alias",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+	if created.Priority != "P2" || created.Alias != "n1:web" {
+		t.Errorf("created = %+v, want P2 priority and n1:web alias", created)
+	}
+}
+
+func TestNotifyNodeChecks(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.URL.RequestURI())
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	o, err := New("key", WithAPIURL(ts.URL), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checks of the same node have their own alerts
+	for _, ev := range []*consul.Event{
+		{Node: "n1", CheckID: consul.SerfHealth, ID: "n1/serfHealth", Datacenter: "dc1", Status: consul.Critical, Output: "down"},
+		{Node: "n1", CheckID: "disk", ID: "n1/disk", Datacenter: "dc1", Status: consul.Critical, Output: "full"},
+		{Node: "n1", CheckID: "disk", ID: "n1/disk", Datacenter: "dc2", Status: consul.Critical, Output: "full"},
+		{Node: "n1", CheckID: consul.SerfHealth, ID: "n1/serfHealth", Datacenter: "dc1", Status: consul.Passing, Output: "up"},
+	} {
+		if err = o.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"/v2/alerts",
+		"/v2/alerts",
+		"/v2/alerts",
+		"/v2/alerts/dc1%2Fn1%2FserfHealth/close?identifierType=alias",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+	if len(o.open) != 2 {
+		t.Errorf("open alerts = %v, want disk ones of both datacenters", o.open)
+	}
+}
+
+func TestNewInvalidPriority(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New("key", WithPriority(consul.Warning, "high")); err == nil {
+		t.Fatal("expected invalid priority error")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// outage coalesces alerts of mass failures: once more than threshold
+// checks go critical within the window, events are held and the failing
+// checks are posted as a single rolled-up message updated every interval,
+// it's over when no checks go critical for the whole window.
+type outage struct {
+	threshold int
+	window    time.Duration
+	interval  time.Duration
+
+	mu      sync.Mutex
+	recent  []outageFailure          // checks gone critical within the window
+	since   time.Time                // zero when there's no outage
+	failing map[string]*consul.Event // checks failing during the outage
+	changed bool                     // failing checks changed since the last update
+	posted  time.Time                // when the last update was posted
+	seen    map[string]outageSeen    // by seenKey, events are shared by targets
+}
+
+// outageSeen is whether an event is held and when it's been seen.
+type outageSeen struct {
+	held bool
+	at   time.Time
+}
+
+// outageFailure is a check that has gone critical at the time.
+type outageFailure struct {
+	ev *consul.Event
+	at time.Time
+}
+
+func newOutage(threshold int, window, interval time.Duration) *outage {
+	return &outage{
+		threshold: threshold,
+		window:    window,
+		interval:  interval,
+		failing:   map[string]*consul.Event{},
+		seen:      map[string]outageSeen{},
+	}
+}
+
+// hold reports whether the event is held because of the outage at now,
+// it starts one when the event is one too many checks going critical.
+func (o *outage) hold(ev *consul.Event, now time.Time) bool {
+	if ev.IsLock() {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	sk := seenKey(ev)
+	if s, ok := o.seen[sk]; ok {
+		return s.held
+	}
+
+	o.prune(now)
+	if ev.Status == consul.Critical && ev.PrevStatus != consul.Critical && ev.Reminder == 0 {
+		o.recent = append(o.recent, outageFailure{ev: ev, at: now})
+	}
+	if o.since.IsZero() && len(o.recent) > o.threshold {
+		// checks that started it are alerted about already but listed too
+		o.since = now
+		for _, f := range o.recent {
+			o.failing[f.ev.Key()] = f.ev
+		}
+		o.changed = true
+	}
+	held := !o.since.IsZero()
+	if held {
+		if ev.Failing() {
+			o.failing[ev.Key()] = ev
+		} else {
+			delete(o.failing, ev.Key())
+		}
+		o.changed = true
+	}
+	o.seen[sk] = outageSeen{held: held, at: now}
+	return held
+}
+
+// seenKey identifies the status change of the event, targets get copies
+// of events when they're grouped so they cannot be told apart by pointers.
+func seenKey(ev *consul.Event) string {
+	return ev.Key() + "/" + ev.PrevStatus + "/" + ev.Status + "/" +
+		strconv.Itoa(ev.Reminder) + "/" + strconv.FormatInt(ev.Time.UnixNano(), 10)
+}
+
+// prune forgets checks gone critical before the window, o.mu has to be held.
+func (o *outage) prune(now time.Time) {
+	i := 0
+	for i < len(o.recent) && now.Sub(o.recent[i].at) >= o.window {
+		i++
+	}
+	o.recent = o.recent[i:]
+}
+
+// update returns checks failing during the outage when the rolled-up message
+// is due at now, over is true when the outage is over and alerts resume.
+func (o *outage) update(now time.Time) (since time.Time, evs []*consul.Event, over, due bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for k, s := range o.seen {
+		// all targets have got the event by then
+		if now.Sub(s.at) >= time.Minute {
+			delete(o.seen, k)
+		}
+	}
+	if o.since.IsZero() {
+		return time.Time{}, nil, false, false
+	}
+	o.prune(now)
+	over = len(o.recent) == 0
+	if !over && (!o.changed || now.Sub(o.posted) < o.interval) {
+		return time.Time{}, nil, false, false
+	}
+
+	since = o.since
+	evs = make([]*consul.Event, 0, len(o.failing))
+	for _, ev := range o.failing {
+		evs = append(evs, ev)
+	}
+	sort.Slice(evs, func(i, j int) bool {
+		return evs[i].Key() < evs[j].Key()
+	})
+	o.changed, o.posted = false, now
+	if over {
+		o.since = time.Time{}
+		o.failing = map[string]*consul.Event{}
+	}
+	return since, evs, over, true
+}
+
+// outageReporter is a notifier that can post the rolled-up message
+// about the outage that started at since, over is true for the last one.
+type outageReporter interface {
+	Outage(since time.Time, evs []*consul.Event, over bool) error
+}
+
+// reportOutage posts rolled-up messages about outages to the targets
+// when they're due until done is closed.
+func reportOutage(o *outage, targets []*target, done <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			if since, evs, over, due := o.update(now); due {
+				sendOutage(targets, since, evs, over)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// sendOutage posts the rolled-up message to all targets that support it.
+func sendOutage(targets []*target, since time.Time, evs []*consul.Event, over bool) {
+	for _, t := range targets {
+		r, ok := t.notifier.(outageReporter)
+		if !ok {
+			continue
+		}
+		if err := r.Outage(since, evs, over); err != nil {
+			notifyError(t.label(), err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// owners mentions owners of critical services, their emails are
+// read from the key service meta field and looked up in slack.
+type owners struct {
+	key    string
+	lookup func(email string) (string, error)
+}
+
+// mention returns a copy of the critical event mentioning owners of the
+// service unless a rule mentions someone already. Owners that aren't
+// found are skipped, the event is sent without them when lookups fail.
+func (o *owners) mention(ev *consul.Event) *consul.Event {
+	if o == nil || ev.Status != consul.Critical || ev.Mention != "" || ev.Meta[o.key] == "" {
+		return ev
+	}
+	var mentions []string
+	for _, email := range splitList(ev.Meta[o.key]) {
+		id, err := o.lookup(strings.TrimSpace(email))
+		if err != nil {
+			notifyError("slack-owner", err)
+			continue
+		}
+		if id != "" {
+			mentions = append(mentions, "<@"+id+">")
+		}
+	}
+	if len(mentions) == 0 {
+		return ev
+	}
+	e := *ev
+	e.Mention = strings.Join(mentions, " ")
+	return &e
+}
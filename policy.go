@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// service meta fields services declare their own alerting policy with, see -meta-policy
+const (
+	policyChannelKey     = "alert_channel"
+	policyMinSeverityKey = "alert_min_severity"
+	policyRemindersKey   = "alert_reminders"
+)
+
+// policyKeys are all meta fields of policies, they're looked up
+// along with the service but aren't shown in messages.
+var policyKeys = []string{policyChannelKey, policyMinSeverityKey, policyRemindersKey}
+
+// policy is the alerting policy a service declares in its meta fields.
+type policy struct {
+	channel     string        // empty when routing decides
+	minSeverity string        // empty when all changes are reported
+	reminders   time.Duration // zero when -remind-interval applies, negative when off
+}
+
+// parsePolicy reads the policy from service meta fields, invalid fields
+// are left unset and reported with the error along with valid ones.
+func parsePolicy(meta map[string]string) (*policy, error) {
+	p := &policy{channel: meta[policyChannelKey]}
+	var err error
+	switch s := meta[policyMinSeverityKey]; s {
+	case "":
+	case consul.Warning, consul.Critical:
+		p.minSeverity = s
+	default:
+		err = fmt.Errorf("%s %q is neither warning nor critical", policyMinSeverityKey, s)
+	}
+	switch s := meta[policyRemindersKey]; s {
+	case "":
+	case "off":
+		p.reminders = -1
+	default:
+		d, e := time.ParseDuration(s)
+		if e != nil || d <= 0 {
+			err = fmt.Errorf("%s %q is neither a positive duration nor off", policyRemindersKey, s)
+			break
+		}
+		p.reminders = d
+	}
+	return p, err
+}
+
+// severities are weights of statuses min severities are compared by.
+var severities = map[string]int{
+	consul.Passing:  0,
+	consul.Warning:  1,
+	consul.Critical: 2,
+}
+
+// minor reports whether neither the new nor the previous status of
+// the event is as severe as the min severity, like -min-severity does.
+func (p *policy) minor(ev *consul.Event) bool {
+	if p.minSeverity == "" || ev.Reminder != 0 {
+		return false
+	}
+	status, ok := severities[ev.Status]
+	if !ok {
+		return false
+	}
+	return status < severities[p.minSeverity] && severities[ev.PrevStatus] < severities[p.minSeverity]
+}
+
+// withoutPolicy returns the event without meta fields of the policy.
+func withoutPolicy(ev *consul.Event) *consul.Event {
+	var meta map[string]string
+	for k, v := range ev.Meta {
+		if k == policyChannelKey || k == policyMinSeverityKey || k == policyRemindersKey {
+			continue
+		}
+		if meta == nil {
+			meta = map[string]string{}
+		}
+		meta[k] = v
+	}
+	e := *ev
+	e.Meta = meta
+	return &e
+}
+
+// policyChannels creates notifiers of channels services declare on demand.
+type policyChannels struct {
+	mu       sync.Mutex
+	channels map[*target]map[string]notifier
+}
+
+func newPolicyChannels() *policyChannels {
+	return &policyChannels{channels: map[*target]map[string]notifier{}}
+}
+
+// channel returns the notifier posting to the channel, it's nil
+// when the notifier of the target has no channels.
+func (c *policyChannels) channel(t *target, name string) (notifier, error) {
+	if n, ok := t.channels[name]; ok {
+		return n, nil
+	}
+	if t.inChannel == nil {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.channels[t][name]; ok {
+		return n, nil
+	}
+	n, err := t.inChannel(name)
+	if err != nil {
+		return nil, err
+	}
+	if c.channels[t] == nil {
+		c.channels[t] = map[string]notifier{}
+	}
+	c.channels[t][name] = n
+	return n, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// priorityKey is the service meta field services declare their priority in, see -priority.
+const priorityKey = "criticality"
+
+// priorityLevels are priorities services can declare, p1 being the most critical.
+var priorityLevels = map[string]bool{"p1": true, "p2": true, "p3": true}
+
+// priority is how events of services declaring the level are notified about.
+type priority struct {
+	level    string
+	rule     *rule         // nil when only reminders are changed
+	reminder time.Duration // zero when -remind-interval applies, negative when off
+}
+
+// parsePriority parses a LEVEL -> ACTIONS priority, actions are the ones
+// of rules along with remind=DURATION|off changing the reminder interval,
+// e.g. 'p1 -> notifiers=slack,opsgenie mention=<!here> remind=15m'.
+func parsePriority(s string) (*priority, error) {
+	parts := strings.SplitN(s, "->", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed priority %q, want LEVEL -> ACTIONS", s)
+	}
+	p := &priority{level: strings.TrimSpace(parts[0])}
+	if !priorityLevels[p.level] {
+		return nil, fmt.Errorf("priority %q: level %q is neither p1, p2 nor p3", s, p.level)
+	}
+
+	var actions []string
+	for _, f := range strings.Fields(parts[1]) {
+		if !strings.HasPrefix(f, "remind=") {
+			actions = append(actions, f)
+			continue
+		}
+		switch val := f[len("remind="):]; val {
+		case "off":
+			p.reminder = -1
+		default:
+			d, err := time.ParseDuration(val)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("priority %q: remind %q is neither a positive duration nor off", s, val)
+			}
+			p.reminder = d
+		}
+	}
+	if len(actions) == 0 {
+		if p.reminder == 0 {
+			return nil, fmt.Errorf("priority %q has no actions", s)
+		}
+		return p, nil
+	}
+	var err error
+	if p.rule, err = parseRule("meta." + priorityKey + "=" + p.level + " -> " + strings.Join(actions, " ")); err != nil {
+		return nil, fmt.Errorf("priority %q: %v", s, err)
+	}
+	return p, nil
+}
+
+// prioritiesFlag is a repeatable priority command-line flag.
+type prioritiesFlag []*priority
+
+func (f *prioritiesFlag) String() string {
+	return ""
+}
+
+func (f *prioritiesFlag) Set(s string) error {
+	p, err := parsePriority(s)
+	if err != nil {
+		return err
+	}
+	for _, q := range *f {
+		if q.level == p.level {
+			return fmt.Errorf("priority %s is given twice", p.level)
+		}
+	}
+	*f = append(*f, p)
+	return nil
+}
+
+// rules returns rules of the priorities, they apply after routing
+// rules, so the ones given explicitly take precedence.
+func (f prioritiesFlag) rules() []*rule {
+	var rules []*rule
+	for _, p := range f {
+		if p.rule != nil {
+			rules = append(rules, p.rule)
+		}
+	}
+	return rules
+}
+
+// reminders returns reminder intervals by levels.
+func (f prioritiesFlag) reminders() map[string]time.Duration {
+	var m map[string]time.Duration
+	for _, p := range f {
+		if p.reminder == 0 {
+			continue
+		}
+		if m == nil {
+			m = map[string]time.Duration{}
+		}
+		m[p.level] = p.reminder
+	}
+	return m
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// profile is a named set of notifiers configured in its own file.
+type profile struct {
+	name string
+	path string
+}
+
+// profilesFlag is a repeatable NAME=FILE command-line flag.
+type profilesFlag []profile
+
+func (f *profilesFlag) String() string {
+	return ""
+}
+
+func (f *profilesFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 1 || i == len(s)-1 {
+		return fmt.Errorf("malformed profile %q, want NAME=FILE", s)
+	}
+	for _, p := range *f {
+		if p.name == s[:i] {
+			return fmt.Errorf("duplicate profile %q", p.name)
+		}
+	}
+	*f = append(*f, profile{name: s[:i], path: s[i+1:]})
+	return nil
+}
+
+// profilePrefixes are prefixes of flags that can be set in profile files.
+var profilePrefixes = []string{
+	"slack-", "telegram-", "webhook-", "opsgenie-", "sns-", "victorops-", "ndjson-",
+	"rocketchat-", "alertmanager-", "kafka-", "nats-", "jira-", "github-", "servicenow-",
+	"filter", "rule", "escalate-mention",
+}
+
+// isProfileFlag reports whether the flag can be set in profile files.
+func isProfileFlag(name string) bool {
+	for _, p := range profilePrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// allTargets creates notifiers configured by command-line flags
+// and the ones of every profile that are labeled with its name,
+// notifiers of watch profiles are sent only their own events.
+func allTargets(webhookURL string) ([]*target, error) {
+	targets, err := newTargets(webhookURL)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range notifierProfilesFlag {
+		ts, err := profileTargets(flag.CommandLine, p)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", p.name, err)
+		}
+		if len(ts) == 0 {
+			return nil, fmt.Errorf("profile %s: no notifiers configured", p.name)
+		}
+		targets = append(targets, ts...)
+	}
+	if len(watchProfilesFlag) != 0 {
+		// the default watch's events only
+		def := ""
+		for _, t := range targets {
+			t.watch = &def
+		}
+	}
+	for _, p := range watchProfilesFlag {
+		ts, err := watchTargets(flag.CommandLine, p)
+		if err != nil {
+			return nil, fmt.Errorf("watch profile %s: %v", p.name, err)
+		}
+		if len(ts) == 0 {
+			return nil, fmt.Errorf("watch profile %s: no notifiers configured", p.name)
+		}
+		targets = append(targets, ts...)
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no notifiers configured")
+	}
+	return targets, nil
+}
+
+// profileTargets creates notifiers of the profile, see withProfileFlags.
+func profileTargets(fs *flag.FlagSet, p profile) ([]*target, error) {
+	var targets []*target
+	err := withProfileFlags(fs, p, isProfileFlag, func() (err error) {
+		targets, err = newTargets(slackWebhookURLFlag)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		t.profile = p.name
+	}
+	return targets, nil
+}
+
+// withProfileFlags calls fn with flags of fs accepted by isFlag reset
+// to their defaults and set from the profile file, they're restored
+// once it's done. Filters and rules of the profile start empty.
+func withProfileFlags(fs *flag.FlagSet, p profile, isFlag func(name string) bool, fn func() error) error {
+	filters, rules := notifierFiltersFlag, routingRulesFlag
+	notifierFiltersFlag, routingRulesFlag = filtersFlag{}, nil
+
+	pfs := flag.NewFlagSet(p.name, flag.ContinueOnError)
+	saved := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		switch {
+		case f.Name == "filter":
+			pfs.Var(notifierFiltersFlag, f.Name, f.Usage)
+		case f.Name == "rule", f.Name == "tag-channel":
+			// both append to the profile's rules
+			pfs.Var(f.Value, f.Name, f.Usage)
+		case isFlag(f.Name):
+			pfs.Var(f.Value, f.Name, f.Usage)
+			saved[f.Name] = f.Value.String()
+		}
+	})
+	defer func() {
+		notifierFiltersFlag, routingRulesFlag = filters, rules
+		for name, val := range saved {
+			fs.Lookup(name).Value.Set(val)
+		}
+	}()
+
+	for name := range saved {
+		f := fs.Lookup(name)
+		if err := f.Value.Set(f.DefValue); err != nil {
+			return err
+		}
+	}
+	if err := loadConfig(pfs, p.path); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// watchFlags are flags of what's watched that can be set in
+// watch profile files in addition to the ones of notifiers.
+var watchFlags = []string{
+	"consul-interval", "watch-services", "ignore-services", "watch-nodes", "ignore-nodes",
+	"consul-filter", "node-meta", "node-checks", "registrations", "confirmations",
+	"recovery-confirmations", "per-check", "output-changes", "min-severity", "aggregate-services",
+}
+
+// isWatchProfileFlag reports whether the flag can be set in watch profile files.
+func isWatchProfileFlag(name string) bool {
+	for _, s := range watchFlags {
+		if s == name {
+			return true
+		}
+	}
+	return isProfileFlag(name)
+}
+
+// watchTargets creates notifiers of the watch profile, they're sent
+// only events of its own watch, see watchClusters.
+func watchTargets(fs *flag.FlagSet, p profile) ([]*target, error) {
+	var targets []*target
+	err := withProfileFlags(fs, p, isWatchProfileFlag, func() (err error) {
+		targets, err = newTargets(slackWebhookURLFlag)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		t.profile = p.name
+		t.watch = &p.name
+	}
+	return targets, nil
+}
+
+// watchClusters returns the default watch and the ones of watch profiles as clusters
+// of the same consul, each with its own state and lock. Watch flags of a profile are
+// set by its file only, others like the consul address are shared, extra options
+// of the default watch apply to all of them.
+func watchClusters(fs *flag.FlagSet, extra []consul.Option) ([]cluster, error) {
+	list := []cluster{{}}
+	for _, p := range watchProfilesFlag {
+		var opts []consul.Option
+		err := withProfileFlags(fs, p, isWatchProfileFlag, func() (err error) {
+			opts, err = consulOptions()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("watch profile %s: %v", p.name, err)
+		}
+		opts = append(opts, extra...)
+		list = append(list, cluster{name: p.name, opts: append(opts, consul.WithProfile(p.name))})
+	}
+	return list, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// limiter caps the number of notifications per minute, events over
+// the limit are held and reported with a single message once it's over.
+type limiter struct {
+	limit int
+
+	mu    sync.Mutex
+	start time.Time // beginning of the current minute
+	sent  int
+	held  int
+}
+
+func newLimiter(limit int) *limiter {
+	return &limiter{limit: limit}
+}
+
+// allow reports whether an event can be sent at now, otherwise it's held.
+func (l *limiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.start) >= time.Minute {
+		if l.held != 0 {
+			l.held++ // the previous minute isn't reported yet
+			return false
+		}
+		l.start, l.sent = now, 0
+	}
+	if l.sent < l.limit {
+		l.sent++
+		return true
+	}
+	l.held++
+	return false
+}
+
+// overflow returns the number of events held during the minute
+// that is over at now and starts counting a new one.
+func (l *limiter) overflow(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == 0 || now.Sub(l.start) < time.Minute {
+		return 0
+	}
+	n := l.held
+	l.start, l.sent, l.held = now, 1, 0 // the report counts as a notification
+	return n
+}
+
+// overflowReporter is a notifier that can report
+// the number of events held by the rate limit.
+type overflowReporter interface {
+	Overflow(n int) error
+}
+
+// reportOverflow reports events held by rate limits of the targets
+// once their minutes are over, until done is closed.
+func reportOverflow(targets []*target, done <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			sendOverflow(targets, now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// sendOverflow reports events held by rate limits of the targets at now,
+// targets that cannot report them only log the number.
+func sendOverflow(targets []*target, now time.Time) {
+	for _, t := range targets {
+		if t.limiter == nil {
+			continue
+		}
+		n := t.limiter.overflow(now)
+		if n == 0 {
+			continue
+		}
+		r, ok := t.notifier.(overflowReporter)
+		if !ok {
+			notifyError(t.label(), fmt.Errorf("%d events suppressed by the rate limit", n))
+			continue
+		}
+		if err := r.Overflow(n); err != nil {
+			notifyError(t.label(), err)
+		}
+	}
+}
+
+// cooldown lets through one notification about a check every interval,
+// status changes in between are held. The latest of them is delivered
+// once the interval is over unless the check is back in the notified
+// status, so flappy checks don't end up shown in a stale one.
+type cooldown struct {
+	interval time.Duration
+
+	mu     sync.Mutex
+	checks map[string]*cooling
+}
+
+// cooling is a check notified about within the interval.
+type cooling struct {
+	until  time.Time
+	status string        // notified status
+	held   *consul.Event // latest held event, nil when there's none
+}
+
+func newCooldown(interval time.Duration) *cooldown {
+	return &cooldown{interval: interval, checks: map[string]*cooling{}}
+}
+
+// hold reports whether the check of the event is cooling down
+// at now, the event is held then.
+func (c *cooldown) hold(ev *consul.Event, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cc, ok := c.checks[ev.Key()]; ok && now.Before(cc.until) {
+		cc.held = ev
+		return true
+	}
+	return false
+}
+
+// start starts the interval of the check of the delivered event at now.
+func (c *cooldown) start(ev *consul.Event, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[ev.Key()] = &cooling{until: now.Add(c.interval), status: ev.Status}
+}
+
+// due returns events held for checks which intervals are over at
+// now and forgets the checks, so the events can be sent as usual.
+func (c *cooldown) due(now time.Time) []*consul.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var evs []*consul.Event
+	for k, cc := range c.checks {
+		if now.Before(cc.until) {
+			continue
+		}
+		delete(c.checks, k)
+		if cc.held != nil && cc.held.Status != cc.status {
+			evs = append(evs, cc.held)
+		}
+	}
+	return evs
+}
+
+// releaseCooldowns delivers events held by cooldowns of
+// the targets once their intervals are over, until done is closed.
+func releaseCooldowns(targets []*target, done <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			sendCooldowns(targets, now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// sendCooldowns delivers events held by cooldowns of the targets at now.
+func sendCooldowns(targets []*target, now time.Time) {
+	for _, t := range targets {
+		if t.cooldown == nil {
+			continue
+		}
+		for _, ev := range t.cooldown.due(now) {
+			// held events have gone through the middleware already
+			if err := t.notify(ev); err != nil {
+				notifyError(t.label(), err)
+			}
+		}
+	}
+}
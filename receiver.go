@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+	"github.com/amenzhinsky/consul-slack/webhook"
+)
+
+// maxAlertSize limits bodies of received alerts.
+const maxAlertSize = 64 << 10
+
+// receivedAlert is an alert posted to the receiver by a script or another system.
+type receivedAlert struct {
+	Name       string            `json:"name"`
+	Status     string            `json:"status"`
+	Source     string            `json:"source"`
+	Node       string            `json:"node"`
+	Service    string            `json:"service"`
+	Tags       []string          `json:"tags"`
+	CheckID    string            `json:"check_id"`
+	Output     string            `json:"output"`
+	Notes      string            `json:"notes"`
+	Datacenter string            `json:"datacenter"`
+	Incident   string            `json:"incident"`
+	Meta       map[string]string `json:"meta"`
+}
+
+// event converts the alert into an event, check ids default
+// to names and statuses of alerts are compared by them.
+func (a *receivedAlert) event(now time.Time) (*consul.Event, error) {
+	if a.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	switch a.Status {
+	case consul.Passing, consul.Warning, consul.Critical:
+	default:
+		return nil, fmt.Errorf("status %q is not one of passing, warning or critical", a.Status)
+	}
+	ev := &consul.Event{
+		Node:        a.Node,
+		CheckID:     a.CheckID,
+		Name:        a.Name,
+		Status:      a.Status,
+		Output:      a.Output,
+		Notes:       a.Notes,
+		ServiceID:   a.Service,
+		ServiceName: a.Service,
+		ServiceTags: a.Tags,
+		Datacenter:  a.Datacenter,
+		Incident:    a.Incident,
+		Meta:        a.Meta,
+		Time:        now,
+	}
+	if ev.CheckID == "" {
+		ev.CheckID = a.Name
+	}
+	if ev.Node == "" {
+		ev.Node = a.Source
+	}
+	ev.ID = "receiver:" + a.Source + ":" + ev.Node + ":" + a.Service + ":" + ev.CheckID
+	return ev, nil
+}
+
+// receiver passes alerts posted over http through targets, only status
+// changes are delivered, the same way they are for consul checks.
+type receiver struct {
+	targets []*target
+	secret  []byte
+
+	mu     sync.Mutex
+	status map[string]string
+}
+
+func newReceiver(targets []*target, secret string) *receiver {
+	r := &receiver{targets: targets, status: map[string]string{}}
+	if secret != "" {
+		r.secret = []byte(secret)
+	}
+	return r
+}
+
+// change sets PrevStatus of the event to the last received status
+// of the alert, false is returned when the status is the same.
+func (r *receiver) change(ev *consul.Event) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev, ok := r.status[ev.ID]
+	if ok && prev == ev.Status {
+		return false
+	}
+	if ev.Status == consul.Passing {
+		delete(r.status, ev.ID)
+	} else {
+		r.status[ev.ID] = ev.Status
+	}
+	ev.PrevStatus = prev
+	return true
+}
+
+// forget drops the status of the event, so it's delivered again
+// when the sender retries an alert that hasn't been delivered.
+func (r *receiver) forget(ev *consul.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ev.PrevStatus == "" || ev.PrevStatus == consul.Passing {
+		delete(r.status, ev.ID)
+	} else {
+		r.status[ev.ID] = ev.PrevStatus
+	}
+}
+
+// ServeHTTP accepts a json alert with POST, when a secret is set the body
+// has to be signed the same way the webhook notifier signs it, responds
+// with 502 and errors of targets when the alert hasn't been delivered.
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxAlertSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if r.secret != nil && !hmac.Equal([]byte(req.Header.Get(webhook.SignatureHeader)), []byte(webhook.Sign(r.secret, b))) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "bad signature"})
+		return
+	}
+	var a receivedAlert
+	if err = json.Unmarshal(b, &a); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	ev, err := a.event(time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if !r.change(ev) {
+		writeJSON(w, http.StatusOK, map[string]string{"result": "unchanged"})
+		return
+	}
+	stats.inc("events", "status", ev.Status)
+
+	errs := map[string]string{}
+	dispatch(r.targets, ev, func(name string, err error) {
+		notifyError(name, err)
+		errs[name] = err.Error()
+	})
+	if len(errs) != 0 {
+		r.forget(ev)
+		writeJSON(w, http.StatusBadGateway, map[string]interface{}{"error": "not delivered", "targets": errs})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "sent"})
+}
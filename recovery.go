@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// modes of -recoveries
+const (
+	recoveriesAll      = "all"
+	recoveriesNotified = "notified"
+	recoveriesNone     = "none"
+)
+
+// recovery decides which recoveries of failing checks a target sends.
+type recovery struct {
+	mode string
+
+	mu sync.Mutex
+	// checks failing since they've been passing last, true when
+	// any of their failures got delivered, false when none did
+	failing map[string]bool
+}
+
+func newRecovery(mode string) (*recovery, error) {
+	switch mode {
+	case recoveriesAll, recoveriesNotified, recoveriesNone:
+	default:
+		return nil, fmt.Errorf("unknown recoveries mode %q", mode)
+	}
+	return &recovery{mode: mode, failing: map[string]bool{}}, nil
+}
+
+// isRecovery reports whether the event is about a failing check that's passing or gone now.
+func isRecovery(ev *consul.Event) bool {
+	return ev.Resolved() && (ev.PrevStatus == consul.Warning || ev.PrevStatus == consul.Critical)
+}
+
+// allow reports whether the event is sent, only recoveries can be held back.
+// In the notified mode recoveries of checks which failures have all been
+// filtered out or failed to deliver aren't sent, ones of checks which failures
+// weren't seen, e.g. before a restart, are sent as usual.
+func (r *recovery) allow(ev *consul.Event) bool {
+	if r == nil || !isRecovery(ev) {
+		return true
+	}
+	switch r.mode {
+	case recoveriesNone:
+		return false
+	case recoveriesNotified:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delivered, ok := r.failing[ev.Key()]
+		delete(r.failing, ev.Key())
+		return !ok || delivered
+	}
+	return true
+}
+
+// record records whether the failure has been delivered, reminders don't count.
+func (r *recovery) record(ev *consul.Event, delivered bool) {
+	if r == nil || r.mode != recoveriesNotified || !ev.Failing() || ev.Reminder != 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if delivered || !r.failing[ev.Key()] {
+		r.failing[ev.Key()] = delivered
+	}
+}
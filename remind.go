@@ -0,0 +1,152 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// reminders keeps track of critical checks to re-notify
+// about them every interval while they stay critical.
+type reminders struct {
+	interval time.Duration
+	policies bool // intervals services declare in meta fields apply, see parsePolicy
+
+	// intervals of priorities services declare, see -priority
+	priorities map[string]time.Duration
+
+	mu       sync.Mutex
+	critical map[string]*reminder
+}
+
+// reminder is a critical check waiting for the next reminder.
+type reminder struct {
+	ev       *consul.Event
+	count    int
+	interval time.Duration
+	next     time.Time
+}
+
+func newReminders(interval time.Duration) *reminders {
+	return &reminders{
+		interval: interval,
+		critical: map[string]*reminder{},
+	}
+}
+
+// track starts reminding about critical events and stops
+// when the check isn't critical anymore.
+func (r *reminders) track(ev *consul.Event, now time.Time) {
+	if ev.Reminder != 0 || ev.IsLock() {
+		return
+	}
+
+	interval := r.interval
+	if d := r.priorities[ev.Meta[priorityKey]]; d != 0 {
+		interval = d
+	}
+	if r.policies {
+		// invalid policies are reported when events are delivered
+		if p, _ := parsePolicy(ev.Meta); p.reminders != 0 {
+			interval = p.reminders
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ev.Status != consul.Critical || interval <= 0 {
+		delete(r.critical, ev.Key())
+		return
+	}
+	r.critical[ev.Key()] = &reminder{ev: ev, interval: interval, next: now.Add(interval)}
+}
+
+// due returns reminder events that are due at now.
+func (r *reminders) due(now time.Time) []*consul.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var evs []*consul.Event
+	for _, rem := range r.critical {
+		if now.Before(rem.next) {
+			continue
+		}
+		rem.count++
+		rem.next = now.Add(rem.interval)
+
+		ev := *rem.ev
+		ev.PrevStatus = consul.Critical
+		ev.Reminder = rem.count
+		ev.Time = now
+		evs = append(evs, &ev)
+	}
+	return evs
+}
+
+// silencer lists silenced services.
+type silencer interface {
+	Silences() (map[string]time.Time, error)
+}
+
+// acker lists acknowledged services.
+type acker interface {
+	Acks() (map[string]*consul.Ack, error)
+}
+
+// unacked returns events that aren't about acknowledged services,
+// all of them are returned when acks cannot be listed.
+func unacked(a acker, evs []*consul.Event) []*consul.Event {
+	if len(evs) == 0 {
+		return evs
+	}
+	acks, err := a.Acks()
+	if err != nil {
+		notifyError("acks", err)
+		return evs
+	}
+	var r []*consul.Event
+	for _, ev := range evs {
+		if _, ok := acks[ev.ServiceName]; ok && ev.ServiceName != "" {
+			continue
+		}
+		r = append(r, ev)
+	}
+	return r
+}
+
+// unsilenced returns events that aren't about silenced services,
+// all of them are returned when silences cannot be listed.
+func unsilenced(s silencer, evs []*consul.Event) []*consul.Event {
+	if len(evs) == 0 {
+		return evs
+	}
+	silences, err := s.Silences()
+	if err != nil {
+		notifyError("silences", err)
+		return evs
+	}
+	var r []*consul.Event
+	for _, ev := range evs {
+		if _, ok := silences[ev.ServiceName]; ok && ev.ServiceName != "" {
+			continue
+		}
+		r = append(r, ev)
+	}
+	return r
+}
+
+// selectTargets returns targets with the given names,
+// names of not configured notifiers are ignored.
+func selectTargets(targets []*target, names []string) []*target {
+	var r []*target
+	for _, t := range targets {
+		for _, name := range names {
+			if t.name == name {
+				r = append(r, t)
+				break
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// reporter is a notifier that can post the daily report.
+type reporter interface {
+	Report(r *consul.Report) error
+}
+
+// reportSource reports health of all watched checks.
+type reportSource interface {
+	Active() bool
+	Report() (*consul.Report, error)
+}
+
+// dailyReport posts the report to the targets every day at the given time
+// of day in loc until done is closed, standby instances post nothing.
+func dailyReport(c reportSource, targets []*target, at time.Duration, loc *time.Location, done <-chan struct{}) {
+	for {
+		t := time.NewTimer(time.Until(nextDaily(time.Now().In(loc), at)))
+		select {
+		case <-t.C:
+		case <-done:
+			t.Stop()
+			return
+		}
+		if !c.Active() {
+			continue
+		}
+		r, err := c.Report()
+		if err != nil {
+			notifyError("report", err)
+			continue
+		}
+		for _, t := range targets {
+			n, ok := t.notifier.(reporter)
+			if !ok {
+				continue
+			}
+			if err := n.Report(r); err != nil {
+				notifyError(t.label(), err)
+			}
+		}
+	}
+}
+
+// nextDaily returns the first time after now that's at the given time
+// of day in now's location, it's the same clock time across DST changes.
+func nextDaily(now time.Time, at time.Duration) time.Time {
+	h, m := int(at/time.Hour), int(at%time.Hour/time.Minute)
+	y, mon, d := now.Date()
+	next := time.Date(y, mon, d, h, m, 0, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(y, mon, d+1, h, m, 0, 0, now.Location())
+	}
+	return next
+}
+
+// weeklyReporter is a notifier that can post weekly statistics of incidents.
+type weeklyReporter interface {
+	WeeklyReport(s *consul.Stats) error
+}
+
+// parseWeekly parses DAY HH:MM, e.g. mon 09:00.
+func parseWeekly(s string) (time.Weekday, time.Duration, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("malformed %q, want DAY HH:MM", s)
+	}
+	day, ok := weekdays[fields[0]]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown day %q", fields[0])
+	}
+	at, err := parseClock(fields[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return day, at, nil
+}
+
+// weeklyReport posts statistics of incidents of the last week according to
+// the history file to the targets every week on the day at the given time of
+// day in loc until done is closed, standby instances post nothing.
+func weeklyReport(c roler, path string, targets []*target, day time.Weekday, at time.Duration, loc *time.Location, done <-chan struct{}) {
+	for {
+		t := time.NewTimer(time.Until(nextWeekly(time.Now().In(loc), day, at)))
+		select {
+		case <-t.C:
+		case <-done:
+			t.Stop()
+			return
+		}
+		if !c.Active() {
+			continue
+		}
+		s, err := weeklyStats(path, time.Now())
+		if err != nil {
+			notifyError("weekly report", err)
+			continue
+		}
+		for _, t := range targets {
+			n, ok := t.notifier.(weeklyReporter)
+			if !ok {
+				continue
+			}
+			if err := n.WeeklyReport(s); err != nil {
+				notifyError(t.label(), err)
+			}
+		}
+	}
+}
+
+// weeklyStats aggregates incidents of the week before now in the history file.
+func weeklyStats(path string, now time.Time) (*consul.Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	from := now.AddDate(0, 0, -7)
+	evs, err := readHistory(f, from)
+	if err != nil {
+		return nil, err
+	}
+	return consul.NewStats(evs, from, now), nil
+}
+
+// nextWeekly returns the first time after now that's on the day at
+// the given time of day in now's location, the same as nextDaily.
+func nextWeekly(now time.Time, day time.Weekday, at time.Duration) time.Time {
+	next := nextDaily(now, at)
+	for next.Weekday() != day {
+		next = nextDaily(next, at)
+	}
+	return next
+}
+
+// toChannel returns the targets posting to the channel instead of their
+// own ones, those that cannot post to other channels are kept as they are.
+func toChannel(targets []*target, channel string) ([]*target, error) {
+	if channel == "" {
+		return targets, nil
+	}
+	r := make([]*target, 0, len(targets))
+	for _, t := range targets {
+		if t.inChannel != nil {
+			n, err := t.inChannel(channel)
+			if err != nil {
+				return nil, err
+			}
+			t = &target{name: t.name, profile: t.profile, notifier: n}
+		}
+		r = append(r, t)
+	}
+	return r, nil
+}
@@ -0,0 +1,153 @@
+package rocketchat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Option is a configuration value.
+type Option func(r *RocketChat)
+
+// WithChannel overrides channel configured in the integration.
+func WithChannel(channel string) Option {
+	return func(r *RocketChat) {
+		r.channel = channel
+	}
+}
+
+// WithAlias sets name that messages are sent on behalf of.
+func WithAlias(alias string) Option {
+	return func(r *RocketChat) {
+		r.alias = alias
+	}
+}
+
+// WithAvatar sets avatar image url.
+func WithAvatar(url string) Option {
+	return func(r *RocketChat) {
+		r.avatar = url
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(r *RocketChat) {
+		r.logger = l
+	}
+}
+
+// New creates new rocket.chat incoming webhook client.
+func New(url string, opts ...Option) (*RocketChat, error) {
+	r := &RocketChat{
+		webhookURL: url,
+		logger:     log.New(os.Stdout, "[rocketchat] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+// RocketChat is a rocket.chat incoming webhook client.
+type RocketChat struct {
+	webhookURL string
+	channel    string
+	alias      string
+	avatar     string
+	logger     *log.Logger
+}
+
+// payload is data that is sent to the webhook url,
+// unlike slack it uses alias and avatar for the sender.
+type payload struct {
+	Channel     string       `json:"channel,omitempty"`
+	Alias       string       `json:"alias,omitempty"`
+	Avatar      string       `json:"avatar,omitempty"`
+	Attachments []attachment `json:"attachments"`
+}
+
+// attachment is a message container,
+// rocket.chat understands only css colors.
+type attachment struct {
+	Color string `json:"color,omitempty"`
+	Text  string `json:"text"`
+}
+
+// Colors matching slack's good, warning and danger.
+const (
+	Good    = "#2eb886"
+	Warning = "#daa038"
+	Danger  = "#a30200"
+)
+
+// Danger is equivalent of Send(Danger, ...)
+func (r *RocketChat) Danger(msg string, v ...interface{}) error {
+	return r.Send(Danger, msg, v...)
+}
+
+// Good is equivalent of Send(Good, ...)
+func (r *RocketChat) Good(msg string, v ...interface{}) error {
+	return r.Send(Good, msg, v...)
+}
+
+// Warning is equivalent of Send(Warning, ...)
+func (r *RocketChat) Warning(msg string, v ...interface{}) error {
+	return r.Send(Warning, msg, v...)
+}
+
+// Message is equivalent of Send("", ...), no color.
+func (r *RocketChat) Message(msg string, v ...interface{}) error {
+	return r.Send("", msg, v...)
+}
+
+// Send sends message to the webhook url.
+func (r *RocketChat) Send(color, msg string, v ...interface{}) error {
+	b, err := json.Marshal(&payload{
+		Channel: r.channel,
+		Alias:   r.alias,
+		Avatar:  r.avatar,
+		Attachments: []attachment{
+			{
+				Color: color,
+				Text:  fmt.Sprintf(msg, v...),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	r.infof("payload: %s", b)
+	res, err := http.Post(r.webhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	r.infof("response: %s", res.Status)
+
+	if res.StatusCode >= 400 {
+		return &ResponseError{res}
+	}
+	return nil
+}
+
+// infof prints a debug message.
+func (r *RocketChat) infof(format string, v ...interface{}) {
+	if r.logger != nil {
+		r.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("rocket.chat responded with %d status code", r.r.StatusCode)
+}
@@ -0,0 +1,38 @@
+package rocketchat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	var got payload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := New(ts.URL, WithAlias("Consul"), WithChannel("#ops"), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Danger("%s is critical", "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Alias != "Consul" || got.Channel != "#ops" {
+		t.Errorf("alias, channel = %q, %q, want %q, %q", got.Alias, got.Channel, "Consul", "#ops")
+	}
+	if len(got.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want 1", len(got.Attachments))
+	}
+	if a := got.Attachments[0]; a.Color != Danger || a.Text != "web is critical" {
+		t.Errorf("attachment = %+v", a)
+	}
+}
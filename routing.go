@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// kvRouting is routing configuration read from the KV with -routing-kv,
+// its rules apply before the targets' own ones and its filters along
+// with theirs, so routing can be changed without restarting.
+type kvRouting struct {
+	targets []*target
+
+	mu       sync.RWMutex
+	rules    []*rule
+	filters  filtersFlag
+	channels map[*target]map[string]notifier // channels only kv rules mention
+}
+
+// newKVRouting creates an empty configuration of the targets.
+func newKVRouting(targets []*target) *kvRouting {
+	r := &kvRouting{targets: targets, filters: filtersFlag{}}
+	for _, t := range targets {
+		t.kv = r
+	}
+	return r
+}
+
+// update replaces the configuration with the KV values, the rules and filters
+// keys are -rule and -filter values one per line, empty lines and ones starting
+// with # are skipped. The configuration is left as is when it's invalid.
+func (r *kvRouting) update(values map[string]string) error {
+	var rules rulesFlag
+	filters := filtersFlag{}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var f interface {
+			Set(s string) error
+		}
+		switch name {
+		case "rules":
+			f = &rules
+		case "filters":
+			f = filters
+		default:
+			return fmt.Errorf("unknown key %q, want rules or filters", name)
+		}
+		for _, line := range strings.Split(values[name], "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := f.Set(line); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	// notifiers of channels are created before anything is replaced
+	r.mu.RLock()
+	prev := r.channels
+	r.mu.RUnlock()
+	channels := map[*target]map[string]notifier{}
+	for _, t := range r.targets {
+		for _, rl := range rules {
+			if t.inChannel == nil || !rl.allows(t.name) {
+				continue
+			}
+			for _, channel := range rl.channelNames() {
+				if _, ok := t.channels[channel]; ok {
+					continue
+				}
+				n, ok := prev[t][channel]
+				if !ok {
+					var err error
+					if n, err = t.inChannel(channel); err != nil {
+						return fmt.Errorf("%s: channel %s: %v", t.label(), channel, err)
+					}
+				}
+				if channels[t] == nil {
+					channels[t] = map[string]notifier{}
+				}
+				channels[t][channel] = n
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules, r.filters, r.channels = rules, filters, channels
+	return nil
+}
+
+// route returns the kv rules, the filter and notifiers of channels of the target.
+func (r *kvRouting) route(t *target) ([]*rule, *filter, map[string]notifier) {
+	if r == nil {
+		return nil, nil, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.rules, r.filters[t.label()], r.channels[t]
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// rule routes events matching all of its conditions, the first
+// matching rule wins and events matching no rules are sent as usual.
+type rule struct {
+	spec string
+
+	// conditions, empty ones match anything
+	service  *regexp.Regexp
+	node     *regexp.Regexp
+	dc       *regexp.Regexp
+	tags     []string
+	meta     map[string]*regexp.Regexp // service meta fields
+	statuses map[string]bool
+	hours    *window        // days and hours of the week, nil matches any time
+	loc      *time.Location // timezone of hours, the local one when nil
+	expr     *expr          // replaces the other conditions when set
+
+	// actions
+	notifiers map[string]bool // nil allows all notifiers
+	channel   string
+	mention   string
+	suppress  bool
+	severity  string
+
+	// escalation of reminders, after either count reminders or
+	// the check has been critical for the duration, zero when off
+	escalateAfter   int
+	escalateFor     time.Duration
+	escalateChannel string
+	escalateMention string
+}
+
+// parseRule parses a CONDITIONS -> ACTIONS rule, conditions are service=REGEXP,
+// node=REGEXP, dc=REGEXP, meta.KEY=REGEXP matching whole values, tag=TAG, status=LIST
+// and days=DAYS with hours=HH:MM-HH:MM in tz=ZONE like maintenance windows, actions
+// are notifiers=LIST, channel=CHANNEL, mention=TEXT, severity=STATUS, suppress and
+// escalate=N|DURATION with escalate-channel=CHANNEL and escalate-mention=TEXT.
+// Conditions can be an expression instead, e.g. 'if event.Node.startsWith("db") -> ...'.
+func parseRule(s string) (*rule, error) {
+	parts := strings.SplitN(s, "->", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed rule %q, want CONDITIONS -> ACTIONS", s)
+	}
+	r := &rule{spec: s}
+
+	conds := strings.Fields(parts[0])
+	if len(conds) != 0 && conds[0] == "if" {
+		var err error
+		cond := strings.TrimSpace(parts[0])
+		if r.expr, err = parseExpr(cond[len("if"):]); err != nil {
+			return nil, fmt.Errorf("rule %q: %v", s, err)
+		}
+		conds = nil
+	}
+	for _, f := range conds {
+		i := strings.IndexByte(f, '=')
+		if i < 1 {
+			return nil, fmt.Errorf("malformed condition %q in rule %q, want KEY=VALUE", f, s)
+		}
+		key, val := f[:i], f[i+1:]
+		var err error
+		switch key {
+		case "service":
+			r.service, err = regexp.Compile("^(?:" + val + ")$")
+		case "node":
+			r.node, err = regexp.Compile("^(?:" + val + ")$")
+		case "dc":
+			r.dc, err = regexp.Compile("^(?:" + val + ")$")
+		case "tag":
+			r.tags = append(r.tags, val)
+		case "meta.":
+			err = fmt.Errorf("condition %q has no meta key", f)
+		case "status":
+			r.statuses = map[string]bool{}
+			for _, status := range strings.Split(val, ",") {
+				r.statuses[status] = true
+			}
+		case "days":
+			r.hoursOrAll().days, err = parseDays(val)
+		case "hours":
+			w := r.hoursOrAll()
+			w.start, w.end, err = parseClockRange(val)
+		case "tz":
+			r.loc, err = time.LoadLocation(val)
+		default:
+			if !strings.HasPrefix(key, "meta.") {
+				err = fmt.Errorf("unknown condition %q", key)
+				break
+			}
+			if r.meta == nil {
+				r.meta = map[string]*regexp.Regexp{}
+			}
+			r.meta[key[len("meta."):]], err = regexp.Compile("^(?:" + val + ")$")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", s, err)
+		}
+	}
+
+	actions := strings.Fields(parts[1])
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("rule %q has no actions", s)
+	}
+	for _, f := range actions {
+		if f == "suppress" {
+			r.suppress = true
+			continue
+		}
+		i := strings.IndexByte(f, '=')
+		if i < 1 {
+			return nil, fmt.Errorf("malformed action %q in rule %q, want KEY=VALUE or suppress", f, s)
+		}
+		key, val := f[:i], f[i+1:]
+		switch key {
+		case "notifiers":
+			r.notifiers = map[string]bool{}
+			for _, name := range splitList(val) {
+				r.notifiers[name] = true
+			}
+		case "channel":
+			r.channel = val
+		case "mention":
+			r.mention = val
+		case "severity":
+			if val != consul.Warning && val != consul.Critical {
+				return nil, fmt.Errorf("rule %q: severity %q is neither warning nor critical", s, val)
+			}
+			r.severity = val
+		case "escalate":
+			if n, err := strconv.Atoi(val); err == nil && n > 0 {
+				r.escalateAfter = n
+			} else if d, err := time.ParseDuration(val); err == nil && d > 0 {
+				r.escalateFor = d
+			} else {
+				return nil, fmt.Errorf("rule %q: escalate %q is neither a positive number of reminders nor a duration", s, val)
+			}
+		case "escalate-channel":
+			r.escalateChannel = val
+		case "escalate-mention":
+			r.escalateMention = val
+		default:
+			return nil, fmt.Errorf("rule %q: unknown action %q", s, key)
+		}
+	}
+	if (r.escalateChannel != "" || r.escalateMention != "") && r.escalateAfter == 0 && r.escalateFor == 0 {
+		return nil, fmt.Errorf("rule %q: escalate-channel and escalate-mention need escalate", s)
+	}
+	return r, nil
+}
+
+// hoursOrAll returns the days and hours condition,
+// it's created matching any time when it's not set yet.
+func (r *rule) hoursOrAll() *window {
+	if r.hours == nil {
+		r.hours = &window{}
+		for i := range r.hours.days {
+			r.hours.days[i] = true
+		}
+	}
+	return r.hours
+}
+
+// match reports whether the event meets all conditions of the rule,
+// days and hours are matched against the time of the event.
+func (r *rule) match(ev *consul.Event) bool {
+	if r.expr != nil {
+		return r.expr.match(ev)
+	}
+	if r.hours != nil {
+		t := ev.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		if r.loc != nil {
+			t = t.In(r.loc)
+		}
+		if !r.hours.active(t) {
+			return false
+		}
+	}
+	if r.service != nil && !r.service.MatchString(ev.ServiceName) {
+		return false
+	}
+	if r.node != nil && !r.node.MatchString(ev.Node) {
+		return false
+	}
+	if r.dc != nil && !r.dc.MatchString(ev.Datacenter) {
+		return false
+	}
+	if r.statuses != nil && !r.statuses[ev.Status] {
+		return false
+	}
+	for k, re := range r.meta {
+		if !re.MatchString(ev.Meta[k]) {
+			return false
+		}
+	}
+	for _, tag := range r.tags {
+		found := false
+		for _, t := range ev.ServiceTags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// allows reports whether the event goes to the named notifier.
+func (r *rule) allows(name string) bool {
+	return !r.suppress && (r.notifiers == nil || r.notifiers[name])
+}
+
+// escalates reports whether the event is a reminder sent after escalate
+// reminders or about a check that's been critical for the escalate duration.
+func (r *rule) escalates(ev *consul.Event) bool {
+	if ev.Reminder == 0 {
+		return false
+	}
+	if r.escalateAfter > 0 && ev.Reminder > r.escalateAfter {
+		return true
+	}
+	return r.escalateFor > 0 && !ev.CriticalSince.IsZero() && ev.Time.Sub(ev.CriticalSince) >= r.escalateFor
+}
+
+// channelOf returns the channel the event is routed to,
+// the escalation one for escalated reminders when it's set.
+func (r *rule) channelOf(ev *consul.Event) string {
+	if r.escalateChannel != "" && r.escalates(ev) {
+		return r.escalateChannel
+	}
+	return r.channel
+}
+
+// channelNames returns channels the rule routes events to.
+func (r *rule) channelNames() []string {
+	var names []string
+	for _, c := range []string{r.channel, r.escalateChannel} {
+		if c != "" {
+			names = append(names, c)
+		}
+	}
+	return names
+}
+
+// apply returns a copy of the event with the rule's severity and mention,
+// escalated reminders are marked so and get the escalation mention.
+func (r *rule) apply(ev *consul.Event) *consul.Event {
+	e := *ev
+	if r.severity != "" && (e.Status == consul.Warning || e.Status == consul.Critical) {
+		e.Status = r.severity
+	}
+	if r.mention != "" {
+		e.Mention = r.mention
+	}
+	if r.escalates(ev) {
+		e.Escalated = true
+		if r.escalateMention != "" {
+			e.Mention = r.escalateMention
+		}
+	}
+	return &e
+}
+
+// findRule returns the first rule matching the event, nil when there's none.
+func findRule(rules []*rule, ev *consul.Event) *rule {
+	for _, r := range rules {
+		if r.match(ev) {
+			return r
+		}
+	}
+	return nil
+}
+
+// ruleMetaKeys returns service meta keys rules, filters and middleware of the targets
+// match events on and owners are read from, they have to be looked up for every event.
+func ruleMetaKeys(targets []*target) []string {
+	seen := map[string]bool{}
+	for _, t := range targets {
+		for _, r := range t.rules {
+			for k := range r.meta {
+				seen[k] = true
+			}
+			if r.expr != nil {
+				for _, k := range r.expr.metaKeys {
+					seen[k] = true
+				}
+			}
+		}
+		if t.owners != nil {
+			seen[t.owners.key] = true
+		}
+		if t.policies != nil {
+			for _, k := range policyKeys {
+				seen[k] = true
+			}
+		}
+		if t.filter != nil && t.filter.expr != nil {
+			for _, k := range t.filter.expr.metaKeys {
+				seen[k] = true
+			}
+		}
+		for _, st := range t.steps {
+			if st.cond != nil {
+				for _, k := range st.cond.metaKeys {
+					seen[k] = true
+				}
+			}
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rulesFlag is a repeatable routing rule command-line flag.
+type rulesFlag []*rule
+
+func (f *rulesFlag) String() string {
+	return ""
+}
+
+func (f *rulesFlag) Set(s string) error {
+	r, err := parseRule(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
+
+// tagChannelFlag is a repeatable TAG=CHANNEL command-line flag, every mapping
+// is a shorthand for the `tag=TAG -> channel=CHANNEL` rule added in its place,
+// so channels follow service ownership expressed with tags in the catalog.
+type tagChannelFlag struct{}
+
+func (tagChannelFlag) String() string {
+	return ""
+}
+
+func (tagChannelFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 1 || i == len(s)-1 || strings.ContainsAny(s, " \t") {
+		return fmt.Errorf("malformed tag channel %q, want TAG=CHANNEL", s)
+	}
+	return routingRulesFlag.Set("tag=" + s[:i] + " -> channel=" + s[i+1:])
+}
+
+// applyRules makes targets route events with the rules,
+// it creates notifiers of all channels the rules mention.
+func applyRules(targets []*target, rules []*rule) error {
+	for _, t := range targets {
+		t.rules = rules
+		for _, r := range rules {
+			if t.inChannel == nil || !r.allows(t.name) {
+				continue
+			}
+			for _, channel := range r.channelNames() {
+				if _, ok := t.channels[channel]; ok {
+					continue
+				}
+				n, err := t.inChannel(channel)
+				if err != nil {
+					return err
+				}
+				if t.channels == nil {
+					t.channels = map[string]notifier{}
+				}
+				t.channels[channel] = n
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/amenzhinsky/consul-slack/vault"
+)
+
+// secretFlags are flags with credentials, their values can
+// refer to files and vault secrets, see resolveSecrets.
+var secretFlags = []string{
+	"vault-token", // goes first, vault secrets are read with it
+	"consul-token",
+	"consul-password",
+	"slack-webhook-url",
+	"slack-token",
+	"slack-signing-secret",
+	"telegram-token",
+	"webhook-secret",
+	"opsgenie-api-key",
+	"victorops-api-key",
+	"rocketchat-webhook-url",
+	"jira-token",
+	"github-token",
+	"servicenow-password",
+	"receiver-secret",
+	"sql-dsn",
+}
+
+// secretReader reads fields of vault secrets.
+type secretReader interface {
+	Read(path, field string) (*vault.Secret, error)
+}
+
+// vaultClient reads secrets of vault: flag values, nil when there are none.
+var vaultClient *vault.Client
+
+// newVault creates vaultClient of -vault-address and -vault-token.
+func newVault() (secretReader, error) {
+	var err error
+	vaultClient, err = vault.New(vaultAddressFlag, vaultTokenFlag, vault.WithLogger(debugLogger("[vault] ")))
+	if err != nil {
+		return nil, err
+	}
+	return vaultClient, nil
+}
+
+// resolveSecrets replaces file:PATH values of the named flags with contents
+// of the file and vault:PATH#FIELD ones with the field of the vault secret,
+// so credentials don't leak through process listings and shell history.
+// The vault client is created with newVault once it's needed.
+func resolveSecrets(fs *flag.FlagSet, names []string, newVault func() (secretReader, error)) error {
+	var r secretReader
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			continue
+		}
+		s := f.Value.String()
+		var val string
+		switch {
+		case strings.HasPrefix(s, "file:"):
+			b, err := ioutil.ReadFile(s[len("file:"):])
+			if err != nil {
+				return fmt.Errorf("-%s: %v", name, err)
+			}
+			val = strings.TrimSpace(string(b))
+		case strings.HasPrefix(s, "vault:"):
+			if name == "vault-token" {
+				return fmt.Errorf("-%s cannot be read from vault", name)
+			}
+			i := strings.LastIndexByte(s, '#')
+			if i < len("vault:")+1 || i == len(s)-1 {
+				return fmt.Errorf("-%s: malformed vault secret %q, want vault:PATH#FIELD", name, s)
+			}
+			if r == nil {
+				var err error
+				if r, err = newVault(); err != nil {
+					return err
+				}
+			}
+			secret, err := r.Read(s[len("vault:"):i], s[i+1:])
+			if err != nil {
+				return fmt.Errorf("-%s: %v", name, err)
+			}
+			val = secret.Value
+		default:
+			continue
+		}
+		if err := fs.Set(name, val); err != nil {
+			return fmt.Errorf("-%s: %v", name, err)
+		}
+	}
+	return nil
+}
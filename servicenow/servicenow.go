@@ -0,0 +1,221 @@
+package servicenow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(s *ServiceNow)
+
+// WithUrgency sets incident urgency 1-3, 1 is high.
+func WithUrgency(urgency string) Option {
+	return func(s *ServiceNow) {
+		s.urgency = urgency
+	}
+}
+
+// WithImpact sets incident impact 1-3, 1 is high.
+func WithImpact(impact string) Option {
+	return func(s *ServiceNow) {
+		s.impact = impact
+	}
+}
+
+// WithAssignmentGroup sets incident assignment group.
+func WithAssignmentGroup(group string) Option {
+	return func(s *ServiceNow) {
+		s.assignmentGroup = group
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(s *ServiceNow) {
+		s.logger = l
+	}
+}
+
+// New creates new servicenow table api client, instanceURL
+// is like https://company.service-now.com.
+func New(instanceURL, user, password string, opts ...Option) (*ServiceNow, error) {
+	if instanceURL == "" {
+		return nil, fmt.Errorf("servicenow: instance url is empty")
+	}
+	s := &ServiceNow{
+		url:      strings.TrimRight(instanceURL, "/") + "/api/now/table/incident",
+		user:     user,
+		password: password,
+		urgency:  "1",
+		impact:   "2",
+		open:     map[string]string{},
+		logger:   log.New(os.Stdout, "[servicenow] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for _, v := range []string{s.urgency, s.impact} {
+		if v != "1" && v != "2" && v != "3" {
+			return nil, fmt.Errorf("servicenow: urgency and impact must be 1, 2 or 3, got %q", v)
+		}
+	}
+	return s, nil
+}
+
+// ServiceNow creates incidents for critical checks and resolves them on recovery.
+type ServiceNow struct {
+	url             string
+	user            string
+	password        string
+	urgency         string
+	impact          string
+	assignmentGroup string
+	logger          *log.Logger
+
+	mu   sync.Mutex
+	open map[string]string // correlation id -> sys_id
+}
+
+// incident is a subset of incident table fields.
+type incident struct {
+	SysID            string `json:"sys_id,omitempty"`
+	ShortDescription string `json:"short_description,omitempty"`
+	Description      string `json:"description,omitempty"`
+	Urgency          string `json:"urgency,omitempty"`
+	Impact           string `json:"impact,omitempty"`
+	CorrelationID    string `json:"correlation_id,omitempty"`
+	AssignmentGroup  string `json:"assignment_group,omitempty"`
+	State            string `json:"state,omitempty"`
+	CloseCode        string `json:"close_code,omitempty"`
+	CloseNotes       string `json:"close_notes,omitempty"`
+}
+
+// resolved is the incident state "Resolved".
+const resolved = "6"
+
+// Notify creates an incident for critical events
+// and resolves it when the check is passing again.
+func (s *ServiceNow) Notify(ev *consul.Event) error {
+	cid := "consul-slack:" + ev.Key()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sysID, err := s.find(cid)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case ev.Status == consul.Critical && sysID == "":
+		var res struct {
+			Result incident `json:"result"`
+		}
+		if err = s.do("POST", s.url, &incident{
+			ShortDescription: fmt.Sprintf("[%s] %s is critical", ev.Node, ev.ServiceID),
+			Description:      fmt.Sprintf("Notes: %s\nOutput: %s", ev.Notes, ev.Output),
+			Urgency:          s.urgency,
+			Impact:           s.impact,
+			CorrelationID:    cid,
+			AssignmentGroup:  s.assignmentGroup,
+		}, &res); err != nil {
+			return err
+		}
+		s.open[cid] = res.Result.SysID
+		s.infof("incident %s created for %s", res.Result.SysID, cid)
+	case ev.Resolved() && sysID != "":
+		if err = s.do("PATCH", s.url+"/"+url.PathEscape(sysID), &incident{
+			State:      resolved,
+			CloseCode:  "Solved (Permanently)",
+			CloseNotes: fmt.Sprintf("[%s] %s is back to normal\nOutput: %s", ev.Node, ev.ServiceID, ev.Output),
+		}, nil); err != nil {
+			return err
+		}
+		delete(s.open, cid)
+		s.infof("incident %s resolved", sysID)
+	}
+	return nil
+}
+
+// find returns sys_id of the active incident with the given correlation id,
+// the instance is queried when it's unknown locally e.g. after a restart.
+func (s *ServiceNow) find(cid string) (string, error) {
+	if sysID, ok := s.open[cid]; ok {
+		return sysID, nil
+	}
+
+	var res struct {
+		Result []incident `json:"result"`
+	}
+	q := url.Values{}
+	q.Set("sysparm_query", "active=true^correlation_id="+cid)
+	q.Set("sysparm_fields", "sys_id")
+	q.Set("sysparm_limit", "1")
+	if err := s.do("GET", s.url+"?"+q.Encode(), nil, &res); err != nil {
+		return "", err
+	}
+	if len(res.Result) == 0 {
+		return "", nil
+	}
+	s.open[cid] = res.Result[0].SysID
+	return res.Result[0].SysID, nil
+}
+
+// do performs an api request with json encoded in and out bodies.
+func (s *ServiceNow) do(method, url string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(s.user, s.password)
+
+	s.infof("%s %s", method, url)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	if out != nil {
+		return json.NewDecoder(r.Body).Decode(out)
+	}
+	return nil
+}
+
+// infof prints a debug message.
+func (s *ServiceNow) infof(format string, v ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("servicenow responded with %d status code", r.r.StatusCode)
+}
@@ -0,0 +1,52 @@
+package servicenow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var created, updated incident
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"result":[]}`))
+		case "POST":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(`{"result":{"sys_id":"abc"}}`))
+		case "PATCH":
+			if r.URL.Path != "/api/now/table/incident/abc" {
+				t.Errorf("path = %q", r.URL.Path)
+			}
+			if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := New(ts.URL, "admin", "secret", WithUrgency("2"), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{consul.Critical, consul.Passing} {
+		if err = s.Notify(&consul.Event{Node: "n1", ServiceID: "web", ID: "n1:web", Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if created.Urgency != "2" || created.Impact != "2" || created.CorrelationID != "consul-slack:n1:web" {
+		t.Errorf("created = %+v", created)
+	}
+	if updated.State != resolved {
+		t.Errorf("State = %q, want %q", updated.State, resolved)
+	}
+}
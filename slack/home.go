@@ -0,0 +1,135 @@
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSectionText limits text of a section block.
+const maxSectionText = 3000
+
+// block is a section block of a view with mrkdwn text.
+type block struct {
+	Type string    `json:"type"`
+	Text *textItem `json:"text,omitempty"`
+}
+
+type textItem struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// view is the App Home view.
+type view struct {
+	Type   string  `json:"type"`
+	Blocks []block `json:"blocks"`
+}
+
+// PublishHome publishes the mrkdwn text to the App Home tab of the user
+// with views.publish, the text is split into section blocks by lines.
+// It needs the bot token and the App Home to be enabled for the app.
+func (s *Slack) PublishHome(user, text string) error {
+	if s.token == "" {
+		return errors.New("slack: token is required to publish home tabs")
+	}
+	b, err := json.Marshal(&struct {
+		UserID string `json:"user_id"`
+		View   view   `json:"view"`
+	}{
+		UserID: user,
+		View:   view{Type: "home", Blocks: sections(text)},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.apiURL+"/views.publish", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	s.infof("publish home: %s", b)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	s.infof("response: %s", r.Status)
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+
+	var res struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return err
+	}
+	if !res.OK {
+		return fmt.Errorf("slack: publish home of %s: %s", user, res.Error)
+	}
+	return nil
+}
+
+// sections splits text into section blocks by lines,
+// lines longer than a section allows are truncated.
+func sections(text string) []block {
+	var (
+		blocks []block
+		cur    []string
+		n      int
+	)
+	flush := func() {
+		if len(cur) != 0 {
+			blocks = append(blocks, block{
+				Type: "section",
+				Text: &textItem{Type: "mrkdwn", Text: strings.Join(cur, "\n")},
+			})
+		}
+		cur, n = nil, 0
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if len(line) > maxSectionText {
+			line = line[:maxSectionText]
+		}
+		if n+len(line)+1 > maxSectionText {
+			flush()
+		}
+		cur = append(cur, line)
+		n += len(line) + 1
+	}
+	flush()
+	return blocks
+}
+
+// maxRequestAge is how old signed requests of slack can be.
+const maxRequestAge = 5 * time.Minute
+
+// Verify checks the signature of the request slack sent to the app
+// with the signing secret, it fails for requests older than 5 minutes.
+func Verify(secret []byte, h http.Header, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(h.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return errors.New("slack: malformed request timestamp")
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > maxRequestAge || d < -maxRequestAge {
+		return errors.New("slack: request is too old")
+	}
+	m := hmac.New(sha256.New, secret)
+	fmt.Fprintf(m, "v0:%d:%s", ts, body)
+	if !hmac.Equal([]byte(h.Get("X-Slack-Signature")), []byte("v0="+hex.EncodeToString(m.Sum(nil)))) {
+		return errors.New("slack: bad signature")
+	}
+	return nil
+}
@@ -2,11 +2,15 @@ package slack
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/tracing"
 )
 
 // Option is a configuration value.
@@ -44,6 +48,7 @@ func WithLogger(l *log.Logger) Option {
 func New(url string, opts ...Option) (*Slack, error) {
 	s := &Slack{
 		webhookURL: url,
+		apiURL:     "https://slack.com/api",
 		username:   "webhooker",
 		channel:    "webhooks",
 		logger:     log.New(os.Stdout, "[slack] ", log.LstdFlags),
@@ -61,6 +66,12 @@ type Slack struct {
 	username   string
 	iconURL    string
 	logger     *log.Logger
+
+	apiURL  string
+	token   string
+	mu      sync.Mutex
+	users   map[string]cachedUser // looked up users by email
+	threads map[string]string     // parent message timestamps by thread key
 }
 
 // payload is data that is sent to the webhook url.
@@ -68,6 +79,7 @@ type payload struct {
 	Channel     string       `json:"channel"`
 	Username    string       `json:"username"`
 	IconURL     string       `json:"icon_url"`
+	ThreadTS    string       `json:"thread_ts,omitempty"` // chat.postMessage only
 	Attachments []attachment `json:"attachments"`
 }
 
@@ -99,6 +111,12 @@ func (s *Slack) Message(msg string, v ...interface{}) error {
 
 // Send sends message to the webhook url.
 func (s *Slack) Send(color, msg string, v ...interface{}) error {
+	return s.SendContext(context.Background(), color, msg, v...)
+}
+
+// SendContext is Send that makes the request with ctx,
+// it's traced when ctx carries a span, see tracing.Transport.
+func (s *Slack) SendContext(ctx context.Context, color, msg string, v ...interface{}) error {
 	b, err := json.Marshal(&payload{
 		Channel:  s.channel,
 		Username: s.username,
@@ -116,10 +134,16 @@ func (s *Slack) Send(color, msg string, v ...interface{}) error {
 	}
 
 	s.infof("payload: %s", b)
-	r, err := http.Post(s.webhookURL, "application/json", bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.webhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r, err := tracing.Client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer r.Body.Close()
 	s.infof("response: %s", r.Status)
 
 	if r.StatusCode >= 400 {
@@ -1,11 +1,14 @@
 package slack
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -45,3 +48,146 @@ func TestNew(t *testing.T) {
 		t.Fatal("http callback hasn't been called")
 	}
 }
+
+func TestLookupByEmail(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer xoxb-1" {
+			w.Write([]byte(`{"ok":false,"error":"invalid_auth"}`))
+			return
+		}
+		switch r.URL.Query().Get("email") {
+		case "jane@corp.com":
+			w.Write([]byte(`{"ok":true,"user":{"id":"U024BE7LH"}}`))
+		default:
+			w.Write([]byte(`{"ok":false,"error":"users_not_found"}`))
+		}
+	}))
+	defer ts.Close()
+
+	s, err := New(ts.URL, WithToken("xoxb-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.apiURL = ts.URL
+	for i := 0; i < 2; i++ {
+		if id, err := s.LookupByEmail("jane@corp.com"); err != nil || id != "U024BE7LH" {
+			t.Errorf("LookupByEmail = %q, %v, want U024BE7LH", id, err)
+		}
+		if id, err := s.LookupByEmail("bob@corp.com"); err != nil || id != "" {
+			t.Errorf("LookupByEmail = %q, %v, want no user", id, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("api called %d times, want 2 with cached results", calls)
+	}
+
+	s.token = "xoxb-2"
+	if _, err := s.LookupByEmail("alice@corp.com"); err == nil {
+		t.Error("LookupByEmail expected to fail with an invalid token")
+	}
+}
+
+func TestSendThread(t *testing.T) {
+	t.Parallel()
+
+	var posted []payload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		posted = append(posted, p)
+		fmt.Fprintf(w, `{"ok":true,"ts":"1503435956.%06d"}`, len(posted))
+	}))
+	defer ts.Close()
+
+	s, err := New(ts.URL, WithToken("xoxb-1"), WithChannel("#consul"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.apiURL = ts.URL
+	for _, start := range []bool{false, false, true, false} {
+		if err = s.SendThread("i1", start, "danger", "web is critical"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.EndThread("i1")
+	if err = s.SendThread("i1", false, "good", "web is back to normal"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, p := range posted {
+		got = append(got, p.ThreadTS)
+	}
+	want := []string{"", "1503435956.000001", "", "1503435956.000003", ""}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("thread timestamps = %q, want %q", got, want)
+	}
+}
+
+func TestPublishHome(t *testing.T) {
+	t.Parallel()
+
+	var req struct {
+		UserID string `json:"user_id"`
+		View   view   `json:"view"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/views.publish" || r.Header.Get("Authorization") != "Bearer xoxb-1" {
+			w.Write([]byte(`{"ok":false,"error":"invalid_auth"}`))
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer ts.Close()
+
+	s, err := New(ts.URL, WithToken("xoxb-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.apiURL = ts.URL
+	text := "*2 checks are failing*\n" + strings.Repeat("x", 2000) + "\n" + strings.Repeat("y", 2000)
+	if err = s.PublishHome("U1", text); err != nil {
+		t.Fatal(err)
+	}
+	if req.UserID != "U1" || req.View.Type != "home" || len(req.View.Blocks) != 2 {
+		t.Fatalf("request = %+v, want U1's home with 2 blocks", req)
+	}
+	if got := req.View.Blocks[0].Text.Text + "\n" + req.View.Blocks[1].Text.Text; got != text {
+		t.Errorf("blocks text = %q, want %q", got, text)
+	}
+
+	s.token = "xoxb-2"
+	if err = s.PublishHome("U1", text); err == nil {
+		t.Error("PublishHome expected to fail with an invalid token")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	// the example of the slack documentation
+	body := []byte("token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c")
+	h := http.Header{}
+	h.Set("X-Slack-Request-Timestamp", "1531420618")
+	h.Set("X-Slack-Signature", "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503")
+	secret := []byte("8f742231b10e8888abcd99yyyzzz85a5")
+	now := time.Unix(1531420618, 0)
+	if err := Verify(secret, h, body, now); err != nil {
+		t.Errorf("Verify = %v, want nil", err)
+	}
+	if err := Verify(secret, h, body, now.Add(10*time.Minute)); err == nil {
+		t.Error("Verify expected to fail for an old request")
+	}
+	if err := Verify([]byte("other"), h, body, now); err == nil {
+		t.Error("Verify expected to fail with another secret")
+	}
+}
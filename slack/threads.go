@@ -0,0 +1,97 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/amenzhinsky/consul-slack/tracing"
+)
+
+// SendThread posts the message to the thread with the key. When start
+// is true it's posted to the channel and becomes the thread's parent,
+// otherwise it's a reply or the parent when the thread isn't known yet,
+// e.g. after a restart. Messages are posted with chat.postMessage since
+// webhooks don't tell where they end up, so WithToken is required.
+func (s *Slack) SendThread(key string, start bool, color, msg string, v ...interface{}) error {
+	return s.SendThreadContext(context.Background(), key, start, color, msg, v...)
+}
+
+// SendThreadContext is SendThread that makes the request with ctx,
+// it's traced when ctx carries a span, see tracing.Transport.
+func (s *Slack) SendThreadContext(ctx context.Context, key string, start bool, color, msg string, v ...interface{}) error {
+	if s.token == "" {
+		return errors.New("slack: token is required to post to threads")
+	}
+	s.mu.Lock()
+	ts := s.threads[key]
+	s.mu.Unlock()
+	if start {
+		ts = ""
+	}
+
+	b, err := json.Marshal(&payload{
+		Channel:  s.channel,
+		Username: s.username,
+		IconURL:  s.iconURL,
+		ThreadTS: ts,
+		Attachments: []attachment{
+			{
+				Color: color,
+				Text:  fmt.Sprintf(msg, v...),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.apiURL+"/chat.postMessage", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	s.infof("payload: %s", b)
+	r, err := tracing.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	s.infof("response: %s", r.Status)
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+
+	var res struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		TS    string `json:"ts"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return err
+	}
+	if !res.OK {
+		return fmt.Errorf("slack: post message: %s", res.Error)
+	}
+	if ts == "" {
+		s.mu.Lock()
+		if s.threads == nil {
+			s.threads = map[string]string{}
+		}
+		s.threads[key] = res.TS
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// EndThread forgets the thread with the key,
+// the next message sent to it starts a new one.
+func (s *Slack) EndThread(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.threads, key)
+}
@@ -0,0 +1,80 @@
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// userTTL is how long looked up users are cached for.
+const userTTL = time.Hour
+
+// WithToken sets the bot token web api calls like LookupByEmail
+// are authorized with, it needs the users:read.email scope.
+func WithToken(token string) Option {
+	return func(s *Slack) {
+		s.token = token
+	}
+}
+
+// cachedUser is a looked up user id, empty when there's no such user.
+type cachedUser struct {
+	id      string
+	expires time.Time
+}
+
+// LookupByEmail returns id of the user with the email, it's empty when
+// there's no such user. Results are cached for an hour, failures aren't.
+func (s *Slack) LookupByEmail(email string) (string, error) {
+	if s.token == "" {
+		return "", errors.New("slack: token is required to look up users")
+	}
+	now := time.Now()
+	s.mu.Lock()
+	u, ok := s.users[email]
+	s.mu.Unlock()
+	if ok && now.Before(u.expires) {
+		return u.id, nil
+	}
+
+	req, err := http.NewRequest("GET", s.apiURL+"/users.lookupByEmail?email="+url.QueryEscape(email), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	s.infof("lookup user: %s", email)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	s.infof("response: %s", r.Status)
+	if r.StatusCode >= 400 {
+		return "", &ResponseError{r}
+	}
+
+	var res struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		User  struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if !res.OK && res.Error != "users_not_found" {
+		return "", fmt.Errorf("slack: lookup %s: %s", email, res.Error)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = map[string]cachedUser{}
+	}
+	s.users[email] = cachedUser{id: res.User.ID, expires: now.Add(userTTL)}
+	return res.User.ID, nil
+}
@@ -0,0 +1,248 @@
+package sns
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(s *SNS)
+
+// WithCredentials sets aws credentials, by default they're taken from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables.
+func WithCredentials(accessKeyID, secretAccessKey, sessionToken string) Option {
+	return func(s *SNS) {
+		s.accessKeyID = accessKeyID
+		s.secretAccessKey = secretAccessKey
+		s.sessionToken = sessionToken
+	}
+}
+
+// WithEndpoint overrides sns endpoint url that is derived from the topic region.
+func WithEndpoint(endpoint string) Option {
+	return func(s *SNS) {
+		s.endpoint = endpoint
+	}
+}
+
+// WithAttribute adds a static message attribute to every published message.
+func WithAttribute(name, value string) Option {
+	return func(s *SNS) {
+		s.attrs[name] = value
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(s *SNS) {
+		s.logger = l
+	}
+}
+
+// New creates new sns client publishing to the given topic arn.
+func New(topicARN string, opts ...Option) (*SNS, error) {
+	// arn:aws:sns:us-east-1:123456789012:topic
+	parts := strings.Split(topicARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" {
+		return nil, fmt.Errorf("sns: malformed topic arn %q", topicARN)
+	}
+
+	s := &SNS{
+		topicARN:        topicARN,
+		region:          parts[3],
+		endpoint:        "https://sns." + parts[3] + ".amazonaws.com/",
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		attrs:           map[string]string{},
+		logger:          log.New(os.Stdout, "[sns] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.accessKeyID == "" || s.secretAccessKey == "" {
+		return nil, fmt.Errorf("sns: aws credentials are not set")
+	}
+	return s, nil
+}
+
+// SNS publishes events to an aws sns topic.
+type SNS struct {
+	topicARN        string
+	region          string
+	endpoint        string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	attrs           map[string]string
+	logger          *log.Logger
+}
+
+// Notify publishes the event as json with status,
+// service, node and dc message attributes.
+func (s *SNS) Notify(ev *consul.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	attrs := map[string]string{
+		"status":  ev.Status,
+		"service": ev.ServiceName,
+		"node":    ev.Node,
+	}
+	if ev.Datacenter != "" {
+		attrs["dc"] = ev.Datacenter
+	}
+	for k, v := range s.attrs {
+		attrs[k] = v
+	}
+	return s.Publish(fmt.Sprintf("[%s] %s is %s", ev.Node, ev.ServiceID, ev.Status), string(b), attrs)
+}
+
+// Publish publishes message to the topic.
+func (s *SNS) Publish(subject, message string, attrs map[string]string) error {
+	v := url.Values{}
+	v.Set("Action", "Publish")
+	v.Set("Version", "2010-03-31")
+	v.Set("TopicArn", s.topicARN)
+	v.Set("Subject", truncate(subject, 100))
+	v.Set("Message", message)
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		if attrs[name] != "" { // empty values are rejected by sns
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		prefix := "MessageAttributes.entry." + strconv.Itoa(i+1)
+		v.Set(prefix+".Name", name)
+		v.Set(prefix+".Value.DataType", "String")
+		v.Set(prefix+".Value.StringValue", attrs[name])
+	}
+
+	body := v.Encode()
+	req, err := http.NewRequest("POST", s.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	s.sign(req, body, time.Now().UTC())
+
+	s.infof("publish: %s", subject)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	s.infof("response: %s", r.Status)
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	return nil
+}
+
+// sign signs the request with aws signature version 4.
+func (s *SNS) sign(req *http.Request, body string, now time.Time) {
+	amzdate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzdate)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if s.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+
+	var canonical []string
+	canonical = append(canonical, req.Method, path(req.URL), req.URL.RawQuery)
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonical = append(canonical, h+":"+strings.TrimSpace(v))
+	}
+	canonical = append(canonical, "", strings.Join(headers, ";"), hexsum(body))
+
+	scope := date + "/" + s.region + "/sns/aws4_request"
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzdate,
+		scope,
+		hexsum(strings.Join(canonical, "\n")),
+	}, "\n")
+
+	key := hmacsum([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacsum(key, s.region)
+	key = hmacsum(key, "sns")
+	key = hmacsum(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, strings.Join(headers, ";"), hex.EncodeToString(hmacsum(key, toSign)),
+	))
+}
+
+func path(u *url.URL) string {
+	if u.EscapedPath() == "" {
+		return "/"
+	}
+	return u.EscapedPath()
+}
+
+func hexsum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacsum(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+// truncate cuts s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// infof prints a debug message.
+func (s *SNS) infof(format string, v ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("sns responded with %d status code", r.r.StatusCode)
+}
@@ -0,0 +1,64 @@
+package sns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/eu-west-1/sns/aws4_request") {
+			t.Errorf("unexpected Authorization header %q", auth)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range map[string]string{
+			"Action":                         "Publish",
+			"TopicArn":                       "arn:aws:sns:eu-west-1:123456789012:consul",
+			"MessageAttributes.entry.1.Name": "dc",
+			"MessageAttributes.entry.1.Value.StringValue": "dc1",
+			"MessageAttributes.entry.4.Name":              "status",
+			"MessageAttributes.entry.4.Value.StringValue": "critical",
+		} {
+			if got := r.PostForm.Get(k); got != v {
+				t.Errorf("%s = %q, want %q", k, got, v)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := New("arn:aws:sns:eu-west-1:123456789012:consul",
+		WithCredentials("AKID", "SECRET", ""),
+		WithEndpoint(ts.URL),
+		WithAttribute("dc", "dc1"),
+		WithLogger(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Notify(&consul.Event{
+		Node:        "n1",
+		ServiceID:   "web",
+		ServiceName: "web",
+		Status:      consul.Critical,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewMalformedARN(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New("consul", WithCredentials("AKID", "SECRET", "")); err == nil {
+		t.Fatal("expected malformed arn error")
+	}
+}
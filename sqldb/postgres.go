@@ -0,0 +1,7 @@
+//go:build postgres
+// +build postgres
+
+package sqldb
+
+// registers the postgres driver
+import _ "github.com/lib/pq"
@@ -0,0 +1,132 @@
+// Package sqldb writes status changes to a table of a SQL database for
+// reliability analytics. It works with any database/sql driver, the vendored
+// postgres one is linked in with the postgres build tag.
+package sqldb
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// DefaultTable is the table events are written to by default.
+const DefaultTable = "consul_slack_events"
+
+// columns of the table in the order of insert arguments.
+var columns = []string{
+	"time", "cluster", "datacenter", "node", "service_id", "service_name", "check_id",
+	"check_name", "status", "prev_status", "incident", "critical_since", "output_hash",
+}
+
+// tableRegexp is what table names may look like, they cannot be query arguments.
+var tableRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Option is a configuration value.
+type Option func(d *DB)
+
+// WithTable sets the table name, it may be qualified with a schema.
+func WithTable(name string) Option {
+	return func(d *DB) {
+		d.table = name
+	}
+}
+
+// New opens the database with the driver and dsn
+// and creates the table when it doesn't exist.
+func New(driver, dsn string, opts ...Option) (*DB, error) {
+	d := &DB{table: DefaultTable}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if !tableRegexp.MatchString(d.table) {
+		return nil, fmt.Errorf("sqldb: malformed table name %q", d.table)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(createQuery(d.table)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	d.db, d.insert = db, insertQuery(driver, d.table)
+	return d, nil
+}
+
+// DB is a notifier that writes events to the database.
+type DB struct {
+	db     *sql.DB
+	table  string
+	insert string
+}
+
+// createQuery returns the statement creating the table, types are the
+// ones both postgres and sqlite understand, times are stored in UTC.
+func createQuery(table string) string {
+	return "CREATE TABLE IF NOT EXISTS " + table + ` (
+	time TIMESTAMP NOT NULL,
+	cluster VARCHAR(255) NOT NULL,
+	datacenter VARCHAR(255) NOT NULL,
+	node VARCHAR(255) NOT NULL,
+	service_id VARCHAR(255) NOT NULL,
+	service_name VARCHAR(255) NOT NULL,
+	check_id VARCHAR(255) NOT NULL,
+	check_name VARCHAR(255) NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	prev_status VARCHAR(32) NOT NULL,
+	incident VARCHAR(32) NOT NULL,
+	critical_since TIMESTAMP NULL,
+	output_hash VARCHAR(64) NOT NULL
+)`
+}
+
+// insertQuery returns the statement inserting an event,
+// postgres drivers take numbered placeholders, others ?.
+func insertQuery(driver, table string) string {
+	args := make([]string, len(columns))
+	for i := range args {
+		if driver == "postgres" || driver == "pgx" {
+			args[i] = fmt.Sprintf("$%d", i+1)
+		} else {
+			args[i] = "?"
+		}
+	}
+	return "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(args, ", ") + ")"
+}
+
+// Notify writes the status change or the output change to the table, the
+// output is stored as its sha256 hash, reminders and lock events are skipped.
+func (d *DB) Notify(ev *consul.Event) error {
+	for _, ev := range ev.Ungroup() {
+		if ev.Reminder != 0 || ev.IsLock() {
+			continue
+		}
+		t := ev.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		var since interface{}
+		if !ev.CriticalSince.IsZero() {
+			since = ev.CriticalSince.UTC()
+		}
+		sum := sha256.Sum256([]byte(ev.Output))
+		if _, err := d.db.Exec(d.insert, t.UTC(), ev.Cluster, ev.Datacenter, ev.Node, ev.ServiceID,
+			ev.ServiceName, ev.CheckID, ev.Name, ev.Status, ev.PrevStatus, ev.Incident, since,
+			hex.EncodeToString(sum[:])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
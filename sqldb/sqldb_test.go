@@ -0,0 +1,117 @@
+package sqldb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// recorder is a driver recording executed statements with their arguments.
+type recorder struct {
+	mu    sync.Mutex
+	execs []string
+}
+
+func (r *recorder) Open(dsn string) (driver.Conn, error) {
+	return &conn{r: r}, nil
+}
+
+type conn struct {
+	r *recorder
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{r: c.r, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type stmt struct {
+	r     *recorder
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.execs = append(s.r.execs, fmt.Sprintf("%s %v", s.query, args))
+	return driver.RowsAffected(1), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+var rec = &recorder{}
+
+func init() {
+	sql.Register("sqldbtest", rec)
+}
+
+func TestNotify(t *testing.T) {
+	d, err := New("sqldbtest", "", WithTable("events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	at := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	ev := &consul.Event{Datacenter: "dc1", Node: "n1", ServiceID: "web", ServiceName: "web", CheckID: "service:web",
+		Name: "http", Status: consul.Critical, PrevStatus: consul.Passing, Output: "timeout", Time: at, CriticalSince: at,
+		Grouped: []*consul.Event{{Datacenter: "dc1", Node: "n1", CheckID: "mem", Name: "mem", Status: consul.Warning, Time: at}}}
+	for _, ev := range []*consul.Event{ev, {Status: consul.LockAcquired}, {Status: consul.Critical, Reminder: 1}} {
+		if err = d.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.execs) != 3 || !strings.HasPrefix(rec.execs[0], "CREATE TABLE IF NOT EXISTS events (") {
+		t.Fatalf("execs = %q, want the table created and two inserts", rec.execs)
+	}
+	insert := "INSERT INTO events (time, cluster, datacenter, node, service_id, service_name, check_id, check_name, " +
+		"status, prev_status, incident, critical_since, output_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) "
+	for i, want := range []string{
+		insert + "[2017-09-01 12:00:00 +0000 UTC  dc1 n1 web web service:web http critical passing  2017-09-01 12:00:00 +0000 UTC " +
+			"f77d1bb58da886e3cbeebbf35a0b3d217b003506792268052c6a730fbc5ec9bc]",
+		insert + "[2017-09-01 12:00:00 +0000 UTC  dc1 n1   mem mem warning   <nil> e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855]",
+	} {
+		if got := rec.execs[i+1]; got != want {
+			t.Errorf("insert %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("sqldbtest", "", WithTable("events; DROP TABLE users")); err == nil {
+		t.Error("New with a malformed table name expected to fail")
+	}
+	if _, err := New("unknown", ""); err == nil {
+		t.Error("New with an unknown driver expected to fail")
+	}
+	if q := insertQuery("postgres", "public.events"); !strings.HasSuffix(q, "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)") {
+		t.Errorf("postgres insert = %q, want numbered placeholders", q)
+	}
+}
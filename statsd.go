@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsdInterval is the interval counters are flushed to statsd at.
+const statsdInterval = 10 * time.Second
+
+// statsdPacketSize is the size statsd lines are batched into packets
+// up to, it fits into the usual 1500 bytes ethernet mtu.
+const statsdPacketSize = 1432
+
+// statsd sends counters to a statsd server as increments since the
+// previous flush, with dogstatsd labels become tags, otherwise their
+// values are appended to metric names, e.g. consul_slack.events.critical.
+type statsd struct {
+	w         io.Writer
+	prefix    string
+	dogstatsd bool
+	tags      []string // dogstatsd tags of every metric
+	last      map[string]uint64
+}
+
+// newStatsd creates a statsd client sending metrics to the udp address.
+func newStatsd(addr, prefix string, dogstatsd bool, tags []string) (*statsd, error) {
+	if len(tags) != 0 && !dogstatsd {
+		return nil, errors.New("statsd tags require the dogstatsd format")
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsd{w: conn, prefix: prefix, dogstatsd: dogstatsd, tags: tags, last: map[string]uint64{}}, nil
+}
+
+// flush sends increments of counters that have changed since the previous flush.
+func (s *statsd) flush(cs []counter) error {
+	var b bytes.Buffer
+	for _, c := range cs {
+		key := c.name + "\x00" + strings.Join(c.labels, "\x00")
+		delta := c.value - s.last[key]
+		if delta == 0 {
+			continue
+		}
+		line := s.line(c, delta)
+		if b.Len() != 0 && b.Len()+1+len(line) > statsdPacketSize {
+			if _, err := s.w.Write(b.Bytes()); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+		if b.Len() != 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+		s.last[key] = c.value
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	_, err := s.w.Write(b.Bytes())
+	return err
+}
+
+// statsdEscaper replaces characters that have special meaning in statsd lines.
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_", " ", "_")
+
+// line formats the counter increment.
+func (s *statsd) line(c counter, delta uint64) string {
+	name := c.name
+	if s.prefix != "" {
+		name = s.prefix + "." + name
+	}
+	if !s.dogstatsd {
+		for i := 1; i < len(c.labels); i += 2 {
+			name += "." + strings.Replace(statsdEscaper.Replace(c.labels[i]), ".", "_", -1)
+		}
+		return fmt.Sprintf("%s:%d|c", name, delta)
+	}
+
+	tags := append([]string{}, s.tags...)
+	for i := 0; i < len(c.labels); i += 2 {
+		tags = append(tags, c.labels[i]+":"+statsdEscaper.Replace(c.labels[i+1]))
+	}
+	line := fmt.Sprintf("%s:%d|c", name, delta)
+	if len(tags) != 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// reportStats flushes counters to statsd every interval until done
+// is closed, the last increments are flushed on return.
+func reportStats(s *statsd, c *counters, n sourceCounter, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-done:
+			if err := s.flush(c.snapshot(n)); err != nil {
+				notifyError("statsd", err)
+			}
+			return
+		}
+		if err := s.flush(c.snapshot(n)); err != nil {
+			notifyError("statsd", err)
+		}
+	}
+}
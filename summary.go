@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// summarizer is a notifier that can post a summary of failing checks.
+type summarizer interface {
+	Summary(evs []*consul.Event) error
+}
+
+// failingLister lists currently failing checks.
+type failingLister interface {
+	Active() bool
+	Failing() ([]*consul.Event, error)
+}
+
+// summarize posts a summary of failing checks to the targets every
+// interval until done is closed, standby instances post nothing.
+func summarize(c failingLister, targets []*target, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-done:
+			return
+		}
+		if !c.Active() {
+			continue
+		}
+		evs, err := c.Failing()
+		if err != nil {
+			notifyError("summary", err)
+			continue
+		}
+		sendSummary(targets, evs)
+	}
+}
+
+// sendSummary posts the summary to all targets that support it.
+func sendSummary(targets []*target, evs []*consul.Event) {
+	for _, t := range targets {
+		s, ok := t.notifier.(summarizer)
+		if !ok {
+			continue
+		}
+		if err := s.Summary(evs); err != nil {
+			notifyError(t.label(), err)
+		}
+	}
+}
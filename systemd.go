@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// systemd implements the sd_notify protocol, readiness is reported with
+// the first heartbeat and the watchdog is petted on every heartbeat
+// not more often than half of its interval.
+type systemd struct {
+	addr     string        // notify socket, empty when not run by systemd
+	watchdog time.Duration // zero when the watchdog is disabled
+
+	mu     sync.Mutex
+	ready  bool
+	active bool
+	last   time.Time
+}
+
+// newSystemd creates a notifier configured by the environment systemd
+// sets for Type=notify services, getenv is usually os.Getenv.
+func newSystemd(getenv func(string) string) *systemd {
+	s := &systemd{addr: getenv("NOTIFY_SOCKET")}
+	if pid := getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return s // the watchdog is meant for another process
+	}
+	if usec, err := strconv.ParseInt(getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		s.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	return s
+}
+
+// heartbeat is a consul heartbeat function.
+func (s *systemd) heartbeat(active bool) {
+	if s.addr == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var state string
+	if !s.ready || s.active != active {
+		status := "standby"
+		if active {
+			status = "active"
+		}
+		state = "READY=1\nSTATUS=" + status + "\n"
+	}
+	now := time.Now()
+	if s.watchdog > 0 && now.Sub(s.last) >= s.watchdog/2 {
+		state += "WATCHDOG=1\n"
+		s.last = now
+	}
+	if state == "" {
+		return
+	}
+	if err := s.notify(state); err != nil {
+		notifyError("systemd", err)
+		return
+	}
+	s.ready, s.active = true, active
+}
+
+// notify sends the state to the notify socket.
+func (s *systemd) notify(state string) error {
+	addr := s.addr
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
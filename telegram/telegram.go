@@ -0,0 +1,568 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/catalog"
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Parse modes supported by the Bot API.
+const (
+	HTML     = "HTML"
+	Markdown = "Markdown"
+)
+
+// Option is a configuration option.
+type Option func(t *Telegram)
+
+// WithParseMode sets message formatting mode, HTML or Markdown.
+func WithParseMode(mode string) Option {
+	return func(t *Telegram) {
+		t.parseMode = mode
+	}
+}
+
+// WithAPIURL sets bot api base url, useful for proxies and testing.
+func WithAPIURL(url string) Option {
+	return func(t *Telegram) {
+		t.apiURL = strings.TrimRight(url, "/")
+	}
+}
+
+// WithTimestamps appends times events have been detected at
+// to messages rendered with fn, e.g. in a specific timezone.
+func WithTimestamps(fn func(t time.Time) string) Option {
+	return func(t *Telegram) {
+		t.timestamp = fn
+	}
+}
+
+// WithStatusPrefixes prepends texts to messages about events
+// with the given statuses, e.g. "🔴 CRITICAL".
+func WithStatusPrefixes(prefixes map[string]string) Option {
+	return func(t *Telegram) {
+		t.prefixes = prefixes
+	}
+}
+
+// WithCatalog translates messages and status words with c.
+func WithCatalog(c catalog.Catalog) Option {
+	return func(t *Telegram) {
+		t.c = c
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(t *Telegram) {
+		t.logger = l
+	}
+}
+
+// New creates new telegram bot client that posts to the given chat.
+func New(token, chatID string, opts ...Option) (*Telegram, error) {
+	if token == "" {
+		return nil, fmt.Errorf("telegram: token is empty")
+	}
+	if chatID == "" {
+		return nil, fmt.Errorf("telegram: chat id is empty")
+	}
+
+	t := &Telegram{
+		token:     token,
+		chatID:    chatID,
+		apiURL:    "https://api.telegram.org",
+		parseMode: HTML,
+		logger:    log.New(os.Stdout, "[telegram] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	switch t.parseMode {
+	case HTML, Markdown:
+	default:
+		return nil, fmt.Errorf("telegram: unknown parse mode %q", t.parseMode)
+	}
+	return t, nil
+}
+
+// Telegram is a telegram bot api client.
+type Telegram struct {
+	token     string
+	chatID    string
+	apiURL    string
+	parseMode string
+	timestamp func(t time.Time) string // nil when timestamps are disabled
+	prefixes  map[string]string        // status prefixes, see WithStatusPrefixes
+	c         catalog.Catalog          // nil when messages are in english
+	logger    *log.Logger
+}
+
+// payload is the sendMessage request body.
+type payload struct {
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"`
+}
+
+// Notify formats the event and sends it to the chat.
+func (t *Telegram) Notify(ev *consul.Event) error {
+	text := t.format(ev)
+	if p := t.prefixes[ev.Status]; p != "" {
+		text = t.escape(p) + " " + text
+	}
+	if ev.Mention != "" {
+		text = t.escape(ev.Mention) + " " + text
+	}
+	return t.Send(text)
+}
+
+// NotifyGroup sends status changes of checks of the same node
+// as a single message, the first event is the most severe of them.
+func (t *Telegram) NotifyGroup(evs []*consul.Event) error {
+	lead := evs[0]
+	head := fmt.Sprintf(t.c.T("[%s] %d checks changed"), t.location(lead), len(evs))
+
+	var b bytes.Buffer
+	for _, s := range []string{lead.Mention, t.prefixes[lead.Status]} {
+		if s != "" {
+			b.WriteString(t.escape(s) + " ")
+		}
+	}
+	switch t.parseMode {
+	case Markdown:
+		b.WriteString(markdownEntity("*", head))
+	default:
+		b.WriteString("<b>" + html.EscapeString(head) + "</b>")
+	}
+	for _, ev := range evs {
+		line := t.subject(ev) + " " + t.describe(ev.Status)
+		if ev.PrevStatus != "" {
+			line += fmt.Sprintf(t.c.T(" (was %s)"), t.c.T(ev.PrevStatus))
+		}
+		switch t.parseMode {
+		case Markdown:
+			fmt.Fprintf(&b, "\n%s", escapeMarkdown(line))
+			if ev.Output != "" {
+				fmt.Fprintf(&b, "\n```\n%s\n```", strings.Replace(ev.Output, "```", "'''", -1))
+			}
+		default:
+			fmt.Fprintf(&b, "\n%s", html.EscapeString(line))
+			if ev.Output != "" {
+				fmt.Fprintf(&b, "\n<pre>%s</pre>", html.EscapeString(ev.Output))
+			}
+		}
+	}
+	if t.timestamp != nil && !lead.Time.IsZero() {
+		switch t.parseMode {
+		case Markdown:
+			fmt.Fprintf(&b, "\n_%s:_ %s", t.c.T("Time"), escapeMarkdown(t.timestamp(lead.Time)))
+		default:
+			fmt.Fprintf(&b, "\n<i>%s:</i> %s", t.c.T("Time"), html.EscapeString(t.timestamp(lead.Time)))
+		}
+	}
+	return t.Send(b.String())
+}
+
+// Send sends a preformatted text message to the chat.
+func (t *Telegram) Send(text string) error {
+	b, err := json.Marshal(&payload{
+		ChatID:    t.chatID,
+		Text:      text,
+		ParseMode: t.parseMode,
+	})
+	if err != nil {
+		return err
+	}
+
+	t.infof("payload: %s", b)
+	r, err := http.Post(t.apiURL+"/bot"+t.token+"/sendMessage", "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	t.infof("response: %s", r.Status)
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	return nil
+}
+
+// Summary sends the list of failing checks to the chat.
+func (t *Telegram) Summary(evs []*consul.Event) error {
+	var b bytes.Buffer
+	switch {
+	case len(evs) == 0:
+		b.WriteString(t.c.T("All checks are passing"))
+	case t.parseMode == Markdown:
+		fmt.Fprintf(&b, "*"+t.c.T("%d checks are failing")+"*", len(evs))
+	default:
+		fmt.Fprintf(&b, "<b>"+t.c.T("%d checks are failing")+"</b>", len(evs))
+	}
+	t.writeSummaryLines(&b, evs)
+	return t.Send(b.String())
+}
+
+// Report sends the daily report, it's all clear when nothing is failing.
+func (t *Telegram) Report(r *consul.Report) error {
+	var b bytes.Buffer
+	head := fmt.Sprintf(t.c.T("All clear: all %d checks are passing in %s"), r.Total(), r.Locations())
+	if len(r.Failing) != 0 {
+		head = fmt.Sprintf(t.c.T("Daily report: %d of %d checks are failing in %s"), len(r.Failing), r.Total(), r.Locations())
+	}
+	switch t.parseMode {
+	case Markdown:
+		b.WriteString(markdownEntity("*", head))
+	case HTML:
+		b.WriteString("<b>" + html.EscapeString(head) + "</b>")
+	}
+	t.writeSummaryLines(&b, r.Failing)
+	return t.Send(b.String())
+}
+
+// maxOutageLines limits the number of checks listed in outage messages.
+const maxOutageLines = 30
+
+// Outage sends the rolled-up message about the outage that started at since
+// listing failing checks, over is true once it's over and alerts resume.
+func (t *Telegram) Outage(since time.Time, evs []*consul.Event, over bool) error {
+	at := since.Format(time.RFC3339)
+	if t.timestamp != nil {
+		at = t.timestamp(since)
+	}
+	var head string
+	switch {
+	case !over:
+		head = fmt.Sprintf(t.c.T("Outage since %s: %d checks are failing, alerts about them are held"), at, len(evs))
+	case len(evs) == 0:
+		head = fmt.Sprintf(t.c.T("Outage since %s is over, all checks are passing"), at)
+	default:
+		head = fmt.Sprintf(t.c.T("Outage since %s is over, alerts resume: %d checks are still failing"), at, len(evs))
+	}
+	var b bytes.Buffer
+	switch t.parseMode {
+	case Markdown:
+		b.WriteString(markdownEntity("*", head))
+	case HTML:
+		b.WriteString("<b>" + html.EscapeString(head) + "</b>")
+	}
+	if len(evs) > maxOutageLines {
+		t.writeSummaryLines(&b, evs[:maxOutageLines])
+		b.WriteString("\n" + fmt.Sprintf(t.c.T("and %d more"), len(evs)-maxOutageLines))
+	} else {
+		t.writeSummaryLines(&b, evs)
+	}
+	return t.Send(b.String())
+}
+
+// writeSummaryLines writes a line about every failing check.
+func (t *Telegram) writeSummaryLines(b *bytes.Buffer, evs []*consul.Event) {
+	for _, ev := range evs {
+		line := fmt.Sprintf(t.c.T("[%s] %s is %s"), t.location(ev), t.subject(ev), t.c.T(ev.Status))
+		b.WriteString("\n" + t.escape(line))
+	}
+}
+
+// maxWeeklyLines limits the number of services and checks listed in weekly reports.
+const maxWeeklyLines = 5
+
+// WeeklyReport sends statistics of incidents of the week, worst offenders
+// with their mean times to recovery and between failures and flappiest checks.
+func (t *Telegram) WeeklyReport(s *consul.Stats) error {
+	period := s.From.Format("Jan 2") + " - " + s.To.Format("Jan 2")
+	head := fmt.Sprintf(t.c.T("Weekly report for %s: no incidents"), period)
+	if s.Incidents != 0 || len(s.Flappy) != 0 {
+		head = fmt.Sprintf(t.c.T("Weekly report for %s: %d incidents, mean time to recovery %s"),
+			period, s.Incidents, consul.Minutes(s.MTTR))
+	}
+	var lines []string
+	if len(s.Services) != 0 {
+		lines = append(lines, t.c.T("Worst offenders:"))
+	}
+	for i, svc := range s.Services {
+		if i == maxWeeklyLines {
+			break
+		}
+		lines = append(lines, fmt.Sprintf(t.c.T("%s: %d incidents, down for %s, MTTR %s, MTBF %s"),
+			svc.Service, svc.Incidents, consul.Minutes(svc.Downtime), consul.Minutes(svc.MTTR), consul.Minutes(svc.MTBF)))
+	}
+	if len(s.Flappy) != 0 {
+		lines = append(lines, t.c.T("Flappiest checks:"))
+	}
+	for i, c := range s.Flappy {
+		if i == maxWeeklyLines {
+			break
+		}
+		lines = append(lines, fmt.Sprintf(t.c.T("[%s] %s %s: %d status changes"), c.Location, c.Service, c.Check, c.Changes))
+	}
+
+	var b bytes.Buffer
+	switch t.parseMode {
+	case Markdown:
+		b.WriteString(markdownEntity("*", head))
+	case HTML:
+		b.WriteString("<b>" + html.EscapeString(head) + "</b>")
+	}
+	for _, line := range lines {
+		b.WriteString("\n" + t.escape(line))
+	}
+	return t.Send(b.String())
+}
+
+// Suppressed sends the list of alerts suppressed during the maintenance window.
+func (t *Telegram) Suppressed(window string, evs []*consul.Event) error {
+	var b bytes.Buffer
+	head := fmt.Sprintf(t.c.T("Suppressed %d alerts during maintenance window %s"), len(evs), window)
+	switch t.parseMode {
+	case Markdown:
+		b.WriteString(markdownEntity("*", head))
+	case HTML:
+		b.WriteString("<b>" + html.EscapeString(head) + "</b>")
+	}
+	for _, ev := range evs {
+		line := fmt.Sprintf(t.c.T("[%s] %s is %s"), ev.Location(), t.subject(ev), t.c.T(ev.Status))
+		b.WriteString("\n" + t.escape(line))
+	}
+	return t.Send(b.String())
+}
+
+// Heartbeat sends the heartbeat message.
+func (t *Telegram) Heartbeat(msg string) error {
+	return t.Send(t.escape(msg))
+}
+
+// Overflow sends the number of events held by the rate limit.
+func (t *Telegram) Overflow(n int) error {
+	return t.Send(fmt.Sprintf(t.c.T("%d more events suppressed by the rate limit, see consul-slack status"), n))
+}
+
+// format renders the event according to the configured parse mode.
+func (t *Telegram) format(ev *consul.Event) string {
+	var b bytes.Buffer
+	was := ""
+	if ev.PrevStatus != "" {
+		was = fmt.Sprintf(t.c.T(" (was %s)"), t.c.T(ev.PrevStatus))
+	}
+
+	node := t.location(ev)
+	subject := t.subject(ev)
+	if ev.IsLock() {
+		subject = "consul-slack"
+	}
+
+	status := t.describe(ev.Status)
+	d := ev.CriticalFor()
+	if d != "" && ev.PrevStatus == consul.Critical {
+		was = fmt.Sprintf(t.c.T(" (was critical for %s)"), d)
+	}
+	if ev.PrevStatus == ev.Status {
+		was = t.c.T(" (output changed)")
+	}
+	if ev.Reminder != 0 {
+		status = t.c.T("is still critical")
+		if d != "" {
+			status = fmt.Sprintf(t.c.T("is still critical for %s"), d)
+		}
+		status += fmt.Sprintf(t.c.T(" (reminder #%d)"), ev.Reminder)
+		was = ""
+	}
+
+	deps, depsLabel := "", t.c.T("Affected dependents")
+	if len(ev.Dependents) != 0 {
+		deps = strings.Join(ev.Dependents, ", ")
+	}
+	if ev.IsNode() {
+		depsLabel = t.c.T("Affected services")
+	}
+	status, was = t.escape(status), t.escape(was) // unknown statuses are described as is
+	notes, acked, incident, at := t.c.T("Notes"), t.c.T("Acknowledged by"), t.c.T("Incident"), t.c.T("Time")
+	timeline, tl := t.c.T("Timeline"), t.timeline(ev)
+
+	switch t.parseMode {
+	case Markdown:
+		fmt.Fprintf(&b, "%s %s%s", markdownEntity("*", "["+node+"] "+subject), status, was)
+		if deps != "" {
+			fmt.Fprintf(&b, "\n_%s:_ %s", depsLabel, escapeMarkdown(deps))
+		}
+		if ev.Notes != "" {
+			fmt.Fprintf(&b, "\n_%s:_ %s", notes, escapeMarkdown(ev.Notes))
+		}
+		if ev.Output != "" {
+			fmt.Fprintf(&b, "\n```\n%s\n```", strings.Replace(ev.Output, "```", "'''", -1))
+		}
+		if tl != "" {
+			fmt.Fprintf(&b, "\n_%s:_ %s", timeline, tl)
+		}
+		if ev.Ack != nil {
+			fmt.Fprintf(&b, "\n_%s_ %s", acked, escapeMarkdown(t.ackLine(ev.Ack)))
+		}
+		for _, k := range metaKeys(ev.Meta) {
+			fmt.Fprintf(&b, "\n%s %s", markdownEntity("_", k+":"), escapeMarkdown(ev.Meta[k]))
+		}
+		if ev.Incident != "" {
+			fmt.Fprintf(&b, "\n_%s:_ %s", incident, ev.Incident)
+		}
+		if t.timestamp != nil && !ev.Time.IsZero() {
+			fmt.Fprintf(&b, "\n_%s:_ %s", at, escapeMarkdown(t.timestamp(ev.Time)))
+		}
+	default:
+		fmt.Fprintf(&b, "<b>[%s] %s</b> %s%s",
+			html.EscapeString(node), html.EscapeString(subject), status, was)
+		if deps != "" {
+			fmt.Fprintf(&b, "\n<i>%s:</i> %s", depsLabel, html.EscapeString(deps))
+		}
+		if ev.Notes != "" {
+			fmt.Fprintf(&b, "\n<i>%s:</i> %s", notes, html.EscapeString(ev.Notes))
+		}
+		if ev.Output != "" {
+			fmt.Fprintf(&b, "\n<pre>%s</pre>", html.EscapeString(ev.Output))
+		}
+		if tl != "" {
+			fmt.Fprintf(&b, "\n<i>%s:</i> %s", timeline, tl)
+		}
+		if ev.Ack != nil {
+			fmt.Fprintf(&b, "\n<i>%s</i> %s", acked, html.EscapeString(t.ackLine(ev.Ack)))
+		}
+		for _, k := range metaKeys(ev.Meta) {
+			fmt.Fprintf(&b, "\n<i>%s:</i> %s", html.EscapeString(k), html.EscapeString(ev.Meta[k]))
+		}
+		if ev.Incident != "" {
+			fmt.Fprintf(&b, "\n<i>%s:</i> %s", incident, ev.Incident)
+		}
+		if t.timestamp != nil && !ev.Time.IsZero() {
+			fmt.Fprintf(&b, "\n<i>%s:</i> %s", at, html.EscapeString(t.timestamp(ev.Time)))
+		}
+	}
+	return b.String()
+}
+
+// markdownEscaper escapes characters starting entities in Markdown.
+var markdownEscaper = strings.NewReplacer("_", `\_`, "*", `\*`, "[", `\[`, "`", "\\`")
+
+// escapeMarkdown escapes s placed outside of Markdown entities.
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// markdownEntity wraps s in the entity of mark, e.g. * for bold. Entities
+// cannot contain escapes, so marks in s close it, are escaped and reopen it.
+func markdownEntity(mark, s string) string {
+	return mark + strings.Replace(s, mark, mark+`\`+mark+mark, -1) + mark
+}
+
+// escape escapes s according to the configured parse mode.
+func (t *Telegram) escape(s string) string {
+	if t.parseMode == Markdown {
+		return escapeMarkdown(s)
+	}
+	return html.EscapeString(s)
+}
+
+// timeline summarizes the incident the event resolves,
+// e.g. failing for 1h5m, 2 flaps, empty when it has no timeline.
+func (t *Telegram) timeline(ev *consul.Event) string {
+	d := ev.FailingFor()
+	if d == "" {
+		return ""
+	}
+	parts := []string{fmt.Sprintf(t.c.T("failing for %s"), d)}
+	if ev.Timeline.Flaps != 0 {
+		parts = append(parts, fmt.Sprintf(t.c.T("%d flaps"), ev.Timeline.Flaps))
+	}
+	if ev.Timeline.Outputs != 0 {
+		parts = append(parts, fmt.Sprintf(t.c.T("%d output changes"), ev.Timeline.Outputs))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ackLine returns who and when acknowledged the service with the comment.
+func (t *Telegram) ackLine(ack *consul.Ack) string {
+	at := ack.Time.Format(time.RFC3339)
+	if t.timestamp != nil {
+		at = t.timestamp(ack.Time)
+	}
+	line := fmt.Sprintf(t.c.T("%s at %s"), ack.By, at)
+	if ack.Comment != "" {
+		line += ": " + ack.Comment
+	}
+	return line
+}
+
+// metaKeys returns sorted keys of service meta fields.
+func metaKeys(meta map[string]string) []string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// describe returns human readable status description.
+func (t *Telegram) describe(status string) string {
+	switch status {
+	case consul.Passing:
+		return t.c.T("is back to normal")
+	case consul.Warning:
+		return t.c.T("is having problems")
+	case consul.Critical:
+		return t.c.T("is critical")
+	case consul.Maintenance:
+		return t.c.T("is under maintenance")
+	case consul.Added:
+		return t.c.T("has been registered")
+	case consul.Deleted:
+		return t.c.T("has been deregistered")
+	case consul.LockAcquired:
+		return t.c.T("acquired the lock and is now active")
+	case consul.LockLost:
+		return t.c.T("lost the lock")
+	default:
+		return fmt.Sprintf(t.c.T("is %s"), status)
+	}
+}
+
+// location returns where the node of the event is, e.g. dc1/node1.
+func (t *Telegram) location(ev *consul.Event) string {
+	if ev.External {
+		return ev.Location() + t.c.T(" (external)")
+	}
+	return ev.Location()
+}
+
+// subject returns the service id of the event or the check name of node checks.
+func (t *Telegram) subject(ev *consul.Event) string {
+	if ev.IsNode() {
+		return fmt.Sprintf(t.c.T("node check %s"), ev.Name)
+	}
+	return ev.ServiceID
+}
+
+// infof prints a debug message.
+func (t *Telegram) infof(format string, v ...interface{}) {
+	if t.logger != nil {
+		t.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("telegram responded with %d status code", r.r.StatusCode)
+}
@@ -0,0 +1,153 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var got payload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/sendMessage" {
+			t.Errorf("path = %q, want %q", r.URL.Path, "/bottoken/sendMessage")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer ts.Close()
+
+	tg, err := New("token", "-100", WithAPIURL(ts.URL), WithLogger(nil), WithTimestamps(func(t time.Time) string {
+		return t.UTC().Format(time.Kitchen)
+	}), WithStatusPrefixes(map[string]string{consul.Critical: "🔴 CRITICAL"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tg.Notify(&consul.Event{
+		Node:      "node1",
+		ServiceID: "web",
+		Status:    consul.Critical,
+		Output:    "<timeout>",
+		Meta:      map[string]string{"owner": "web-team"},
+		Ack:       &consul.Ack{By: "alice", Time: time.Date(2017, 9, 1, 11, 55, 0, 0, time.UTC)},
+		Time:      time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ChatID != "-100" {
+		t.Errorf("ChatID = %q, want %q", got.ChatID, "-100")
+	}
+	if got.ParseMode != HTML {
+		t.Errorf("ParseMode = %q, want %q", got.ParseMode, HTML)
+	}
+	for _, s := range []string{"🔴 CRITICAL <b>[node1] web</b> is critical", "<pre>&lt;timeout&gt;</pre>", "<i>owner:</i> web-team",
+		"<i>Acknowledged by</i> alice at 11:55AM", "<i>Time:</i> 12:00PM"} {
+		if !strings.Contains(got.Text, s) {
+			t.Errorf("text %q expected to include %q", got.Text, s)
+		}
+	}
+
+	if err = tg.Notify(&consul.Event{
+		Node:          "node1",
+		ServiceID:     "web",
+		Status:        consul.Critical,
+		PrevStatus:    consul.Critical,
+		Reminder:      2,
+		CriticalSince: time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC),
+		Time:          time.Date(2017, 9, 1, 12, 23, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if s := "is still critical for 23m (reminder #2)"; !strings.Contains(got.Text, s) {
+		t.Errorf("text %q expected to include %q", got.Text, s)
+	}
+
+	if err = tg.Notify(&consul.Event{
+		Node:       "node1",
+		ServiceID:  "web",
+		Status:     consul.Passing,
+		PrevStatus: consul.Critical,
+		Timeline:   &consul.Timeline{Since: time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC), Flaps: 2},
+		Time:       time.Date(2017, 9, 1, 12, 42, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if s := "<i>Timeline:</i> failing for 42m, 2 flaps"; !strings.Contains(got.Text, s) {
+		t.Errorf("text %q expected to include %q", got.Text, s)
+	}
+}
+
+func TestNotifyMarkdown(t *testing.T) {
+	t.Parallel()
+
+	var got payload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	tg, err := New("token", "-100", WithAPIURL(ts.URL), WithLogger(nil), WithParseMode(Markdown))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tg.Notify(&consul.Event{
+		Node:      "web_1",
+		ServiceID: "my_service",
+		Status:    consul.Critical,
+		Notes:     "see [runbook]",
+		Meta:      map[string]string{"team_name": "web_team"},
+		Mention:   "@on_call",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"@on\\_call *[web_1] my_service* is critical", "_Notes:_ see \\[runbook]",
+		"_team_\\__name:_ web\\_team"} {
+		if !strings.Contains(got.Text, s) {
+			t.Errorf("text %q expected to include %q", got.Text, s)
+		}
+	}
+
+	if err = tg.Summary([]*consul.Event{{Node: "web_1", ServiceID: "my_service", Status: consul.Critical}}); err != nil {
+		t.Fatal(err)
+	}
+	if s := "\\[web\\_1] my\\_service is critical"; !strings.Contains(got.Text, s) {
+		t.Errorf("text %q expected to include %q", got.Text, s)
+	}
+
+	// unknown statuses and heartbeats are text too
+	if err = tg.Notify(&consul.Event{Node: "web_1", ServiceID: "my_service", Status: "out_of_*"}); err != nil {
+		t.Fatal(err)
+	}
+	if s := "* is out\\_of\\_\\*"; !strings.HasSuffix(got.Text, s) {
+		t.Errorf("text %q expected to end with %q", got.Text, s)
+	}
+	if err = tg.Heartbeat("consul_slack is alive"); err != nil {
+		t.Fatal(err)
+	}
+	if s := "consul\\_slack is alive"; got.Text != s {
+		t.Errorf("text = %q, want %q", got.Text, s)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New("token", "1", WithParseMode("BBCode")); err == nil {
+		t.Error("expected unknown parse mode error")
+	}
+	if _, err := New("", "1"); err == nil {
+		t.Error("expected empty token error")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// timestampFormat is the format of event times in messages.
+const timestampFormat = "2006-01-02 15:04:05 MST"
+
+// timezone returns the -timezone location, the local one when it's not set.
+func timezone() (*time.Location, error) {
+	if timezoneFlag == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(timezoneFlag)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %v", err)
+	}
+	return loc, nil
+}
+
+// timestamps returns functions rendering event times in messages in the
+// configured timezone, both are nil when timestamps are disabled. Slack ones
+// are date tokens when they're enabled, so every reader sees their local time,
+// the text in the timezone is a fallback for clients that don't support them.
+func timestamps() (plain, slack func(t time.Time) string, err error) {
+	if !timestampsFlag {
+		return nil, nil, nil
+	}
+	loc, err := timezone()
+	if err != nil {
+		return nil, nil, err
+	}
+	plain = func(t time.Time) string {
+		return t.In(loc).Format(timestampFormat)
+	}
+	if !slackDateTokensFlag {
+		return plain, plain, nil
+	}
+	return plain, func(t time.Time) string {
+		return fmt.Sprintf("<!date^%d^{date_short_pretty} {time_secs}|%s>", t.Unix(), plain(t))
+	}, nil
+}
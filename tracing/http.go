@@ -0,0 +1,50 @@
+package tracing
+
+import "net/http"
+
+// Client is an http client tracing requests made with a context carrying a span.
+var Client = &http.Client{Transport: &Transport{}}
+
+// Transport is an http.RoundTripper that records requests made with a context
+// carrying a span as its client spans and propagates them with the traceparent
+// header, urls aren't recorded since they often contain secrets like tokens.
+type Transport struct {
+	Base http.RoundTripper // http.DefaultTransport when nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	_, s := Start(req.Context(), "HTTP "+req.Method)
+	if s == nil {
+		return base.RoundTrip(req)
+	}
+	defer s.End()
+	s.kind = kindClient
+	s.SetAttribute("http.request.method", req.Method)
+	s.SetAttribute("server.address", req.URL.Host)
+
+	r := req.Clone(req.Context())
+	r.Header.Set("traceparent", s.sc.Traceparent())
+	res, err := base.RoundTrip(r)
+	if err != nil {
+		s.SetError(err)
+		return nil, err
+	}
+	s.SetAttribute("http.response.status_code", res.StatusCode)
+	if res.StatusCode >= 500 {
+		s.SetError(&statusCodeError{res.StatusCode})
+	}
+	return res, nil
+}
+
+type statusCodeError struct {
+	code int
+}
+
+func (e *statusCodeError) Error() string {
+	return http.StatusText(e.code)
+}
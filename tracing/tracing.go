@@ -0,0 +1,380 @@
+// Package tracing records spans of the event pipeline and exports them
+// to an OpenTelemetry collector with OTLP over HTTP in the JSON encoding,
+// trace context is propagated to HTTP calls with the traceparent header.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span within a trace, zero when there's no span.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports whether the span context identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent returns the W3C traceparent header value of the sampled span.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-01"
+}
+
+// span kinds of the OTLP protocol.
+const (
+	kindInternal = 1
+	kindClient   = 3
+)
+
+// Span is a timed operation, all its methods are no-ops on nil spans
+// so they're returned when tracing is disabled.
+type Span struct {
+	t      *Tracer
+	name   string
+	kind   int
+	sc     SpanContext
+	parent [8]byte
+	start  time.Time
+	end    time.Time
+	attrs  []keyValue
+	err    string
+}
+
+// Context returns the span context of the span, zero for nil spans.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute sets an attribute of the span,
+// values other than strings, integers and bools are formatted with fmt.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	var v anyValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int:
+		i := strconv.Itoa(x)
+		v.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(x, 10)
+		v.IntValue = &i
+	case uint64:
+		i := strconv.FormatUint(x, 10)
+		v.IntValue = &i
+	default:
+		str := fmt.Sprint(x)
+		v.StringValue = &str
+	}
+	s.attrs = append(s.attrs, keyValue{Key: key, Value: v})
+}
+
+// SetError marks the span failed with err, nil errors are ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+// End finishes the span and queues it for exporting.
+func (s *Span) End() {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	s.t.queue(s)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying the span,
+// HTTP calls made with the context are its children, see Transport.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span ctx carries, nil when there's none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a child of the span ctx carries and returns a context carrying it,
+// it's a no-op returning a nil span when ctx doesn't carry a span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.t.Start(parent.sc, name)
+	return ContextWithSpan(ctx, s), s
+}
+
+// flushInterval is the interval spans are exported at.
+const flushInterval = 5 * time.Second
+
+// maxQueued limits the number of spans waiting to be exported,
+// newer spans are dropped when the collector can't keep up.
+const maxQueued = 2048
+
+// Option is a configuration option.
+type Option func(t *Tracer)
+
+// WithServiceName sets the service.name resource attribute, consul-slack by default.
+func WithServiceName(name string) Option {
+	return func(t *Tracer) {
+		t.service = name
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(t *Tracer) {
+		t.logger = l
+	}
+}
+
+// New creates a tracer exporting spans to the OTLP/HTTP endpoint of a collector,
+// e.g. http://localhost:4318, /v1/traces is appended when the url has no path.
+func New(endpoint string, opts ...Option) (*Tracer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("tracing: endpoint %q is not an http or https url", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	t := &Tracer{
+		endpoint: u.String(),
+		service:  "consul-slack",
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   log.New(os.Stdout, "[tracing] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t, nil
+}
+
+// Tracer starts spans and exports them in batches,
+// all its methods are no-ops on nil tracers.
+type Tracer struct {
+	endpoint string
+	service  string
+	client   *http.Client // not traced, exports would be traced otherwise
+	logger   *log.Logger
+
+	mu      sync.Mutex
+	spans   []*Span
+	dropped int // spans dropped since the last export
+}
+
+// Start starts a span, it's a root span of a new trace when parent is zero.
+func (t *Tracer) Start(parent SpanContext, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{t: t, name: name, kind: kindInternal, start: time.Now()}
+	if parent.IsValid() {
+		s.sc.TraceID, s.parent = parent.TraceID, parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+	}
+	rand.Read(s.sc.SpanID[:])
+	return s
+}
+
+// queue queues the finished span for exporting.
+func (t *Tracer) queue(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) == maxQueued {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, s)
+}
+
+// Run exports finished spans every few seconds until stop is closed,
+// spans finished by then are exported before it returns.
+func (t *Tracer) Run(stop <-chan struct{}) {
+	if t == nil {
+		return
+	}
+	tick := time.NewTicker(flushInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-stop:
+			if err := t.Flush(); err != nil {
+				t.infof("export error: %v", err)
+			}
+			return
+		}
+		if err := t.Flush(); err != nil {
+			t.infof("export error: %v", err)
+		}
+	}
+}
+
+// Flush exports finished spans, they're discarded when it fails
+// since a collector that's down shouldn't make consul-slack run out of memory.
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans, dropped := t.spans, t.dropped
+	t.spans, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped != 0 {
+		t.infof("%d spans dropped, the export queue is full", dropped)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %d status code", r.StatusCode)
+	}
+	return nil
+}
+
+// infof prints a debug message.
+func (t *Tracer) infof(format string, v ...interface{}) {
+	if t.logger != nil {
+		t.logger.Printf(format, v...)
+	}
+}
+
+// request builds the OTLP export request of the spans.
+func (t *Tracer) request(spans []*Span) *exportRequest {
+	service := t.service
+	out := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		j := spanJSON{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.parent != [8]byte{} {
+			j.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			j.Status = &status{Code: statusError, Message: s.err}
+		}
+		out = append(out, j)
+	}
+	return &exportRequest{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: []keyValue{
+			{Key: "service.name", Value: anyValue{StringValue: &service}},
+		}},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: "github.com/amenzhinsky/consul-slack/tracing"},
+			Spans: out,
+		}},
+	}}}
+}
+
+// statusError is the OTLP status code of failed spans.
+const statusError = 2
+
+// exportRequest is the JSON encoding of ExportTraceServiceRequest of OTLP,
+// trace and span ids are hex encoded, 64-bit integers are strings.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanJSON struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	reqs := make(chan *exportRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("path = %q, want %q", r.URL.Path, "/v1/traces")
+		}
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		reqs <- &req
+	}))
+	defer ts.Close()
+
+	tr, err := New(ts.URL, WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll := tr.Start(SpanContext{}, "poll")
+	poll.SetAttribute("watcher", "dc1")
+	poll.End()
+	route := tr.Start(poll.Context(), "route")
+	route.SetError(errors.New("boom"))
+	route.End()
+	if err = tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-reqs
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("malformed request %+v", req)
+	}
+	if v := req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; v == nil || *v != "consul-slack" {
+		t.Errorf("service.name = %v, want consul-slack", v)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("len(spans) = %d, want 2", len(spans))
+	}
+	if spans[0].Name != "poll" || spans[0].ParentSpanID != "" {
+		t.Errorf("spans[0] = %+v, want a root poll span", spans[0])
+	}
+	if v := spans[0].Attributes[0].Value.StringValue; v == nil || *v != "dc1" {
+		t.Errorf("watcher = %v, want dc1", v)
+	}
+	if spans[1].TraceID != spans[0].TraceID || spans[1].ParentSpanID != spans[0].SpanID {
+		t.Errorf("route span isn't a child of the poll span: %+v", spans[1])
+	}
+	if spans[1].Status == nil || spans[1].Status.Code != statusError || spans[1].Status.Message != "boom" {
+		t.Errorf("status = %+v, want an error", spans[1].Status)
+	}
+
+	// nothing's left to export
+	if err = tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case req = <-reqs:
+		t.Errorf("unexpected request %+v", req)
+	default:
+	}
+}
+
+func TestTransport(t *testing.T) {
+	t.Parallel()
+
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer ts.Close()
+
+	tr, err := New("http://localhost:4318", WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify := tr.Start(SpanContext{}, "notify")
+	req, err := http.NewRequest("POST", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Client.Do(req.WithContext(ContextWithSpan(context.Background(), notify)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+
+	if len(tr.spans) != 1 {
+		t.Fatalf("len(spans) = %d, want 1", len(tr.spans))
+	}
+	s := tr.spans[0]
+	if s.kind != kindClient || s.sc.TraceID != notify.sc.TraceID || s.parent != notify.sc.SpanID {
+		t.Errorf("http span isn't a client child of the notify span: %+v", s)
+	}
+	if want := "00-" + hex.EncodeToString(s.sc.TraceID[:]) + "-" + hex.EncodeToString(s.sc.SpanID[:]) + "-01"; traceparent != want {
+		t.Errorf("traceparent = %q, want %q", traceparent, want)
+	}
+
+	// requests without spans aren't traced
+	traceparent = ""
+	if r, err = Client.Post(ts.URL, "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if traceparent != "" || len(tr.spans) != 1 {
+		t.Errorf("untraced request got traceparent %q", traceparent)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"", "localhost:4318", "ftp://localhost"} {
+		if _, err := New(s); err == nil {
+			t.Errorf("New(%q) = nil error", s)
+		}
+	}
+	tr, err := New("https://otel.example.com/custom/traces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.endpoint != "https://otel.example.com/custom/traces" {
+		t.Errorf("endpoint = %q, custom path is lost", tr.endpoint)
+	}
+}
@@ -0,0 +1,270 @@
+// Package vault reads secrets from HashiCorp Vault over its http api
+// and keeps leases of them and the token alive while they're used.
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryDelay is how soon failed renewals are retried.
+const retryDelay = 30 * time.Second
+
+// Option is a configuration option.
+type Option func(c *Client)
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// New creates new vault client, VAULT_ADDR and VAULT_TOKEN
+// are used when the address or the token is empty.
+func New(addr, token string, opts ...Option) (*Client, error) {
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("vault: address is empty")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault: token is empty")
+	}
+	c := &Client{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		logger: log.New(os.Stdout, "[vault] ", log.LstdFlags),
+		leases: map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Client reads secrets and renews their leases.
+type Client struct {
+	addr   string
+	token  string
+	logger *log.Logger
+
+	mu     sync.Mutex
+	leases map[string]time.Time // lease id -> next renewal
+}
+
+// Secret is a field of a secret.
+type Secret struct {
+	Value         string
+	LeaseID       string // empty for static secrets like kv ones
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// response is a subset of fields of vault responses.
+type response struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+}
+
+// Read reads the field of the secret at the path, e.g. secret/data/consul-slack,
+// fields of kv version 2 secrets are looked up in their nested data. Renewable
+// leases of secrets are renewed by Renew from then on.
+func (c *Client) Read(path, field string) (*Secret, error) {
+	var res response
+	if err := c.do("GET", "/v1/"+strings.TrimLeft(path, "/"), nil, &res); err != nil {
+		return nil, err
+	}
+	data := res.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	v, ok := data[field]
+	if !ok {
+		return nil, fmt.Errorf("vault: secret %s has no %q field", path, field)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("vault: field %q of secret %s is not a string", field, path)
+	}
+
+	secret := &Secret{
+		Value:         s,
+		LeaseID:       res.LeaseID,
+		LeaseDuration: time.Duration(res.LeaseDuration) * time.Second,
+		Renewable:     res.Renewable,
+	}
+	if secret.LeaseID != "" && secret.Renewable && secret.LeaseDuration > 0 {
+		c.mu.Lock()
+		c.leases[secret.LeaseID] = time.Now().Add(secret.LeaseDuration / 2)
+		c.mu.Unlock()
+	}
+	return secret, nil
+}
+
+// Renew renews the token when it's renewable and leases of secrets read at
+// the half of their durations until done is closed, it returns right away
+// when there's nothing to renew. Failures are passed to onErr and retried,
+// leases that cannot be renewed anymore are reported and dropped.
+func (c *Client) Renew(done <-chan struct{}, onErr func(err error)) {
+	var tokenNext time.Time
+	ttl, renewable, err := c.lookupToken()
+	switch {
+	case err != nil:
+		onErr(err)
+	case renewable && ttl > 0:
+		tokenNext = time.Now().Add(ttl / 2)
+	}
+	for {
+		next := tokenNext
+		c.mu.Lock()
+		for _, t := range c.leases {
+			if next.IsZero() || t.Before(next) {
+				next = t
+			}
+		}
+		c.mu.Unlock()
+		if next.IsZero() {
+			return
+		}
+
+		select {
+		case <-time.After(time.Until(next)):
+		case <-done:
+			return
+		}
+
+		now := time.Now()
+		if !tokenNext.IsZero() && !now.Before(tokenNext) {
+			d, err := c.renewToken()
+			switch {
+			case err != nil:
+				onErr(err)
+				tokenNext = now.Add(retryDelay)
+			case d <= 0:
+				onErr(fmt.Errorf("vault: token cannot be renewed anymore"))
+				tokenNext = time.Time{}
+			default:
+				tokenNext = now.Add(d / 2)
+			}
+		}
+		c.renewLeases(now, onErr)
+	}
+}
+
+// renewLeases renews leases that are due at now.
+func (c *Client) renewLeases(now time.Time, onErr func(err error)) {
+	c.mu.Lock()
+	var due []string
+	for id, t := range c.leases {
+		if !now.Before(t) {
+			due = append(due, id)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, id := range due {
+		var res response
+		err := c.do("PUT", "/v1/sys/leases/renew", map[string]string{"lease_id": id}, &res)
+		c.mu.Lock()
+		switch {
+		case err != nil:
+			onErr(err)
+			c.leases[id] = now.Add(retryDelay)
+		case res.LeaseDuration <= 0 || !res.Renewable:
+			onErr(fmt.Errorf("vault: lease %s cannot be renewed anymore, restart to read a new secret", id))
+			delete(c.leases, id)
+		default:
+			c.leases[id] = now.Add(time.Duration(res.LeaseDuration) * time.Second / 2)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// lookupToken returns the ttl of the token and whether it's renewable.
+func (c *Client) lookupToken() (time.Duration, bool, error) {
+	var res response
+	if err := c.do("GET", "/v1/auth/token/lookup-self", nil, &res); err != nil {
+		return 0, false, err
+	}
+	ttl, _ := res.Data["ttl"].(float64)
+	renewable, _ := res.Data["renewable"].(bool)
+	return time.Duration(ttl) * time.Second, renewable, nil
+}
+
+// renewToken renews the token and returns its new ttl.
+func (c *Client) renewToken() (time.Duration, error) {
+	var res response
+	if err := c.do("POST", "/v1/auth/token/renew-self", nil, &res); err != nil {
+		return 0, err
+	}
+	if res.Auth == nil || !res.Auth.Renewable {
+		return 0, nil
+	}
+	return time.Duration(res.Auth.LeaseDuration) * time.Second, nil
+}
+
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.addr+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	c.infof("%s %s", method, path)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	return json.NewDecoder(r.Body).Decode(out)
+}
+
+// infof prints a debug message.
+func (c *Client) infof(format string, v ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("vault responded with %d status code", r.r.StatusCode)
+}
@@ -0,0 +1,115 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Vault-Token"); got != "token" {
+			t.Errorf("X-Vault-Token = %q, want %q", got, "token")
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/v1/secret/data/consul-slack":
+			w.Write([]byte(`{"data":{"data":{"slack_token":"xoxb-1"},"metadata":{"version":3}}}`))
+		case "/v1/kv/consul-slack":
+			w.Write([]byte(`{"data":{"telegram_token":"123:abc","port":8080}}`))
+		case "/v1/consul/creds/consul-slack":
+			w.Write([]byte(`{"lease_id":"consul/creds/consul-slack/xyz","lease_duration":3600,"renewable":true,"data":{"token":"acl-1"}}`))
+		case "/v1/sys/leases/renew":
+			var in map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in["lease_id"] != "consul/creds/consul-slack/xyz" {
+				t.Errorf("renew = %v, %v, want the lease id", in, err)
+			}
+			w.Write([]byte(`{"lease_id":"consul/creds/consul-slack/xyz","lease_duration":0,"renewable":false}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, "token", WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path, field, want string
+	}{
+		{"secret/data/consul-slack", "slack_token", "xoxb-1"},
+		{"kv/consul-slack", "telegram_token", "123:abc"},
+		{"consul/creds/consul-slack", "token", "acl-1"},
+	} {
+		s, err := c.Read(tt.path, tt.field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Value != tt.want {
+			t.Errorf("Read(%q, %q) = %q, want %q", tt.path, tt.field, s.Value, tt.want)
+		}
+	}
+	for _, field := range []string{"missing", "port"} {
+		if _, err = c.Read("kv/consul-slack", field); err == nil {
+			t.Errorf("Read of %q field expected to fail", field)
+		}
+	}
+	if _, err = c.Read("kv/unknown", "token"); err == nil {
+		t.Error("Read of an unknown secret expected to fail")
+	}
+
+	// the dynamic secret's lease is renewed at the half of its duration
+	var errs []error
+	requests = nil
+	c.renewLeases(time.Now().Add(time.Minute), func(err error) {
+		errs = append(errs, err)
+	})
+	if len(requests) != 0 {
+		t.Errorf("requests = %v, want none before the lease is due", requests)
+	}
+	c.renewLeases(time.Now().Add(31*time.Minute), func(err error) {
+		errs = append(errs, err)
+	})
+	if want := []string{"PUT /v1/sys/leases/renew"}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+	if len(errs) != 1 || len(c.leases) != 0 {
+		t.Errorf("errs = %v, leases = %v, want the lease that cannot be renewed reported and dropped", errs, c.leases)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/token/lookup-self" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"data":{"ttl":0,"renewable":false}}`))
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, "root", WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	returned := make(chan struct{})
+	go func() {
+		c.Renew(nil, func(err error) {
+			t.Error(err)
+		})
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Renew doesn't return when there's nothing to renew")
+	}
+}
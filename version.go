@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information, set with -ldflags "-X main.version=..." by make.
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// versionString returns the build information.
+func versionString() string {
+	return fmt.Sprintf("consul-slack %s (commit %s, built %s, %s)", version, commit, date, runtime.Version())
+}
@@ -0,0 +1,149 @@
+package victorops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Option is a configuration option.
+type Option func(v *VictorOps)
+
+// WithRoutingKey sets routing key that determines alert team, "everyone" by default.
+func WithRoutingKey(key string) Option {
+	return func(v *VictorOps) {
+		v.routingKey = key
+	}
+}
+
+// WithAPIURL sets rest endpoint base url.
+func WithAPIURL(url string) Option {
+	return func(v *VictorOps) {
+		v.apiURL = strings.TrimRight(url, "/")
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(v *VictorOps) {
+		v.logger = l
+	}
+}
+
+// New creates new splunk on-call (victorops) rest endpoint client.
+func New(apiKey string, opts ...Option) (*VictorOps, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("victorops: api key is empty")
+	}
+	v := &VictorOps{
+		apiKey:     apiKey,
+		routingKey: "everyone",
+		apiURL:     "https://alert.victorops.com/integrations/generic/20131114/alert",
+		logger:     log.New(os.Stdout, "[victorops] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v, nil
+}
+
+// VictorOps is a splunk on-call rest endpoint client.
+type VictorOps struct {
+	apiKey     string
+	routingKey string
+	apiURL     string
+	logger     *log.Logger
+}
+
+// Message types.
+const (
+	Critical = "CRITICAL"
+	Warning  = "WARNING"
+	Info     = "INFO"
+	Recovery = "RECOVERY"
+)
+
+// messageTypes maps check statuses to message types.
+var messageTypes = map[string]string{
+	consul.Passing:     Recovery,
+	consul.Deleted:     Recovery,
+	consul.Warning:     Warning,
+	consul.Critical:    Critical,
+	consul.Maintenance: Info,
+}
+
+// alert is the rest endpoint request body.
+type alert struct {
+	MessageType       string `json:"message_type"`
+	EntityID          string `json:"entity_id"`
+	EntityDisplayName string `json:"entity_display_name"`
+	StateMessage      string `json:"state_message"`
+	MonitoringTool    string `json:"monitoring_tool"`
+	Node              string `json:"node"`
+	Service           string `json:"service"`
+	Status            string `json:"status"`
+}
+
+// Notify sends the event, every check has its own entity id
+// so a recovery resolves the incident opened by the failure.
+func (v *VictorOps) Notify(ev *consul.Event) error {
+	mt, ok := messageTypes[ev.Status]
+	if !ok {
+		mt = Info
+	}
+
+	b, err := json.Marshal(&alert{
+		MessageType:       mt,
+		EntityID:          "consul/" + ev.Key(),
+		EntityDisplayName: fmt.Sprintf("[%s] %s is %s", ev.Node, ev.ServiceID, ev.Status),
+		StateMessage:      fmt.Sprintf("Notes: %s\nOutput: %s", ev.Notes, ev.Output),
+		MonitoringTool:    "consul-slack",
+		Node:              ev.Node,
+		Service:           ev.ServiceName,
+		Status:            ev.Status,
+	})
+	if err != nil {
+		return err
+	}
+
+	v.infof("payload: %s", b)
+	r, err := http.Post(
+		v.apiURL+"/"+url.PathEscape(v.apiKey)+"/"+url.PathEscape(v.routingKey),
+		"application/json",
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	v.infof("response: %s", r.Status)
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	return nil
+}
+
+// infof prints a debug message.
+func (v *VictorOps) infof(format string, args ...interface{}) {
+	if v.logger != nil {
+		v.logger.Printf(format, args...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("victorops responded with %d status code", r.r.StatusCode)
+}
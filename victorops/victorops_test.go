@@ -0,0 +1,48 @@
+package victorops
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	var got []alert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/key/ops" {
+			t.Errorf("path = %q, want %q", r.URL.Path, "/key/ops")
+		}
+		var a alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, a)
+	}))
+	defer ts.Close()
+
+	v, err := New("key", WithRoutingKey("ops"), WithAPIURL(ts.URL), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []string{consul.Critical, consul.Passing} {
+		if err = v.Notify(&consul.Event{Node: "n1", ServiceID: "web", ID: "n1:web", Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d requests, want 2", len(got))
+	}
+	if got[0].MessageType != Critical || got[1].MessageType != Recovery {
+		t.Errorf("message types = %q, %q, want %q, %q", got[0].MessageType, got[1].MessageType, Critical, Recovery)
+	}
+	if got[0].EntityID != got[1].EntityID {
+		t.Errorf("entity ids differ: %q != %q", got[0].EntityID, got[1].EntityID)
+	}
+}
@@ -0,0 +1,616 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/catalog"
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// AttachmentSender is a chat that supports colored attachments.
+type AttachmentSender interface {
+	Good(msg string, v ...interface{}) error
+	Warning(msg string, v ...interface{}) error
+	Danger(msg string, v ...interface{}) error
+	Message(msg string, v ...interface{}) error
+}
+
+// ThreadSender is an AttachmentSender that groups messages into threads,
+// colors are slack attachment ones: good, warning, danger or empty.
+type ThreadSender interface {
+	AttachmentSender
+	SendThread(key string, start bool, color, msg string, v ...interface{}) error
+	EndThread(key string)
+}
+
+// ContextSender is an AttachmentSender that can send messages with
+// a context, e.g. to trace them, see AttachmentNotifier.NotifyContext.
+type ContextSender interface {
+	AttachmentSender
+	SendContext(ctx context.Context, color, msg string, v ...interface{}) error
+}
+
+// ThreadContextSender is a ThreadSender that can post to threads with a context.
+type ThreadContextSender interface {
+	ThreadSender
+	SendThreadContext(ctx context.Context, key string, start bool, color, msg string, v ...interface{}) error
+}
+
+// AttachmentOption is an attachment notifier configuration option.
+type AttachmentOption func(n *AttachmentNotifier)
+
+// WithTimestamps appends times events have been detected at
+// to messages rendered with fn, e.g. in a specific timezone.
+func WithTimestamps(fn func(t time.Time) string) AttachmentOption {
+	return func(n *AttachmentNotifier) {
+		n.timestamp = fn
+	}
+}
+
+// WithStatusPrefixes prepends texts to messages about events with
+// the given statuses, e.g. "🔴 CRITICAL", since colors alone aren't
+// shown in notifications and are hard to tell apart for some people.
+func WithStatusPrefixes(prefixes map[string]string) AttachmentOption {
+	return func(n *AttachmentNotifier) {
+		n.prefixes = prefixes
+	}
+}
+
+// WithThreads makes output changes of failing checks posted as replies
+// to the last message about the incident when the sender is a ThreadSender.
+func WithThreads(enabled bool) AttachmentOption {
+	return func(n *AttachmentNotifier) {
+		n.threads = enabled
+	}
+}
+
+// WithCatalog translates messages and status words with c.
+func WithCatalog(c catalog.Catalog) AttachmentOption {
+	return func(n *AttachmentNotifier) {
+		n.c = c
+	}
+}
+
+// NewAttachmentNotifier creates a notifier that sends events to s,
+// mention is prepended to escalated reminders, e.g. <!here>.
+func NewAttachmentNotifier(s AttachmentSender, mention string, opts ...AttachmentOption) *AttachmentNotifier {
+	n := &AttachmentNotifier{s: s, mention: mention}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// AttachmentNotifier formats events as slack-like attachments.
+type AttachmentNotifier struct {
+	s         AttachmentSender
+	mention   string                   // prepended to escalated reminders
+	timestamp func(t time.Time) string // nil when timestamps are disabled
+	prefixes  map[string]string        // status prefixes, see WithStatusPrefixes
+	threads   bool                     // see WithThreads
+	c         catalog.Catalog          // nil when messages are in english
+	ctx       context.Context          // set by NotifyContext and NotifyGroupContext
+}
+
+// NotifyContext is Notify that sends the message with ctx when the sender is a ContextSender.
+func (n *AttachmentNotifier) NotifyContext(ctx context.Context, ev *consul.Event) error {
+	m := *n
+	m.ctx = ctx
+	return m.Notify(ev)
+}
+
+// NotifyGroupContext is NotifyGroup that sends the message with ctx when the sender is a ContextSender.
+func (n *AttachmentNotifier) NotifyGroupContext(ctx context.Context, evs []*consul.Event) error {
+	m := *n
+	m.ctx = ctx
+	return m.NotifyGroup(evs)
+}
+
+// bind makes the notifier send messages with the context
+// of NotifyContext when the sender supports it.
+func (n *AttachmentNotifier) bind() *AttachmentNotifier {
+	cs, ok := n.s.(ContextSender)
+	if n.ctx == nil || !ok {
+		return n
+	}
+	m := *n
+	m.s = &contextSender{s: cs, ctx: n.ctx}
+	return &m
+}
+
+// Notify sends the event colored by its status.
+func (n *AttachmentNotifier) Notify(ev *consul.Event) error {
+	if ts, ok := n.s.(ThreadSender); ok && n.threads && ev.Incident != "" {
+		m := *n
+		m.s = &threadSender{s: ts, ctx: n.ctx, key: ev.Incident, start: ev.Status != ev.PrevStatus || ev.Reminder != 0}
+		n = &m
+		if ev.Resolved() {
+			defer ts.EndThread(ev.Incident)
+		}
+	} else {
+		n = n.bind()
+	}
+	if ev.Mention != "" {
+		m := *n
+		m.s = &prefixSender{s: n.s, prefix: ev.Mention}
+		n = &m
+	}
+	if p := n.prefixes[ev.Status]; p != "" {
+		m := *n
+		m.s = &prefixSender{s: n.s, prefix: p}
+		n = &m
+	}
+	if n.timestamp != nil && !ev.Time.IsZero() {
+		m := *n
+		m.s = &suffixSender{s: n.s, suffix: fmt.Sprintf(n.c.T("\nTime: %s"), n.timestamp(ev.Time))}
+		n = &m
+	}
+	if suffix := n.timelineLine(ev) + n.ackLine(ev.Ack) + metaLines(ev.Meta) + n.incidentLine(ev.Incident); suffix != "" {
+		m := *n
+		m.s = &suffixSender{s: n.s, suffix: suffix}
+		n = &m
+	}
+
+	// show where the node is, e.g. dc1/node1
+	e := *ev
+	e.Node = n.location(ev)
+	ev = &e
+
+	was := ""
+	if ev.PrevStatus != "" {
+		was = fmt.Sprintf(n.c.T(" (was %s)"), n.c.T(ev.PrevStatus))
+	}
+	if d := ev.CriticalFor(); d != "" && ev.PrevStatus == consul.Critical {
+		was = fmt.Sprintf(n.c.T(" (was critical for %s)"), d)
+	}
+	if ev.PrevStatus == ev.Status {
+		was = n.c.T(" (output changed)")
+	}
+
+	switch {
+	case ev.Reminder != 0:
+		return n.notifyReminder(ev)
+	case ev.Status == consul.LockAcquired:
+		return n.s.Message(n.c.T("consul-slack on %s acquired the lock and is now active"), ev.Node)
+	case ev.Status == consul.LockLost:
+		return n.s.Warning(n.c.T("consul-slack on %s lost the lock"), ev.Node)
+	case ev.IsNode():
+		return n.notifyNode(ev, was)
+	}
+
+	switch ev.Status {
+	case consul.Passing:
+		return n.s.Good(n.c.T("[%s] %s is back to normal%s\nCheck: %s\nNotes: %s\nOutput: %s"), ev.Node, ev.ServiceID, was, ev.Name, ev.Notes, ev.Output)
+	case consul.Warning:
+		return n.s.Warning(n.c.T("[%s] %s is having problems%s\nCheck: %s\nNotes: %s\nOutput: %s"), ev.Node, ev.ServiceID, was, ev.Name, ev.Notes, ev.Output)
+	case consul.Critical:
+		deps := ""
+		if len(ev.Dependents) != 0 {
+			deps = fmt.Sprintf(n.c.T("\nAffected dependents: %s"), strings.Join(ev.Dependents, ", "))
+		}
+		return n.s.Danger(n.c.T("[%s] %s is critical%s\nCheck: %s\nNotes: %s\nOutput: %s%s"), ev.Node, ev.ServiceID, was, ev.Name, ev.Notes, ev.Output, deps)
+	case consul.Maintenance:
+		return n.s.Message(n.c.T("[%s] %s is under maintenance%s\nNotes: %s"), ev.Node, ev.ServiceID, was, ev.Notes)
+	case consul.Added:
+		return n.s.Message(n.c.T("[%s] %s has been registered\nTags: %s"), ev.Node, ev.ServiceID, strings.Join(ev.ServiceTags, ", "))
+	case consul.Deleted:
+		return n.s.Message(n.c.T("[%s] %s has been deregistered%s"), ev.Node, ev.ServiceID, was)
+	default:
+		// statuses added by newer consul releases
+		return n.s.Message(n.c.T("[%s] %s is %s%s\nCheck: %s\nNotes: %s\nOutput: %s"), ev.Node, ev.ServiceID, n.c.T(ev.Status), was, ev.Name, ev.Notes, ev.Output)
+	}
+}
+
+// NotifyGroup sends status changes of checks of the same node as a single
+// message colored by the first event, it's the most severe of them.
+func (n *AttachmentNotifier) NotifyGroup(evs []*consul.Event) error {
+	n = n.bind()
+	lead := evs[0]
+	if lead.Mention != "" {
+		m := *n
+		m.s = &prefixSender{s: n.s, prefix: lead.Mention}
+		n = &m
+	}
+	if p := n.prefixes[lead.Status]; p != "" {
+		m := *n
+		m.s = &prefixSender{s: n.s, prefix: p}
+		n = &m
+	}
+	if n.timestamp != nil && !lead.Time.IsZero() {
+		m := *n
+		m.s = &suffixSender{s: n.s, suffix: fmt.Sprintf(n.c.T("\nTime: %s"), n.timestamp(lead.Time))}
+		n = &m
+	}
+
+	lines := make([]string, 0, len(evs))
+	for _, ev := range evs {
+		lines = append(lines, n.groupLine(ev))
+	}
+	msg, v := n.c.T("[%s] %d checks changed")+"\n%s", []interface{}{n.location(lead), len(evs), strings.Join(lines, "\n")}
+	switch lead.Status {
+	case consul.Critical:
+		return n.s.Danger(msg, v...)
+	case consul.Warning:
+		return n.s.Warning(msg, v...)
+	case consul.Passing:
+		return n.s.Good(msg, v...)
+	default:
+		return n.s.Message(msg, v...)
+	}
+}
+
+// Summary sends the list of failing checks.
+func (n *AttachmentNotifier) Summary(evs []*consul.Event) error {
+	if len(evs) == 0 {
+		return n.s.Good("%s", n.c.T("Summary: all checks are passing"))
+	}
+	lines := make([]string, 0, len(evs))
+	for _, ev := range evs {
+		lines = append(lines, n.summaryLine(ev))
+	}
+	return n.s.Danger(n.c.T("Summary: %d checks are failing")+"\n%s", len(evs), strings.Join(lines, "\n"))
+}
+
+// Report sends the daily report, it's all clear when nothing is failing.
+func (n *AttachmentNotifier) Report(r *consul.Report) error {
+	if len(r.Failing) == 0 {
+		return n.s.Good(n.c.T("All clear: all %d checks are passing in %s"), r.Total(), r.Locations())
+	}
+	lines := make([]string, 0, len(r.Failing))
+	for _, ev := range r.Failing {
+		lines = append(lines, n.summaryLine(ev))
+	}
+	return n.s.Danger(n.c.T("Daily report: %d of %d checks are failing in %s")+"\n%s",
+		len(r.Failing), r.Total(), r.Locations(), strings.Join(lines, "\n"))
+}
+
+// maxWeeklyLines limits the number of services and checks listed in weekly reports.
+const maxWeeklyLines = 5
+
+// WeeklyReport sends statistics of incidents of the week, worst offenders
+// with their mean times to recovery and between failures and flappiest checks.
+func (n *AttachmentNotifier) WeeklyReport(s *consul.Stats) error {
+	period := s.From.Format("Jan 2") + " - " + s.To.Format("Jan 2")
+	if s.Incidents == 0 && len(s.Flappy) == 0 {
+		return n.s.Good(n.c.T("Weekly report for %s: no incidents"), period)
+	}
+	lines := []string{fmt.Sprintf(n.c.T("Weekly report for %s: %d incidents, mean time to recovery %s"),
+		period, s.Incidents, consul.Minutes(s.MTTR))}
+	if len(s.Services) != 0 {
+		lines = append(lines, n.c.T("Worst offenders:"))
+	}
+	for i, svc := range s.Services {
+		if i == maxWeeklyLines {
+			break
+		}
+		lines = append(lines, fmt.Sprintf(n.c.T("%s: %d incidents, down for %s, MTTR %s, MTBF %s"),
+			svc.Service, svc.Incidents, consul.Minutes(svc.Downtime), consul.Minutes(svc.MTTR), consul.Minutes(svc.MTBF)))
+	}
+	if len(s.Flappy) != 0 {
+		lines = append(lines, n.c.T("Flappiest checks:"))
+	}
+	for i, c := range s.Flappy {
+		if i == maxWeeklyLines {
+			break
+		}
+		lines = append(lines, fmt.Sprintf(n.c.T("[%s] %s %s: %d status changes"), c.Location, c.Service, c.Check, c.Changes))
+	}
+	return n.s.Warning("%s", strings.Join(lines, "\n"))
+}
+
+// Suppressed sends the list of alerts suppressed during the maintenance window.
+func (n *AttachmentNotifier) Suppressed(window string, evs []*consul.Event) error {
+	lines := make([]string, 0, len(evs))
+	for _, ev := range evs {
+		lines = append(lines, n.summaryLine(ev))
+	}
+	return n.s.Message(n.c.T("Suppressed %d alerts during maintenance window %s")+"\n%s", len(evs), window, strings.Join(lines, "\n"))
+}
+
+// maxOutageLines limits the number of checks listed in outage messages.
+const maxOutageLines = 30
+
+// Outage sends the rolled-up message about the outage that started at since
+// listing failing checks, over is true once it's over and alerts resume.
+// Updates are posted as replies to the first message with threads enabled.
+func (n *AttachmentNotifier) Outage(since time.Time, evs []*consul.Event, over bool) error {
+	s := n.s
+	if ts, ok := n.s.(ThreadSender); ok && n.threads {
+		key := "outage/" + since.UTC().Format(time.RFC3339Nano)
+		s = &threadSender{s: ts, key: key}
+		if over {
+			defer ts.EndThread(key)
+		}
+	}
+	at := since.Format(time.RFC3339)
+	if n.timestamp != nil {
+		at = n.timestamp(since)
+	}
+	lines := ""
+	for i, ev := range evs {
+		if i == maxOutageLines {
+			lines += "\n" + fmt.Sprintf(n.c.T("and %d more"), len(evs)-i)
+			break
+		}
+		lines += "\n" + n.summaryLine(ev)
+	}
+	switch {
+	case !over:
+		return s.Danger(n.c.T("Outage since %s: %d checks are failing, alerts about them are held")+"%s", at, len(evs), lines)
+	case len(evs) == 0:
+		return s.Good(n.c.T("Outage since %s is over, all checks are passing"), at)
+	default:
+		return s.Warning(n.c.T("Outage since %s is over, alerts resume: %d checks are still failing")+"%s", at, len(evs), lines)
+	}
+}
+
+// Heartbeat sends the heartbeat message.
+func (n *AttachmentNotifier) Heartbeat(msg string) error {
+	return n.s.Good("%s", msg)
+}
+
+// Overflow sends the number of events held by the rate limit.
+func (n *AttachmentNotifier) Overflow(count int) error {
+	return n.s.Message(n.c.T("%d more events suppressed by the rate limit, see `consul-slack status`"), count)
+}
+
+// notifyReminder sends a reminder about a check that is still critical.
+func (n *AttachmentNotifier) notifyReminder(ev *consul.Event) error {
+	mention := ""
+	if ev.Escalated && n.mention != "" {
+		mention = n.mention + " "
+	}
+	critical := n.c.T(consul.Critical)
+	if d := ev.CriticalFor(); d != "" {
+		critical = fmt.Sprintf(n.c.T("critical for %s"), d)
+	}
+	return n.s.Danger(n.c.T("%s[%s] %s is still %s (reminder #%d)\nCheck: %s\nOutput: %s"),
+		mention, ev.Node, n.subject(ev), critical, ev.Reminder, ev.Name, ev.Output)
+}
+
+// notifyNode sends node-level check event.
+func (n *AttachmentNotifier) notifyNode(ev *consul.Event, was string) error {
+	switch ev.Status {
+	case consul.Added:
+		return n.s.Message(n.c.T("Node %s check %s has been registered"), ev.Node, ev.Name)
+	case consul.Deleted:
+		return n.s.Message(n.c.T("Node %s check %s has been deregistered%s"), ev.Node, ev.Name, was)
+	}
+	switch {
+	case ev.CheckID == consul.WANCheckID && ev.Status == consul.Passing:
+		return n.s.Good(n.c.T("Datacenter %s is reachable over WAN again%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.WANCheckID:
+		return n.s.Danger(n.c.T("Datacenter %s is unreachable over WAN%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.LeaderCheckID && ev.Status == consul.Passing:
+		return n.s.Good(n.c.T("Datacenter %s has a raft leader again%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.LeaderCheckID:
+		return n.s.Danger(n.c.T("Datacenter %s has no raft leader%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.QuorumCheckID && ev.Status == consul.Passing:
+		return n.s.Good(n.c.T("Datacenter %s has raft quorum again%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.QuorumCheckID:
+		return n.s.Danger(n.c.T("Datacenter %s lost raft quorum%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.FailureToleranceCheckID && ev.Status == consul.Passing:
+		return n.s.Good(n.c.T("Datacenter %s tolerates server failures again%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.FailureToleranceCheckID:
+		return n.s.Danger(n.c.T("Datacenter %s cannot lose any more servers%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.AutopilotCheckID && ev.Status == consul.Passing:
+		return n.s.Good(n.c.T("Server %s is healthy again%s\nOutput: %s"), ev.Node, was, ev.Output)
+	case ev.CheckID == consul.AutopilotCheckID:
+		return n.s.Danger(n.c.T("Server %s is unhealthy according to autopilot%s\nOutput: %s"), ev.Node, was, ev.Output)
+	}
+	if ev.CheckID == consul.SerfHealth {
+		switch ev.Status {
+		case consul.Passing:
+			return n.s.Good(n.c.T("Node %s is back in the cluster%s"), ev.Node, was)
+		default:
+			affects := ""
+			if len(ev.Dependents) != 0 {
+				affects = fmt.Sprintf(n.c.T(", affects %d services: %s"), len(ev.Dependents), strings.Join(ev.Dependents, ", "))
+			}
+			return n.s.Danger(n.c.T("Node %s left the cluster or is unreachable%s%s\nOutput: %s"), ev.Node, affects, was, ev.Output)
+		}
+	}
+
+	switch ev.Status {
+	case consul.Passing:
+		return n.s.Good(n.c.T("Node %s check %s is back to normal%s\nOutput: %s"), ev.Node, ev.Name, was, ev.Output)
+	case consul.Warning:
+		return n.s.Warning(n.c.T("Node %s check %s is having problems%s\nOutput: %s"), ev.Node, ev.Name, was, ev.Output)
+	default:
+		return n.s.Danger(n.c.T("Node %s check %s is %s%s\nOutput: %s"), ev.Node, ev.Name, n.c.T(ev.Status), was, ev.Output)
+	}
+}
+
+// prefixSender prepends text like mentions and status prefixes to all messages.
+type prefixSender struct {
+	s      AttachmentSender
+	prefix string
+}
+
+func (m *prefixSender) Good(msg string, v ...interface{}) error {
+	return m.s.Good("%s "+msg, append([]interface{}{m.prefix}, v...)...)
+}
+
+func (m *prefixSender) Warning(msg string, v ...interface{}) error {
+	return m.s.Warning("%s "+msg, append([]interface{}{m.prefix}, v...)...)
+}
+
+func (m *prefixSender) Danger(msg string, v ...interface{}) error {
+	return m.s.Danger("%s "+msg, append([]interface{}{m.prefix}, v...)...)
+}
+
+func (m *prefixSender) Message(msg string, v ...interface{}) error {
+	return m.s.Message("%s "+msg, append([]interface{}{m.prefix}, v...)...)
+}
+
+// suffixSender appends lines like times, acks and service meta fields to all messages.
+type suffixSender struct {
+	s      AttachmentSender
+	suffix string
+}
+
+func (m *suffixSender) Good(msg string, v ...interface{}) error {
+	return m.s.Good(msg+"%s", append(v, m.suffix)...)
+}
+
+func (m *suffixSender) Warning(msg string, v ...interface{}) error {
+	return m.s.Warning(msg+"%s", append(v, m.suffix)...)
+}
+
+func (m *suffixSender) Danger(msg string, v ...interface{}) error {
+	return m.s.Danger(msg+"%s", append(v, m.suffix)...)
+}
+
+func (m *suffixSender) Message(msg string, v ...interface{}) error {
+	return m.s.Message(msg+"%s", append(v, m.suffix)...)
+}
+
+// contextSender sends all messages with the context, see ContextSender.
+type contextSender struct {
+	s   ContextSender
+	ctx context.Context
+}
+
+func (c *contextSender) Good(msg string, v ...interface{}) error {
+	return c.s.SendContext(c.ctx, "good", msg, v...)
+}
+
+func (c *contextSender) Warning(msg string, v ...interface{}) error {
+	return c.s.SendContext(c.ctx, "warning", msg, v...)
+}
+
+func (c *contextSender) Danger(msg string, v ...interface{}) error {
+	return c.s.SendContext(c.ctx, "danger", msg, v...)
+}
+
+func (c *contextSender) Message(msg string, v ...interface{}) error {
+	return c.s.SendContext(c.ctx, "", msg, v...)
+}
+
+// threadSender posts all messages to the thread with the key, see ThreadSender,
+// with the context when it's set and the sender is a ThreadContextSender.
+type threadSender struct {
+	s     ThreadSender
+	ctx   context.Context
+	key   string
+	start bool
+}
+
+func (t *threadSender) Good(msg string, v ...interface{}) error {
+	return t.send("good", msg, v...)
+}
+
+func (t *threadSender) Warning(msg string, v ...interface{}) error {
+	return t.send("warning", msg, v...)
+}
+
+func (t *threadSender) Danger(msg string, v ...interface{}) error {
+	return t.send("danger", msg, v...)
+}
+
+func (t *threadSender) Message(msg string, v ...interface{}) error {
+	return t.send("", msg, v...)
+}
+
+func (t *threadSender) send(color, msg string, v ...interface{}) error {
+	if ts, ok := t.s.(ThreadContextSender); ok && t.ctx != nil {
+		return ts.SendThreadContext(t.ctx, t.key, t.start, color, msg, v...)
+	}
+	return t.s.SendThread(t.key, t.start, color, msg, v...)
+}
+
+// ackLine returns the line describing the ack, empty when it's nil.
+func (n *AttachmentNotifier) ackLine(ack *consul.Ack) string {
+	if ack == nil {
+		return ""
+	}
+	at := ack.Time.Format(time.RFC3339)
+	if n.timestamp != nil {
+		at = n.timestamp(ack.Time)
+	}
+	line := fmt.Sprintf(n.c.T("\nAcknowledged by %s at %s"), ack.By, at)
+	if ack.Comment != "" {
+		line += ": " + ack.Comment
+	}
+	return line
+}
+
+// timelineLine returns the line summarizing the incident the event
+// resolves, e.g. failing for 1h5m, 2 flaps, empty when it has no timeline.
+func (n *AttachmentNotifier) timelineLine(ev *consul.Event) string {
+	d := ev.FailingFor()
+	if d == "" {
+		return ""
+	}
+	parts := []string{fmt.Sprintf(n.c.T("failing for %s"), d)}
+	if ev.Timeline.Flaps != 0 {
+		parts = append(parts, fmt.Sprintf(n.c.T("%d flaps"), ev.Timeline.Flaps))
+	}
+	if ev.Timeline.Outputs != 0 {
+		parts = append(parts, fmt.Sprintf(n.c.T("%d output changes"), ev.Timeline.Outputs))
+	}
+	return fmt.Sprintf(n.c.T("\nTimeline: %s"), strings.Join(parts, ", "))
+}
+
+// metaLines returns service meta fields sorted by key, a line each.
+func metaLines(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var lines string
+	for _, k := range keys {
+		lines += "\n" + k + ": " + meta[k]
+	}
+	return lines
+}
+
+// incidentLine returns the incident id line, empty when it's not known.
+func (n *AttachmentNotifier) incidentLine(id string) string {
+	if id == "" {
+		return ""
+	}
+	return fmt.Sprintf(n.c.T("\nIncident: %s"), id)
+}
+
+// location returns where the node of the event is, e.g. dc1/node1.
+func (n *AttachmentNotifier) location(ev *consul.Event) string {
+	if ev.External {
+		return ev.Location() + n.c.T(" (external)")
+	}
+	return ev.Location()
+}
+
+// subject returns the service id of the event or the check name of node checks.
+func (n *AttachmentNotifier) subject(ev *consul.Event) string {
+	if ev.IsNode() {
+		return fmt.Sprintf(n.c.T("node check %s"), ev.Name)
+	}
+	return ev.ServiceID
+}
+
+// groupLine returns a single line description of the status change
+// of a grouped check, the node is omitted since it's the same for all.
+func (n *AttachmentNotifier) groupLine(ev *consul.Event) string {
+	line := fmt.Sprintf(n.c.T("%s is %s"), n.subject(ev), n.c.T(ev.Status))
+	if ev.PrevStatus != "" {
+		line += fmt.Sprintf(n.c.T(" (was %s)"), n.c.T(ev.PrevStatus))
+	}
+	if !ev.IsNode() {
+		line += fmt.Sprintf(n.c.T(", check %s"), ev.Name)
+	}
+	if ev.Output != "" {
+		line += ": " + ev.Output
+	}
+	return line
+}
+
+// summaryLine returns a single line description of the failing check.
+func (n *AttachmentNotifier) summaryLine(ev *consul.Event) string {
+	return fmt.Sprintf(n.c.T("[%s] %s is %s"), n.location(ev), n.subject(ev), n.c.T(ev.Status))
+}
@@ -0,0 +1,32 @@
+package watcher
+
+import (
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Middleware handles an event on its way to a notifier, it calls next
+// to pass it further, possibly rewritten or enriched, doesn't call it
+// to drop the event or calls it several times to fan it out.
+type Middleware func(ev *consul.Event, next func(ev *consul.Event) error) error
+
+// NotifierFunc is a function used as a Notifier.
+type NotifierFunc func(ev *consul.Event) error
+
+// Notify calls f.
+func (f NotifierFunc) Notify(ev *consul.Event) error {
+	return f(ev)
+}
+
+// Chain returns a notifier that passes events through the middlewares
+// in the given order before delivering them to n. Since the result is
+// a NotifierFunc, optional methods of n like Summary aren't available on it.
+func Chain(n Notifier, mws ...Middleware) Notifier {
+	next := n.Notify
+	for i := len(mws) - 1; i >= 0; i-- {
+		mw, inner := mws[i], next
+		next = func(ev *consul.Event) error {
+			return mw(ev, inner)
+		}
+	}
+	return NotifierFunc(next)
+}
@@ -0,0 +1,60 @@
+package watcher
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Pool delivers events to a notifier by workers in the background,
+// so a slow notifier holds up neither others nor watching. Events of
+// the same check are delivered by the same worker to keep them in order.
+type Pool struct {
+	n      Notifier
+	onErr  func(ev *consul.Event, err error)
+	queues []chan *consul.Event
+	wg     sync.WaitGroup
+}
+
+// NewPool starts workers delivering events to n, every one of them
+// queues up to queue events, onErr is called on delivery failures.
+func NewPool(n Notifier, workers, queue int, onErr func(ev *consul.Event, err error)) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{n: n, onErr: onErr, queues: make([]chan *consul.Event, workers)}
+	p.wg.Add(workers)
+	for i := range p.queues {
+		p.queues[i] = make(chan *consul.Event, queue)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// Notify queues the event, it waits while the worker's queue is full
+// and always returns nil, delivery errors are passed to onErr instead.
+func (p *Pool) Notify(ev *consul.Event) error {
+	h := fnv.New32a()
+	h.Write([]byte(ev.Cluster + "\x00" + ev.Datacenter + "\x00" + ev.Peer + "\x00" + ev.ID))
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- ev
+	return nil
+}
+
+// Close waits until queued events are delivered,
+// Notify mustn't be called after that.
+func (p *Pool) Close() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+func (p *Pool) work(q <-chan *consul.Event) {
+	defer p.wg.Done()
+	for ev := range q {
+		if err := p.n.Notify(ev); err != nil && p.onErr != nil {
+			p.onErr(ev, err)
+		}
+	}
+}
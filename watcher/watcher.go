@@ -0,0 +1,113 @@
+// Package watcher glues consul health watching and notifiers together
+// so the pipeline can be embedded into other programs without the cli:
+//
+//	s, _ := slack.New(webhookURL)
+//	w, err := watcher.New([]consul.Option{
+//		consul.WithDatacenter("dc1"),
+//	}, watcher.NewAttachmentNotifier(s, ""))
+//	if err != nil {
+//		return err
+//	}
+//	go w.Run(ctx)
+//	for {
+//		ev, err := w.Next(ctx)
+//		if ev == nil {
+//			return err
+//		}
+//		if err != nil {
+//			log.Print(err)
+//		}
+//	}
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Notifier delivers consul events to an external system.
+type Notifier interface {
+	Notify(ev *consul.Event) error
+}
+
+// Error is an event delivery error of a notifier.
+type Error struct {
+	Notifier Notifier
+	Err      error
+}
+
+// Error is a string representation.
+func (e *Error) Error() string {
+	return fmt.Sprintf("notify error: %v", e.Err)
+}
+
+// Errors are delivery errors of an event.
+type Errors []*Error
+
+// Error is a string representation.
+func (e Errors) Error() string {
+	s := make([]string, 0, len(e))
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// Notify delivers the event to all notifiers concurrently
+// and waits until all of them are done.
+func Notify(ev *consul.Event, notifiers ...Notifier) Errors {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs Errors
+	)
+	wg.Add(len(notifiers))
+	for _, n := range notifiers {
+		go func(n Notifier) {
+			defer wg.Done()
+			if err := n.Notify(ev); err != nil {
+				mu.Lock()
+				errs = append(errs, &Error{Notifier: n, Err: err})
+				mu.Unlock()
+			}
+		}(n)
+	}
+	wg.Wait()
+	return errs
+}
+
+// New starts watching consul configured with the given options,
+// events are delivered to the notifiers when they're read with Next.
+func New(opts []consul.Option, notifiers ...Notifier) (*Watcher, error) {
+	c, err := consul.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{Consul: c, notifiers: notifiers}, nil
+}
+
+// Watcher is a consul watcher that delivers events to notifiers,
+// methods of the underlying consul watcher like Active and Close
+// are available on it as well.
+type Watcher struct {
+	*consul.Consul
+	notifiers []Notifier
+}
+
+// Next returns the next event after delivering it to all notifiers,
+// the error is Errors then when some of them failed. Nil event is
+// returned when watching is stopped or ctx is done, see consul's Next.
+func (w *Watcher) Next(ctx context.Context) (*consul.Event, error) {
+	ev, err := w.Consul.Next(ctx)
+	if ev == nil {
+		return nil, err
+	}
+	if errs := Notify(ev, w.notifiers...); len(errs) != 0 {
+		return ev, errs
+	}
+	return ev, nil
+}
@@ -0,0 +1,491 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/catalog"
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	ok := NotifierFunc(func(*consul.Event) error { return nil })
+	bad := NotifierFunc(func(*consul.Event) error { return errors.New("boom") })
+	errs := Notify(&consul.Event{Status: consul.Critical}, ok, bad, ok)
+	if len(errs) != 1 || errs[0].Err.Error() != "boom" {
+		t.Fatalf("errs = %v, want one boom", errs)
+	}
+}
+
+// recorder is an AttachmentSender that records the last message.
+type recorder struct {
+	color, msg string
+}
+
+func (r *recorder) send(color, msg string, v ...interface{}) error {
+	r.color, r.msg = color, fmt.Sprintf(msg, v...)
+	return nil
+}
+
+func (r *recorder) Good(msg string, v ...interface{}) error    { return r.send("good", msg, v...) }
+func (r *recorder) Warning(msg string, v ...interface{}) error { return r.send("warning", msg, v...) }
+func (r *recorder) Danger(msg string, v ...interface{}) error  { return r.send("danger", msg, v...) }
+func (r *recorder) Message(msg string, v ...interface{}) error { return r.send("", msg, v...) }
+
+func TestAttachmentNotifier(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	n := NewAttachmentNotifier(r, "<!here>")
+	for _, tc := range []struct {
+		ev    *consul.Event
+		color string
+		msg   string
+	}{
+		{
+			&consul.Event{Node: "n1", Datacenter: "dc1", ServiceID: "web", Name: "http", Status: consul.Critical, PrevStatus: consul.Passing},
+			"danger", "[dc1/n1] web is critical (was passing)\nCheck: http\nNotes: \nOutput: ",
+		},
+		{
+			&consul.Event{Node: "n1", CheckID: consul.SerfHealth, Status: consul.Critical, Dependents: []string{"db", "web"}},
+			"danger", "Node n1 left the cluster or is unreachable, affects 2 services: db, web\nOutput: ",
+		},
+		{
+			&consul.Event{Node: "n1", ServiceID: "web", Status: consul.Maintenance, Mention: "@web-team"},
+			"", "@web-team [n1] web is under maintenance\nNotes: ",
+		},
+		{
+			&consul.Event{Node: "n1", ServiceID: "web", Name: "http", Status: consul.Critical, Reminder: 3, Escalated: true},
+			"danger", "<!here> [n1] web is still critical (reminder #3)\nCheck: http\nOutput: ",
+		},
+		{
+			&consul.Event{Node: "n1", ServiceID: "web", Name: "http", Status: consul.Critical, PrevStatus: consul.Critical, Reminder: 1,
+				CriticalSince: time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC), Time: time.Date(2017, 9, 1, 12, 23, 0, 0, time.UTC)},
+			"danger", "[n1] web is still critical for 23m (reminder #1)\nCheck: http\nOutput: ",
+		},
+		{
+			&consul.Event{Node: "n1", ServiceID: "web", Name: "http", Status: consul.Passing, PrevStatus: consul.Critical,
+				CriticalSince: time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC), Time: time.Date(2017, 9, 1, 13, 5, 0, 0, time.UTC)},
+			"good", "[n1] web is back to normal (was critical for 1h5m)\nCheck: http\nNotes: \nOutput: ",
+		},
+		{
+			&consul.Event{Node: "n1", ServiceID: "web", Name: "http", Status: "degraded", PrevStatus: consul.Passing},
+			"", "[n1] web is degraded (was passing)\nCheck: http\nNotes: \nOutput: ",
+		},
+	} {
+		if err := n.Notify(tc.ev); err != nil {
+			t.Fatal(err)
+		}
+		if r.color != tc.color || r.msg != tc.msg {
+			t.Errorf("Notify(%+v) = %s %q, want %s %q", tc.ev, r.color, r.msg, tc.color, tc.msg)
+		}
+	}
+
+	if err := n.Summary([]*consul.Event{{Node: "n1", Datacenter: "dc1", ServiceID: "web", Status: consul.Critical}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Summary: 1 checks are failing\n[dc1/n1] web is critical"; r.msg != want {
+		t.Errorf("Summary = %q, want %q", r.msg, want)
+	}
+	if err := n.Suppressed("sun 02:00-04:00", []*consul.Event{{Node: "n1", ServiceID: "web", Status: consul.Warning}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Suppressed 1 alerts during maintenance window sun 02:00-04:00\n[n1] web is warning"; r.msg != want {
+		t.Errorf("Suppressed = %q, want %q", r.msg, want)
+	}
+	if err := n.Overflow(42); err != nil {
+		t.Fatal(err)
+	}
+	if want := "42 more events suppressed by the rate limit, see `consul-slack status`"; r.msg != want {
+		t.Errorf("Overflow = %q, want %q", r.msg, want)
+	}
+
+	n = NewAttachmentNotifier(r, "", WithStatusPrefixes(map[string]string{consul.Critical: "🔴 CRITICAL"}))
+	if err := n.Notify(&consul.Event{Node: "n1", ServiceID: "web", Name: "http", Status: consul.Critical, Mention: "@web-team"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "@web-team 🔴 CRITICAL [n1] web is critical\nCheck: http\nNotes: \nOutput: "; r.msg != want {
+		t.Errorf("Notify with status prefixes = %q, want %q", r.msg, want)
+	}
+
+	n = NewAttachmentNotifier(r, "", WithTimestamps(func(t time.Time) string {
+		return t.UTC().Format(time.Kitchen)
+	}))
+	if err := n.Notify(&consul.Event{
+		Node: "n1", ServiceID: "web", Status: consul.Maintenance, Mention: "@web-team",
+		Time: time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "@web-team [n1] web is under maintenance\nNotes: \nTime: 12:00PM"; r.msg != want {
+		t.Errorf("Notify with timestamps = %q, want %q", r.msg, want)
+	}
+
+	if err := n.Notify(&consul.Event{
+		Node: "n1", ServiceID: "web", Name: "http", Status: consul.Critical,
+		Meta: map[string]string{"runbook_url": "https://wiki/web%20down", "owner": "web-team"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[n1] web is critical\nCheck: http\nNotes: \nOutput: \nowner: web-team\nrunbook_url: https://wiki/web%20down"; r.msg != want {
+		t.Errorf("Notify with meta = %q, want %q", r.msg, want)
+	}
+
+	if err := n.Notify(&consul.Event{
+		Node: "n1", ServiceID: "web", Name: "http", Status: consul.Passing, PrevStatus: consul.Critical,
+		Ack: &consul.Ack{By: "alice", Time: time.Date(2017, 9, 1, 12, 5, 0, 0, time.UTC), Comment: "restarting"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[n1] web is back to normal (was critical)\nCheck: http\nNotes: \nOutput: \nAcknowledged by alice at 12:05PM: restarting"; r.msg != want {
+		t.Errorf("Notify with ack = %q, want %q", r.msg, want)
+	}
+
+	if err := n.Notify(&consul.Event{
+		Node: "n1", ServiceID: "web", Name: "http", Status: consul.Passing, PrevStatus: consul.Critical,
+		CriticalSince: time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC), Time: time.Date(2017, 9, 1, 12, 42, 0, 0, time.UTC),
+		Timeline: &consul.Timeline{Since: time.Date(2017, 9, 1, 11, 0, 0, 0, time.UTC), Flaps: 2, Outputs: 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[n1] web is back to normal (was critical for 42m)\nCheck: http\nNotes: \nOutput: \n" +
+		"Timeline: failing for 1h42m, 2 flaps, 3 output changes\nTime: 12:42PM"; r.msg != want {
+		t.Errorf("Notify with timeline = %q, want %q", r.msg, want)
+	}
+}
+
+func TestAttachmentNotifier_Report(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	n := NewAttachmentNotifier(r, "")
+	if err := n.Report(&consul.Report{Checks: map[string]int{"dc1": 214}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "All clear: all 214 checks are passing in dc1"; r.color != "good" || r.msg != want {
+		t.Errorf("Report = %s %q, want good %q", r.color, r.msg, want)
+	}
+
+	if err := n.Report(&consul.Report{
+		Checks:  map[string]int{"dc1": 214, "dc2": 12},
+		Failing: []*consul.Event{{Datacenter: "dc2", Node: "n1", ServiceID: "web", Status: consul.Critical}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Daily report: 1 of 226 checks are failing in dc1 (214), dc2 (12)\n[dc2/n1] web is critical"; r.color != "danger" || r.msg != want {
+		t.Errorf("Report = %s %q, want danger %q", r.color, r.msg, want)
+	}
+}
+
+func TestAttachmentNotifier_WeeklyReport(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	n := NewAttachmentNotifier(r, "")
+	from := time.Date(2017, 9, 1, 9, 0, 0, 0, time.UTC)
+	if err := n.WeeklyReport(&consul.Stats{From: from, To: from.AddDate(0, 0, 7)}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Weekly report for Sep 1 - Sep 8: no incidents"; r.color != "good" || r.msg != want {
+		t.Errorf("WeeklyReport = %s %q, want good %q", r.color, r.msg, want)
+	}
+
+	if err := n.WeeklyReport(&consul.Stats{
+		From: from, To: from.AddDate(0, 0, 7), Incidents: 3, MTTR: 42 * time.Minute,
+		Services: []*consul.ServiceStats{{Service: "web", Incidents: 3, Downtime: 126 * time.Minute, MTTR: 42 * time.Minute, MTBF: 55 * time.Hour}},
+		Flappy:   []*consul.CheckStats{{Location: "dc1/n1", Service: "web", Check: "http", Changes: 6}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Weekly report for Sep 1 - Sep 8: 3 incidents, mean time to recovery 42m\nWorst offenders:\n" +
+		"web: 3 incidents, down for 2h6m, MTTR 42m, MTBF 55h\nFlappiest checks:\n[dc1/n1] web http: 6 status changes"; r.color != "warning" || r.msg != want {
+		t.Errorf("WeeklyReport = %s %q, want warning %q", r.color, r.msg, want)
+	}
+}
+
+func TestAttachmentNotifier_Outage(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	n := NewAttachmentNotifier(r, "", WithTimestamps(func(t time.Time) string {
+		return t.UTC().Format("15:04")
+	}))
+	since := time.Date(2024, 5, 6, 10, 30, 0, 0, time.UTC)
+	evs := []*consul.Event{{Datacenter: "dc1", Node: "n1", ServiceID: "web", Status: consul.Critical}}
+	if err := n.Outage(since, evs, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Outage since 10:30: 1 checks are failing, alerts about them are held\n[dc1/n1] web is critical"; r.color != "danger" || r.msg != want {
+		t.Errorf("Outage = %s %q, want danger %q", r.color, r.msg, want)
+	}
+	if err := n.Outage(since, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Outage since 10:30 is over, all checks are passing"; r.color != "good" || r.msg != want {
+		t.Errorf("Outage = %s %q, want good %q", r.color, r.msg, want)
+	}
+}
+
+func TestAttachmentNotifier_NotifyGroup(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	n := NewAttachmentNotifier(r, "")
+	if err := n.NotifyGroup([]*consul.Event{
+		{Datacenter: "dc1", Node: "n1", ServiceID: "web", Name: "http", Status: consul.Critical, PrevStatus: consul.Passing, Output: "refused", Mention: "@ops"},
+		{Datacenter: "dc1", Node: "n1", Name: "disk", Status: consul.Warning, PrevStatus: consul.Passing},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := "@ops [dc1/n1] 2 checks changed\n" +
+		"web is critical (was passing), check http: refused\n" +
+		"node check disk is warning (was passing)"
+	if r.color != "danger" || r.msg != want {
+		t.Errorf("NotifyGroup = %s %q, want danger %q", r.color, r.msg, want)
+	}
+}
+
+func TestAttachmentNotifier_Catalog(t *testing.T) {
+	t.Parallel()
+
+	c, err := catalog.Lookup("de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &recorder{}
+	n := NewAttachmentNotifier(r, "", WithCatalog(c), WithTimestamps(func(t time.Time) string {
+		return t.UTC().Format(time.Kitchen)
+	}))
+	if err = n.Notify(&consul.Event{
+		Node:       "n1",
+		ServiceID:  "web",
+		Name:       "http",
+		Status:     consul.Critical,
+		PrevStatus: consul.Warning,
+		Output:     "refused",
+		Incident:   "1f2e",
+		Time:       time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := "[n1] web ist kritisch (war Warnung)\nCheck: http\nNotizen: \nAusgabe: refused\nVorfall: 1f2e\nZeit: 12:00PM"
+	if r.color != "danger" || r.msg != want {
+		t.Errorf("Notify = %s %q, want danger %q", r.color, r.msg, want)
+	}
+}
+
+// threads is a ThreadSender that records messages of threads.
+type threads struct {
+	recorder
+	sent  []string // key and start of every message
+	ended []string
+}
+
+func (r *threads) SendThread(key string, start bool, color, msg string, v ...interface{}) error {
+	r.sent = append(r.sent, fmt.Sprintf("%s %t", key, start))
+	return r.send(color, msg, v...)
+}
+
+func (r *threads) EndThread(key string) {
+	r.ended = append(r.ended, key)
+}
+
+func TestAttachmentNotifier_Threads(t *testing.T) {
+	t.Parallel()
+
+	r := &threads{}
+	n := NewAttachmentNotifier(r, "", WithThreads(true))
+	for _, ev := range []*consul.Event{
+		{Node: "n1", ServiceID: "web", Status: consul.Critical, PrevStatus: consul.Passing, Incident: "i1"},
+		{Node: "n1", ServiceID: "web", Status: consul.Critical, PrevStatus: consul.Critical, Incident: "i1", Output: "oom"},
+		{Node: "n1", ServiceID: "web", Status: consul.Passing, PrevStatus: consul.Critical, Incident: "i1"},
+	} {
+		if err := n.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Join(r.sent, ","); got != "i1 true,i1 false,i1 true" {
+		t.Errorf("sent = %s, want the update replying to the thread", got)
+	}
+	if len(r.ended) != 1 || r.ended[0] != "i1" {
+		t.Errorf("ended = %v, want [i1]", r.ended)
+	}
+
+	// events without incidents aren't threaded
+	if err := n.Notify(&consul.Event{Node: "n1", ServiceID: "web", Status: consul.Added}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.sent) != 3 {
+		t.Errorf("sent = %v, want the event posted without a thread", r.sent)
+	}
+}
+
+// contexts is a ThreadContextSender that records values of contexts of messages.
+type contexts struct {
+	threads
+	values []interface{}
+}
+
+type ctxKey struct{}
+
+func (r *contexts) SendContext(ctx context.Context, color, msg string, v ...interface{}) error {
+	r.values = append(r.values, ctx.Value(ctxKey{}))
+	return r.send(color, msg, v...)
+}
+
+func (r *contexts) SendThreadContext(ctx context.Context, key string, start bool, color, msg string, v ...interface{}) error {
+	r.values = append(r.values, ctx.Value(ctxKey{}))
+	return r.SendThread(key, start, color, msg, v...)
+}
+
+func TestAttachmentNotifier_Context(t *testing.T) {
+	t.Parallel()
+
+	r := &contexts{}
+	n := NewAttachmentNotifier(r, "", WithThreads(true), WithStatusPrefixes(map[string]string{consul.Critical: "🔴"}))
+	ctx := context.WithValue(context.Background(), ctxKey{}, "span")
+	if err := n.NotifyContext(ctx, &consul.Event{Node: "n1", ServiceID: "web", Status: consul.Critical, Incident: "i1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.NotifyGroupContext(ctx, []*consul.Event{
+		{Node: "n1", ServiceID: "web", Status: consul.Critical},
+		{Node: "n1", ServiceID: "db", Status: consul.Critical},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.values) != 2 || r.values[0] != "span" || r.values[1] != "span" {
+		t.Errorf("values = %v, want both messages sent with the context", r.values)
+	}
+	if !strings.HasPrefix(r.msg, "🔴 ") {
+		t.Errorf("msg = %q, the status prefix is lost", r.msg)
+	}
+
+	// without a context messages are sent as usual
+	if err := n.Notify(&consul.Event{Node: "n1", ServiceID: "web", Status: consul.Passing}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.values) != 2 {
+		t.Errorf("values = %v, want Notify to send without a context", r.values)
+	}
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	n := NotifierFunc(func(ev *consul.Event) error {
+		got = append(got, ev.ServiceID+":"+ev.Mention)
+		return nil
+	})
+	drop := func(ev *consul.Event, next func(ev *consul.Event) error) error {
+		if ev.ServiceName == "canary" {
+			return nil
+		}
+		return next(ev)
+	}
+	mention := func(ev *consul.Event, next func(ev *consul.Event) error) error {
+		e := *ev
+		e.Mention = "@" + ev.ServiceName
+		return next(&e)
+	}
+	fanout := func(ev *consul.Event, next func(ev *consul.Event) error) error {
+		for _, id := range []string{ev.ServiceID + "-1", ev.ServiceID + "-2"} {
+			e := *ev
+			e.ServiceID = id
+			if err := next(&e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	c := Chain(n, drop, mention, fanout)
+	for _, ev := range []*consul.Event{
+		{ServiceID: "web", ServiceName: "web", Status: consul.Critical},
+		{ServiceID: "canary", ServiceName: "canary", Status: consul.Critical},
+	} {
+		if err := c.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "web-1:@web,web-2:@web"; strings.Join(got, ",") != want {
+		t.Errorf("delivered %v, want %s", got, want)
+	}
+
+	errStop := errors.New("stop")
+	fail := func(ev *consul.Event, next func(ev *consul.Event) error) error {
+		return errStop
+	}
+	if err := Chain(n, fail).Notify(&consul.Event{}); err != errStop {
+		t.Errorf("Notify = %v, want %v", err, errStop)
+	}
+}
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu   sync.Mutex
+		got  = map[string][]string{}
+		errs []string
+	)
+	release := make(chan struct{})
+	n := NotifierFunc(func(ev *consul.Event) error {
+		if ev.ID == "slow" {
+			<-release
+		}
+		if ev.Status == consul.Warning {
+			return errors.New("boom")
+		}
+		mu.Lock()
+		got[ev.ID] = append(got[ev.ID], ev.Status)
+		mu.Unlock()
+		return nil
+	})
+	p := NewPool(n, 4, 8, func(ev *consul.Event, err error) {
+		mu.Lock()
+		errs = append(errs, ev.ID+": "+err.Error())
+		mu.Unlock()
+	})
+
+	// a slow delivery doesn't hold up Notify
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, ev := range []*consul.Event{
+			{ID: "slow", Status: consul.Critical},
+			{ID: "web", Status: consul.Critical},
+			{ID: "web", Status: consul.Warning},
+			{ID: "web", Status: consul.Passing},
+			{ID: "slow", Status: consul.Passing},
+		} {
+			if err := p.Notify(ev); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Notify is held up by a slow delivery")
+	}
+	close(release)
+	p.Close()
+
+	if s := strings.Join(got["web"], ","); s != "critical,passing" {
+		t.Errorf("web delivered %s, want critical,passing", s)
+	}
+	if s := strings.Join(got["slow"], ","); s != "critical,passing" {
+		t.Errorf("slow delivered %s, want critical,passing", s)
+	}
+	if len(errs) != 1 || errs[0] != "web: boom" {
+		t.Errorf("errors = %v, want web: boom", errs)
+	}
+}
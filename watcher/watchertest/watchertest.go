@@ -0,0 +1,116 @@
+// Package watchertest provides in-memory notifiers and senders for tests
+// of programs embedding the watcher, see consultest for a fake consul.
+package watchertest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// Recorder is a watcher.Notifier that records delivered events.
+type Recorder struct {
+	mu     sync.Mutex
+	events []*consul.Event
+	err    error
+	ch     chan struct{} // closed and replaced on every event
+}
+
+// NewRecorder creates an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{ch: make(chan struct{})}
+}
+
+// Notify records the event, it returns the error set with Fail
+// without recording when there is one.
+func (r *Recorder) Notify(ev *consul.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.events = append(r.events, ev)
+	close(r.ch)
+	r.ch = make(chan struct{})
+	return nil
+}
+
+// Events returns recorded events in the order of delivery.
+func (r *Recorder) Events() []*consul.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*consul.Event(nil), r.events...)
+}
+
+// Wait blocks until at least n events are recorded and returns them,
+// it gives up when done is closed and returns what's recorded so far.
+func (r *Recorder) Wait(n int, done <-chan struct{}) []*consul.Event {
+	for {
+		r.mu.Lock()
+		if len(r.events) >= n {
+			r.mu.Unlock()
+			return r.Events()
+		}
+		ch := r.ch
+		r.mu.Unlock()
+		select {
+		case <-ch:
+		case <-done:
+			return r.Events()
+		}
+	}
+}
+
+// Fail makes subsequent Notify calls fail with err, nil restores delivery.
+func (r *Recorder) Fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// Reset forgets recorded events.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+// Message is a message sent with a Sender.
+type Message struct {
+	Color string // good, warning, danger or empty for plain messages
+	Text  string
+}
+
+// Sender is a watcher.AttachmentSender that records formatted messages,
+// so output of an AttachmentNotifier can be checked without a chat.
+type Sender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (s *Sender) send(color, msg string, v ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, Message{Color: color, Text: fmt.Sprintf(msg, v...)})
+	return nil
+}
+
+// Good records a good message.
+func (s *Sender) Good(msg string, v ...interface{}) error { return s.send("good", msg, v...) }
+
+// Warning records a warning message.
+func (s *Sender) Warning(msg string, v ...interface{}) error { return s.send("warning", msg, v...) }
+
+// Danger records a danger message.
+func (s *Sender) Danger(msg string, v ...interface{}) error { return s.send("danger", msg, v...) }
+
+// Message records a plain message.
+func (s *Sender) Message(msg string, v ...interface{}) error { return s.send("", msg, v...) }
+
+// Messages returns recorded messages in the order they were sent.
+func (s *Sender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
@@ -0,0 +1,88 @@
+package watchertest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+	"github.com/amenzhinsky/consul-slack/consul/consultest"
+	"github.com/amenzhinsky/consul-slack/watcher"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestWatcher(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	srv.SetCheck(&api.HealthCheck{
+		Node:        "node-1",
+		CheckID:     "service:web",
+		Name:        "web",
+		Status:      consul.Passing,
+		ServiceID:   "web",
+		ServiceName: "web",
+	})
+
+	r := NewRecorder()
+	s := &Sender{}
+	w, err := watcher.New([]consul.Option{
+		consul.WithAddress(strings.TrimPrefix(srv.URL, "http://")),
+		consul.WithInterval(100 * time.Millisecond),
+		consul.WithWaitTime(time.Second),
+		consul.WithLogger(nil),
+	}, r, watcher.NewAttachmentNotifier(s, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go w.Run(ctx)
+
+	// the first poll reports the initial state,
+	// Next returns when all notifiers are done
+	if _, err := w.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r.Reset()
+	if !srv.SetStatus("node-1", "service:web", consul.Critical, "connection refused") {
+		t.Fatal("check is missing")
+	}
+	if _, err := w.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	evs := r.Events()
+	if len(evs) != 1 {
+		t.Fatalf("events = %v, want one", evs)
+	}
+	if evs[0].ServiceName != "web" || evs[0].Status != consul.Critical || evs[0].PrevStatus != consul.Passing {
+		t.Errorf("event = %+v, want web going critical", evs[0])
+	}
+	if m := s.Messages(); len(m) != 2 || m[1].Color != "danger" || !strings.Contains(m[1].Text, "web is critical") {
+		t.Errorf("messages = %v, want a passing and a danger one", m)
+	}
+	if _, ok := srv.Value("consul-slack/.lock"); !ok {
+		t.Errorf("lock key isn't set")
+	}
+}
+
+func TestRecorder_Fail(t *testing.T) {
+	r := NewRecorder()
+	r.Fail(errors.New("boom"))
+	if err := r.Notify(&consul.Event{}); err == nil || err.Error() != "boom" {
+		t.Fatalf("err = %v, want boom", err)
+	}
+	r.Fail(nil)
+	if err := r.Notify(&consul.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.Events()); n != 1 {
+		t.Fatalf("len(events) = %d, want 1", n)
+	}
+	r.Reset()
+	if n := len(r.Events()); n != 0 {
+		t.Fatalf("len(events) = %d after reset, want 0", n)
+	}
+}
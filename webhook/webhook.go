@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+	"github.com/amenzhinsky/consul-slack/tracing"
+)
+
+// SignatureHeader is the request header carrying hex encoded
+// HMAC-SHA256 of the request body prefixed with "sha256=".
+const SignatureHeader = "X-Consul-Slack-Signature"
+
+// Option is a configuration option.
+type Option func(w *Webhook)
+
+// WithSecret enables request signing with the given key.
+func WithSecret(secret string) Option {
+	return func(w *Webhook) {
+		w.secret = []byte(secret)
+	}
+}
+
+// WithLogger sets logger.
+func WithLogger(l *log.Logger) Option {
+	return func(w *Webhook) {
+		w.logger = l
+	}
+}
+
+// New creates new webhook client.
+func New(url string, opts ...Option) (*Webhook, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook: url is empty")
+	}
+	w := &Webhook{
+		url:    url,
+		logger: log.New(os.Stdout, "[webhook] ", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w, nil
+}
+
+// Webhook posts events as json to an arbitrary url.
+type Webhook struct {
+	url    string
+	secret []byte
+	logger *log.Logger
+}
+
+// Notify posts the event as json.
+func (w *Webhook) Notify(ev *consul.Event) error {
+	return w.NotifyContext(context.Background(), ev)
+}
+
+// NotifyContext is Notify that makes the request with ctx,
+// it's traced when ctx carries a span, see tracing.Transport.
+func (w *Webhook) NotifyContext(ctx context.Context, ev *consul.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) != 0 {
+		req.Header.Set(SignatureHeader, Sign(w.secret, b))
+	}
+
+	w.infof("payload: %s", b)
+	r, err := tracing.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	w.infof("response: %s", r.Status)
+
+	if r.StatusCode >= 400 {
+		return &ResponseError{r}
+	}
+	return nil
+}
+
+// Sign computes signature header value of the body,
+// receivers can use it to verify requests.
+func Sign(secret, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// infof prints a debug message.
+func (w *Webhook) infof(format string, v ...interface{}) {
+	if w.logger != nil {
+		w.logger.Printf(format, v...)
+	}
+}
+
+// ResponseError returned when response code is more than 400.
+type ResponseError struct {
+	r *http.Response
+}
+
+// Error is a string representation.
+func (r *ResponseError) Error() string {
+	return fmt.Sprintf("webhook responded with %d status code", r.r.StatusCode)
+}
@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+
+		if got, want := r.Header.Get(SignatureHeader), Sign([]byte("secret"), b); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+
+		var ev consul.Event
+		if err = json.Unmarshal(b, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.ServiceID != "web" {
+			t.Errorf("ServiceID = %q, want %q", ev.ServiceID, "web")
+		}
+	}))
+	defer ts.Close()
+
+	w, err := New(ts.URL, WithSecret("secret"), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Notify(&consul.Event{ServiceID: "web", Status: consul.Critical}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotifyError(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	w, err := New(ts.URL, WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Notify(&consul.Event{}); err == nil {
+		t.Fatal("expected response error")
+	}
+}
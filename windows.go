@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/consul-slack/consul"
+)
+
+// window is a weekly recurring maintenance window.
+type window struct {
+	spec    string
+	service string // empty when it applies to all services and nodes
+	days    [7]bool
+	start   time.Duration // since midnight
+	end     time.Duration // since midnight, the window ends the next day when it's not after start
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseWindow parses a [SERVICE:]DAYS HH:MM-HH:MM window, where DAYS is daily
+// or a comma-separated list of days and day ranges like mon-fri or sat,sun.
+func parseWindow(s string) (*window, error) {
+	w := &window{spec: s}
+	if i := strings.IndexByte(s, ':'); i != -1 && !strings.Contains(s[:i], " ") {
+		w.service, s = s[:i], s[i+1:]
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("malformed maintenance window %q, want [SERVICE:]DAYS HH:MM-HH:MM", w.spec)
+	}
+
+	var err error
+	if w.days, err = parseDays(fields[0]); err != nil {
+		return nil, fmt.Errorf("%v in maintenance window %q", err, w.spec)
+	}
+	if w.start, w.end, err = parseClockRange(fields[1]); err != nil {
+		return nil, fmt.Errorf("%v in maintenance window %q", err, w.spec)
+	}
+	return w, nil
+}
+
+// parseDays parses daily or a comma-separated list of days
+// and day ranges like mon-fri or sat,sun into a set of weekdays.
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	if s == "daily" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+	for _, r := range strings.Split(s, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		from, ok := weekdays[bounds[0]]
+		if !ok {
+			return days, fmt.Errorf("unknown day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[bounds[1]]; !ok {
+				return days, fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClockRange parses HH:MM-HH:MM into durations since midnight.
+func parseClockRange(s string) (start, end time.Duration, err error) {
+	times := strings.SplitN(s, "-", 2)
+	if len(times) != 2 {
+		return 0, 0, fmt.Errorf("malformed time range %q", s)
+	}
+	if start, err = parseClock(times[0]); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(times[1]); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// parseClock parses HH:MM into duration since midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("malformed time %q, want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// active reports whether t falls into the window,
+// windows that end the next day belong to their start day.
+func (w *window) active(t time.Time) bool {
+	day := t.Weekday()
+	since := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	if w.start < w.end {
+		return w.days[day] && since >= w.start && since < w.end
+	}
+	return w.days[day] && since >= w.start || w.days[(day+6)%7] && since < w.end
+}
+
+// matches reports whether the window applies to the event.
+func (w *window) matches(ev *consul.Event) bool {
+	return !ev.IsLock() && (w.service == "" || w.service == ev.ServiceName)
+}
+
+// windowsFlag is a repeatable maintenance window command-line flag.
+type windowsFlag []*window
+
+func (f *windowsFlag) String() string {
+	return ""
+}
+
+func (f *windowsFlag) Set(s string) error {
+	w, err := parseWindow(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, w)
+	return nil
+}
+
+// windows suppresses events during maintenance windows
+// and keeps them to be reported once a window is over.
+type windows struct {
+	list []*window
+
+	mu         sync.Mutex
+	suppressed map[*window][]*consul.Event
+	seen       map[*consul.Event]bool // events are shared by targets
+}
+
+func newWindows(list []*window) *windows {
+	return &windows{
+		list:       list,
+		suppressed: map[*window][]*consul.Event{},
+		seen:       map[*consul.Event]bool{},
+	}
+}
+
+// suppress reports whether the event falls into a maintenance window at now.
+func (ws *windows) suppress(ev *consul.Event, now time.Time) bool {
+	for _, w := range ws.list {
+		if !w.matches(ev) || !w.active(now) {
+			continue
+		}
+		ws.mu.Lock()
+		if !ws.seen[ev] {
+			ws.seen[ev] = true
+			ws.suppressed[w] = append(ws.suppressed[w], ev)
+		}
+		ws.mu.Unlock()
+		return true
+	}
+	return false
+}
+
+// ended returns events suppressed by windows that are over at now and forgets them.
+func (ws *windows) ended(now time.Time) map[*window][]*consul.Event {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	r := map[*window][]*consul.Event{}
+	for w, evs := range ws.suppressed {
+		if w.active(now) {
+			continue
+		}
+		r[w] = evs
+		delete(ws.suppressed, w)
+		for _, ev := range evs {
+			delete(ws.seen, ev)
+		}
+	}
+	return r
+}
+
+// suppressedReporter is a notifier that can report alerts
+// suppressed during a maintenance window.
+type suppressedReporter interface {
+	Suppressed(window string, evs []*consul.Event) error
+}
+
+// reportWindows reports alerts suppressed during maintenance windows
+// to the targets that support it once windows are over, until done is closed.
+func reportWindows(ws *windows, targets []*target, done <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			for w, evs := range ws.ended(now) {
+				sendSuppressed(targets, w.spec, evs)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// sendSuppressed sends the suppressed alerts report to all targets that support it.
+func sendSuppressed(targets []*target, window string, evs []*consul.Event) {
+	for _, t := range targets {
+		r, ok := t.notifier.(suppressedReporter)
+		if !ok {
+			continue
+		}
+		if err := r.Suppressed(window, evs); err != nil {
+			notifyError(t.label(), err)
+		}
+	}
+}